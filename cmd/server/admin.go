@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/kacy/auth-proxy/internal/config"
+)
+
+// newAdminServer creates the admin listener's server. With a client CA,
+// clients must present a certificate it signed, unless a token is also
+// configured, in which case either one is enough.
+func newAdminServer(cfg *config.Config, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:        fmt.Sprintf(":%d", cfg.AdminPort),
+		Handler:     handler,
+		ReadTimeout: cfg.ServerReadTimeout,
+		IdleTimeout: cfg.ServerIdleTimeout,
+		// No write timeout: CPU profiles and traces stream for as long as
+		// the caller asks
+	}
+	if cfg.AdminTLSCertFile == "" {
+		return server, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.AdminTLSCertFile, cfg.AdminTLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load admin TLS credentials: %w", err)
+	}
+	server.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cfg.AdminTLSClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.AdminTLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read admin client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("admin client CA %s has no PEM certificates", cfg.AdminTLSClientCAFile)
+		}
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.AdminAPIToken != "" {
+			server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return server, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runChallenge handles "challenge issue": it issues an attestation challenge
+// in the shared challenge store, as POST /attestation/challenge would, for
+// testing a device or a client build by hand.
+func runChallenge(args []string) int {
+	if len(args) == 0 || args[0] != "issue" {
+		fmt.Fprintln(os.Stderr, "usage: auth-proxy challenge issue [-config file] <identifier>")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("challenge issue", flag.ContinueOnError)
+	configPath := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: auth-proxy challenge issue [-config file] <identifier>")
+		fmt.Fprintln(fs.Output(), "Prints a challenge for the identifier. It expires after ATTESTATION_CHALLENGE_TIMEOUT and can be used once.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, ok := loadConfig(*configPath)
+	if !ok {
+		return 1
+	}
+	verifier, closeVerifier, err := openSharedVerifier(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer closeVerifier()
+
+	challenge, err := verifier.GenerateChallenge(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to issue challenge: %v\n", err)
+		return 1
+	}
+	fmt.Println(challenge)
+	return 0
+}
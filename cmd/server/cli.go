@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/kacy/auth-proxy/internal/attestation"
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+	"github.com/kacy/auth-proxy/internal/config"
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+// run dispatches to a subcommand and returns the process exit code: 0 on
+// success, 1 when the command failed and 2 for usage errors. Without a
+// subcommand the proxy is served, so existing deployments keep working.
+func run(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+
+	switch args[0] {
+	case "serve":
+		return runServe(args[1:])
+	case "config":
+		return runConfig(args[1:])
+	case "challenge":
+		return runChallenge(args[1:])
+	case "keys":
+		return runKeys(args[1:])
+	case "doctor":
+		return runDoctor(args[1:])
+	case "verify":
+		return runVerify(args[1:])
+	case "version":
+		return runVersion(args[1:])
+	case "help":
+		usage(os.Stdout)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		return 2
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, `usage: auth-proxy <command> [flags]
+
+Commands:
+  serve                            Run the proxy (the default)
+  config validate                  Check the configuration and print the effective settings
+  challenge issue <identifier>     Issue an attestation challenge
+  keys list|revoke|export|import   Manage stored iOS App Attest keys
+  doctor                           Check the configuration and the services it points at
+  verify <audit log file>...       Check the hash chain of audit log files
+  version [-json]                  Print the version, revision and Go version of this build
+
+Commands read the same environment and config file as the proxy. Run
+"auth-proxy <command> -h" for a command's flags.
+`)
+}
+
+// runVersion prints the build info.
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the full build info, with dependency versions, as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	info := buildinfo.Get()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+		return 0
+	}
+	fmt.Printf("auth-proxy %s\n", info.Version)
+	fmt.Printf("  build time:         %s\n", orUnknown(info.BuildTime))
+	fmt.Printf("  revision:           %s\n", orUnknown(info.Revision))
+	fmt.Printf("  modified:           %t\n", info.Modified)
+	fmt.Printf("  go version:         %s\n", info.GoVersion)
+	fmt.Printf("  device-attestation: %s\n", orUnknown(info.DeviceAttestation()))
+	return 0
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// configFlag adds the -config flag, defaulting to CONFIG_FILE.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file (default $CONFIG_FILE)")
+}
+
+// loadConfig loads the configuration for a command, printing why it failed.
+func loadConfig(path string) (*config.Config, bool) {
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return nil, false
+	}
+	return cfg, true
+}
+
+func newRedisClient(cfg *config.Config) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     cfg.RedisAddr,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+	})
+}
+
+// newVerifier creates the attestation verifier. Its challenge and key stores
+// live in Redis when redisClient is set, and in memory otherwise.
+func newVerifier(cfg *config.Config, redisClient *redis.Client, logger *logging.Logger) (*attestation.Verifier, error) {
+	var redisConfig *attestation.RedisConfig
+	if redisClient != nil {
+		redisConfig = &attestation.RedisConfig{
+			Enabled:   true,
+			Addr:      cfg.RedisAddr,
+			Password:  cfg.RedisPassword,
+			DB:        cfg.RedisDB,
+			KeyPrefix: cfg.RedisKeyPrefix,
+			Client:    redisClient,
+		}
+	}
+
+	return attestation.NewVerifier(attestation.Config{
+		IOSEnabled:                  cfg.AttestationIOSEnabled,
+		AndroidEnabled:              cfg.AttestationAndroidEnabled,
+		IOSBundleID:                 cfg.AttestationIOSBundleID,
+		IOSTeamID:                   cfg.AttestationIOSTeamID,
+		AndroidPackageName:          cfg.AttestationAndroidPackage,
+		GCPProjectID:                cfg.AttestationGCPProjectID,
+		GCPCredentialsFile:          cfg.AttestationGCPCredentialsFile,
+		RequireStrongIntegrity:      cfg.AttestationRequireStrong,
+		ChallengeTimeout:            cfg.AttestationChallengeTimeout,
+		SkipCertificateVerification: cfg.AttestationSkipCertVerification,
+	}, redisConfig, logger)
+}
+
+// openSharedVerifier creates a verifier on the stores the running proxies
+// use. The in-memory stores belong to one proxy process, so commands that
+// read or change them need Redis.
+func openSharedVerifier(cfg *config.Config) (*attestation.Verifier, func(), error) {
+	if !cfg.RedisEnabled {
+		return nil, nil, fmt.Errorf("REDIS_ENABLED is false: without Redis, challenges and keys only live in each proxy's memory")
+	}
+	if !cfg.AttestationIOSEnabled && !cfg.AttestationAndroidEnabled {
+		return nil, nil, fmt.Errorf("attestation is not enabled")
+	}
+
+	redisClient := newRedisClient(cfg)
+	verifier, err := newVerifier(cfg, redisClient, &logging.Logger{Logger: zap.NewNop()})
+	if err != nil {
+		redisClient.Close()
+		return nil, nil, err
+	}
+	return verifier, func() {
+		verifier.Close()
+		redisClient.Close()
+	}, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/config"
+)
+
+// doctorTimeout bounds each network check.
+const doctorTimeout = 5 * time.Second
+
+// certExpiryWarning is how close to expiry a certificate gets a warning.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+var (
+	bundleIDPattern       = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+$`)
+	teamIDPattern         = regexp.MustCompile(`^[A-Z0-9]{10}$`)
+	androidPackagePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)+$`)
+)
+
+type checkStatus string
+
+const (
+	checkOK   checkStatus = "ok"
+	checkWarn checkStatus = "warn"
+	checkFail checkStatus = "FAIL"
+	checkSkip checkStatus = "skip"
+)
+
+type checkResult struct {
+	name   string
+	status checkStatus
+	detail string
+}
+
+// runDoctor checks the configuration and the services it points at, so a
+// deployment can be verified before it takes traffic.
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	configPath := configFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadFile(*configPath)
+	if err != nil {
+		printCheck(os.Stdout, checkResult{"config", checkFail, err.Error()})
+		return 1
+	}
+
+	results := []checkResult{{"config", checkOK, "valid"}}
+	results = append(results,
+		checkRedis(cfg),
+		checkGoTrue(cfg.GoTrueURL, cfg.GoTrueAnonKey, http.DefaultClient),
+		checkTLS(cfg, time.Now()),
+		checkGCPCredentials(cfg),
+		checkIOSIdentifiers(cfg),
+		checkAndroidPackage(cfg),
+	)
+
+	code := 0
+	for _, r := range results {
+		printCheck(os.Stdout, r)
+		if r.status == checkFail {
+			code = 1
+		}
+	}
+	return code
+}
+
+func printCheck(w io.Writer, r checkResult) {
+	fmt.Fprintf(w, "%-4s  %-20s %s\n", r.status, r.name, strings.ReplaceAll(r.detail, "\n", "; "))
+}
+
+func checkRedis(cfg *config.Config) checkResult {
+	if !cfg.RedisEnabled {
+		return checkResult{"redis", checkSkip, "REDIS_ENABLED is false"}
+	}
+	client := newRedisClient(cfg)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return checkResult{"redis", checkFail, fmt.Sprintf("ping %s: %v", cfg.RedisAddr, err)}
+	}
+	return checkResult{"redis", checkOK, "ping " + cfg.RedisAddr}
+}
+
+func checkGoTrue(baseURL, anonKey string, client *http.Client) checkResult {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	if err := pingGoTrue(ctx, client, baseURL, anonKey); err != nil {
+		return checkResult{"gotrue", checkFail, err.Error()}
+	}
+	return checkResult{"gotrue", checkOK, "GET " + strings.TrimRight(baseURL, "/") + "/auth/v1/health"}
+}
+
+func checkTLS(cfg *config.Config, now time.Time) checkResult {
+	if !cfg.TLSEnabled {
+		return checkResult{"tls", checkSkip, "TLS_ENABLED is false"}
+	}
+	pair, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return checkResult{"tls", checkFail, err.Error()}
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return checkResult{"tls", checkFail, err.Error()}
+	}
+	return certExpiry(leaf, now)
+}
+
+func certExpiry(cert *x509.Certificate, now time.Time) checkResult {
+	expires := cert.NotAfter.UTC().Format(time.RFC3339)
+	switch {
+	case now.After(cert.NotAfter):
+		return checkResult{"tls", checkFail, "certificate expired " + expires}
+	case now.Before(cert.NotBefore):
+		return checkResult{"tls", checkFail, "certificate is not valid until " + cert.NotBefore.UTC().Format(time.RFC3339)}
+	case cert.NotAfter.Sub(now) < certExpiryWarning:
+		return checkResult{"tls", checkWarn, "certificate expires " + expires}
+	}
+	return checkResult{"tls", checkOK, "certificate expires " + expires}
+}
+
+func checkGCPCredentials(cfg *config.Config) checkResult {
+	if !cfg.AttestationAndroidEnabled {
+		return checkResult{"gcp credentials", checkSkip, "ATTESTATION_ANDROID_ENABLED is false"}
+	}
+	if cfg.AttestationGCPCredentialsFile == "" {
+		return checkResult{"gcp credentials", checkOK, "using application default credentials"}
+	}
+	data, err := os.ReadFile(cfg.AttestationGCPCredentialsFile)
+	if err != nil {
+		return checkResult{"gcp credentials", checkFail, err.Error()}
+	}
+	if err := validateGCPCredentials(data); err != nil {
+		return checkResult{"gcp credentials", checkFail, fmt.Sprintf("%s: %v", cfg.AttestationGCPCredentialsFile, err)}
+	}
+	return checkResult{"gcp credentials", checkOK, cfg.AttestationGCPCredentialsFile}
+}
+
+// validateGCPCredentials checks that data is a service account key file.
+func validateGCPCredentials(data []byte) error {
+	var creds struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return fmt.Errorf("not a JSON key file: %w", err)
+	}
+	if creds.Type != "service_account" {
+		return fmt.Errorf("type is %q, want service_account", creds.Type)
+	}
+	if creds.ClientEmail == "" {
+		return fmt.Errorf("client_email is missing")
+	}
+	if !strings.Contains(creds.PrivateKey, "PRIVATE KEY") {
+		return fmt.Errorf("private_key is missing")
+	}
+	return nil
+}
+
+func checkIOSIdentifiers(cfg *config.Config) checkResult {
+	if !cfg.AttestationIOSEnabled {
+		return checkResult{"ios app", checkSkip, "ATTESTATION_IOS_ENABLED is false"}
+	}
+	var problems []string
+	if !bundleIDPattern.MatchString(cfg.AttestationIOSBundleID) {
+		problems = append(problems, fmt.Sprintf("ATTESTATION_IOS_BUNDLE_ID %q is not a bundle ID like com.example.app", cfg.AttestationIOSBundleID))
+	}
+	if !teamIDPattern.MatchString(cfg.AttestationIOSTeamID) {
+		problems = append(problems, fmt.Sprintf("ATTESTATION_IOS_TEAM_ID %q is not a 10 character team ID", cfg.AttestationIOSTeamID))
+	}
+	if len(problems) > 0 {
+		return checkResult{"ios app", checkFail, strings.Join(problems, "; ")}
+	}
+	return checkResult{"ios app", checkOK, cfg.AttestationIOSTeamID + "." + cfg.AttestationIOSBundleID}
+}
+
+func checkAndroidPackage(cfg *config.Config) checkResult {
+	if !cfg.AttestationAndroidEnabled {
+		return checkResult{"android app", checkSkip, "ATTESTATION_ANDROID_ENABLED is false"}
+	}
+	if !androidPackagePattern.MatchString(cfg.AttestationAndroidPackage) {
+		return checkResult{"android app", checkFail, fmt.Sprintf("ATTESTATION_ANDROID_PACKAGE %q is not a package name like com.example.app", cfg.AttestationAndroidPackage)}
+	}
+	return checkResult{"android app", checkOK, cfg.AttestationAndroidPackage}
+}
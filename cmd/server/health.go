@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/kacy/auth-proxy/internal/attestation"
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+	"github.com/kacy/auth-proxy/internal/config"
+	"github.com/kacy/auth-proxy/internal/health"
+)
+
+// readinessChecks builds the dependency checks behind /readyz. Redis is only
+// checked when it's enabled.
+func readinessChecks(cfg *config.Config, redisClient *redis.Client, verifier *attestation.Verifier) []health.Check {
+	critical := func(name string) bool {
+		return slices.Contains(cfg.HealthCriticalChecks, name)
+	}
+
+	var checks []health.Check
+	if redisClient != nil {
+		checks = append(checks, health.Check{
+			Name:     "redis",
+			Critical: critical("redis"),
+			Run: func(ctx context.Context) error {
+				return redisClient.Ping(ctx).Err()
+			},
+		})
+	}
+
+	client := &http.Client{}
+	checks = append(checks,
+		health.Check{
+			Name:     "gotrue",
+			Critical: critical("gotrue"),
+			Run: func(ctx context.Context) error {
+				return pingGoTrue(ctx, client, cfg.GoTrueURL, cfg.GoTrueAnonKey)
+			},
+		},
+		health.Check{
+			Name:     "attestation",
+			Critical: critical("attestation"),
+			Run:      verifier.Ready,
+		},
+	)
+	return checks
+}
+
+// pingGoTrue calls GoTrue's health endpoint with the anon key, which also
+// catches a key the API gateway rejects.
+func pingGoTrue(ctx context.Context, client *http.Client, baseURL, anonKey string) error {
+	url := strings.TrimRight(baseURL, "/") + "/auth/v1/health"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("apikey", anonKey)
+	req.Header.Set("User-Agent", buildinfo.UserAgent())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("GET %s: %s, check GOTRUE_ANON_KEY", url, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return nil
+}
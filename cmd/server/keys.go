@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/attestation"
+)
+
+const keysUsage = `usage: auth-proxy keys <command> [-config file] [args]
+
+Commands:
+  list [-json]                 List stored iOS App Attest keys
+  revoke <key id>...           Delete keys; those devices must attest again
+  export [-o file]             Write every key as JSON lines
+  import [file]                Store keys from an export (default stdin); existing keys are skipped
+`
+
+// runKeys manages the iOS App Attest key store shared by the proxies.
+func runKeys(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	configPath := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), keysUsage)
+		fs.PrintDefaults()
+	}
+
+	var cmd func(ctx context.Context, v *attestation.Verifier, fs *flag.FlagSet) int
+	switch args[0] {
+	case "list":
+		asJSON := fs.Bool("json", false, "print JSON lines")
+		cmd = func(ctx context.Context, v *attestation.Verifier, fs *flag.FlagSet) int {
+			return listKeys(ctx, v, *asJSON)
+		}
+	case "revoke":
+		cmd = revokeKeys
+	case "export":
+		output := fs.String("o", "", "write to this file instead of stdout")
+		cmd = func(ctx context.Context, v *attestation.Verifier, fs *flag.FlagSet) int {
+			return exportKeys(ctx, v, *output)
+		}
+	case "import":
+		cmd = importKeys
+	default:
+		fmt.Fprintf(os.Stderr, "unknown keys command %q\n\n", args[0])
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if args[0] == "revoke" && fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, ok := loadConfig(*configPath)
+	if !ok {
+		return 1
+	}
+	if !cfg.AttestationIOSEnabled {
+		fmt.Fprintln(os.Stderr, "ATTESTATION_IOS_ENABLED is false, there is no key store")
+		return 1
+	}
+	verifier, closeVerifier, err := openSharedVerifier(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer closeVerifier()
+
+	return cmd(context.Background(), verifier, fs)
+}
+
+func listKeys(ctx context.Context, v *attestation.Verifier, asJSON bool) int {
+	keys, err := v.ListKeys(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list keys: %v\n", err)
+		return 1
+	}
+
+	if asJSON {
+		return writeKeys(os.Stdout, keys)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY ID\tBUNDLE ID\tTEAM ID\tCOUNTER\tCREATED\tLAST USED")
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", k.KeyID, k.BundleID, k.TeamID, k.Counter, formatTime(k.CreatedAt), formatTime(k.LastUsedAt))
+	}
+	tw.Flush()
+	return 0
+}
+
+func revokeKeys(ctx context.Context, v *attestation.Verifier, fs *flag.FlagSet) int {
+	code := 0
+	for _, id := range fs.Args() {
+		if err := v.RevokeKey(ctx, id); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", id, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("revoked %s\n", id)
+	}
+	return code
+}
+
+func exportKeys(ctx context.Context, v *attestation.Verifier, output string) int {
+	keys, err := v.ListKeys(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list keys: %v\n", err)
+		return 1
+	}
+
+	if output == "" {
+		return writeKeys(os.Stdout, keys)
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if code := writeKeys(f, keys); code != 0 {
+		f.Close()
+		return code
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d keys to %s\n", len(keys), output)
+	return 0
+}
+
+func importKeys(ctx context.Context, v *attestation.Verifier, fs *flag.FlagSet) int {
+	in := io.Reader(os.Stdin)
+	if fs.NArg() > 0 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var imported, skipped, failed int
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec attestation.KeyRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			fmt.Fprintf(os.Stderr, "line %d: %v\n", line, err)
+			failed++
+			continue
+		}
+		err := v.ImportKey(ctx, rec)
+		switch {
+		case errors.Is(err, attestation.ErrKeyExists):
+			skipped++
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "line %d: %s: %v\n", line, rec.KeyID, err)
+			failed++
+		default:
+			imported++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("imported %d keys, skipped %d existing, %d failed\n", imported, skipped, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// writeKeys writes keys as JSON lines, the export format.
+func writeKeys(w io.Writer, keys []attestation.KeyRecord) int {
+	enc := json.NewEncoder(w)
+	for _, k := range keys {
+		if err := enc.Encode(k); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
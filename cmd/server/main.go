@@ -4,67 +4,139 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-
-	"github.com/kacy/auth-proxy/internal/attestation"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.uber.org/zap"
+
+	"github.com/kacy/auth-proxy/internal/admin"
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+	"github.com/kacy/auth-proxy/internal/clientip"
 	"github.com/kacy/auth-proxy/internal/config"
+	"github.com/kacy/auth-proxy/internal/devices"
+	"github.com/kacy/auth-proxy/internal/events"
+	"github.com/kacy/auth-proxy/internal/geoip"
+	"github.com/kacy/auth-proxy/internal/health"
+	"github.com/kacy/auth-proxy/internal/jwt"
 	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/metrics"
 	"github.com/kacy/auth-proxy/internal/middleware"
+	"github.com/kacy/auth-proxy/internal/passwords"
 	"github.com/kacy/auth-proxy/internal/proxy"
+	"github.com/kacy/auth-proxy/internal/proxyproto"
+	"github.com/kacy/auth-proxy/internal/requestid"
+	"github.com/kacy/auth-proxy/internal/revocation"
+	"github.com/kacy/auth-proxy/internal/store"
+	"github.com/kacy/auth-proxy/internal/tokenwrap"
+	"github.com/kacy/auth-proxy/internal/tracing"
+)
+
+// Set by the linker, see the Makefile.
+var (
+	version   string
+	buildTime string
 )
 
 func main() {
-	cfg, err := config.Load()
+	buildinfo.Set(version, buildTime)
+	os.Exit(run(os.Args[1:]))
+}
+
+// runServe runs the proxy until it's told to shut down.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	configPath := configFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: auth-proxy serve [-config file]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadFile(*configPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	logger, err := logging.New(cfg.LogLevel, cfg.IsProduction())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 	defer logger.Sync()
 
+	build := buildinfo.Get()
 	logger.Startup("starting auth-proxy HTTP service")
+	logger.Logger.Info(logging.EmojiConfig+" build info",
+		zap.String("version", build.Version),
+		zap.String("build_time", build.BuildTime),
+		zap.String("revision", build.Revision),
+		zap.Bool("modified", build.Modified),
+		zap.String("go_version", build.GoVersion),
+		zap.String("device_attestation", build.DeviceAttestation()))
+	if cfg.ConfigFile != "" {
+		logger.Logger.Info(logging.EmojiConfig+" configuration file loaded", zap.String("file", cfg.ConfigFile))
+	}
+
+	// Error bodies carry the underlying cause outside production
+	apierror.SetDebug(!cfg.IsProduction())
+
+	// Tracing goes first so Redis and upstream clients created below are
+	// instrumented
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.TracingEnabled {
+		shutdownTracing, err = tracing.Setup(context.Background(), tracing.Config{
+			Endpoint:    cfg.TracingEndpoint,
+			ServiceName: cfg.TracingServiceName,
+			SampleRatio: cfg.TracingSampleRatio,
+		})
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" failed to initialize tracing", zap.Error(err))
+			return 1
+		}
+		logger.Logger.Info(logging.EmojiConfig+" tracing enabled",
+			zap.Float64("sample_ratio", cfg.TracingSampleRatio))
+	}
+	// stage wraps a middleware in its own span when tracing is on
+	stage := func(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+		if !cfg.TracingEnabled {
+			return mw
+		}
+		return tracing.Stage(name, mw)
+	}
 
-	// Configure Redis if enabled (for distributed attestation state)
-	var redisConfig *attestation.RedisConfig
+	// Configure Redis if enabled (for distributed attestation and session state)
+	var redisClient *redis.Client
 	if cfg.RedisEnabled {
-		redisConfig = &attestation.RedisConfig{
-			Enabled:   true,
-			Addr:      cfg.RedisAddr,
-			Password:  cfg.RedisPassword,
-			DB:        cfg.RedisDB,
-			KeyPrefix: cfg.RedisKeyPrefix,
+		redisClient = newRedisClient(cfg)
+		defer redisClient.Close()
+		if cfg.TracingEnabled {
+			redisClient.AddHook(tracing.RedisHook{})
 		}
 		logger.Logger.Info(logging.EmojiDatabase + " redis enabled for attestation state")
 	}
 
 	// Initialize attestation verifier
-	attestationVerifier, err := attestation.NewVerifier(attestation.Config{
-		IOSEnabled:                  cfg.AttestationIOSEnabled,
-		AndroidEnabled:              cfg.AttestationAndroidEnabled,
-		IOSBundleID:                 cfg.AttestationIOSBundleID,
-		IOSTeamID:                   cfg.AttestationIOSTeamID,
-		AndroidPackageName:          cfg.AttestationAndroidPackage,
-		GCPProjectID:                cfg.AttestationGCPProjectID,
-		GCPCredentialsFile:          cfg.AttestationGCPCredentialsFile,
-		RequireStrongIntegrity:      cfg.AttestationRequireStrong,
-		ChallengeTimeout:            cfg.AttestationChallengeTimeout,
-		SkipCertificateVerification: cfg.AttestationSkipCertVerification,
-	}, redisConfig, logger)
+	attestationVerifier, err := newVerifier(cfg, redisClient, logger)
 	if err != nil {
 		logger.Logger.Error(logging.EmojiError + " failed to initialize attestation verifier")
-		os.Exit(1)
+		return 1
 	}
 	defer attestationVerifier.Close()
 
@@ -79,19 +151,263 @@ func main() {
 		logger.Logger.Info(logging.EmojiAuth + " app attestation disabled")
 	}
 
-	// Initialize HTTP metrics
+	// Initialize HTTP and application metrics
 	httpMetrics := middleware.NewHTTPMetrics()
+	appMetrics := metrics.New()
+	appMetrics.BuildInfo.WithLabelValues(build.Version, build.Revision, strconv.FormatBool(build.Modified),
+		build.GoVersion, build.DeviceAttestation()).Set(1)
 	logger.Logger.Info(logging.EmojiMetrics + " prometheus metrics initialized")
 
+	// Cookie sessions for web clients (backend-for-frontend mode)
+	sessionConfig := proxy.SessionConfig{
+		Enabled:       cfg.BFFEnabled,
+		CookieName:    cfg.BFFCookieName,
+		CookieDomain:  cfg.BFFCookieDomain,
+		SameSite:      parseSameSite(cfg.BFFCookieSameSite),
+		TTL:           cfg.BFFSessionTTL,
+		RefreshBefore: cfg.BFFRefreshBefore,
+	}
+	if cfg.BFFEnabled {
+		sessionConfig.Store = newStore(redisClient, cfg.RedisKeyPrefix+"session:")
+		defer sessionConfig.Store.Close()
+		logger.Logger.Info(logging.EmojiAuth + " cookie sessions enabled for web clients")
+	}
+
+	// Access token verification, shared by the checks that look at the bearer
+	verifierConfig := jwt.Config{
+		Secret: cfg.GoTrueJWTSecret,
+		Issuer: cfg.GoTrueJWTIssuer,
+		Leeway: 30 * time.Second,
+	}
+	if cfg.GoTrueJWKSURL != "" {
+		verifierConfig.JWKS = jwt.NewKeySet(cfg.GoTrueJWKSURL, nil, 0)
+	}
+	tokenVerifier, err := jwt.NewVerifier(verifierConfig)
+	if err != nil {
+		logger.Logger.Error(logging.EmojiError+" invalid token verifier configuration", zap.Error(err))
+		return 1
+	}
+
+	// DPoP sender-constrained sessions
+	dpopConfig := proxy.DPoPConfig{
+		Enabled:    cfg.DPoPEnabled,
+		MaxAge:     cfg.DPoPMaxAge,
+		Leeway:     cfg.DPoPLeeway,
+		BindingTTL: cfg.DPoPBindingTTL,
+	}
+	if cfg.DPoPEnabled {
+		dpopConfig.Store = newStore(redisClient, cfg.RedisKeyPrefix+"dpop:")
+		defer dpopConfig.Store.Close()
+		logger.Logger.Info(logging.EmojiAuth + " DPoP sender-constrained sessions enabled")
+	}
+
+	// Device-bound refresh token handles
+	var refreshWrapper *tokenwrap.Wrapper
+	if cfg.RefreshTokenWrapEnabled {
+		keys, err := tokenwrap.ParseKeys(cfg.RefreshTokenWrapKeys)
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid REFRESH_TOKEN_WRAP_KEYS", zap.Error(err))
+			return 1
+		}
+		wrapStore := newStore(redisClient, cfg.RedisKeyPrefix+"refresh:")
+		defer wrapStore.Close()
+		refreshWrapper, err = tokenwrap.New(tokenwrap.Config{
+			Keys:            keys,
+			Store:           wrapStore,
+			AcceptUnwrapped: cfg.RefreshTokenWrapAcceptUnwrapped,
+		})
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid refresh token wrapping configuration", zap.Error(err))
+			return 1
+		}
+		logger.Logger.Info(logging.EmojiAuth+" refresh token wrapping enabled",
+			zap.String("active_key", keys[0].ID))
+	}
+
+	// Device key to user bindings
+	var deviceRegistry *devices.Registry
+	if cfg.DeviceBindingEnabled {
+		action, err := devices.ParseAction(cfg.DeviceKeyMoveAction)
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid DEVICE_KEY_MOVE_ACTION", zap.Error(err))
+			return 1
+		}
+		deviceStore := newStore(redisClient, cfg.RedisKeyPrefix+"devices:")
+		defer deviceStore.Close()
+		deviceRegistry, err = devices.New(devices.Config{
+			Store:                deviceStore,
+			MaxDevicesPerUser:    cfg.DeviceMaxPerUser,
+			MaxAccountsPerDevice: cfg.DeviceMaxAccounts,
+			OnKeyMove:            action,
+			TTL:                  cfg.DeviceBindingTTL,
+		})
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid device binding configuration", zap.Error(err))
+			return 1
+		}
+		logger.Logger.Info(logging.EmojiAuth+" device binding enabled",
+			zap.Int("max_per_user", cfg.DeviceMaxPerUser),
+			zap.Int("max_accounts", cfg.DeviceMaxAccounts),
+			zap.String("key_move_action", cfg.DeviceKeyMoveAction))
+	}
+
+	// Revoked sessions
+	var revocationList *revocation.List
+	if cfg.RevocationEnabled {
+		revocationStore := newStore(redisClient, cfg.RedisKeyPrefix+"revoked:")
+		defer revocationStore.Close()
+		revocationList, err = revocation.New(revocation.Config{
+			Store:     revocationStore,
+			Retention: cfg.RevocationRetention,
+		})
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid session revocation configuration", zap.Error(err))
+			return 1
+		}
+		logger.Logger.Info(logging.EmojiAuth+" session revocation enabled",
+			zap.Duration("retention", cfg.RevocationRetention))
+	}
+
+	// Tamper-evident audit log of security decisions
+	var auditLog *audit.Log
+	if cfg.AuditLogFile != "" {
+		auditLog, err = audit.Open(audit.Config{
+			Path: cfg.AuditLogFile,
+			Key:  []byte(cfg.AuditLogKey),
+		}, logger)
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" failed to open audit log", zap.Error(err))
+			return 1
+		}
+		defer auditLog.Close()
+		logger.Logger.Info(logging.EmojiAuth+" audit log enabled",
+			zap.String("path", cfg.AuditLogFile))
+	}
+
+	// Auth event webhooks
+	var eventDispatcher *events.Dispatcher
+	if len(cfg.WebhookEndpoints) > 0 {
+		endpoints := make([]events.Endpoint, 0, len(cfg.WebhookEndpoints))
+		for _, entry := range cfg.WebhookEndpoints {
+			endpoint, err := events.ParseEndpoint(entry)
+			if err != nil {
+				logger.Logger.Error(logging.EmojiError+" invalid WEBHOOK_ENDPOINTS", zap.Error(err))
+				return 1
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		eventDispatcher, err = events.New(events.Config{
+			Endpoints:      endpoints,
+			Secret:         cfg.WebhookSecret,
+			QueueSize:      cfg.WebhookQueueSize,
+			Workers:        cfg.WebhookWorkers,
+			MaxAttempts:    cfg.WebhookMaxAttempts,
+			Backoff:        cfg.WebhookBackoff,
+			Timeout:        cfg.WebhookTimeout,
+			DeadLetterFile: cfg.WebhookDeadLetterFile,
+		}, appMetrics, logger)
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid webhook configuration", zap.Error(err))
+			return 1
+		}
+		logger.Logger.Info(logging.EmojiNetwork+" auth event webhooks enabled",
+			zap.Int("endpoints", len(endpoints)))
+	}
+
 	// Initialize reverse proxy
+	// GeoIP lookups, available to later handlers through the request context
+	var geoDB *geoip.DB
+	if cfg.GeoIPDatabaseFile != "" {
+		geoDB, err = geoip.Open(cfg.GeoIPDatabaseFile, cfg.GeoIPReloadInterval, func(err error) {
+			if err != nil {
+				logger.Logger.Error(logging.EmojiError+" failed to reload GeoIP database", zap.Error(err))
+				return
+			}
+			logger.Logger.Info(logging.EmojiNetwork + " GeoIP database reloaded")
+		})
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" failed to load GeoIP database", zap.Error(err))
+			return 1
+		}
+		defer geoDB.Close()
+		logger.Logger.Info(logging.EmojiNetwork+" GeoIP database loaded", zap.String("file", cfg.GeoIPDatabaseFile))
+	}
+
+	// Request policies, rebuilt when the configuration is reloaded
+	signupStore := newStore(redisClient, cfg.RedisKeyPrefix+"signup:")
+	defer signupStore.Close()
+	phoneStore := newStore(redisClient, cfg.RedisKeyPrefix+"sms:")
+	defer phoneStore.Close()
+	deps := policyDeps{
+		geo:           geoDB,
+		metrics:       appMetrics,
+		tokenVerifier: tokenVerifier,
+		signupStore:   signupStore,
+		phoneStore:    phoneStore,
+		logger:        logger,
+	}
+	initialPolicies, err := buildPolicies(cfg, deps)
+	if err != nil {
+		logger.Logger.Error(logging.EmojiError+" invalid request policy", zap.Error(err))
+		return 1
+	}
+	reloads := newReloader(cfg, initialPolicies, deps, refreshWrapper, logger)
+
+	if cfg.CORSEnabled {
+		logger.Logger.Info(logging.EmojiNetwork + " CORS enabled for browser clients")
+	}
+	if cfg.NetworkAccessEnabled {
+		logger.Logger.Info(logging.EmojiNetwork + " network access rules enabled")
+	}
+	if cfg.MFAEnforcementEnabled {
+		logger.Logger.Info(logging.EmojiAuth+" MFA step-up enforcement enabled",
+			zap.String("required_aal", cfg.MFARequiredAAL))
+	}
+	if cfg.SignupPolicyEnabled {
+		logger.Logger.Info(logging.EmojiEmail + " signup email policy enabled")
+	}
+	if cfg.PhonePolicyEnabled {
+		logger.Logger.Info(logging.EmojiAuth + " SMS pumping protection enabled")
+	}
+
 	authProxy, err := proxy.New(proxy.Config{
-		TargetURL: cfg.GoTrueURL,
-		AnonKey:   cfg.GoTrueAnonKey,
-		Timeout:   cfg.GoTrueTimeout,
-	}, logger, nil)
+		TargetURL:         cfg.GoTrueURL,
+		AnonKey:           cfg.GoTrueAnonKey,
+		Timeout:           cfg.GoTrueTimeout,
+		PublicURL:         cfg.PublicURL,
+		RedirectAllowList: cfg.RedirectAllowList,
+		Sessions:          sessionConfig,
+		DPoP:              dpopConfig,
+		RefreshTokens:     refreshWrapper,
+		Devices:           deviceRegistry,
+		Revocations:       revocationList,
+		Events:            eventDispatcher,
+		Enumeration: proxy.EnumerationConfig{
+			Enabled:    cfg.AntiEnumerationEnabled,
+			Routes:     cfg.AntiEnumerationRoutes,
+			MinLatency: cfg.AntiEnumerationMinLatency,
+			Jitter:     cfg.AntiEnumerationJitter,
+		},
+		Middleware: []func(http.Handler) http.Handler{
+			stage("revocation", middleware.NewRevocationMiddleware(revocationList, logger).Middleware),
+			stage("mfa", reloads.mfa.Middleware),
+		},
+	}, logger, appMetrics)
 	if err != nil {
 		logger.Logger.Error(logging.EmojiError + " failed to initialize proxy")
-		os.Exit(1)
+		return 1
+	}
+
+	if cfg.AntiEnumerationEnabled {
+		logger.Logger.Info(logging.EmojiAuth+" anti-enumeration responses enabled",
+			zap.Duration("min_latency", cfg.AntiEnumerationMinLatency))
+	}
+
+	// Resolve the real client IP behind trusted proxies
+	clientIPResolver, err := clientip.NewResolver(cfg.TrustedProxyCIDRs, cfg.TrustedProxyHeader)
+	if err != nil {
+		logger.Logger.Error(logging.EmojiError+" invalid trusted proxy configuration", zap.Error(err))
+		return 1
 	}
 
 	// Build middleware chain
@@ -111,28 +427,113 @@ func main() {
 		logger.Logger.Info(logging.EmojiAuth + " API key validation disabled")
 	}
 
-	attestationMiddleware := middleware.NewAttestationMiddleware(attestationVerifier, logger)
+	attestationMiddleware := middleware.NewAttestationMiddleware(attestationVerifier, eventDispatcher, logger)
+
+	// Breached-password check on signup and password change
+	var breachChecker *passwords.Checker
+	if cfg.BreachedPasswordCheckEnabled {
+		breachChecker = passwords.NewChecker(passwords.Config{
+			Endpoint: cfg.BreachedPasswordAPIURL,
+			Timeout:  cfg.BreachedPasswordTimeout,
+			CacheTTL: cfg.BreachedPasswordCacheTTL,
+			MinCount: cfg.BreachedPasswordMinCount,
+		})
+		logger.Logger.Info(logging.EmojiAuth+" breached password check enabled",
+			zap.Bool("fail_open", cfg.BreachedPasswordFailOpen))
+	}
+	passwordMiddleware := middleware.NewBreachedPasswordMiddleware(breachChecker, middleware.BreachedPasswordConfig{
+		FailOpen: cfg.BreachedPasswordFailOpen,
+	}, logger)
 
 	// Create router/mux
 	mux := http.NewServeMux()
 
-	// Health check endpoint (no auth required)
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/healthz", healthHandler)
+	// Health check endpoints (no auth required). /livez only says the
+	// process is up; /readyz checks dependencies and fails while draining.
+	readiness := health.New(health.Config{
+		Checks:   readinessChecks(cfg, redisClient, attestationVerifier),
+		Timeout:  cfg.HealthCheckTimeout,
+		CacheTTL: cfg.HealthCheckCacheTTL,
+	})
+	mux.HandleFunc("/health", healthHandler(readiness))
+	mux.HandleFunc("/healthz", healthHandler(readiness))
+	mux.HandleFunc("GET /livez", health.LiveHandler)
+	mux.HandleFunc("GET /readyz", readiness.ReadyHandler)
+
+	// Which build is running, for checking a rollout across clusters
+	mux.HandleFunc("GET /version", buildinfo.Handler)
+
+	// Operator API (own bearer token, no API key or attestation). With an
+	// admin port it moves there, next to pprof and the runtime controls.
+	var adminServer *http.Server
+	if cfg.AdminPort != 0 {
+		adminHandler := admin.NewHandler(admin.Config{
+			Token:         cfg.AdminAPIToken,
+			Devices:       deviceRegistry,
+			Revocations:   revocationList,
+			RefreshTokens: refreshWrapper,
+			Runtime: &admin.Runtime{
+				Settings:    reloads.settings,
+				Attestation: attestationVerifier,
+				Readiness:   readiness,
+			},
+		}, logger)
+		var h http.Handler = adminHandler
+		h = loggingMiddleware.Middleware(h)
+		h = auditLog.Middleware(h)
+		h = clientIPResolver.Middleware(h)
+		h = requestid.Middleware(h)
+		adminServer, err = newAdminServer(cfg, h)
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid admin listener configuration", zap.Error(err))
+			return 1
+		}
+		logger.Logger.Info(logging.EmojiConfig+" admin listener enabled",
+			zap.Int("port", cfg.AdminPort),
+			zap.Bool("tls", cfg.AdminTLSCertFile != ""),
+			zap.Bool("client_certificates", cfg.AdminTLSClientCAFile != ""))
+	} else if cfg.AdminAPIToken != "" {
+		mux.Handle("/admin/", admin.NewHandler(admin.Config{
+			Token:         cfg.AdminAPIToken,
+			Devices:       deviceRegistry,
+			Revocations:   revocationList,
+			RefreshTokens: refreshWrapper,
+		}, logger))
+		logger.Logger.Info(logging.EmojiConfig + " admin API enabled")
+	}
 
 	// Challenge endpoint for attestation (requires API key but not attestation)
 	mux.HandleFunc("/attestation/challenge", middleware.ChallengeHandler(attestationVerifier, logger))
 
 	// All other requests go to the proxy with attestation middleware
-	proxyHandler := attestationMiddleware.Middleware(authProxy)
+	var proxyHandler http.Handler = authProxy
+	proxyHandler = stage("password", passwordMiddleware.Middleware)(proxyHandler)
+	proxyHandler = stage("phone", reloads.phone.Middleware)(proxyHandler)
+	proxyHandler = stage("signup", reloads.signup.Middleware)(proxyHandler)
+	proxyHandler = stage("enumeration", authProxy.EnumerationMiddleware)(proxyHandler)
+	proxyHandler = stage("attestation", attestationMiddleware.Middleware)(proxyHandler)
 	mux.Handle("/", proxyHandler)
 
-	// Apply global middleware: metrics -> logging -> apikey -> handler
-	// Order matters: outermost (metrics) runs first, innermost (handler) runs last
+	// Apply global middleware: tracing -> requestid -> clientip -> audit -> metrics -> logging -> cors -> network -> apikey -> handler
+	// Order matters: outermost (tracing) runs first, innermost (handler) runs last
 	var handler http.Handler = mux
-	handler = apiKeyMiddleware.Middleware(handler)
+	handler = stage("api_key", apiKeyMiddleware.Middleware)(handler)
+	handler = stage("network", reloads.network.Middleware)(handler)
+	handler = stage("cors", reloads.cors.Middleware)(handler)
 	handler = loggingMiddleware.Middleware(handler)
 	handler = httpMetrics.Middleware(handler)
+	handler = auditLog.Middleware(handler)
+	handler = clientIPResolver.Middleware(handler)
+	handler = requestid.Middleware(handler)
+	if cfg.TracingEnabled {
+		// Continues the caller's trace from traceparent, or starts one. Span
+		// names stay low-cardinality; the path is an attribute.
+		handler = otelhttp.NewHandler(handler, "auth-proxy",
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return r.Method
+			}),
+		)
+	}
 
 	// Create main HTTP server
 	server := &http.Server{
@@ -148,7 +549,7 @@ func main() {
 		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
 		if err != nil {
 			logger.Logger.Error(logging.EmojiError + " failed to load TLS credentials")
-			os.Exit(1)
+			return 1
 		}
 		server.TLSConfig = &tls.Config{
 			Certificates: []tls.Certificate{cert},
@@ -157,10 +558,32 @@ func main() {
 		logger.Logger.Info(logging.EmojiAuth + " TLS enabled")
 	}
 
+	// Listen up front so PROXY protocol can wrap the socket for both plain
+	// and TLS serving
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		logger.Logger.Error(logging.EmojiError+" failed to listen", zap.Error(err))
+		return 1
+	}
+	if cfg.ProxyProtocolEnabled {
+		listener, err = proxyproto.NewListener(listener, proxyproto.Config{
+			TrustedSources:    cfg.ProxyProtocolTrustedCIDRs,
+			ReadHeaderTimeout: cfg.ProxyProtocolHeaderTimeout,
+		})
+		if err != nil {
+			logger.Logger.Error(logging.EmojiError+" invalid PROXY protocol configuration", zap.Error(err))
+			return 1
+		}
+		logger.Logger.Info(logging.EmojiNetwork + " PROXY protocol enabled")
+	}
+
 	// Create metrics server
 	metricsServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.MetricsPort),
-		Handler: promhttp.Handler(),
+		Addr: fmt.Sprintf(":%d", cfg.MetricsPort),
+		// OpenMetrics carries the trace exemplars on latency histograms
+		Handler: promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+		),
 	}
 
 	// Graceful shutdown handling
@@ -175,14 +598,30 @@ func main() {
 		}
 	}()
 
+	// Start admin server
+	if adminServer != nil {
+		go func() {
+			logger.Startup(fmt.Sprintf("admin server starting on port %d", cfg.AdminPort))
+			var err error
+			if adminServer.TLSConfig != nil {
+				err = adminServer.ListenAndServeTLS("", "")
+			} else {
+				err = adminServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				logger.Logger.Error(logging.EmojiError+" admin server error", zap.Error(err))
+			}
+		}()
+	}
+
 	// Start main HTTP server
 	go func() {
 		logger.Startup(fmt.Sprintf("HTTP proxy server starting on port %d", cfg.HTTPPort))
 		var err error
 		if cfg.TLSEnabled {
-			err = server.ListenAndServeTLS("", "")
+			err = server.ServeTLS(listener, "", "")
 		} else {
-			err = server.ListenAndServe()
+			err = server.Serve(listener)
 		}
 		if err != nil && err != http.ErrServerClosed {
 			logger.Logger.Error(logging.EmojiError + " HTTP server error")
@@ -190,12 +629,25 @@ func main() {
 		}
 	}()
 
+	// Apply policy, log level and key changes without a restart
+	stopReloads := make(chan struct{})
+	go reloads.watch(stopReloads)
+
 	logger.Startup("auth-proxy HTTP service started successfully")
 
 	// Wait for shutdown signal
 	<-shutdown
 
 	logger.Shutdown("shutting down...")
+	close(stopReloads)
+
+	// Fail readiness first and give load balancers time to notice before
+	// the server stops accepting connections
+	readiness.Drain()
+	if cfg.ShutdownDrainDelay > 0 {
+		logger.Shutdown(fmt.Sprintf("readiness failing, waiting %s for traffic to drain", cfg.ShutdownDrainDelay))
+		time.Sleep(cfg.ShutdownDrainDelay)
+	}
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -208,18 +660,115 @@ func main() {
 		logger.Logger.Info(logging.EmojiSuccess + " HTTP server stopped gracefully")
 	}
 
+	// Flush queued webhook deliveries
+	if err := eventDispatcher.Close(ctx); err != nil {
+		logger.Logger.Error(logging.EmojiError+" webhook deliveries still pending at shutdown", zap.Error(err))
+	}
+
+	// Flush buffered spans
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Logger.Error(logging.EmojiError+" failed to flush traces", zap.Error(err))
+	}
+
 	// Shutdown metrics server
 	if err := metricsServer.Shutdown(ctx); err != nil {
 		logger.Logger.Error(logging.EmojiError + " error shutting down metrics server")
 	}
 
+	// Shutdown admin server
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			logger.Logger.Error(logging.EmojiError + " error shutting down admin server")
+		}
+	}
+
 	logger.Shutdown("done")
+	return 0
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "healthy",
-	})
+// newStore returns a Redis-backed store when Redis is enabled, otherwise an
+// in-memory one.
+func newStore(client *redis.Client, prefix string) store.Store {
+	if client != nil {
+		return store.NewRedis(client, prefix)
+	}
+	return store.NewMemory()
+}
+
+// corsRoutes merges per-route method and header overrides into CORS routes.
+func corsRoutes(methods, headers map[string][]string) []middleware.CORSRoute {
+	byPath := make(map[string]*middleware.CORSRoute)
+	var routes []middleware.CORSRoute
+
+	for path, list := range methods {
+		byPath[path] = &middleware.CORSRoute{PathPrefix: path, Methods: list}
+	}
+	for path, list := range headers {
+		if route, ok := byPath[path]; ok {
+			route.Headers = list
+			continue
+		}
+		byPath[path] = &middleware.CORSRoute{PathPrefix: path, Headers: list}
+	}
+	for _, route := range byPath {
+		routes = append(routes, *route)
+	}
+	return routes
+}
+
+// networkRoutes merges the per-route CIDR and country lists into network
+// access routes.
+func networkRoutes(cfg *config.Config) []middleware.NetworkRoute {
+	byPath := make(map[string]*middleware.NetworkRoute)
+	route := func(path string) *middleware.NetworkRoute {
+		if r, ok := byPath[path]; ok {
+			return r
+		}
+		byPath[path] = &middleware.NetworkRoute{PathPrefix: path}
+		return byPath[path]
+	}
+
+	for path, list := range cfg.NetworkRouteAllowCIDRs {
+		route(path).AllowCIDRs = list
+	}
+	for path, list := range cfg.NetworkRouteDenyCIDRs {
+		route(path).DenyCIDRs = list
+	}
+	for path, list := range cfg.NetworkRouteAllowCountries {
+		route(path).AllowCountries = list
+	}
+	for path, list := range cfg.NetworkRouteDenyCountries {
+		route(path).DenyCountries = list
+	}
+
+	var routes []middleware.NetworkRoute
+	for _, r := range byPath {
+		routes = append(routes, *r)
+	}
+	return routes
+}
+
+func parseSameSite(s string) http.SameSite {
+	switch strings.ToLower(s) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func healthHandler(readiness *health.Readiness) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, body := http.StatusOK, "healthy"
+		if readiness.Draining() {
+			status, body = http.StatusServiceUnavailable, "draining"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": body,
+		})
+	}
 }
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/kacy/auth-proxy/internal/config"
+	"github.com/kacy/auth-proxy/internal/geoip"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/metrics"
+	"github.com/kacy/auth-proxy/internal/middleware"
+	"github.com/kacy/auth-proxy/internal/phone"
+	"github.com/kacy/auth-proxy/internal/signup"
+	"github.com/kacy/auth-proxy/internal/store"
+	"github.com/kacy/auth-proxy/internal/tokenwrap"
+)
+
+// policyDeps are the long-lived pieces the request policies are built on.
+// They're shared across reloads so rate limit counters and alias records
+// survive a policy change.
+type policyDeps struct {
+	geo           *geoip.DB
+	metrics       *metrics.Metrics
+	tokenVerifier *jwt.Verifier
+	signupStore   store.Store
+	phoneStore    store.Store
+	logger        *logging.Logger
+}
+
+// policies are the request policies that can be rebuilt on reload.
+type policies struct {
+	cors    func(http.Handler) http.Handler
+	network func(http.Handler) http.Handler
+	mfa     func(http.Handler) http.Handler
+	signup  func(http.Handler) http.Handler
+	phone   func(http.Handler) http.Handler
+}
+
+// buildPolicies builds the request policies from cfg.
+func buildPolicies(cfg *config.Config, deps policyDeps) (*policies, error) {
+	corsMiddleware, err := middleware.NewCORSMiddleware(middleware.CORSConfig{
+		Enabled:          cfg.CORSEnabled,
+		AllowedOrigins:   cfg.CORSAllowedOrigins,
+		AllowedMethods:   cfg.CORSAllowedMethods,
+		AllowedHeaders:   cfg.CORSAllowedHeaders,
+		ExposedHeaders:   cfg.CORSExposedHeaders,
+		AllowCredentials: cfg.CORSAllowCredentials,
+		MaxAge:           cfg.CORSMaxAge,
+		Routes:           corsRoutes(cfg.CORSRouteMethods, cfg.CORSRouteHeaders),
+	}, deps.logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CORS configuration: %w", err)
+	}
+
+	// IP and country access rules
+	networkMiddleware, err := middleware.NewNetworkAccessMiddleware(middleware.NetworkAccessConfig{
+		Enabled: cfg.NetworkAccessEnabled,
+		NetworkRule: middleware.NetworkRule{
+			AllowCIDRs:     cfg.NetworkAllowCIDRs,
+			DenyCIDRs:      cfg.NetworkDenyCIDRs,
+			AllowCountries: cfg.NetworkAllowCountries,
+			DenyCountries:  cfg.NetworkDenyCountries,
+		},
+		Routes: networkRoutes(cfg),
+	}, deps.geo, deps.metrics, deps.logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network access rules: %w", err)
+	}
+
+	// MFA step-up on sensitive routes
+	var mfaRoutes []middleware.MFARoute
+	for _, s := range cfg.MFARequiredRoutes {
+		route, err := middleware.ParseMFARoute(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MFA_REQUIRED_ROUTES: %w", err)
+		}
+		mfaRoutes = append(mfaRoutes, route)
+	}
+	mfaMiddleware, err := middleware.NewMFAMiddleware(middleware.MFAConfig{
+		Enabled:         cfg.MFAEnforcementEnabled,
+		Routes:          mfaRoutes,
+		RequiredAAL:     cfg.MFARequiredAAL,
+		AllowUnenrolled: cfg.MFAAllowUnenrolled,
+		GoTrueURL:       cfg.GoTrueURL,
+		AnonKey:         cfg.GoTrueAnonKey,
+	}, deps.tokenVerifier, deps.logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MFA configuration: %w", err)
+	}
+
+	// Signup email policy (domain allow/deny lists, disposable emails, aliases)
+	var signupPolicy *signup.Policy
+	if cfg.SignupPolicyEnabled {
+		signupConfig := signup.Config{
+			AllowedDomains:    cfg.SignupAllowedDomains,
+			DeniedDomains:     cfg.SignupDeniedDomains,
+			BlockDisposable:   cfg.SignupBlockDisposable,
+			DisposableFile:    cfg.SignupDisposableFile,
+			ReplaceDisposable: cfg.SignupDisposableReplace,
+			DetectAliases:     cfg.SignupDetectEmailAliases,
+			AliasRetention:    cfg.SignupAliasRetention,
+		}
+		if cfg.SignupDetectEmailAliases {
+			signupConfig.Store = deps.signupStore
+		}
+		signupPolicy, err = signup.New(signupConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signup policy: %w", err)
+		}
+	}
+
+	// SMS pumping protection on endpoints that send SMS
+	var phonePolicy *phone.Policy
+	if cfg.PhonePolicyEnabled {
+		phonePolicy, err = phone.New(phone.Config{
+			AllowedCountryCodes: cfg.PhoneAllowedCountryCodes,
+			BlockPremium:        cfg.PhoneBlockPremium,
+			PremiumPrefixFile:   cfg.PhonePremiumPrefixesFile,
+			PerNumberLimit:      cfg.PhoneLimitPerNumber,
+			PerPrefixLimit:      cfg.PhoneLimitPerPrefix,
+			PerIPLimit:          cfg.PhoneLimitPerIP,
+			Window:              cfg.PhoneLimitWindow,
+			PrefixDigits:        cfg.PhonePrefixDigits,
+			Store:               deps.phoneStore,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("invalid phone policy: %w", err)
+		}
+	}
+
+	return &policies{
+		cors:    corsMiddleware.Middleware,
+		network: networkMiddleware.Middleware,
+		mfa:     mfaMiddleware.Middleware,
+		signup:  middleware.NewSignupPolicyMiddleware(signupPolicy, deps.logger).Middleware,
+		phone:   middleware.NewPhonePolicyMiddleware(phonePolicy, deps.logger).Middleware,
+	}, nil
+}
+
+// reloader applies config changes to the running server. Only the request
+// policies, the log level and the refresh token wrapping keys are reloaded;
+// other changes are logged and wait for a restart.
+type reloader struct {
+	mu      sync.Mutex
+	cfg     *config.Config
+	deps    policyDeps
+	cors    *middleware.ReloadableMiddleware
+	network *middleware.ReloadableMiddleware
+	mfa     *middleware.ReloadableMiddleware
+	signup  *middleware.ReloadableMiddleware
+	phone   *middleware.ReloadableMiddleware
+	wrapper *tokenwrap.Wrapper
+	logger  *logging.Logger
+}
+
+func newReloader(cfg *config.Config, p *policies, deps policyDeps, wrapper *tokenwrap.Wrapper, logger *logging.Logger) *reloader {
+	return &reloader{
+		cfg:     cfg,
+		deps:    deps,
+		cors:    middleware.NewReloadableMiddleware(p.cors),
+		network: middleware.NewReloadableMiddleware(p.network),
+		mfa:     middleware.NewReloadableMiddleware(p.mfa),
+		signup:  middleware.NewReloadableMiddleware(p.signup),
+		phone:   middleware.NewReloadableMiddleware(p.phone),
+		wrapper: wrapper,
+		logger:  logger,
+	}
+}
+
+// reload reads the configuration again and applies what changed. Nothing is
+// applied unless the whole configuration is valid.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.LoadFile(r.cfg.ConfigFile)
+	if err != nil {
+		return err
+	}
+	changed, restart := r.cfg.Changed(next)
+	if len(restart) > 0 {
+		r.logger.Logger.Warn(logging.EmojiWarning+" changed settings need a restart to take effect",
+			zap.Strings("settings", restart))
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	merged := r.cfg.Reloaded(next)
+	p, err := buildPolicies(merged, r.deps)
+	if err != nil {
+		return err
+	}
+	if r.wrapper != nil {
+		keys, err := tokenwrap.ParseKeys(merged.RefreshTokenWrapKeys)
+		if err != nil {
+			return fmt.Errorf("invalid REFRESH_TOKEN_WRAP_KEYS: %w", err)
+		}
+		if err := r.wrapper.SetKeys(keys); err != nil {
+			return fmt.Errorf("invalid REFRESH_TOKEN_WRAP_KEYS: %w", err)
+		}
+	}
+	if err := r.logger.SetLevel(merged.LogLevel); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL: %w", err)
+	}
+
+	r.cors.Set(p.cors)
+	r.network.Set(p.network)
+	r.mfa.Set(p.mfa)
+	r.signup.Set(p.signup)
+	r.phone.Set(p.phone)
+	r.cfg = merged
+
+	r.logger.Logger.Info(logging.EmojiConfig+" configuration reloaded", zap.Strings("settings", changed))
+	return nil
+}
+
+// settings returns the settings currently in effect.
+func (r *reloader) settings() []config.Setting {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg.Settings()
+}
+
+// watch reloads on SIGHUP, and when the config file changes if there is one,
+// until stop is closed.
+func (r *reloader) watch(stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	r.mu.Lock()
+	path, interval := r.cfg.ConfigFile, r.cfg.ConfigWatchInterval
+	r.mu.Unlock()
+
+	var tick <-chan time.Time
+	var lastMod time.Time
+	if path != "" && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		lastMod = modTime(path)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			r.logger.Logger.Info(logging.EmojiConfig + " SIGHUP received, reloading configuration")
+		case <-tick:
+			mod := modTime(path)
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+			r.logger.Logger.Info(logging.EmojiConfig+" config file changed, reloading configuration",
+				zap.String("file", path))
+		}
+
+		if err := r.reload(); err != nil {
+			r.logger.Logger.Error(logging.EmojiError+" configuration reload failed, keeping the current configuration", zap.Error(err))
+		}
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
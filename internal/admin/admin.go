@@ -0,0 +1,218 @@
+// Package admin serves the proxy's operator API under /admin/v1 and, on the
+// admin listener, pprof and runtime controls. Every request must carry the
+// admin token as a bearer token or come with a verified client certificate.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/devices"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/revocation"
+	"github.com/kacy/auth-proxy/internal/tokenwrap"
+	"go.uber.org/zap"
+)
+
+// Config holds configuration for the admin API.
+type Config struct {
+	// Token authenticates operators.
+	Token string
+	// Devices serves the device binding endpoints; nil disables them.
+	Devices *devices.Registry
+	// Revocations serves the revocation endpoints; nil disables them.
+	Revocations *revocation.List
+	// RefreshTokens revokes the wrapped refresh tokens of a removed device;
+	// nil skips it.
+	RefreshTokens *tokenwrap.Wrapper
+	// Runtime serves pprof and the runtime endpoints under /admin; nil
+	// disables them. Only set it on the admin listener.
+	Runtime *Runtime
+}
+
+// Handler serves the admin API.
+type Handler struct {
+	token         []byte
+	devices       *devices.Registry
+	revocations   *revocation.List
+	refreshTokens *tokenwrap.Wrapper
+	runtime       *Runtime
+	logger        *logging.Logger
+	mux           *http.ServeMux
+}
+
+// NewHandler creates the admin API handler.
+func NewHandler(cfg Config, logger *logging.Logger) *Handler {
+	h := &Handler{
+		token:         []byte(cfg.Token),
+		devices:       cfg.Devices,
+		revocations:   cfg.Revocations,
+		refreshTokens: cfg.RefreshTokens,
+		runtime:       cfg.Runtime,
+		logger:        logger,
+		mux:           http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /admin/v1/users/{user_id}/devices", h.listDevices)
+	h.mux.HandleFunc("DELETE /admin/v1/users/{user_id}/devices/{key_id}", h.removeDevice)
+	h.mux.HandleFunc("POST /admin/v1/users/{user_id}/revoke", h.revokeUser)
+	h.mux.HandleFunc("POST /admin/v1/sessions/{session_id}/revoke", h.revokeSession)
+	h.mux.HandleFunc("POST /admin/v1/revoke", h.revokeAll)
+	if h.runtime != nil {
+		h.registerRuntime()
+	}
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		h.logger.For(r.Context()).AuthWarning("rejected admin request",
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("client_ip", clientip.FromRequest(r)),
+		)
+		audit.Report(r, audit.Entry{Action: "admin_auth", Decision: audit.DecisionDeny, Reason: "invalid_admin_token"})
+		apierror.Write(w, r, apierror.ErrUnauthorized)
+		return
+	}
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// authorized reports whether r carries the admin token or came over TLS with
+// a client certificate the listener verified against its client CA.
+func (h *Handler) authorized(r *http.Request) bool {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	token, ok := jwt.BearerToken(r.Header.Get("Authorization"))
+	return ok && len(h.token) > 0 && subtle.ConstantTimeCompare([]byte(token), h.token) == 1
+}
+
+func (h *Handler) listDevices(w http.ResponseWriter, r *http.Request) {
+	if h.devices == nil {
+		apierror.Write(w, r, apierror.ErrNotEnabled.WithMessage("Device binding is not enabled"))
+		return
+	}
+
+	userID := r.PathValue("user_id")
+	list, err := h.devices.Devices(r.Context(), userID)
+	if err != nil {
+		h.logger.For(r.Context()).DatabaseError("failed to load devices", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrStore.WithMessage("Failed to load devices").WithCause(err))
+		return
+	}
+	if list == nil {
+		list = []devices.Device{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id": userID,
+		"devices": list,
+	})
+}
+
+func (h *Handler) removeDevice(w http.ResponseWriter, r *http.Request) {
+	if h.devices == nil && h.refreshTokens == nil {
+		apierror.Write(w, r, apierror.ErrNotEnabled.WithMessage("Device binding is not enabled"))
+		return
+	}
+
+	userID := r.PathValue("user_id")
+	keyID := r.PathValue("key_id")
+	if h.devices != nil {
+		if err := h.devices.Remove(r.Context(), userID, keyID); err != nil {
+			h.logger.For(r.Context()).DatabaseError("failed to remove device", zap.Error(err))
+			apierror.Write(w, r, apierror.ErrStore.WithMessage("Failed to remove device").WithCause(err))
+			return
+		}
+	}
+	// A removed device must not keep refreshing from the handles it holds
+	if h.refreshTokens != nil {
+		if err := h.refreshTokens.RevokeDevice(r.Context(), keyID); err != nil {
+			h.logger.For(r.Context()).DatabaseError("failed to revoke device refresh tokens", zap.Error(err))
+			apierror.Write(w, r, apierror.ErrStore.WithMessage("Failed to revoke device refresh tokens").WithCause(err))
+			return
+		}
+	}
+
+	audit.Report(r, audit.Entry{
+		Action:   "admin_remove_device",
+		Decision: audit.DecisionChange,
+		UserID:   userID,
+		KeyID:    keyID,
+	})
+	h.logger.For(r.Context()).AuthSuccess("admin removed device binding",
+		zap.String("user_id", logging.MaskUserID(userID)),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) revokeUser(w http.ResponseWriter, r *http.Request) {
+	if h.revocations == nil {
+		apierror.Write(w, r, apierror.ErrNotEnabled.WithMessage("Session revocation is not enabled"))
+		return
+	}
+
+	userID := r.PathValue("user_id")
+	if err := h.revocations.RevokeUser(r.Context(), userID, ""); err != nil {
+		h.logger.For(r.Context()).DatabaseError("failed to revoke user sessions", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrStore.WithMessage("Failed to revoke sessions").WithCause(err))
+		return
+	}
+
+	audit.Report(r, audit.Entry{Action: "admin_revoke_user", Decision: audit.DecisionRevoke, UserID: userID})
+	h.logger.For(r.Context()).AuthSuccess("admin revoked all sessions for user",
+		zap.String("user_id", logging.MaskUserID(userID)),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	if h.revocations == nil {
+		apierror.Write(w, r, apierror.ErrNotEnabled.WithMessage("Session revocation is not enabled"))
+		return
+	}
+
+	if err := h.revocations.RevokeSession(r.Context(), r.PathValue("session_id"), time.Time{}); err != nil {
+		h.logger.For(r.Context()).DatabaseError("failed to revoke session", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrStore.WithMessage("Failed to revoke session").WithCause(err))
+		return
+	}
+
+	audit.Report(r, audit.Entry{Action: "admin_revoke_session", Decision: audit.DecisionRevoke})
+	h.logger.For(r.Context()).AuthSuccess("admin revoked session")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) revokeAll(w http.ResponseWriter, r *http.Request) {
+	if h.revocations == nil {
+		apierror.Write(w, r, apierror.ErrNotEnabled.WithMessage("Session revocation is not enabled"))
+		return
+	}
+
+	if err := h.revocations.RevokeAll(r.Context()); err != nil {
+		h.logger.For(r.Context()).DatabaseError("failed to revoke all sessions", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrStore.WithMessage("Failed to revoke sessions").WithCause(err))
+		return
+	}
+
+	audit.Report(r, audit.Entry{Action: "admin_revoke_all", Decision: audit.DecisionRevoke})
+	h.logger.For(r.Context()).AuthWarning("admin revoked every session",
+		zap.String("client_ip", clientip.FromRequest(r)),
+	)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
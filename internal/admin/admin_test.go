@@ -0,0 +1,183 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/devices"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/revocation"
+	"github.com/kacy/auth-proxy/internal/store"
+	"github.com/kacy/auth-proxy/internal/tokenwrap"
+)
+
+const testToken = "admin-secret"
+
+func newTestHandler(t *testing.T) (*Handler, *devices.Registry) {
+	t.Helper()
+	s := store.NewMemory()
+	t.Cleanup(func() { s.Close() })
+	registry, err := devices.New(devices.Config{Store: s})
+	if err != nil {
+		t.Fatalf("devices.New() error = %v", err)
+	}
+	logger, _ := logging.New("error", false)
+	return NewHandler(Config{Token: testToken, Devices: registry}, logger), registry
+}
+
+func do(h http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminAuth(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "guess", http.StatusUnauthorized},
+		{"valid token", testToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(h, http.MethodGet, "/admin/v1/users/u1/devices", tt.token)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+
+	empty := NewHandler(Config{}, h.logger)
+	if rec := do(empty, http.MethodGet, "/admin/v1/users/u1/devices", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("handler without a token: status = %d, want 401", rec.Code)
+	}
+}
+
+func TestAdminRevocation(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	list, _ := revocation.New(revocation.Config{Store: s})
+	logger, _ := logging.New("error", false)
+	h := NewHandler(Config{Token: testToken, Revocations: list}, logger)
+
+	signedIn := time.Now().Add(-time.Minute).Unix()
+	tests := []struct {
+		name    string
+		path    string
+		claims  jwt.Claims
+		revoked bool
+	}{
+		{"session", "/admin/v1/sessions/s1/revoke", jwt.Claims{Subject: "u1", SessionID: "s1", IssuedAt: signedIn}, true},
+		{"user", "/admin/v1/users/u2/revoke", jwt.Claims{Subject: "u2", SessionID: "s2", IssuedAt: signedIn}, true},
+		{"all", "/admin/v1/revoke", jwt.Claims{Subject: "u3", SessionID: "s3", IssuedAt: signedIn}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := list.Check(context.Background(), &tt.claims); err != nil {
+				t.Fatalf("Check() before revoke = %v", err)
+			}
+			if rec := do(h, http.MethodPost, tt.path, testToken); rec.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want 204", rec.Code)
+			}
+			if err := list.Check(context.Background(), &tt.claims); errors.Is(err, revocation.ErrRevoked) != tt.revoked {
+				t.Errorf("Check() after revoke = %v, want revoked = %v", err, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestAdminDevices(t *testing.T) {
+	h, registry := newTestHandler(t)
+	ctx := context.Background()
+	registry.Record(ctx, "u1", "key-1", "ios")
+	registry.Record(ctx, "u1", "key-2", "android")
+
+	rec := do(h, http.MethodGet, "/admin/v1/users/u1/devices", testToken)
+	var body struct {
+		UserID  string           `json:"user_id"`
+		Devices []devices.Device `json:"devices"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if body.UserID != "u1" || len(body.Devices) != 2 {
+		t.Fatalf("body = %+v, want two devices for u1", body)
+	}
+
+	if rec := do(h, http.MethodDelete, "/admin/v1/users/u1/devices/key-1", testToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want 204", rec.Code)
+	}
+	list, _ := registry.Devices(ctx, "u1")
+	if len(list) != 1 || list[0].KeyID != "key-2" {
+		t.Errorf("devices after DELETE = %+v, want only key-2", list)
+	}
+}
+
+func TestAdminRemoveDeviceRevokesRefreshTokens(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	registry, _ := devices.New(devices.Config{Store: s})
+	wrapper, err := tokenwrap.New(tokenwrap.Config{
+		Keys:  []tokenwrap.Key{{ID: "k1", Secret: bytes.Repeat([]byte{1}, 32)}},
+		Store: s,
+	})
+	if err != nil {
+		t.Fatalf("tokenwrap.New() error = %v", err)
+	}
+	logger, _ := logging.New("error", false)
+	h := NewHandler(Config{Token: testToken, Devices: registry, RefreshTokens: wrapper}, logger)
+
+	ctx := context.Background()
+	removed, _ := wrapper.Wrap(ctx, "refresh-1", "key-1", "s1")
+	kept, _ := wrapper.Wrap(ctx, "refresh-2", "key-2", "s2")
+
+	if rec := do(h, http.MethodDelete, "/admin/v1/users/u1/devices/key-1", testToken); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want 204", rec.Code)
+	}
+	if _, err := wrapper.Unwrap(ctx, removed, "key-1"); !errors.Is(err, tokenwrap.ErrInvalidHandle) {
+		t.Errorf("Unwrap() of removed device's handle error = %v, want ErrInvalidHandle", err)
+	}
+	if got, err := wrapper.Unwrap(ctx, kept, "key-2"); err != nil || got != "refresh-2" {
+		t.Errorf("Unwrap() of other device's handle = %q, %v, want refresh-2", got, err)
+	}
+}
+
+func TestAdminClientCertificate(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/users/u1/devices", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("verified client certificate: status = %d, want 200", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/admin/v1/users/u1/devices", nil)
+	req.TLS = &tls.ConnectionState{}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("TLS without a client certificate: status = %d, want 401", rec.Code)
+	}
+}
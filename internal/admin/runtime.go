@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/attestation"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/config"
+	"github.com/kacy/auth-proxy/internal/health"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"go.uber.org/zap"
+)
+
+// Runtime is what the admin listener's runtime endpoints report on and
+// control.
+type Runtime struct {
+	// Settings returns the configuration currently in effect.
+	Settings func() []config.Setting
+	// Attestation is reported by /admin/attestation.
+	Attestation *attestation.Verifier
+	// Readiness is flipped by /admin/drain.
+	Readiness *health.Readiness
+}
+
+func (h *Handler) registerRuntime() {
+	h.mux.HandleFunc("GET /admin/config", h.showConfig)
+	h.mux.HandleFunc("GET /admin/attestation", h.showAttestation)
+	h.mux.HandleFunc("GET /admin/loglevel", h.showLogLevel)
+	h.mux.HandleFunc("PUT /admin/loglevel", h.setLogLevel)
+	h.mux.HandleFunc("POST /admin/drain", h.drain)
+
+	h.mux.Handle("/debug/pprof/", h.audited("admin_pprof", http.HandlerFunc(pprof.Index)))
+	h.mux.Handle("/debug/pprof/cmdline", h.audited("admin_pprof", http.HandlerFunc(pprof.Cmdline)))
+	h.mux.Handle("/debug/pprof/profile", h.audited("admin_pprof", http.HandlerFunc(pprof.Profile)))
+	h.mux.Handle("/debug/pprof/symbol", h.audited("admin_pprof", http.HandlerFunc(pprof.Symbol)))
+	h.mux.Handle("/debug/pprof/trace", h.audited("admin_pprof", http.HandlerFunc(pprof.Trace)))
+}
+
+// audited records an access audit entry before serving next.
+func (h *Handler) audited(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		audit.Report(r, audit.Entry{Action: action, Decision: audit.DecisionAccess})
+		next.ServeHTTP(w, r)
+	})
+}
+
+type settingView struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+func (h *Handler) showConfig(w http.ResponseWriter, r *http.Request) {
+	settings := h.runtime.Settings()
+	list := make([]settingView, 0, len(settings))
+	for _, s := range settings {
+		list = append(list, settingView{Key: s.Key, Value: s.Redacted(), Source: s.Source})
+	}
+
+	audit.Report(r, audit.Entry{Action: "admin_view_config", Decision: audit.DecisionAccess})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"settings": list})
+}
+
+func (h *Handler) showAttestation(w http.ResponseWriter, r *http.Request) {
+	if h.runtime.Attestation == nil || !h.runtime.Attestation.IsEnabled() {
+		apierror.Write(w, r, apierror.ErrNotEnabled.WithMessage("Attestation is not enabled"))
+		return
+	}
+
+	stats, err := h.runtime.Attestation.StoreStats(r.Context())
+	if err != nil {
+		h.logger.For(r.Context()).DatabaseError("failed to read attestation store stats", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrStore.WithMessage("Failed to read attestation stores").WithCause(err))
+		return
+	}
+
+	audit.Report(r, audit.Entry{Action: "admin_view_attestation", Decision: audit.DecisionAccess})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"verifier": h.runtime.Attestation.DebugInfo(),
+		"stores":   stats,
+	})
+}
+
+func (h *Handler) showLogLevel(w http.ResponseWriter, r *http.Request) {
+	audit.Report(r, audit.Entry{Action: "admin_view_loglevel", Decision: audit.DecisionAccess})
+	writeJSON(w, http.StatusOK, map[string]string{"level": h.logger.Level()})
+}
+
+func (h *Handler) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&body); err != nil {
+		apierror.Write(w, r, apierror.ErrInvalidRequest.WithMessage("Body must be {\"level\": \"debug|info|warn|error\"}"))
+		return
+	}
+	previous := h.logger.Level()
+	if err := h.logger.SetLevel(body.Level); err != nil {
+		apierror.Write(w, r, apierror.ErrValidationFailed.WithMessage("Level must be debug, info, warn or error").WithCause(err))
+		return
+	}
+
+	audit.Report(r, audit.Entry{Action: "admin_set_loglevel", Decision: audit.DecisionChange, Reason: h.logger.Level()})
+	h.logger.For(r.Context()).Logger.Info(logging.EmojiConfig+" log level changed by admin",
+		zap.String("from", previous),
+		zap.String("to", h.logger.Level()),
+		zap.String("client_ip", clientip.FromRequest(r)),
+	)
+	writeJSON(w, http.StatusOK, map[string]string{"level": h.logger.Level()})
+}
+
+func (h *Handler) drain(w http.ResponseWriter, r *http.Request) {
+	if h.runtime.Readiness.Drain() {
+		audit.Report(r, audit.Entry{Action: "admin_drain", Decision: audit.DecisionChange})
+		h.logger.For(r.Context()).Logger.Warn(logging.EmojiWarning+" draining: health checks now fail so traffic moves away",
+			zap.String("client_ip", clientip.FromRequest(r)),
+		)
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"draining": true})
+}
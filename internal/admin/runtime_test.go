@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/config"
+	"github.com/kacy/auth-proxy/internal/health"
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+func newRuntimeHandler(t *testing.T) (http.Handler, *health.Readiness, *logging.Logger, string) {
+	t.Helper()
+	logger, err := logging.New("info", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := audit.Open(audit.Config{Path: path, Key: []byte("0123456789abcdef")}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+
+	readiness := &health.Readiness{}
+	h := NewHandler(Config{
+		Token: testToken,
+		Runtime: &Runtime{
+			Settings: func() []config.Setting {
+				return []config.Setting{
+					{Key: "GOTRUE_ANON_KEY", Value: "anon-key", Source: "env", Secret: true},
+					{Key: "HTTP_PORT", Value: "8080", Source: "default"},
+				}
+			},
+			Readiness: readiness,
+		},
+	}, logger)
+	return auditLog.Middleware(h), readiness, logger, path
+}
+
+func doBody(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func auditActions(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var rec audit.Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			t.Fatalf("invalid audit record %q: %v", line, err)
+		}
+		actions = append(actions, rec.Action+":"+rec.Decision)
+	}
+	return actions
+}
+
+func TestRuntimeConfig(t *testing.T) {
+	h, _, _, _ := newRuntimeHandler(t)
+
+	rec := doBody(h, http.MethodGet, "/admin/config", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "anon-key") {
+		t.Errorf("body leaks a secret: %s", rec.Body)
+	}
+	var body struct {
+		Settings []settingView `json:"settings"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(body.Settings) != 2 || body.Settings[0].Value != "[redacted]" || body.Settings[1].Value != "8080" {
+		t.Errorf("settings = %+v, want the secret redacted and HTTP_PORT shown", body.Settings)
+	}
+}
+
+func TestRuntimeLogLevel(t *testing.T) {
+	h, _, logger, path := newRuntimeHandler(t)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		level  string
+	}{
+		{"get", http.MethodGet, "", http.StatusOK, "info"},
+		{"set debug", http.MethodPut, `{"level":"debug"}`, http.StatusOK, "debug"},
+		{"unknown level", http.MethodPut, `{"level":"loud"}`, http.StatusBadRequest, "debug"},
+		{"not json", http.MethodPut, `debug`, http.StatusBadRequest, "debug"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doBody(h, tt.method, "/admin/loglevel", tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := logger.Level(); got != tt.level {
+				t.Errorf("level = %s, want %s", got, tt.level)
+			}
+		})
+	}
+
+	want := []string{"admin_view_loglevel:access", "admin_set_loglevel:change"}
+	if got := auditActions(t, path); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("audit actions = %v, want %v", got, want)
+	}
+}
+
+func TestRuntimeDrain(t *testing.T) {
+	h, readiness, _, path := newRuntimeHandler(t)
+
+	for i := 0; i < 2; i++ {
+		if rec := doBody(h, http.MethodPost, "/admin/drain", ""); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", rec.Code)
+		}
+	}
+	if !readiness.Draining() {
+		t.Error("Draining() = false after /admin/drain")
+	}
+	if got := auditActions(t, path); len(got) != 1 || got[0] != "admin_drain:change" {
+		t.Errorf("audit actions = %v, want one admin_drain", got)
+	}
+}
+
+func TestRuntimeRoutes(t *testing.T) {
+	h, _, _, path := newRuntimeHandler(t)
+
+	if rec := doBody(h, http.MethodGet, "/debug/pprof/", ""); rec.Code != http.StatusOK {
+		t.Errorf("pprof index status = %d, want 200", rec.Code)
+	}
+	if rec := doBody(h, http.MethodGet, "/admin/attestation", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("attestation without a verifier status = %d, want 404", rec.Code)
+	}
+	if got := auditActions(t, path); len(got) != 1 || got[0] != "admin_pprof:access" {
+		t.Errorf("audit actions = %v, want one admin_pprof", got)
+	}
+
+	// Without Runtime, e.g. on the main port, none of this is served
+	logger, _ := logging.New("error", false)
+	plain := NewHandler(Config{Token: testToken}, logger)
+	for _, p := range []string{"/debug/pprof/", "/admin/config", "/admin/loglevel"} {
+		if rec := doBody(plain, http.MethodGet, p, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s without Runtime: status = %d, want 404", p, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package apierror writes the error responses the proxy generates itself in
+// GoTrue's format, {"code":<status>,"error_code":<code>,"msg":<message>}, so
+// Supabase client SDKs parse them exactly like upstream errors. Every error
+// the proxy can return is listed here with its status and a stable code.
+package apierror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/requestid"
+)
+
+// Error is an error response. The catalogue values below are shared, so use
+// the With* methods, which return copies, to adjust one.
+type Error struct {
+	// Status is the HTTP status, also sent as "code".
+	Status int
+	// Code is the stable machine-readable error code, sent as "error_code".
+	Code string
+	// Message is the human-readable "msg".
+	Message string
+	// RetryAfter, if set, is sent as a Retry-After header in seconds.
+	RetryAfter time.Duration
+	// Fields are extra members of the body, e.g. "weak_password".
+	Fields map[string]interface{}
+
+	cause error
+}
+
+// New creates an error. Prefer the catalogue below so codes stay stable.
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Code + ": " + e.Message + ": " + e.cause.Error()
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Unwrap returns the cause set with WithCause.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches errors with the same code, so errors.Is(err, ErrBadJWT) holds
+// for a copy with a different message.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithMessage returns a copy with a different message.
+func (e *Error) WithMessage(message string) *Error {
+	c := e.clone()
+	c.Message = message
+	return c
+}
+
+// WithRetryAfter returns a copy that tells the client when to retry.
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	c := e.clone()
+	c.RetryAfter = d
+	return c
+}
+
+// WithField returns a copy with an extra body member.
+func (e *Error) WithField(key string, value interface{}) *Error {
+	c := e.clone()
+	c.Fields = make(map[string]interface{}, len(e.Fields)+1)
+	for k, v := range e.Fields {
+		c.Fields[k] = v
+	}
+	c.Fields[key] = value
+	return c
+}
+
+// WithCause returns a copy wrapping the error behind it. The cause is shown
+// as "detail" only when debug output is on.
+func (e *Error) WithCause(err error) *Error {
+	c := e.clone()
+	c.cause = err
+	return c
+}
+
+func (e *Error) clone() *Error {
+	c := *e
+	return &c
+}
+
+// Catalogue of the errors the proxy returns. Codes that GoTrue also uses keep
+// GoTrue's meaning and status.
+var (
+	ErrInternal            = New(http.StatusInternalServerError, "unexpected_failure", "Unexpected failure, please check server logs for more information")
+	ErrInvalidRequest      = New(http.StatusBadRequest, "invalid_request", "Invalid request")
+	ErrValidationFailed    = New(http.StatusBadRequest, "validation_failed", "Request validation failed")
+	ErrMethodNotAllowed    = New(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+	ErrRequestTooLarge     = New(http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large")
+	ErrBadGateway          = New(http.StatusBadGateway, "bad_gateway", "Upstream service unavailable")
+	ErrRequestNotAllowed   = New(http.StatusForbidden, "request_not_allowed", "Requests from your network or location are not allowed")
+	ErrAPIKeyRequired      = New(http.StatusUnauthorized, "api_key_required", "API key is required")
+	ErrInvalidAPIKey       = New(http.StatusForbidden, "invalid_api_key", "Invalid API key")
+	ErrNoAuthorization     = New(http.StatusUnauthorized, "no_authorization", "This endpoint requires a Bearer token")
+	ErrBadJWT              = New(http.StatusUnauthorized, "bad_jwt", "Invalid or expired access token")
+	ErrSessionNotFound     = New(http.StatusForbidden, "session_not_found", "Session from session_id claim in JWT does not exist")
+	ErrInvalidRedirect     = New(http.StatusBadRequest, "invalid_redirect", "redirect_to is not an allowed URL")
+	ErrOriginNotAllowed    = New(http.StatusForbidden, "origin_not_allowed", "Origin is not allowed")
+	ErrPreflightNotAllowed = New(http.StatusForbidden, "method_not_allowed", "Method is not allowed for this route")
+	ErrHeaderNotAllowed    = New(http.StatusForbidden, "header_not_allowed", "Header is not allowed")
+
+	// Attestation
+	ErrAttestationRequired = New(http.StatusUnauthorized, "attestation_required", "Device attestation is required for this request")
+	ErrInvalidAttestation  = New(http.StatusForbidden, "invalid_attestation", "Device attestation verification failed")
+	ErrUnsupportedPlatform = New(http.StatusBadRequest, "unsupported_platform", "Unsupported platform for attestation")
+	ErrKeyNotFound         = New(http.StatusUnauthorized, "key_not_found", "Attestation key not found, re-attestation required")
+	ErrReplayDetected      = New(http.StatusForbidden, "replay_detected", "Assertion replay detected")
+	ErrInvalidAssertion    = New(http.StatusForbidden, "invalid_assertion", "Invalid assertion")
+	ErrAttestationFailed   = New(http.StatusInternalServerError, "attestation_error", "Attestation verification error")
+	ErrChallengeFailed     = New(http.StatusInternalServerError, "challenge_error", "Failed to generate challenge")
+
+	// Sign-up, password and SMS policies
+	ErrEmailAddressInvalid       = New(http.StatusUnprocessableEntity, "email_address_invalid", "Email address is invalid")
+	ErrEmailAddressNotAuthorized = New(http.StatusUnprocessableEntity, "email_address_not_authorized", "Email address is not allowed")
+	ErrEmailExists               = New(http.StatusUnprocessableEntity, "email_exists", "An account with this email address already exists")
+	ErrWeakPassword              = New(http.StatusUnprocessableEntity, "weak_password", "Password is known to be compromised and can't be used, choose a different one")
+	ErrPasswordCheckUnavailable  = New(http.StatusServiceUnavailable, "password_check_unavailable", "Password could not be checked, try again later")
+	ErrOverSMSSendRateLimit      = New(http.StatusTooManyRequests, "over_sms_send_rate_limit", "Too many SMS sent, try again later")
+	ErrSMSSendFailed             = New(http.StatusUnprocessableEntity, "sms_send_failed", "SMS can't be sent to this number")
+	ErrPremiumNumber             = New(http.StatusUnprocessableEntity, "phone_premium_number", "SMS can't be sent to premium-rate numbers")
+
+	// Sessions and tokens
+	ErrMFARequired            = New(http.StatusForbidden, "mfa_required", "This action requires multi-factor authentication")
+	ErrMFACheckUnavailable    = New(http.StatusServiceUnavailable, "mfa_check_unavailable", "MFA status could not be checked, try again later")
+	ErrSessionUnavailable     = New(http.StatusServiceUnavailable, "session_unavailable", "Session store unavailable")
+	ErrCSRFFailed             = New(http.StatusForbidden, "csrf_failed", "Missing or invalid CSRF token")
+	ErrSessionExpired         = New(http.StatusUnauthorized, "session_expired", "Session expired, sign in again")
+	ErrRefreshTokenNotFound   = New(http.StatusBadRequest, "refresh_token_not_found", "Invalid Refresh Token: Refresh Token Not Found")
+	ErrRefreshUnavailable     = New(http.StatusServiceUnavailable, "refresh_unavailable", "Refresh token could not be checked, try again later")
+	ErrInvalidDPoPProof       = New(http.StatusUnauthorized, "invalid_dpop_proof", "Invalid DPoP proof")
+	ErrDPoPCheckUnavailable   = New(http.StatusServiceUnavailable, "dpop_check_unavailable", "DPoP proof could not be checked, try again later")
+	ErrDeviceLimitExceeded    = New(http.StatusForbidden, "device_limit_exceeded", "Too many devices are signed in to this account")
+	ErrDeviceAccountsExceeded = New(http.StatusForbidden, "device_account_limit_exceeded", "Too many accounts have signed in on this device")
+	ErrDeviceBoundToOtherUser = New(http.StatusForbidden, "device_bound_to_other_user", "This device is registered to another account")
+
+	// Admin API
+	ErrUnauthorized = New(http.StatusUnauthorized, "unauthorized", "A valid admin token is required")
+	ErrNotEnabled   = New(http.StatusNotFound, "not_enabled", "This feature is not enabled")
+	ErrStore        = New(http.StatusInternalServerError, "store_error", "Store operation failed")
+)
+
+var debug atomic.Bool
+
+// SetDebug turns on the "detail" member, which carries the underlying cause
+// of an error. Leave it off in production: causes can describe internals.
+func SetDebug(on bool) {
+	debug.Store(on)
+}
+
+// Write sends err as the response to r. Errors that aren't an *Error are
+// sent as ErrInternal.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	e := from(err)
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfter(e.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(body(r, e))
+}
+
+// Replace turns an upstream response into err, for checks that run on the
+// response rather than the request.
+func Replace(resp *http.Response, err error) {
+	e := from(err)
+	if e.RetryAfter > 0 {
+		resp.Header.Set("Retry-After", retryAfter(e.RetryAfter))
+	}
+	resp.StatusCode = e.Status
+	resp.Status = strconv.Itoa(e.Status) + " " + http.StatusText(e.Status)
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Del("Content-Encoding")
+
+	b := body(resp.Request, e)
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	resp.ContentLength = int64(len(b))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(b)))
+}
+
+func from(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal.WithCause(err)
+}
+
+func body(r *http.Request, e *Error) []byte {
+	m := make(map[string]interface{}, len(e.Fields)+5)
+	for k, v := range e.Fields {
+		m[k] = v
+	}
+	m["code"] = e.Status
+	m["error_code"] = e.Code
+	m["msg"] = e.Message
+	if r != nil {
+		if id := requestid.FromContext(r.Context()); id != "" {
+			m["request_id"] = id
+		}
+	}
+	if e.cause != nil && debug.Load() {
+		m["detail"] = e.cause.Error()
+	}
+	b, _ := json.Marshal(m)
+	return append(b, '\n')
+}
+
+// retryAfter rounds up to whole seconds so clients never retry early.
+func retryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,157 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/requestid"
+)
+
+func TestWrite(t *testing.T) {
+	cause := errors.New("dial tcp 10.0.0.5:9999: connection refused")
+
+	tests := []struct {
+		name           string
+		err            error
+		debug          bool
+		wantStatus     int
+		wantCode       string
+		wantMsg        string
+		wantRetryAfter string
+		wantDetail     string
+		wantField      string
+	}{
+		{
+			name:       "catalogue error",
+			err:        ErrInvalidAPIKey,
+			wantStatus: http.StatusForbidden,
+			wantCode:   "invalid_api_key",
+			wantMsg:    "Invalid API key",
+		},
+		{
+			name:       "custom message keeps code and status",
+			err:        ErrEmailAddressNotAuthorized.WithMessage("Disposable email addresses are not allowed"),
+			wantStatus: http.StatusUnprocessableEntity,
+			wantCode:   "email_address_not_authorized",
+			wantMsg:    "Disposable email addresses are not allowed",
+		},
+		{
+			name:           "retry after rounds up",
+			err:            ErrOverSMSSendRateLimit.WithRetryAfter(1500 * time.Millisecond),
+			wantStatus:     http.StatusTooManyRequests,
+			wantCode:       "over_sms_send_rate_limit",
+			wantMsg:        "Too many SMS sent, try again later",
+			wantRetryAfter: "2",
+		},
+		{
+			name:       "cause hidden in production",
+			err:        ErrBadGateway.WithCause(cause),
+			wantStatus: http.StatusBadGateway,
+			wantCode:   "bad_gateway",
+			wantMsg:    "Upstream service unavailable",
+		},
+		{
+			name:       "cause shown in debug",
+			err:        ErrBadGateway.WithCause(cause),
+			debug:      true,
+			wantStatus: http.StatusBadGateway,
+			wantCode:   "bad_gateway",
+			wantMsg:    "Upstream service unavailable",
+			wantDetail: cause.Error(),
+		},
+		{
+			name:       "extra fields",
+			err:        ErrMFARequired.WithField("required_aal", "aal2"),
+			wantStatus: http.StatusForbidden,
+			wantCode:   "mfa_required",
+			wantMsg:    "This action requires multi-factor authentication",
+			wantField:  "aal2",
+		},
+		{
+			name:       "plain errors are internal errors",
+			err:        cause,
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   "unexpected_failure",
+			wantMsg:    ErrInternal.Message,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetDebug(tt.debug)
+			defer SetDebug(false)
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/v1/token", nil)
+			req = req.WithContext(requestid.NewContext(req.Context(), "req-1"))
+			rec := httptest.NewRecorder()
+			Write(rec, req, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", rec.Body.String(), err)
+			}
+			if body["code"] != float64(tt.wantStatus) || body["error_code"] != tt.wantCode || body["msg"] != tt.wantMsg {
+				t.Errorf("body = %v, want code %d, error_code %s, msg %q", body, tt.wantStatus, tt.wantCode, tt.wantMsg)
+			}
+			if body["request_id"] != "req-1" {
+				t.Errorf("request_id = %v, want req-1", body["request_id"])
+			}
+			if detail, _ := body["detail"].(string); detail != tt.wantDetail {
+				t.Errorf("detail = %q, want %q", detail, tt.wantDetail)
+			}
+			if tt.wantField != "" && body["required_aal"] != tt.wantField {
+				t.Errorf("required_aal = %v, want %s", body["required_aal"], tt.wantField)
+			}
+		})
+	}
+}
+
+func TestReplace(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/auth/v1/token", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"access_token":"secret"}`)),
+		Request:    req,
+	}
+
+	Replace(resp, ErrSessionNotFound)
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden || resp.Status != "403 Forbidden" {
+		t.Errorf("status = %d %q, want 403", resp.StatusCode, resp.Status)
+	}
+	if strings.Contains(string(body), "secret") || !strings.Contains(string(body), `"error_code":"session_not_found"`) {
+		t.Errorf("body = %s, want only the error", body)
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("ContentLength = %d, want %d", resp.ContentLength, len(body))
+	}
+}
+
+func TestIsMatchesCode(t *testing.T) {
+	err := error(ErrBadJWT.WithMessage("Token expired").WithCause(errors.New("exp in the past")))
+	if !errors.Is(err, ErrBadJWT) {
+		t.Error("errors.Is() = false for a copy of ErrBadJWT")
+	}
+	if errors.Is(err, ErrNoAuthorization) {
+		t.Error("errors.Is() = true for a different code")
+	}
+	// Copies don't change the catalogue
+	if ErrBadJWT.Message != "Invalid or expired access token" || ErrBadJWT.Unwrap() != nil {
+		t.Errorf("ErrBadJWT modified: %+v", ErrBadJWT)
+	}
+}
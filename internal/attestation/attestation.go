@@ -57,6 +57,10 @@ type RedisConfig struct {
 	Password  string
 	DB        int
 	KeyPrefix string
+
+	// Client, if set, is used instead of dialing a new connection.
+	// The caller keeps ownership and is responsible for closing it.
+	Client *redis.Client
 }
 
 // AttestationData represents an attestation verification request.
@@ -84,6 +88,11 @@ type Verifier struct {
 	challengeStore challenge.Store
 	keyStore       ios.KeyStore
 	redisClient    *redis.Client
+	ownsRedis      bool
+	// keyPrefix and challengePrefix are where the Redis stores keep their
+	// entries, for listing and counting them
+	keyPrefix       string
+	challengePrefix string
 }
 
 // NewVerifier creates a new attestation verifier.
@@ -143,11 +152,16 @@ func NewVerifier(config Config, redisConfig *RedisConfig, logger *logging.Logger
 }
 
 func (v *Verifier) setupRedisStores(cfg *RedisConfig, timeout time.Duration) error {
-	v.redisClient = redis.NewClient(&redis.Options{
-		Addr:     cfg.Addr,
-		Password: cfg.Password,
-		DB:       cfg.DB,
-	})
+	if cfg.Client != nil {
+		v.redisClient = cfg.Client
+	} else {
+		v.redisClient = redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		})
+		v.ownsRedis = true
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -181,6 +195,8 @@ func (v *Verifier) setupRedisStores(cfg *RedisConfig, timeout time.Duration) err
 		return err
 	}
 	v.keyStore = keyStore
+	v.keyPrefix = keyPrefix
+	v.challengePrefix = challengePrefix
 
 	return nil
 }
@@ -212,7 +228,7 @@ func (v *Verifier) Close() error {
 	if v.challengeStore != nil {
 		v.challengeStore.Close()
 	}
-	if v.redisClient != nil {
+	if v.redisClient != nil && v.ownsRedis {
 		return v.redisClient.Close()
 	}
 	return nil
@@ -258,7 +274,7 @@ func (v *Verifier) VerifyAssertion(ctx context.Context, data *AssertionData) err
 	}
 
 	v.logger.AppleAuth("verifying iOS assertion",
-		zap.String("key_id", maskString(data.KeyID)),
+		zap.String("key_id", logging.MaskKeyID(data.KeyID)),
 		zap.String("bundle_id", data.BundleID),
 		zap.Bool("has_key_store", v.keyStore != nil),
 	)
@@ -285,7 +301,7 @@ func (v *Verifier) VerifyAssertion(ctx context.Context, data *AssertionData) err
 	if err != nil {
 		v.logger.AuthError("iOS assertion verification failed",
 			zap.Error(err),
-			zap.String("key_id", maskString(data.KeyID)),
+			zap.String("key_id", logging.MaskKeyID(data.KeyID)),
 			zap.String("bundle_id", bundleID),
 		)
 		return convertError(err)
@@ -300,7 +316,7 @@ func (v *Verifier) VerifyAssertion(ctx context.Context, data *AssertionData) err
 
 func (v *Verifier) verifyIOS(ctx context.Context, data *AttestationData) error {
 	v.logger.AppleAuth("verifying iOS attestation",
-		zap.String("key_id", maskString(data.KeyID)),
+		zap.String("key_id", logging.MaskKeyID(data.KeyID)),
 		zap.Bool("has_key_store", v.keyStore != nil),
 		zap.Bool("has_verifier", v.verifier != nil),
 	)
@@ -335,7 +351,7 @@ func (v *Verifier) verifyIOS(ctx context.Context, data *AttestationData) error {
 		v.logger.AuthError("iOS attestation verification failed",
 			zap.Error(err),
 			zap.String("error_type", fmt.Sprintf("%T", err)),
-			zap.String("key_id", maskString(data.KeyID)),
+			zap.String("key_id", logging.MaskKeyID(data.KeyID)),
 			zap.String("bundle_id", bundleID),
 		)
 		return convertError(err)
@@ -343,7 +359,7 @@ func (v *Verifier) verifyIOS(ctx context.Context, data *AttestationData) error {
 
 	v.logger.AuthSuccess("iOS attestation verified and key stored",
 		zap.String("device_id", result.DeviceID),
-		zap.String("key_id", maskString(data.KeyID)),
+		zap.String("key_id", logging.MaskKeyID(data.KeyID)),
 	)
 	return nil
 }
@@ -393,6 +409,26 @@ func (v *Verifier) HasKeyStore() bool {
 	return v.keyStore != nil
 }
 
+// Ready reports whether the verifier can serve attestation: it was set up
+// for the enabled platforms and its Redis stores, if any, are reachable.
+func (v *Verifier) Ready(ctx context.Context) error {
+	if !v.IsEnabled() {
+		return nil
+	}
+	if v.verifier == nil || v.challengeStore == nil {
+		return errors.New("attestation verifier is not initialized")
+	}
+	if v.config.IOSEnabled && v.keyStore == nil {
+		return errors.New("iOS key store is not initialized")
+	}
+	if v.redisClient != nil {
+		if err := v.redisClient.Ping(ctx).Err(); err != nil {
+			return fmt.Errorf("attestation store: %w", err)
+		}
+	}
+	return nil
+}
+
 // DebugInfo returns debug information about the verifier state.
 func (v *Verifier) DebugInfo() map[string]interface{} {
 	info := map[string]interface{}{
@@ -415,6 +451,53 @@ func (v *Verifier) DebugInfo() map[string]interface{} {
 	return info
 }
 
+// StoreStats describes the challenge and key stores.
+type StoreStats struct {
+	// Backend is "redis" or "memory".
+	Backend string `json:"backend"`
+	// Challenges is the number of outstanding challenges.
+	Challenges int `json:"challenges"`
+	// Keys is the number of stored iOS keys, or nil when the store can't
+	// count them.
+	Keys *int `json:"keys,omitempty"`
+}
+
+// StoreStats counts the entries in the challenge and key stores. With Redis
+// this scans the keyspace, so it's meant for operators rather than hot paths.
+func (v *Verifier) StoreStats(ctx context.Context) (StoreStats, error) {
+	if v.redisClient == nil {
+		stats := StoreStats{Backend: "memory"}
+		if mem, ok := v.challengeStore.(*challenge.MemoryStore); ok {
+			stats.Challenges = mem.Len()
+		}
+		return stats, nil
+	}
+
+	stats := StoreStats{Backend: "redis"}
+	challenges, err := v.countKeys(ctx, v.challengePrefix)
+	if err != nil {
+		return StoreStats{}, err
+	}
+	stats.Challenges = challenges
+	if v.keyStore != nil {
+		keys, err := v.countKeys(ctx, v.keyPrefix)
+		if err != nil {
+			return StoreStats{}, err
+		}
+		stats.Keys = &keys
+	}
+	return stats, nil
+}
+
+func (v *Verifier) countKeys(ctx context.Context, prefix string) (int, error) {
+	n := 0
+	iter := v.redisClient.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		n++
+	}
+	return n, iter.Err()
+}
+
 func convertError(err error) error {
 	if err == nil {
 		return nil
@@ -448,10 +531,3 @@ func getCounterFromResult(result *deviceattest.Result) uint32 {
 	// library to expose this.
 	return 0
 }
-
-func maskString(s string) string {
-	if len(s) <= 8 {
-		return "***"
-	}
-	return s[:4] + "***" + s[len(s)-4:]
-}
@@ -3,6 +3,7 @@ package attestation
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/kacy/auth-proxy/internal/logging"
 )
@@ -110,27 +111,6 @@ func TestGenerateChallenge(t *testing.T) {
 	}
 }
 
-func TestMaskString(t *testing.T) {
-	tests := []struct {
-		name  string
-		input string
-		want  string
-	}{
-		{"short string", "abc", "***"},
-		{"exactly 8 chars", "12345678", "***"},
-		{"longer string", "1234567890", "1234***7890"},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got := maskString(tt.input)
-			if got != tt.want {
-				t.Errorf("maskString(%q) = %q, want %q", tt.input, got, tt.want)
-			}
-		})
-	}
-}
-
 func TestPlatformConversion(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -176,3 +156,37 @@ func TestValidateChallengeDisabled(t *testing.T) {
 		t.Error("ValidateChallenge() with disabled verifier should return true")
 	}
 }
+
+func TestStoreStatsMemory(t *testing.T) {
+	v := &Verifier{}
+	v.setupMemoryStores(time.Minute)
+	defer v.Close()
+
+	if _, err := v.challengeStore.Generate("user-123"); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := v.StoreStats(context.Background())
+	if err != nil {
+		t.Fatalf("StoreStats() error = %v", err)
+	}
+	if stats.Backend != "memory" || stats.Challenges != 1 || stats.Keys != nil {
+		t.Errorf("StoreStats() = %+v, want memory backend with 1 challenge and no key count", stats)
+	}
+}
+
+func TestReady(t *testing.T) {
+	logger, _ := createTestLogger()
+	disabled, err := NewVerifier(Config{}, nil, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := disabled.Ready(context.Background()); err != nil {
+		t.Errorf("Ready() on a disabled verifier = %v, want nil", err)
+	}
+
+	uninitialized := &Verifier{config: Config{IOSEnabled: true}}
+	if err := uninitialized.Ready(context.Background()); err == nil {
+		t.Error("Ready() on an uninitialized verifier = nil, want an error")
+	}
+}
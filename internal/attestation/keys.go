@@ -0,0 +1,147 @@
+package attestation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kacy/device-attestation/ios"
+)
+
+var (
+	ErrNoKeyStore     = errors.New("iOS attestation is not enabled, there is no key store")
+	ErrKeyListing     = errors.New("listing keys needs the Redis key store")
+	ErrKeyExists      = errors.New("attestation key already exists")
+	ErrInvalidKeyData = errors.New("invalid attestation key data")
+)
+
+// KeyRecord is a stored iOS App Attest key in the form used to list, export
+// and import keys.
+type KeyRecord struct {
+	KeyID string `json:"key_id"`
+	// PublicKey is the PKIX DER public key, base64-encoded.
+	PublicKey  string    `json:"public_key"`
+	BundleID   string    `json:"bundle_id"`
+	TeamID     string    `json:"team_id"`
+	Counter    uint32    `json:"counter"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
+
+// ListKeys returns every stored iOS key, ordered by key ID. Only the Redis
+// key store can be listed.
+func (v *Verifier) ListKeys(ctx context.Context) ([]KeyRecord, error) {
+	if v.keyStore == nil {
+		return nil, ErrNoKeyStore
+	}
+	if v.redisClient == nil {
+		return nil, ErrKeyListing
+	}
+
+	var ids []string
+	iter := v.redisClient.Scan(ctx, 0, v.keyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		ids = append(ids, strings.TrimPrefix(iter.Val(), v.keyPrefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+
+	records := make([]KeyRecord, 0, len(ids))
+	for _, id := range ids {
+		rec, err := v.LoadKey(ctx, id)
+		if errors.Is(err, ErrKeyNotFound) {
+			// Revoked while listing
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// LoadKey returns one stored iOS key.
+func (v *Verifier) LoadKey(ctx context.Context, keyID string) (KeyRecord, error) {
+	if v.keyStore == nil {
+		return KeyRecord{}, ErrNoKeyStore
+	}
+	key, err := v.keyStore.Load(ctx, keyID)
+	if errors.Is(err, ios.ErrKeyNotFound) {
+		return KeyRecord{}, ErrKeyNotFound
+	}
+	if err != nil {
+		return KeyRecord{}, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.PublicKey)
+	if err != nil {
+		return KeyRecord{}, err
+	}
+	return KeyRecord{
+		KeyID:      keyID,
+		PublicKey:  base64.StdEncoding.EncodeToString(der),
+		BundleID:   key.BundleID,
+		TeamID:     key.TeamID,
+		Counter:    key.Counter,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}, nil
+}
+
+// RevokeKey deletes a stored iOS key. The device has to attest again before
+// its assertions are accepted.
+func (v *Verifier) RevokeKey(ctx context.Context, keyID string) error {
+	if v.keyStore == nil {
+		return ErrNoKeyStore
+	}
+	err := v.keyStore.Delete(ctx, keyID)
+	if errors.Is(err, ios.ErrKeyNotFound) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+// ImportKey stores a key exported from another deployment. The assertion
+// counter carries over, so replayed assertions stay rejected; the creation
+// time is reset by the store.
+func (v *Verifier) ImportKey(ctx context.Context, rec KeyRecord) error {
+	if v.keyStore == nil {
+		return ErrNoKeyStore
+	}
+	if rec.KeyID == "" {
+		return fmt.Errorf("%w: key_id is empty", ErrInvalidKeyData)
+	}
+	der, err := base64.StdEncoding.DecodeString(rec.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: public_key is not base64", ErrInvalidKeyData)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidKeyData, err)
+	}
+	ecKey, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: public_key is not an ECDSA key", ErrInvalidKeyData)
+	}
+
+	err = v.keyStore.Store(ctx, rec.KeyID, &ios.StoredKey{
+		KeyID:     rec.KeyID,
+		PublicKey: ecKey,
+		BundleID:  rec.BundleID,
+		TeamID:    rec.TeamID,
+		Counter:   rec.Counter,
+	})
+	if errors.Is(err, ios.ErrKeyExists) {
+		return ErrKeyExists
+	}
+	return err
+}
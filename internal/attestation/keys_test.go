@@ -0,0 +1,86 @@
+package attestation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/kacy/device-attestation/ios"
+)
+
+func TestKeyImportLoadRevoke(t *testing.T) {
+	ctx := context.Background()
+	v := &Verifier{keyStore: ios.NewMemoryKeyStore()}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	rec := KeyRecord{
+		KeyID:     "key-1",
+		PublicKey: base64.StdEncoding.EncodeToString(der),
+		BundleID:  "com.example.app",
+		TeamID:    "ABCDE12345",
+		Counter:   7,
+	}
+
+	if err := v.ImportKey(ctx, rec); err != nil {
+		t.Fatalf("ImportKey() error = %v", err)
+	}
+	if err := v.ImportKey(ctx, rec); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("ImportKey() twice error = %v, want ErrKeyExists", err)
+	}
+
+	got, err := v.LoadKey(ctx, "key-1")
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	if got.PublicKey != rec.PublicKey || got.Counter != 7 || got.BundleID != rec.BundleID {
+		t.Errorf("LoadKey() = %+v, want the imported key", got)
+	}
+
+	if err := v.RevokeKey(ctx, "key-1"); err != nil {
+		t.Fatalf("RevokeKey() error = %v", err)
+	}
+	if _, err := v.LoadKey(ctx, "key-1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("LoadKey() after revoke error = %v, want ErrKeyNotFound", err)
+	}
+	if err := v.RevokeKey(ctx, "key-1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("RevokeKey() twice error = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestKeyErrors(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := (&Verifier{}).ListKeys(ctx); !errors.Is(err, ErrNoKeyStore) {
+		t.Errorf("ListKeys() without a key store error = %v, want ErrNoKeyStore", err)
+	}
+
+	v := &Verifier{keyStore: ios.NewMemoryKeyStore()}
+	if _, err := v.ListKeys(ctx); !errors.Is(err, ErrKeyListing) {
+		t.Errorf("ListKeys() on the memory store error = %v, want ErrKeyListing", err)
+	}
+
+	tests := []struct {
+		name string
+		rec  KeyRecord
+	}{
+		{"missing key id", KeyRecord{PublicKey: "AAAA"}},
+		{"not base64", KeyRecord{KeyID: "k", PublicKey: "%%%"}},
+		{"not a public key", KeyRecord{KeyID: "k", PublicKey: base64.StdEncoding.EncodeToString([]byte("nope"))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := v.ImportKey(ctx, tt.rec); !errors.Is(err, ErrInvalidKeyData) {
+				t.Errorf("ImportKey() error = %v, want ErrInvalidKeyData", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+// Package audit writes security decisions to an append-only JSON lines file.
+// Records are chained: each carries an HMAC over its own fields and the
+// previous record's hash, so edits, insertions and deletions inside the log
+// are detected by Verify. User IDs are pseudonymized with the same key so the
+// log can be shared with auditors without exposing account identifiers.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/requestid"
+	"go.uber.org/zap"
+)
+
+// Decisions recorded in the log.
+const (
+	DecisionDeny   = "deny"
+	DecisionAlert  = "alert"
+	DecisionRevoke = "revoke"
+	DecisionChange = "change"
+	// DecisionAccess records an operator reading runtime state.
+	DecisionAccess = "access"
+)
+
+var ErrChainBroken = errors.New("audit chain broken")
+
+// Entry is a decision as reported by the code that made it.
+type Entry struct {
+	// Action is the check or operation, e.g. "api_key" or "attestation".
+	Action   string
+	Decision string
+	// Reason is the error code or other short cause.
+	Reason string
+	// UserID is pseudonymized before it is written.
+	UserID string
+	// KeyID is masked before it is written.
+	KeyID string
+}
+
+// Record is one line of the log.
+type Record struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Decision  string    `json:"decision"`
+	Reason    string    `json:"reason,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"`
+	// UserID is HMAC(key, user ID): stable per user, but not reversible
+	// without the key.
+	UserID   string `json:"user_id,omitempty"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// Config holds configuration for the log.
+type Config struct {
+	// Path is the file records are appended to.
+	Path string
+	// Key signs the chain and pseudonymizes user IDs. Verification needs
+	// the same key.
+	Key []byte
+}
+
+// Log appends chained records to a file.
+type Log struct {
+	key    []byte
+	now    func() time.Time
+	logger *logging.Logger
+
+	mu   sync.Mutex
+	file *os.File
+	seq  uint64
+	prev string
+}
+
+// Open opens the log for appending, resuming the chain from its last record.
+func Open(cfg Config, logger *logging.Logger) (*Log, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("audit: path is required")
+	}
+	if len(cfg.Key) < 16 {
+		return nil, errors.New("audit: key must be at least 16 bytes")
+	}
+
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("audit: open log: %w", err)
+	}
+
+	l := &Log{key: cfg.Key, now: time.Now, logger: logger, file: f}
+	last, err := lastRecord(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit: read last record: %w", err)
+	}
+	if last != nil {
+		l.seq, l.prev = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Write appends a decision made while handling r. Write on a nil log does
+// nothing.
+func (l *Log) Write(r *http.Request, e Entry) error {
+	if l == nil {
+		return nil
+	}
+
+	rec := Record{
+		Time:      l.now().UTC(),
+		Action:    e.Action,
+		Decision:  e.Decision,
+		Reason:    e.Reason,
+		KeyID:     logging.MaskKeyID(e.KeyID),
+		UserID:    Pseudonymize(l.key, e.UserID),
+		RequestID: requestid.FromContext(r.Context()),
+		ClientIP:  clientip.FromRequest(r),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.Seq = l.seq + 1
+	rec.PrevHash = l.prev
+	rec.Hash = chainHash(l.key, rec)
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("audit: write record: %w", err)
+	}
+	l.seq, l.prev = rec.Seq, rec.Hash
+	return nil
+}
+
+// Middleware makes the log available to Report for every request.
+func (l *Log) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l != nil {
+			r = r.WithContext(NewContext(r.Context(), l))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *Log) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the log stored in ctx, or nil.
+func FromContext(ctx context.Context) *Log {
+	l, _ := ctx.Value(contextKey{}).(*Log)
+	return l
+}
+
+// Report writes e to the log carried by r's context, if there is one. Write
+// errors are logged; a decision is never undone because it couldn't be
+// audited.
+func Report(r *http.Request, e Entry) {
+	l := FromContext(r.Context())
+	if err := l.Write(r, e); err != nil {
+		l.logger.Logger.Error(logging.EmojiError+" failed to write audit record",
+			zap.Error(err),
+			zap.String("action", e.Action),
+		)
+	}
+}
+
+// Pseudonymize returns a stable, keyed pseudonym for a user ID.
+func Pseudonymize(key []byte, userID string) string {
+	if userID == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("user:" + userID))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// VerifyResult summarizes a verified chain.
+type VerifyResult struct {
+	Records  int
+	FirstSeq uint64
+	LastSeq  uint64
+	LastHash string
+}
+
+// Verify checks the chain in r. Records must be consecutive, start at seq 1
+// and each must carry a valid hash linking it to the one before. To check a
+// rotated log, pass the files in order to one Verifier, or anchor it with
+// From.
+func Verify(r io.Reader, key []byte) (VerifyResult, error) {
+	v := NewVerifier(key)
+	if err := v.Check(r); err != nil {
+		return v.Result(), err
+	}
+	return v.Result(), nil
+}
+
+// Verifier checks a chain that may span several files.
+type Verifier struct {
+	key      []byte
+	anchored bool
+	result   VerifyResult
+}
+
+// NewVerifier creates a verifier for logs written with key.
+func NewVerifier(key []byte) *Verifier {
+	return &Verifier{key: key}
+}
+
+// From anchors the chain at a record verified earlier, e.g. the last record
+// of the previous rotated file: the first record checked must follow seq and
+// link to hash. Without it the chain must start at seq 1.
+func (v *Verifier) From(seq uint64, hash string) {
+	v.anchored = true
+	v.result.LastSeq = seq
+	v.result.LastHash = hash
+}
+
+// Result returns what has been verified so far.
+func (v *Verifier) Result() VerifyResult {
+	return v.result
+}
+
+// Check verifies the records in r, continuing the chain from earlier calls.
+func (v *Verifier) Check(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return fmt.Errorf("%w: line %d is not a record: %v", ErrChainBroken, line, err)
+		}
+
+		res := &v.result
+		if res.Records > 0 || v.anchored {
+			if rec.Seq != res.LastSeq+1 {
+				return fmt.Errorf("%w: line %d has seq %d, expected %d", ErrChainBroken, line, rec.Seq, res.LastSeq+1)
+			}
+			if rec.PrevHash != res.LastHash {
+				return fmt.Errorf("%w: seq %d does not link to seq %d", ErrChainBroken, rec.Seq, res.LastSeq)
+			}
+		} else if rec.Seq != 1 || rec.PrevHash != "" {
+			// Anything else could be a log with its head cut off
+			return fmt.Errorf("%w: line %d has seq %d, expected the chain to start at 1", ErrChainBroken, line, rec.Seq)
+		}
+		if res.Records == 0 {
+			res.FirstSeq = rec.Seq
+		}
+		if !hmac.Equal([]byte(rec.Hash), []byte(chainHash(v.key, rec))) {
+			return fmt.Errorf("%w: seq %d has been modified or the key is wrong", ErrChainBroken, rec.Seq)
+		}
+
+		res.Records++
+		res.LastSeq = rec.Seq
+		res.LastHash = rec.Hash
+	}
+	return scanner.Err()
+}
+
+// chainHash is the HMAC of the record with its hash field cleared. The
+// previous hash is one of the fields, which is what links the chain.
+func chainHash(key []byte, rec Record) string {
+	rec.Hash = ""
+	b, _ := json.Marshal(rec)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// lastRecord returns the final record in f, or nil for an empty file.
+func lastRecord(f *os.File) (*Record, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	var rec Record
+	if err := json.Unmarshal(last, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/requestid"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+var testLogger, _ = logging.New("error", false)
+
+func writeTestLog(t *testing.T, path string, n int) {
+	t.Helper()
+	l, err := Open(Config{Path: path, Key: testKey}, testLogger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < n; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/auth/v1/token", nil)
+		req = req.WithContext(requestid.NewContext(req.Context(), "req-1"))
+		if err := l.Write(req, Entry{Action: "api_key", Decision: DecisionDeny, Reason: "invalid_api_key", UserID: "user-1", KeyID: "abcdef123456"}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+}
+
+func TestWriteAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestLog(t, path, 2)
+	// Reopening resumes the chain
+	writeTestLog(t, path, 2)
+
+	data, _ := os.ReadFile(path)
+	res, err := Verify(bytes.NewReader(data), testKey)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if res.Records != 4 || res.FirstSeq != 1 || res.LastSeq != 4 {
+		t.Errorf("Verify() = %+v, want records 1-4", res)
+	}
+
+	line := strings.SplitN(string(data), "\n", 2)[0]
+	if strings.Contains(line, "user-1") || strings.Contains(line, "abcdef123456") {
+		t.Errorf("record leaks identifiers: %s", line)
+	}
+	for _, want := range []string{`"request_id":"req-1"`, `"key_id":"abcd***3456"`, `"user_id":"` + Pseudonymize(testKey, "user-1") + `"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("record %s missing %s", line, want)
+		}
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeTestLog(t, path, 3)
+	data, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(strings.TrimSpace(string(data)), "\n")
+
+	tests := []struct {
+		name string
+		log  string
+		key  []byte
+	}{
+		{"edited reason", strings.Replace(string(data), "invalid_api_key", "api_key_required", 1), testKey},
+		{"deleted record", lines[0] + lines[2], testKey},
+		{"reordered records", lines[1] + lines[0] + lines[2], testKey},
+		{"wrong key", string(data), []byte("another-key-of-32-bytes-length!!")},
+		{"not json", string(data) + "garbage\n", testKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Verify(strings.NewReader(tt.log), tt.key); !errors.Is(err, ErrChainBroken) {
+				t.Errorf("Verify() error = %v, want ErrChainBroken", err)
+			}
+		})
+	}
+
+	// A log that starts mid-chain only verifies against an anchor
+	if _, err := Verify(strings.NewReader(lines[1]+lines[2]), testKey); !errors.Is(err, ErrChainBroken) {
+		t.Errorf("Verify() of a truncated head error = %v, want ErrChainBroken", err)
+	}
+
+	var first Record
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("invalid record: %v", err)
+	}
+	v := NewVerifier(testKey)
+	v.From(first.Seq, first.Hash)
+	if err := v.Check(strings.NewReader(lines[1] + lines[2])); err != nil {
+		t.Errorf("Check() of an anchored tail error = %v", err)
+	}
+	if res := v.Result(); res.Records != 2 || res.FirstSeq != 2 || res.LastSeq != 3 {
+		t.Errorf("Result() = %+v, want records 2-3", res)
+	}
+
+	v = NewVerifier(testKey)
+	v.From(first.Seq, strings.Repeat("0", 64))
+	if err := v.Check(strings.NewReader(lines[1] + lines[2])); !errors.Is(err, ErrChainBroken) {
+		t.Errorf("Check() with the wrong anchor hash error = %v, want ErrChainBroken", err)
+	}
+}
+
+func TestReportFromContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(Config{Path: path, Key: testKey}, testLogger)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer l.Close()
+
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Report(r, Entry{Action: "attestation", Decision: DecisionDeny})
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Without the middleware there is nothing to write to
+	Report(httptest.NewRequest(http.MethodGet, "/", nil), Entry{Action: "attestation"})
+
+	data, _ := os.ReadFile(path)
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Errorf("records = %d, want 1", n)
+	}
+}
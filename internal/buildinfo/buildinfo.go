@@ -0,0 +1,116 @@
+// Package buildinfo describes the running binary: the version and build time
+// stamped in by the linker, and what the Go toolchain recorded about the
+// source revision and dependencies.
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// attestationModule is the dependency whose version is worth calling out:
+// attestation verification behaviour changes with it.
+const attestationModule = "github.com/kacy/device-attestation"
+
+// Info is what's known about the build.
+type Info struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"build_time,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	// RevisionTime is the commit time of the revision.
+	RevisionTime string `json:"revision_time,omitempty"`
+	// Modified is true when the tree had uncommitted changes at build time.
+	Modified  bool   `json:"modified"`
+	GoVersion string `json:"go_version"`
+	// Dependencies maps module paths to the versions compiled in.
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// DeviceAttestation returns the version of the attestation library, or "".
+func (i Info) DeviceAttestation() string {
+	return i.Dependencies[attestationModule]
+}
+
+var (
+	mu      sync.RWMutex
+	current = read(debug.ReadBuildInfo)
+)
+
+// Set records the version and build time passed to the linker. Empty values
+// keep what was read from the binary.
+func Set(version, buildTime string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if version != "" {
+		current.Version = version
+	}
+	if buildTime != "" {
+		current.BuildTime = buildTime
+	}
+}
+
+// Get returns the build info.
+func Get() Info {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// UserAgent is the User-Agent the proxy sends on requests it makes itself.
+func UserAgent() string {
+	return "auth-proxy/" + Get().Version
+}
+
+// Handler serves the build info as JSON.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(Get())
+}
+
+// read fills Info from the build info the toolchain embeds. Without linker
+// flags the version falls back to the module version ("(devel)" for local
+// builds) and then the short revision.
+func read(readBuildInfo func() (*debug.BuildInfo, bool)) Info {
+	info := Info{Version: "dev", GoVersion: runtime.Version()}
+	bi, ok := readBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if bi.GoVersion != "" {
+		info.GoVersion = bi.GoVersion
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.RevisionTime = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+
+	if len(bi.Deps) > 0 {
+		info.Dependencies = make(map[string]string, len(bi.Deps))
+		for _, dep := range bi.Deps {
+			version := dep.Version
+			if dep.Replace != nil && dep.Replace.Version != "" {
+				version = dep.Replace.Version
+			}
+			info.Dependencies[dep.Path] = version
+		}
+	}
+
+	switch {
+	case bi.Main.Version != "" && bi.Main.Version != "(devel)":
+		info.Version = bi.Main.Version
+	case len(info.Revision) >= 12:
+		info.Version = info.Revision[:12]
+	}
+	return info
+}
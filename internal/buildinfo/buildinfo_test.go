@@ -0,0 +1,95 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	const revision = "0123456789abcdef0123456789abcdef01234567"
+	tests := []struct {
+		name         string
+		bi           *debug.BuildInfo
+		wantVersion  string
+		wantRevision string
+		wantModified bool
+		wantAttest   string
+	}{
+		{"no build info", nil, "dev", "", false, ""},
+		{
+			name: "local build with vcs stamping",
+			bi: &debug.BuildInfo{
+				GoVersion: "go1.25.5",
+				Main:      debug.Module{Path: "github.com/kacy/auth-proxy", Version: "(devel)"},
+				Deps: []*debug.Module{
+					{Path: attestationModule, Version: "v0.1.14"},
+				},
+				Settings: []debug.BuildSetting{
+					{Key: "vcs.revision", Value: revision},
+					{Key: "vcs.modified", Value: "true"},
+				},
+			},
+			wantVersion:  revision[:12],
+			wantRevision: revision,
+			wantModified: true,
+			wantAttest:   "v0.1.14",
+		},
+		{
+			name: "go install with a module version and a replaced dependency",
+			bi: &debug.BuildInfo{
+				GoVersion: "go1.25.5",
+				Main:      debug.Module{Path: "github.com/kacy/auth-proxy", Version: "v1.4.0"},
+				Deps: []*debug.Module{
+					{Path: attestationModule, Version: "v0.1.14", Replace: &debug.Module{Path: attestationModule, Version: "v0.1.15"}},
+				},
+			},
+			wantVersion: "v1.4.0",
+			wantAttest:  "v0.1.15",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := read(func() (*debug.BuildInfo, bool) { return tt.bi, tt.bi != nil })
+			if info.Version != tt.wantVersion {
+				t.Errorf("Version = %q, want %q", info.Version, tt.wantVersion)
+			}
+			if info.Revision != tt.wantRevision {
+				t.Errorf("Revision = %q, want %q", info.Revision, tt.wantRevision)
+			}
+			if info.Modified != tt.wantModified {
+				t.Errorf("Modified = %v, want %v", info.Modified, tt.wantModified)
+			}
+			if got := info.DeviceAttestation(); got != tt.wantAttest {
+				t.Errorf("DeviceAttestation() = %q, want %q", got, tt.wantAttest)
+			}
+			if info.GoVersion == "" {
+				t.Error("GoVersion is empty")
+			}
+		})
+	}
+}
+
+func TestSetAndHandler(t *testing.T) {
+	saved := Get()
+	t.Cleanup(func() { current = saved })
+
+	Set("v2.0.0", "2026-01-02T03:04:05Z")
+	Set("", "")
+	if got := UserAgent(); got != "auth-proxy/v2.0.0" {
+		t.Errorf("UserAgent() = %q, want auth-proxy/v2.0.0", got)
+	}
+
+	rec := httptest.NewRecorder()
+	Handler(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var info Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if info.Version != "v2.0.0" || info.BuildTime != "2026-01-02T03:04:05Z" {
+		t.Errorf("info = %+v, want the linker values", info)
+	}
+}
@@ -0,0 +1,201 @@
+// Package clientip resolves the real client IP of a request behind trusted
+// reverse proxies (ingress controllers, load balancers) and carries it in the
+// request context for logging, rate limiting and geo features.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Info describes where a request came from.
+type Info struct {
+	// IP is the resolved client address.
+	IP netip.Addr
+	// Chain is the sanitized forwarding chain: the client followed by each
+	// trusted proxy it passed through, ending with the direct peer.
+	Chain []netip.Addr
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying info.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the client info stored in ctx, if any.
+func FromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(contextKey{}).(Info)
+	return info, ok
+}
+
+// FromRequest returns the resolved client IP as a string, falling back to the
+// host part of RemoteAddr when the resolver middleware hasn't run.
+func FromRequest(r *http.Request) string {
+	if info, ok := FromContext(r.Context()); ok && info.IP.IsValid() {
+		return info.IP.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Forwarding headers a trusted proxy can be set to write.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// Resolver determines the client IP from forwarding headers, trusting them
+// only as far as they were appended by proxies in the trusted ranges.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// NewResolver creates a resolver that trusts the given CIDRs (or bare IPs).
+// With no trusted proxies, forwarding headers are ignored entirely.
+//
+// header names the one forwarding header the trusted proxies write
+// (X-Forwarded-For if empty). The others are ignored: a proxy that only
+// appends to X-Forwarded-For passes a client's own Forwarded header through
+// untouched, so reading it would let the client pick its address.
+func NewResolver(trustedCIDRs []string, header string) (*Resolver, error) {
+	r := &Resolver{header: HeaderXForwardedFor}
+	switch http.CanonicalHeaderKey(header) {
+	case "", HeaderXForwardedFor:
+	case HeaderForwarded:
+		r.header = HeaderForwarded
+	case http.CanonicalHeaderKey(HeaderXRealIP):
+		r.header = HeaderXRealIP
+	default:
+		return nil, fmt.Errorf("unsupported forwarding header %q", header)
+	}
+	for _, cidr := range trustedCIDRs {
+		prefix, err := ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, prefix)
+	}
+	return r, nil
+}
+
+// Middleware resolves the client IP and stores it in the request context.
+func (r *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if info, ok := r.Resolve(req); ok {
+			req = req.WithContext(NewContext(req.Context(), info))
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Resolve walks the forwarding chain from right to left, skipping trusted
+// proxies, and returns the first untrusted address as the client.
+func (r *Resolver) Resolve(req *http.Request) (Info, bool) {
+	peer, ok := parseAddr(req.RemoteAddr)
+	if !ok {
+		return Info{}, false
+	}
+
+	chain := []netip.Addr{peer}
+	if !r.isTrusted(peer) {
+		return Info{IP: peer, Chain: chain}, true
+	}
+
+	hops := forwardedHops(req.Header, r.header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			// Garbage or obfuscated entry: everything left of it is
+			// unverifiable, so the last trusted hop is the best we know.
+			break
+		}
+		chain = append([]netip.Addr{addr}, chain...)
+		if !r.isTrusted(addr) {
+			return Info{IP: addr, Chain: chain}, true
+		}
+	}
+
+	return Info{IP: chain[0], Chain: chain}, true
+}
+
+func (r *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHops returns the forwarding chain from the given header.
+func forwardedHops(h http.Header, header string) []string {
+	var hops []string
+	switch header {
+	case HeaderForwarded:
+		for _, value := range h.Values(HeaderForwarded) {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					key, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+					if found && strings.EqualFold(key, "for") {
+						hops = append(hops, strings.Trim(val, `"`))
+					}
+				}
+			}
+		}
+	case HeaderXForwardedFor:
+		for _, value := range h.Values(HeaderXForwardedFor) {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	case HeaderXRealIP:
+		if realIP := h.Get(HeaderXRealIP); realIP != "" {
+			hops = append(hops, strings.TrimSpace(realIP))
+		}
+	}
+	return hops
+}
+
+// parseAddr accepts "ip", "ip:port", "[ipv6]" and "[ipv6]:port".
+func parseAddr(s string) (netip.Addr, bool) {
+	if s == "" {
+		return netip.Addr{}, false
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// ParsePrefix parses a CIDR or a bare IP, which is treated as a single-host
+// prefix.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q: %w", s, err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
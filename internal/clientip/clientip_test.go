@@ -0,0 +1,151 @@
+package clientip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		headers    map[string]string
+		wantIP     string
+		wantChain  int
+	}{
+		{
+			name:       "direct untrusted peer ignores headers",
+			remoteAddr: "203.0.113.7:5555",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			wantIP:     "203.0.113.7",
+			wantChain:  1,
+		},
+		{
+			name:       "trusted ingress",
+			remoteAddr: "10.1.2.3:443",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.9"},
+			wantIP:     "198.51.100.9",
+			wantChain:  2,
+		},
+		{
+			name:       "spoofed leftmost entry is skipped",
+			remoteAddr: "10.1.2.3:443",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.9, 10.9.9.9"},
+			wantIP:     "198.51.100.9",
+			wantChain:  3,
+		},
+		{
+			name:       "spoofed forwarded header ignored",
+			remoteAddr: "10.1.2.3:443",
+			headers: map[string]string{
+				"Forwarded":       "for=6.6.6.6",
+				"X-Forwarded-For": "198.51.100.9",
+			},
+			wantIP:    "198.51.100.9",
+			wantChain: 2,
+		},
+		{
+			name:       "x-real-ip ignored unless configured",
+			remoteAddr: "10.1.2.3:443",
+			headers:    map[string]string{"X-Real-IP": "6.6.6.6"},
+			wantIP:     "10.1.2.3",
+			wantChain:  1,
+		},
+		{
+			name:       "forwarded header when configured",
+			header:     "Forwarded",
+			remoteAddr: "192.168.1.1:80",
+			headers: map[string]string{
+				"Forwarded":       `for=198.51.100.1;proto=https, for="[2001:db8::17]:4711"`,
+				"X-Forwarded-For": "6.6.6.6",
+			},
+			wantIP:    "2001:db8::17",
+			wantChain: 2,
+		},
+		{
+			name:       "x-real-ip when configured",
+			header:     "x-real-ip",
+			remoteAddr: "10.1.2.3:443",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.2"},
+			wantIP:     "198.51.100.2",
+			wantChain:  2,
+		},
+		{
+			name:       "garbage entry stops the walk",
+			remoteAddr: "10.1.2.3:443",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.9, unknown, 10.0.0.5"},
+			wantIP:     "10.0.0.5",
+			wantChain:  2,
+		},
+		{
+			name:       "all trusted returns leftmost",
+			remoteAddr: "10.1.2.3:443",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.9"},
+			wantIP:     "10.0.0.9",
+			wantChain:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewResolver([]string{"10.0.0.0/8", "192.168.1.1"}, tt.header)
+			if err != nil {
+				t.Fatalf("NewResolver() error = %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			info, ok := resolver.Resolve(req)
+			if !ok {
+				t.Fatal("Resolve() returned no info")
+			}
+			if info.IP.String() != tt.wantIP {
+				t.Errorf("IP = %s, want %s", info.IP, tt.wantIP)
+			}
+			if len(info.Chain) != tt.wantChain {
+				t.Errorf("Chain = %v, want %d entries", info.Chain, tt.wantChain)
+			}
+		})
+	}
+}
+
+func TestMiddlewareStoresClientIP(t *testing.T) {
+	resolver, _ := NewResolver([]string{"10.0.0.0/8"}, "")
+
+	var got string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromRequest(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "198.51.100.9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got != "198.51.100.9" {
+		t.Errorf("FromRequest() = %q, want %q", got, "198.51.100.9")
+	}
+}
+
+func TestFromRequestFallsBackToRemoteAddr(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(context.Background())
+	req.RemoteAddr = "203.0.113.7:5555"
+	if got := FromRequest(req); got != "203.0.113.7" {
+		t.Errorf("FromRequest() = %q, want %q", got, "203.0.113.7")
+	}
+}
+
+func TestNewResolverRejectsInvalidCIDR(t *testing.T) {
+	if _, err := NewResolver([]string{"10.0.0.0/33"}, ""); err == nil {
+		t.Error("NewResolver() should reject an invalid CIDR")
+	}
+	if _, err := NewResolver([]string{"10.0.0.0/8"}, "X-Client-IP"); err == nil {
+		t.Error("NewResolver() should reject an unsupported header")
+	}
+}
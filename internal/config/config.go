@@ -2,28 +2,190 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
 	"time"
 )
 
 type Config struct {
+	// Config file the settings were layered over, if any, and how often it's
+	// checked for changes to reload
+	ConfigFile          string
+	ConfigWatchInterval time.Duration
+
 	// HTTP server settings
 	HTTPPort           int
 	ServerReadTimeout  time.Duration
 	ServerWriteTimeout time.Duration
 	ServerIdleTimeout  time.Duration
+	// ShutdownDrainDelay is how long /readyz fails before the server stops
+	// accepting connections, so load balancers move traffic away first
+	ShutdownDrainDelay time.Duration
+
+	// Readiness checks behind /readyz. Critical checks fail readiness, the
+	// rest only mark it degraded.
+	HealthCheckTimeout   time.Duration
+	HealthCheckCacheTTL  time.Duration
+	HealthCriticalChecks []string
 
 	// Supabase/GoTrue settings
 	GoTrueURL     string
 	GoTrueAnonKey string
 	GoTrueTimeout time.Duration
 
+	// TrustedProxyCIDRs are the proxies (ingress, load balancers) whose
+	// forwarding header is believed when resolving the client IP. Empty
+	// means forwarding headers are ignored.
+	TrustedProxyCIDRs []string
+	// TrustedProxyHeader is the forwarding header those proxies write:
+	// X-Forwarded-For, Forwarded or X-Real-IP.
+	TrustedProxyHeader string
+
+	// Public URL clients reach the proxy on. When set, upstream redirects,
+	// redirect_to parameters and cookie domains are rewritten to it so the
+	// Supabase host never reaches the browser.
+	PublicURL string
+	// RedirectAllowList holds the redirect_to targets clients may request
+	// (exact URLs, or https://*.example.com for subdomains).
+	RedirectAllowList []string
+
+	// Access token verification: HS256 with the project's JWT secret, and
+	// asymmetric keys from GoTrue's JWKS endpoint
+	GoTrueJWTSecret string
+	GoTrueJWKSURL   string
+	GoTrueJWTIssuer string
+
+	// MFA step-up: routes that require a minimum assurance level ("aal")
+	MFAEnforcementEnabled bool
+	MFARequiredRoutes     []string
+	MFARequiredAAL        string
+	MFAAllowUnenrolled    bool
+
+	// DPoP (RFC 9449): sessions whose token grant carried a proof are bound
+	// to the proof key, and later requests must prove possession of it
+	DPoPEnabled    bool
+	DPoPMaxAge     time.Duration
+	DPoPLeeway     time.Duration
+	DPoPBindingTTL time.Duration
+
+	// Refresh token wrapping: clients get AES-GCM sealed, device-bound
+	// handles instead of raw refresh tokens. Keys are "id:base64" entries,
+	// newest first.
+	RefreshTokenWrapEnabled         bool
+	RefreshTokenWrapKeys            []string
+	RefreshTokenWrapAcceptUnwrapped bool
+
+	// Device binding: attested device keys are recorded against the users
+	// that sign in on them
+	DeviceBindingEnabled bool
+	DeviceMaxPerUser     int
+	DeviceMaxAccounts    int
+	DeviceKeyMoveAction  string
+	DeviceBindingTTL     time.Duration
+
+	// Session revocation: logged-out and admin-revoked sessions are
+	// rejected at the edge until their tokens would have expired
+	RevocationEnabled   bool
+	RevocationRetention time.Duration
+
+	// Webhooks: auth events are posted, HMAC-signed, to each endpoint.
+	// Entries are "url" or "url#event|event" to filter what is sent.
+	WebhookEndpoints      []string
+	WebhookSecret         string
+	WebhookQueueSize      int
+	WebhookWorkers        int
+	WebhookMaxAttempts    int
+	WebhookBackoff        time.Duration
+	WebhookTimeout        time.Duration
+	WebhookDeadLetterFile string
+
+	// Audit log: security decisions appended as hash-chained JSON lines.
+	// The key signs the chain and pseudonymizes user IDs.
+	AuditLogFile string
+	AuditLogKey  string
+
+	// Admin API, served under /admin/v1 when a token is set
+	AdminAPIToken string
+	// Admin listener: when a port is set, the admin API moves off the main
+	// port onto its own, alongside pprof and runtime controls. Clients
+	// authenticate with the token, or a certificate signed by the client CA.
+	AdminPort            int
+	AdminTLSCertFile     string
+	AdminTLSKeyFile      string
+	AdminTLSClientCAFile string
+
+	// Anti-enumeration: recover/OTP/signup responses are rewritten to one
+	// success shape and padded to a latency floor so they don't reveal
+	// whether an account exists
+	AntiEnumerationEnabled    bool
+	AntiEnumerationRoutes     []string
+	AntiEnumerationMinLatency time.Duration
+	AntiEnumerationJitter     time.Duration
+
+	// Backend-for-frontend cookie sessions for web clients. Tokens are kept
+	// server-side (memory, or Redis when enabled) behind an HttpOnly cookie.
+	BFFEnabled        bool
+	BFFCookieName     string
+	BFFCookieDomain   string
+	BFFCookieSameSite string
+	BFFSessionTTL     time.Duration
+	BFFRefreshBefore  time.Duration
+
+	// CORS for browser clients. Preflights are answered before API key and
+	// attestation checks run.
+	CORSEnabled          bool
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSExposedHeaders   []string
+	CORSAllowCredentials bool
+	CORSMaxAge           time.Duration
+	// Per-route overrides keyed by path prefix
+	CORSRouteMethods map[string][]string
+	CORSRouteHeaders map[string][]string
+
+	// Signup email policy for /auth/v1/signup
+	SignupPolicyEnabled      bool
+	SignupAllowedDomains     []string
+	SignupDeniedDomains      []string
+	SignupBlockDisposable    bool
+	SignupDisposableFile     string
+	SignupDisposableReplace  bool
+	SignupDetectEmailAliases bool
+	SignupAliasRetention     time.Duration
+
+	// Breached-password check on signup and password change (k-anonymity range API)
+	BreachedPasswordCheckEnabled bool
+	BreachedPasswordAPIURL       string
+	BreachedPasswordTimeout      time.Duration
+	BreachedPasswordCacheTTL     time.Duration
+	BreachedPasswordMinCount     int
+	BreachedPasswordFailOpen     bool
+
+	// SMS pumping protection for OTP, phone signup and phone change
+	PhonePolicyEnabled       bool
+	PhoneAllowedCountryCodes []string
+	PhoneBlockPremium        bool
+	PhonePremiumPrefixesFile string
+	PhoneLimitPerNumber      int
+	PhoneLimitPerPrefix      int
+	PhoneLimitPerIP          int
+	PhoneLimitWindow         time.Duration
+	PhonePrefixDigits        int
+
 	// Metrics
 	MetricsPort int
 	Environment string
 	LogLevel    string
 
+	// Tracing - OTLP/HTTP export of request, middleware, Redis and upstream spans
+	TracingEnabled     bool
+	TracingEndpoint    string
+	TracingServiceName string
+	TracingSampleRatio float64
+
 	// Logging settings
 	LogRequestBodies bool
 	MaxLogBodySize   int64
@@ -51,54 +213,236 @@ type Config struct {
 	RedisDB        int
 	RedisKeyPrefix string
 
+	// PROXY protocol (v1/v2) on the listeners, for TCP load balancers such as
+	// AWS NLB or HAProxy that pass the client address in-band
+	ProxyProtocolEnabled       bool
+	ProxyProtocolTrustedCIDRs  []string
+	ProxyProtocolHeaderTimeout time.Duration
+
+	// Network access rules: CIDR allow/deny lists and GeoIP country rules,
+	// globally and per route ("/auth/v1/otp=RU,NG;...")
+	NetworkAccessEnabled       bool
+	NetworkAllowCIDRs          []string
+	NetworkDenyCIDRs           []string
+	NetworkAllowCountries      []string
+	NetworkDenyCountries       []string
+	NetworkRouteAllowCIDRs     map[string][]string
+	NetworkRouteDenyCIDRs      map[string][]string
+	NetworkRouteAllowCountries map[string][]string
+	NetworkRouteDenyCountries  map[string][]string
+
+	// MaxMind-format (.mmdb) database, reloaded when the file changes
+	GeoIPDatabaseFile   string
+	GeoIPReloadInterval time.Duration
+
 	// TLS
 	TLSEnabled  bool
 	TLSCertFile string
 	TLSKeyFile  string
+
+	settings []Setting
 }
 
+// Load reads the configuration from the environment, layered over the config
+// file named by CONFIG_FILE if one is set.
 func Load() (*Config, error) {
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile reads the configuration from a YAML or JSON file, with environment
+// variables taking precedence over it. Secrets can also be read from the file
+// named by <KEY>_FILE. Invalid values, unknown file settings and failed
+// validation are all errors.
+func LoadFile(path string) (*Config, error) {
+	l, err := newLoader(path)
+	if err != nil {
+		return nil, err
+	}
+
 	cfg := &Config{
-		HTTPPort:           getEnvInt("HTTP_PORT", 8080),
-		ServerReadTimeout:  getEnvDuration("SERVER_READ_TIMEOUT", 10*time.Second),
-		ServerWriteTimeout: getEnvDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
-		ServerIdleTimeout:  getEnvDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
-
-		GoTrueURL:     getEnvRequired("GOTRUE_URL"),
-		GoTrueAnonKey: getEnvRequired("GOTRUE_ANON_KEY"),
-		GoTrueTimeout: getEnvDuration("GOTRUE_TIMEOUT", 30*time.Second),
-
-		MetricsPort: getEnvInt("METRICS_PORT", 9090),
-		Environment: getEnvDefault("ENVIRONMENT", "development"),
-		LogLevel:    getEnvDefault("LOG_LEVEL", "info"),
-
-		LogRequestBodies: getEnvBool("LOG_REQUEST_BODIES", false),
-		MaxLogBodySize:   int64(getEnvInt("MAX_LOG_BODY_SIZE", 10240)),
-
-		RequireAPIKey: getEnvBool("REQUIRE_API_KEY", true),
-
-		AttestationIOSEnabled:           getEnvBool("ATTESTATION_IOS_ENABLED", false),
-		AttestationAndroidEnabled:       getEnvBool("ATTESTATION_ANDROID_ENABLED", false),
-		AttestationIOSBundleID:          os.Getenv("ATTESTATION_IOS_BUNDLE_ID"),
-		AttestationIOSTeamID:            os.Getenv("ATTESTATION_IOS_TEAM_ID"),
-		AttestationAndroidPackage:       os.Getenv("ATTESTATION_ANDROID_PACKAGE"),
-		AttestationGCPProjectID:         os.Getenv("ATTESTATION_GCP_PROJECT_ID"),
-		AttestationGCPCredentialsFile:   os.Getenv("ATTESTATION_GCP_CREDENTIALS_FILE"),
-		AttestationRequireStrong:        getEnvBool("ATTESTATION_REQUIRE_STRONG_INTEGRITY", false),
-		AttestationChallengeTimeout:     getEnvDuration("ATTESTATION_CHALLENGE_TIMEOUT", 5*time.Minute),
-		AttestationSkipCertVerification: getEnvBool("ATTESTATION_SKIP_CERT_VERIFICATION", false),
-
-		RedisEnabled:   getEnvBool("REDIS_ENABLED", false),
-		RedisAddr:      getEnvDefault("REDIS_ADDR", "localhost:6379"),
-		RedisPassword:  os.Getenv("REDIS_PASSWORD"),
-		RedisDB:        getEnvInt("REDIS_DB", 0),
-		RedisKeyPrefix: getEnvDefault("REDIS_KEY_PREFIX", "authproxy:"),
-
-		TLSEnabled:  getEnvBool("TLS_ENABLED", false),
-		TLSCertFile: os.Getenv("TLS_CERT_FILE"),
-		TLSKeyFile:  os.Getenv("TLS_KEY_FILE"),
+		ConfigFile:          path,
+		ConfigWatchInterval: l.duration("CONFIG_WATCH_INTERVAL", 10*time.Second),
+
+		HTTPPort:           l.int("HTTP_PORT", 8080),
+		ServerReadTimeout:  l.duration("SERVER_READ_TIMEOUT", 10*time.Second),
+		ServerWriteTimeout: l.duration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		ServerIdleTimeout:  l.duration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+		ShutdownDrainDelay: l.duration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+
+		HealthCheckTimeout:   l.duration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		HealthCheckCacheTTL:  l.duration("HEALTH_CHECK_CACHE_TTL", 5*time.Second),
+		HealthCriticalChecks: l.listOr("HEALTH_CRITICAL_CHECKS", []string{"redis", "attestation"}),
+
+		GoTrueURL:     l.string("GOTRUE_URL", ""),
+		GoTrueAnonKey: l.secret("GOTRUE_ANON_KEY"),
+		GoTrueTimeout: l.duration("GOTRUE_TIMEOUT", 30*time.Second),
+
+		TrustedProxyCIDRs:  l.list("TRUSTED_PROXY_CIDRS"),
+		TrustedProxyHeader: l.string("TRUSTED_PROXY_HEADER", "X-Forwarded-For"),
+
+		PublicURL:         l.string("PUBLIC_URL", ""),
+		RedirectAllowList: l.list("REDIRECT_ALLOWLIST"),
+
+		GoTrueJWTSecret: l.secret("GOTRUE_JWT_SECRET"),
+		GoTrueJWKSURL:   l.string("GOTRUE_JWKS_URL", ""),
+		GoTrueJWTIssuer: l.string("GOTRUE_JWT_ISSUER", ""),
+
+		MFAEnforcementEnabled: l.bool("MFA_ENFORCEMENT_ENABLED", false),
+		MFARequiredRoutes:     l.list("MFA_REQUIRED_ROUTES"),
+		MFARequiredAAL:        l.string("MFA_REQUIRED_AAL", "aal2"),
+		MFAAllowUnenrolled:    l.bool("MFA_ALLOW_UNENROLLED", false),
+
+		DPoPEnabled:    l.bool("DPOP_ENABLED", false),
+		DPoPMaxAge:     l.duration("DPOP_PROOF_MAX_AGE", time.Minute),
+		DPoPLeeway:     l.duration("DPOP_CLOCK_SKEW", 5*time.Second),
+		DPoPBindingTTL: l.duration("DPOP_BINDING_TTL", 30*24*time.Hour),
+
+		RefreshTokenWrapEnabled:         l.bool("REFRESH_TOKEN_WRAP_ENABLED", false),
+		RefreshTokenWrapKeys:            l.secretList("REFRESH_TOKEN_WRAP_KEYS"),
+		RefreshTokenWrapAcceptUnwrapped: l.bool("REFRESH_TOKEN_WRAP_ACCEPT_UNWRAPPED", true),
+
+		DeviceBindingEnabled: l.bool("DEVICE_BINDING_ENABLED", false),
+		DeviceMaxPerUser:     l.int("DEVICE_MAX_PER_USER", 0),
+		DeviceMaxAccounts:    l.int("DEVICE_MAX_ACCOUNTS", 0),
+		DeviceKeyMoveAction:  l.string("DEVICE_KEY_MOVE_ACTION", "alert"),
+		DeviceBindingTTL:     l.duration("DEVICE_BINDING_TTL", 90*24*time.Hour),
+
+		RevocationEnabled:   l.bool("REVOCATION_ENABLED", false),
+		RevocationRetention: l.duration("REVOCATION_RETENTION", 30*24*time.Hour),
+
+		WebhookEndpoints:      l.list("WEBHOOK_ENDPOINTS"),
+		WebhookSecret:         l.secret("WEBHOOK_SECRET"),
+		WebhookQueueSize:      l.int("WEBHOOK_QUEUE_SIZE", 1000),
+		WebhookWorkers:        l.int("WEBHOOK_WORKERS", 4),
+		WebhookMaxAttempts:    l.int("WEBHOOK_MAX_ATTEMPTS", 5),
+		WebhookBackoff:        l.duration("WEBHOOK_BACKOFF", time.Second),
+		WebhookTimeout:        l.duration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookDeadLetterFile: l.string("WEBHOOK_DEAD_LETTER_FILE", ""),
+
+		AuditLogFile: l.string("AUDIT_LOG_FILE", ""),
+		AuditLogKey:  l.secret("AUDIT_LOG_KEY"),
+
+		AdminAPIToken:        l.secret("ADMIN_API_TOKEN"),
+		AdminPort:            l.int("ADMIN_PORT", 0),
+		AdminTLSCertFile:     l.string("ADMIN_TLS_CERT_FILE", ""),
+		AdminTLSKeyFile:      l.string("ADMIN_TLS_KEY_FILE", ""),
+		AdminTLSClientCAFile: l.string("ADMIN_TLS_CLIENT_CA_FILE", ""),
+
+		AntiEnumerationEnabled:    l.bool("ANTI_ENUMERATION_ENABLED", false),
+		AntiEnumerationRoutes:     l.list("ANTI_ENUMERATION_ROUTES"),
+		AntiEnumerationMinLatency: l.duration("ANTI_ENUMERATION_MIN_LATENCY", 500*time.Millisecond),
+		AntiEnumerationJitter:     l.duration("ANTI_ENUMERATION_JITTER", 100*time.Millisecond),
+
+		BFFEnabled:        l.bool("BFF_ENABLED", false),
+		BFFCookieName:     l.string("BFF_COOKIE_NAME", "authproxy_session"),
+		BFFCookieDomain:   l.string("BFF_COOKIE_DOMAIN", ""),
+		BFFCookieSameSite: l.string("BFF_COOKIE_SAMESITE", "lax"),
+		BFFSessionTTL:     l.duration("BFF_SESSION_TTL", 7*24*time.Hour),
+		BFFRefreshBefore:  l.duration("BFF_REFRESH_BEFORE", time.Minute),
+
+		CORSEnabled:          l.bool("CORS_ENABLED", false),
+		CORSAllowedOrigins:   l.list("CORS_ALLOWED_ORIGINS"),
+		CORSAllowedMethods:   l.list("CORS_ALLOWED_METHODS"),
+		CORSAllowedHeaders:   l.list("CORS_ALLOWED_HEADERS"),
+		CORSExposedHeaders:   l.list("CORS_EXPOSED_HEADERS"),
+		CORSAllowCredentials: l.bool("CORS_ALLOW_CREDENTIALS", false),
+		CORSMaxAge:           l.duration("CORS_MAX_AGE", 10*time.Minute),
+		CORSRouteMethods:     l.routeMap("CORS_ROUTE_METHODS"),
+		CORSRouteHeaders:     l.routeMap("CORS_ROUTE_HEADERS"),
+
+		SignupPolicyEnabled:      l.bool("SIGNUP_POLICY_ENABLED", false),
+		SignupAllowedDomains:     l.list("SIGNUP_ALLOWED_DOMAINS"),
+		SignupDeniedDomains:      l.list("SIGNUP_DENIED_DOMAINS"),
+		SignupBlockDisposable:    l.bool("SIGNUP_BLOCK_DISPOSABLE", true),
+		SignupDisposableFile:     l.string("SIGNUP_DISPOSABLE_DOMAINS_FILE", ""),
+		SignupDisposableReplace:  l.bool("SIGNUP_DISPOSABLE_DOMAINS_REPLACE", false),
+		SignupDetectEmailAliases: l.bool("SIGNUP_DETECT_EMAIL_ALIASES", false),
+		SignupAliasRetention:     l.duration("SIGNUP_ALIAS_RETENTION", 365*24*time.Hour),
+
+		BreachedPasswordCheckEnabled: l.bool("BREACHED_PASSWORD_CHECK_ENABLED", false),
+		BreachedPasswordAPIURL:       l.string("BREACHED_PASSWORD_API_URL", "https://api.pwnedpasswords.com/range"),
+		BreachedPasswordTimeout:      l.duration("BREACHED_PASSWORD_TIMEOUT", 2*time.Second),
+		BreachedPasswordCacheTTL:     l.duration("BREACHED_PASSWORD_CACHE_TTL", time.Hour),
+		BreachedPasswordMinCount:     l.int("BREACHED_PASSWORD_MIN_COUNT", 1),
+		BreachedPasswordFailOpen:     l.bool("BREACHED_PASSWORD_FAIL_OPEN", true),
+
+		PhonePolicyEnabled:       l.bool("PHONE_POLICY_ENABLED", false),
+		PhoneAllowedCountryCodes: l.list("PHONE_ALLOWED_COUNTRY_CODES"),
+		PhoneBlockPremium:        l.bool("PHONE_BLOCK_PREMIUM", true),
+		PhonePremiumPrefixesFile: l.string("PHONE_PREMIUM_PREFIXES_FILE", ""),
+		PhoneLimitPerNumber:      l.int("PHONE_LIMIT_PER_NUMBER", 5),
+		PhoneLimitPerPrefix:      l.int("PHONE_LIMIT_PER_PREFIX", 20),
+		PhoneLimitPerIP:          l.int("PHONE_LIMIT_PER_IP", 10),
+		PhoneLimitWindow:         l.duration("PHONE_LIMIT_WINDOW", time.Hour),
+		PhonePrefixDigits:        l.int("PHONE_PREFIX_DIGITS", 3),
+
+		MetricsPort: l.int("METRICS_PORT", 9090),
+		Environment: l.string("ENVIRONMENT", "development"),
+		LogLevel:    l.string("LOG_LEVEL", "info"),
+
+		TracingEnabled:     l.bool("TRACING_ENABLED", false),
+		TracingEndpoint:    l.string("TRACING_ENDPOINT", ""),
+		TracingServiceName: l.string("TRACING_SERVICE_NAME", "auth-proxy"),
+		TracingSampleRatio: l.float("TRACING_SAMPLE_RATIO", 1.0),
+
+		LogRequestBodies: l.bool("LOG_REQUEST_BODIES", false),
+		MaxLogBodySize:   int64(l.int("MAX_LOG_BODY_SIZE", 10240)),
+
+		RequireAPIKey: l.bool("REQUIRE_API_KEY", true),
+
+		AttestationIOSEnabled:           l.bool("ATTESTATION_IOS_ENABLED", false),
+		AttestationAndroidEnabled:       l.bool("ATTESTATION_ANDROID_ENABLED", false),
+		AttestationIOSBundleID:          l.string("ATTESTATION_IOS_BUNDLE_ID", ""),
+		AttestationIOSTeamID:            l.string("ATTESTATION_IOS_TEAM_ID", ""),
+		AttestationAndroidPackage:       l.string("ATTESTATION_ANDROID_PACKAGE", ""),
+		AttestationGCPProjectID:         l.string("ATTESTATION_GCP_PROJECT_ID", ""),
+		AttestationGCPCredentialsFile:   l.string("ATTESTATION_GCP_CREDENTIALS_FILE", ""),
+		AttestationRequireStrong:        l.bool("ATTESTATION_REQUIRE_STRONG_INTEGRITY", false),
+		AttestationChallengeTimeout:     l.duration("ATTESTATION_CHALLENGE_TIMEOUT", 5*time.Minute),
+		AttestationSkipCertVerification: l.bool("ATTESTATION_SKIP_CERT_VERIFICATION", false),
+
+		RedisEnabled:   l.bool("REDIS_ENABLED", false),
+		RedisAddr:      l.string("REDIS_ADDR", "localhost:6379"),
+		RedisPassword:  l.secret("REDIS_PASSWORD"),
+		RedisDB:        l.int("REDIS_DB", 0),
+		RedisKeyPrefix: l.string("REDIS_KEY_PREFIX", "authproxy:"),
+
+		ProxyProtocolEnabled:       l.bool("PROXY_PROTOCOL_ENABLED", false),
+		ProxyProtocolTrustedCIDRs:  l.list("PROXY_PROTOCOL_TRUSTED_CIDRS"),
+		ProxyProtocolHeaderTimeout: l.duration("PROXY_PROTOCOL_HEADER_TIMEOUT", 5*time.Second),
+
+		NetworkAccessEnabled:       l.bool("NETWORK_ACCESS_ENABLED", false),
+		NetworkAllowCIDRs:          l.list("NETWORK_ALLOW_CIDRS"),
+		NetworkDenyCIDRs:           l.list("NETWORK_DENY_CIDRS"),
+		NetworkAllowCountries:      l.list("NETWORK_ALLOW_COUNTRIES"),
+		NetworkDenyCountries:       l.list("NETWORK_DENY_COUNTRIES"),
+		NetworkRouteAllowCIDRs:     l.routeMap("NETWORK_ROUTE_ALLOW_CIDRS"),
+		NetworkRouteDenyCIDRs:      l.routeMap("NETWORK_ROUTE_DENY_CIDRS"),
+		NetworkRouteAllowCountries: l.routeMap("NETWORK_ROUTE_ALLOW_COUNTRIES"),
+		NetworkRouteDenyCountries:  l.routeMap("NETWORK_ROUTE_DENY_COUNTRIES"),
+
+		GeoIPDatabaseFile:   l.string("GEOIP_DATABASE_FILE", ""),
+		GeoIPReloadInterval: l.duration("GEOIP_RELOAD_INTERVAL", time.Minute),
+
+		TLSEnabled:  l.bool("TLS_ENABLED", false),
+		TLSCertFile: l.string("TLS_CERT_FILE", ""),
+		TLSKeyFile:  l.string("TLS_KEY_FILE", ""),
+	}
+
+	// GoTrue publishes its signing keys next to the API, and names the API
+	// as the issuer of its tokens
+	if cfg.GoTrueJWKSURL == "" && cfg.GoTrueURL != "" {
+		cfg.GoTrueJWKSURL = strings.TrimSuffix(cfg.GoTrueURL, "/") + "/auth/v1/.well-known/jwks.json"
+	}
+	if cfg.GoTrueJWTIssuer == "" && cfg.GoTrueURL != "" {
+		cfg.GoTrueJWTIssuer = strings.TrimSuffix(cfg.GoTrueURL, "/") + "/auth/v1"
 	}
 
+	if err := l.finish(); err != nil {
+		return nil, err
+	}
+	cfg.settings = l.sortedSettings()
+
 	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
@@ -114,6 +458,74 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("GOTRUE_ANON_KEY is required")
 	}
 
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error", "":
+	default:
+		return fmt.Errorf("LOG_LEVEL must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("PUBLIC_URL must be an absolute URL, got %q", c.PublicURL)
+		}
+	}
+
+	// The allowlist is enforced by the redirect rewriter, which needs the
+	// public URL
+	if len(c.RedirectAllowList) > 0 && c.PublicURL == "" {
+		return fmt.Errorf("REDIRECT_ALLOWLIST is set but PUBLIC_URL is not")
+	}
+
+	// A proof's htu is checked against PUBLIC_URL; rebuilding it from the
+	// request gets the scheme wrong behind a TLS-terminating ingress
+	if c.DPoPEnabled && c.PublicURL == "" {
+		return fmt.Errorf("DPOP_ENABLED requires PUBLIC_URL")
+	}
+
+	if c.BFFEnabled {
+		switch strings.ToLower(c.BFFCookieSameSite) {
+		case "lax", "strict", "none":
+		default:
+			return fmt.Errorf("BFF_COOKIE_SAMESITE must be lax, strict or none, got %q", c.BFFCookieSameSite)
+		}
+	}
+
+	if c.CORSEnabled && len(c.CORSAllowedOrigins) == 0 {
+		return fmt.Errorf("CORS_ENABLED is true but CORS_ALLOWED_ORIGINS is not set")
+	}
+	// Reflecting any origin with credentials would let every site read a
+	// cookie session
+	if c.CORSEnabled && c.CORSAllowCredentials && slices.Contains(c.CORSAllowedOrigins, "*") {
+		return fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*; list the origins instead")
+	}
+
+	if c.NetworkAccessEnabled && c.GeoIPDatabaseFile == "" && c.hasCountryRules() {
+		return fmt.Errorf("NETWORK_ACCESS_ENABLED has country rules but GEOIP_DATABASE_FILE is not set")
+	}
+
+	if c.RefreshTokenWrapEnabled && len(c.RefreshTokenWrapKeys) == 0 {
+		return fmt.Errorf("REFRESH_TOKEN_WRAP_ENABLED is true but REFRESH_TOKEN_WRAP_KEYS is not set")
+	}
+
+	// Handles are bound to X-Attestation-Key-ID, which only means something
+	// when the attestation middleware verifies it
+	if c.RefreshTokenWrapEnabled && !c.AttestationIOSEnabled && !c.AttestationAndroidEnabled {
+		return fmt.Errorf("REFRESH_TOKEN_WRAP_ENABLED requires ATTESTATION_IOS_ENABLED or ATTESTATION_ANDROID_ENABLED")
+	}
+
+	if c.AuditLogFile != "" && len(c.AuditLogKey) < 16 {
+		return fmt.Errorf("AUDIT_LOG_FILE is set but AUDIT_LOG_KEY is missing or shorter than 16 bytes")
+	}
+
+	if c.TracingEnabled && (c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1) {
+		return fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %v", c.TracingSampleRatio)
+	}
+
+	if len(c.WebhookEndpoints) > 0 && c.WebhookSecret == "" {
+		return fmt.Errorf("WEBHOOK_ENDPOINTS is set but WEBHOOK_SECRET is not")
+	}
+
 	if c.AttestationIOSEnabled {
 		if c.AttestationIOSBundleID == "" {
 			return fmt.Errorf("ATTESTATION_IOS_ENABLED is true but ATTESTATION_IOS_BUNDLE_ID is not set")
@@ -138,47 +550,152 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	for _, name := range c.HealthCriticalChecks {
+		switch name {
+		case "redis", "gotrue", "attestation", "none":
+		default:
+			return fmt.Errorf("HEALTH_CRITICAL_CHECKS: unknown check %q, want redis, gotrue, attestation or none", name)
+		}
+	}
+
+	if (c.AdminTLSCertFile == "") != (c.AdminTLSKeyFile == "") {
+		return fmt.Errorf("ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE must be set together")
+	}
+	if c.AdminTLSClientCAFile != "" && c.AdminTLSCertFile == "" {
+		return fmt.Errorf("ADMIN_TLS_CLIENT_CA_FILE is set but ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE are not")
+	}
+	if c.AdminPort != 0 {
+		if c.AdminAPIToken == "" && c.AdminTLSClientCAFile == "" {
+			return fmt.Errorf("ADMIN_PORT is set but neither ADMIN_API_TOKEN nor ADMIN_TLS_CLIENT_CA_FILE is")
+		}
+		if c.AdminPort == c.HTTPPort || c.AdminPort == c.MetricsPort {
+			return fmt.Errorf("ADMIN_PORT %d is already used by HTTP_PORT or METRICS_PORT", c.AdminPort)
+		}
+	}
+
 	return nil
 }
 
+func (c *Config) hasCountryRules() bool {
+	return len(c.NetworkAllowCountries) > 0 || len(c.NetworkDenyCountries) > 0 ||
+		len(c.NetworkRouteAllowCountries) > 0 || len(c.NetworkRouteDenyCountries) > 0
+}
+
 func (c *Config) IsProduction() bool {
 	return c.Environment == "production"
 }
 
-func getEnvRequired(key string) string {
-	return os.Getenv(key)
+// Settings returns every setting with its value and source, ordered by key.
+// Use Setting.Redacted to display values.
+func (c *Config) Settings() []Setting {
+	return c.settings
 }
 
-func getEnvDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
+// reloadable lists the settings that can change without a restart: the
+// request policies, the log level and key lists.
+var reloadable = []string{
+	"LOG_LEVEL",
+	"CORS_",
+	"MFA_",
+	"NETWORK_",
+	"PHONE_",
+	"SIGNUP_",
+	"REFRESH_TOKEN_WRAP_KEYS",
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// IsReloadable reports whether a setting is applied by a reload.
+func IsReloadable(key string) bool {
+	for _, prefix := range reloadable {
+		if strings.HasPrefix(key, prefix) {
+			return true
 		}
 	}
-	return defaultValue
+	return false
 }
 
-func getEnvBool(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
-		if boolValue, err := strconv.ParseBool(value); err == nil {
-			return boolValue
+// Changed returns the keys of the settings that differ in next, split into
+// those a reload applies and those that need a restart.
+func (c *Config) Changed(next *Config) (reload, restart []string) {
+	prev := make(map[string]string, len(c.settings))
+	for _, s := range c.settings {
+		prev[s.Key] = s.Value
+	}
+	for _, s := range next.settings {
+		if v, ok := prev[s.Key]; ok && v == s.Value {
+			continue
+		}
+		if IsReloadable(s.Key) {
+			reload = append(reload, s.Key)
+		} else {
+			restart = append(restart, s.Key)
 		}
 	}
-	return defaultValue
+	return reload, restart
 }
 
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
+// Reloaded returns a copy of c with the reloadable settings taken from next.
+// Settings that need a restart keep their current values.
+func (c *Config) Reloaded(next *Config) *Config {
+	merged := *c
+
+	merged.LogLevel = next.LogLevel
+
+	merged.CORSEnabled = next.CORSEnabled
+	merged.CORSAllowedOrigins = next.CORSAllowedOrigins
+	merged.CORSAllowedMethods = next.CORSAllowedMethods
+	merged.CORSAllowedHeaders = next.CORSAllowedHeaders
+	merged.CORSExposedHeaders = next.CORSExposedHeaders
+	merged.CORSAllowCredentials = next.CORSAllowCredentials
+	merged.CORSMaxAge = next.CORSMaxAge
+	merged.CORSRouteMethods = next.CORSRouteMethods
+	merged.CORSRouteHeaders = next.CORSRouteHeaders
+
+	merged.MFAEnforcementEnabled = next.MFAEnforcementEnabled
+	merged.MFARequiredRoutes = next.MFARequiredRoutes
+	merged.MFARequiredAAL = next.MFARequiredAAL
+	merged.MFAAllowUnenrolled = next.MFAAllowUnenrolled
+
+	merged.NetworkAccessEnabled = next.NetworkAccessEnabled
+	merged.NetworkAllowCIDRs = next.NetworkAllowCIDRs
+	merged.NetworkDenyCIDRs = next.NetworkDenyCIDRs
+	merged.NetworkAllowCountries = next.NetworkAllowCountries
+	merged.NetworkDenyCountries = next.NetworkDenyCountries
+	merged.NetworkRouteAllowCIDRs = next.NetworkRouteAllowCIDRs
+	merged.NetworkRouteDenyCIDRs = next.NetworkRouteDenyCIDRs
+	merged.NetworkRouteAllowCountries = next.NetworkRouteAllowCountries
+	merged.NetworkRouteDenyCountries = next.NetworkRouteDenyCountries
+
+	merged.PhonePolicyEnabled = next.PhonePolicyEnabled
+	merged.PhoneAllowedCountryCodes = next.PhoneAllowedCountryCodes
+	merged.PhoneBlockPremium = next.PhoneBlockPremium
+	merged.PhonePremiumPrefixesFile = next.PhonePremiumPrefixesFile
+	merged.PhoneLimitPerNumber = next.PhoneLimitPerNumber
+	merged.PhoneLimitPerPrefix = next.PhoneLimitPerPrefix
+	merged.PhoneLimitPerIP = next.PhoneLimitPerIP
+	merged.PhoneLimitWindow = next.PhoneLimitWindow
+	merged.PhonePrefixDigits = next.PhonePrefixDigits
+
+	merged.SignupPolicyEnabled = next.SignupPolicyEnabled
+	merged.SignupAllowedDomains = next.SignupAllowedDomains
+	merged.SignupDeniedDomains = next.SignupDeniedDomains
+	merged.SignupBlockDisposable = next.SignupBlockDisposable
+	merged.SignupDisposableFile = next.SignupDisposableFile
+	merged.SignupDisposableReplace = next.SignupDisposableReplace
+	merged.SignupDetectEmailAliases = next.SignupDetectEmailAliases
+	merged.SignupAliasRetention = next.SignupAliasRetention
+
+	merged.RefreshTokenWrapKeys = next.RefreshTokenWrapKeys
+
+	merged.settings = make([]Setting, len(c.settings))
+	copy(merged.settings, c.settings)
+	byKey := make(map[string]Setting, len(next.settings))
+	for _, s := range next.settings {
+		byKey[s.Key] = s
+	}
+	for i, s := range merged.settings {
+		if n, ok := byKey[s.Key]; ok && IsReloadable(s.Key) {
+			merged.settings[i] = n
 		}
 	}
-	return defaultValue
+	return &merged
 }
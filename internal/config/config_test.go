@@ -2,11 +2,13 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
 
-func TestGetEnvDefault(t *testing.T) {
+func TestLoaderString(t *testing.T) {
 	tests := []struct {
 		name         string
 		key          string
@@ -25,25 +27,27 @@ func TestGetEnvDefault(t *testing.T) {
 				defer os.Unsetenv(tt.key)
 			}
 
-			got := getEnvDefault(tt.key, tt.defaultValue)
+			l, _ := newLoader("")
+			got := l.string(tt.key, tt.defaultValue)
 			if got != tt.want {
-				t.Errorf("getEnvDefault(%q, %q) = %q, want %q", tt.key, tt.defaultValue, got, tt.want)
+				t.Errorf("string(%q, %q) = %q, want %q", tt.key, tt.defaultValue, got, tt.want)
 			}
 		})
 	}
 }
 
-func TestGetEnvInt(t *testing.T) {
+func TestLoaderInt(t *testing.T) {
 	tests := []struct {
 		name         string
 		key          string
 		defaultValue int
 		envValue     string
 		want         int
+		wantErr      bool
 	}{
-		{"returns default when not set", "TEST_INT_1", 8080, "", 8080},
-		{"returns parsed int when set", "TEST_INT_2", 8080, "9090", 9090},
-		{"returns default on invalid int", "TEST_INT_3", 8080, "invalid", 8080},
+		{"returns default when not set", "TEST_INT_1", 8080, "", 8080, false},
+		{"returns parsed int when set", "TEST_INT_2", 8080, "9090", 9090, false},
+		{"fails on invalid int", "TEST_INT_3", 8080, "80a", 8080, true},
 	}
 
 	for _, tt := range tests {
@@ -53,25 +57,63 @@ func TestGetEnvInt(t *testing.T) {
 				defer os.Unsetenv(tt.key)
 			}
 
-			got := getEnvInt(tt.key, tt.defaultValue)
+			l, _ := newLoader("")
+			got := l.int(tt.key, tt.defaultValue)
 			if got != tt.want {
-				t.Errorf("getEnvInt(%q, %d) = %d, want %d", tt.key, tt.defaultValue, got, tt.want)
+				t.Errorf("int(%q, %d) = %d, want %d", tt.key, tt.defaultValue, got, tt.want)
+			}
+			if err := l.finish(); (err != nil) != tt.wantErr {
+				t.Errorf("finish() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoaderFloat(t *testing.T) {
+	tests := []struct {
+		name         string
+		key          string
+		defaultValue float64
+		envValue     string
+		want         float64
+		wantErr      bool
+	}{
+		{"returns default when not set", "TEST_FLOAT_1", 1, "", 1, false},
+		{"returns parsed float when set", "TEST_FLOAT_2", 1, "0.25", 0.25, false},
+		{"fails on invalid float", "TEST_FLOAT_3", 1, "invalid", 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.envValue != "" {
+				os.Setenv(tt.key, tt.envValue)
+				defer os.Unsetenv(tt.key)
+			}
+
+			l, _ := newLoader("")
+			got := l.float(tt.key, tt.defaultValue)
+			if got != tt.want {
+				t.Errorf("float(%q, %v) = %v, want %v", tt.key, tt.defaultValue, got, tt.want)
+			}
+			if err := l.finish(); (err != nil) != tt.wantErr {
+				t.Errorf("finish() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestGetEnvDuration(t *testing.T) {
+func TestLoaderDuration(t *testing.T) {
 	tests := []struct {
 		name         string
 		key          string
 		defaultValue time.Duration
 		envValue     string
 		want         time.Duration
+		wantErr      bool
 	}{
-		{"returns default when not set", "TEST_DUR_1", 10 * time.Second, "", 10 * time.Second},
-		{"returns parsed duration when set", "TEST_DUR_2", 10 * time.Second, "30s", 30 * time.Second},
-		{"returns default on invalid duration", "TEST_DUR_3", 10 * time.Second, "invalid", 10 * time.Second},
+		{"returns default when not set", "TEST_DUR_1", 10 * time.Second, "", 10 * time.Second, false},
+		{"returns parsed duration when set", "TEST_DUR_2", 10 * time.Second, "30s", 30 * time.Second, false},
+		{"fails on invalid duration", "TEST_DUR_3", 10 * time.Second, "30", 10 * time.Second, true},
 	}
 
 	for _, tt := range tests {
@@ -81,14 +123,63 @@ func TestGetEnvDuration(t *testing.T) {
 				defer os.Unsetenv(tt.key)
 			}
 
-			got := getEnvDuration(tt.key, tt.defaultValue)
+			l, _ := newLoader("")
+			got := l.duration(tt.key, tt.defaultValue)
 			if got != tt.want {
-				t.Errorf("getEnvDuration(%q, %v) = %v, want %v", tt.key, tt.defaultValue, got, tt.want)
+				t.Errorf("duration(%q, %v) = %v, want %v", tt.key, tt.defaultValue, got, tt.want)
+			}
+			if err := l.finish(); (err != nil) != tt.wantErr {
+				t.Errorf("finish() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
+func TestLoaderList(t *testing.T) {
+	os.Setenv("TEST_LIST", " a, b ,,c ")
+	defer os.Unsetenv("TEST_LIST")
+
+	l, _ := newLoader("")
+	got := l.list("TEST_LIST")
+	want := []string{"a", "b", "c"}
+	if len(got) != len(want) {
+		t.Fatalf("list() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("list()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	if got := l.list("TEST_LIST_UNSET"); got != nil {
+		t.Errorf("list() on unset var = %v, want nil", got)
+	}
+}
+
+func TestLoaderRouteMap(t *testing.T) {
+	os.Setenv("TEST_ROUTES", "/auth/v1/user=GET, PUT; /auth/v1/token=POST;")
+	defer os.Unsetenv("TEST_ROUTES")
+
+	l, _ := newLoader("")
+	got := l.routeMap("TEST_ROUTES")
+	if len(got) != 2 {
+		t.Fatalf("routeMap() = %v, want 2 routes", got)
+	}
+	if methods := got["/auth/v1/user"]; len(methods) != 2 || methods[0] != "GET" || methods[1] != "PUT" {
+		t.Errorf("/auth/v1/user = %v, want [GET PUT]", methods)
+	}
+	if methods := got["/auth/v1/token"]; len(methods) != 1 || methods[0] != "POST" {
+		t.Errorf("/auth/v1/token = %v, want [POST]", methods)
+	}
+
+	os.Setenv("TEST_ROUTES", "/auth/v1/user=GET;invalid")
+	l, _ = newLoader("")
+	l.routeMap("TEST_ROUTES")
+	if err := l.finish(); err == nil {
+		t.Error("routeMap() with an entry missing = expected an error")
+	}
+}
+
 func TestConfigValidation(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -117,6 +208,145 @@ func TestConfigValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "redirect allowlist without a public URL",
+			config: Config{
+				GoTrueURL:         "http://gotrue:9999",
+				GoTrueAnonKey:     "anon-key",
+				RedirectAllowList: []string{"https://app.example.com"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dpop without a public URL",
+			config: Config{
+				GoTrueURL:     "http://gotrue:9999",
+				GoTrueAnonKey: "anon-key",
+				DPoPEnabled:   true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "refresh token wrapping without keys",
+			config: Config{
+				GoTrueURL:               "http://gotrue:9999",
+				GoTrueAnonKey:           "anon-key",
+				RefreshTokenWrapEnabled: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "refresh token wrapping without attestation",
+			config: Config{
+				GoTrueURL:               "http://gotrue:9999",
+				GoTrueAnonKey:           "anon-key",
+				RefreshTokenWrapEnabled: true,
+				RefreshTokenWrapKeys:    []string{"k1:c2VjcmV0"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "audit log without a key",
+			config: Config{
+				GoTrueURL:     "http://gotrue:9999",
+				GoTrueAnonKey: "anon-key",
+				AuditLogFile:  "/var/log/auth-proxy/audit.jsonl",
+			},
+			wantErr: true,
+		},
+		{
+			name: "tracing sample ratio out of range",
+			config: Config{
+				GoTrueURL:          "http://gotrue:9999",
+				GoTrueAnonKey:      "anon-key",
+				TracingEnabled:     true,
+				TracingSampleRatio: 1.5,
+			},
+			wantErr: true,
+		},
+		{
+			name: "webhooks without a secret",
+			config: Config{
+				GoTrueURL:        "http://gotrue:9999",
+				GoTrueAnonKey:    "anon-key",
+				WebhookEndpoints: []string{"https://crm.example.com/hook"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "any CORS origin with credentials",
+			config: Config{
+				GoTrueURL:            "http://gotrue:9999",
+				GoTrueAnonKey:        "anon-key",
+				CORSEnabled:          true,
+				CORSAllowedOrigins:   []string{"https://app.example.com", "*"},
+				CORSAllowCredentials: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown critical health check",
+			config: Config{
+				GoTrueURL:            "http://gotrue:9999",
+				GoTrueAnonKey:        "anon-key",
+				HealthCriticalChecks: []string{"redis", "postgres"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin port with a token",
+			config: Config{
+				GoTrueURL:     "http://gotrue:9999",
+				GoTrueAnonKey: "anon-key",
+				HTTPPort:      8080,
+				MetricsPort:   9090,
+				AdminPort:     9091,
+				AdminAPIToken: "admin-token",
+			},
+			wantErr: false,
+		},
+		{
+			name: "admin port with a client CA",
+			config: Config{
+				GoTrueURL:            "http://gotrue:9999",
+				GoTrueAnonKey:        "anon-key",
+				AdminPort:            9091,
+				AdminTLSCertFile:     "/tls/admin.crt",
+				AdminTLSKeyFile:      "/tls/admin.key",
+				AdminTLSClientCAFile: "/tls/ca.crt",
+			},
+			wantErr: false,
+		},
+		{
+			name: "admin port without authentication",
+			config: Config{
+				GoTrueURL:     "http://gotrue:9999",
+				GoTrueAnonKey: "anon-key",
+				AdminPort:     9091,
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin port shared with metrics",
+			config: Config{
+				GoTrueURL:     "http://gotrue:9999",
+				GoTrueAnonKey: "anon-key",
+				MetricsPort:   9090,
+				AdminPort:     9090,
+				AdminAPIToken: "admin-token",
+			},
+			wantErr: true,
+		},
+		{
+			name: "admin client CA without a server certificate",
+			config: Config{
+				GoTrueURL:            "http://gotrue:9999",
+				GoTrueAnonKey:        "anon-key",
+				AdminPort:            9091,
+				AdminTLSClientCAFile: "/tls/ca.crt",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -173,6 +403,10 @@ func TestLoad(t *testing.T) {
 		t.Errorf("GoTrueAnonKey = %q, want %q", cfg.GoTrueAnonKey, "test-anon-key")
 	}
 
+	if cfg.GoTrueJWTIssuer != "http://gotrue:9999/auth/v1" {
+		t.Errorf("GoTrueJWTIssuer = %q, want %q", cfg.GoTrueJWTIssuer, "http://gotrue:9999/auth/v1")
+	}
+
 	if cfg.HTTPPort != 8080 {
 		t.Errorf("HTTPPort = %d, want %d", cfg.HTTPPort, 8080)
 	}
@@ -180,6 +414,10 @@ func TestLoad(t *testing.T) {
 	if cfg.MetricsPort != 9090 {
 		t.Errorf("MetricsPort = %d, want %d", cfg.MetricsPort, 9090)
 	}
+
+	if got := strings.Join(cfg.HealthCriticalChecks, ","); got != "redis,attestation" {
+		t.Errorf("HealthCriticalChecks = %q, want %q", got, "redis,attestation")
+	}
 }
 
 func TestLoadMissingRequired(t *testing.T) {
@@ -192,3 +430,201 @@ func TestLoadMissingRequired(t *testing.T) {
 		t.Error("Load() expected error for missing required vars, got nil")
 	}
 }
+
+func TestLoadInvalidValues(t *testing.T) {
+	os.Setenv("GOTRUE_URL", "http://gotrue:9999")
+	os.Setenv("GOTRUE_ANON_KEY", "test-anon-key")
+	os.Setenv("HTTP_PORT", "80a")
+	os.Setenv("CORS_ENABLED", "yes please")
+	defer func() {
+		os.Unsetenv("GOTRUE_URL")
+		os.Unsetenv("GOTRUE_ANON_KEY")
+		os.Unsetenv("HTTP_PORT")
+		os.Unsetenv("CORS_ENABLED")
+	}()
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Load() expected error for invalid values, got nil")
+	}
+	for _, want := range []string{"HTTP_PORT", `"80a"`, "CORS_ENABLED"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() error = %q, want it to mention %s", err, want)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "anon-key")
+	if err := os.WriteFile(secret, []byte("file-anon-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		body string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			body: `
+gotrue:
+  url: http://gotrue:9999
+  anon_key_file: ` + secret + `
+http_port: 8081
+log_level: debug
+cors:
+  enabled: true
+  allowed_origins:
+    - https://app.example.com
+    - https://admin.example.com
+  route_methods:
+    /auth/v1/user: [GET, PUT]
+`,
+		},
+		{
+			name: "json",
+			file: "config.json",
+			body: `{
+  "gotrue": {"url": "http://gotrue:9999", "anon_key_file": "` + secret + `"},
+  "http_port": 8081,
+  "log_level": "debug",
+  "cors": {
+    "enabled": true,
+    "allowed_origins": ["https://app.example.com", "https://admin.example.com"],
+    "route_methods": {"/auth/v1/user": ["GET", "PUT"]}
+  }
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.body), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			// The environment wins over the file
+			os.Setenv("LOG_LEVEL", "warn")
+			defer os.Unsetenv("LOG_LEVEL")
+
+			cfg, err := LoadFile(path)
+			if err != nil {
+				t.Fatalf("LoadFile() error = %v", err)
+			}
+			if cfg.GoTrueURL != "http://gotrue:9999" || cfg.GoTrueAnonKey != "file-anon-key" {
+				t.Errorf("GoTrue = %q %q, want the file values", cfg.GoTrueURL, cfg.GoTrueAnonKey)
+			}
+			if cfg.HTTPPort != 8081 || cfg.LogLevel != "warn" {
+				t.Errorf("HTTPPort = %d, LogLevel = %q, want 8081 and warn", cfg.HTTPPort, cfg.LogLevel)
+			}
+			if len(cfg.CORSAllowedOrigins) != 2 || cfg.CORSAllowedOrigins[1] != "https://admin.example.com" {
+				t.Errorf("CORSAllowedOrigins = %v", cfg.CORSAllowedOrigins)
+			}
+			if methods := cfg.CORSRouteMethods["/auth/v1/user"]; len(methods) != 2 || methods[1] != "PUT" {
+				t.Errorf("CORSRouteMethods = %v", cfg.CORSRouteMethods)
+			}
+
+			for _, s := range cfg.Settings() {
+				switch s.Key {
+				case "GOTRUE_ANON_KEY":
+					if s.Redacted() != "[redacted]" || s.Source != tt.file+": gotrue.anon_key_file" {
+						t.Errorf("GOTRUE_ANON_KEY = %q from %q", s.Redacted(), s.Source)
+					}
+				case "LOG_LEVEL":
+					if s.Source != "env" {
+						t.Errorf("LOG_LEVEL source = %q, want env", s.Source)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"unknown setting", "gotrue:\n  url: http://gotrue:9999\n  anon_key: k\n  timout: 5s\n", "gotrue.timout is not a known setting"},
+		{"invalid value", "gotrue_url: http://gotrue:9999\ngotrue_anon_key: k\nhttp_port: eighty\n", "HTTP_PORT"},
+		{"same setting twice", "gotrue_url: http://a\ngotrue:\n  url: http://b\n", "set the same value"},
+		{"not a mapping", "- a\n- b\n", "top level must be a mapping"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.body), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadFile() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChanged(t *testing.T) {
+	prev := &Config{settings: []Setting{
+		{Key: "HTTP_PORT", Value: "8080"},
+		{Key: "LOG_LEVEL", Value: "info"},
+		{Key: "SIGNUP_DENIED_DOMAINS", Value: ""},
+	}}
+	next := &Config{settings: []Setting{
+		{Key: "HTTP_PORT", Value: "8081"},
+		{Key: "LOG_LEVEL", Value: "debug"},
+		{Key: "SIGNUP_DENIED_DOMAINS", Value: ""},
+	}}
+
+	reload, restart := prev.Changed(next)
+	if len(reload) != 1 || reload[0] != "LOG_LEVEL" {
+		t.Errorf("reload = %v, want [LOG_LEVEL]", reload)
+	}
+	if len(restart) != 1 || restart[0] != "HTTP_PORT" {
+		t.Errorf("restart = %v, want [HTTP_PORT]", restart)
+	}
+}
+
+func TestReloaded(t *testing.T) {
+	prev := &Config{
+		HTTPPort:             8080,
+		LogLevel:             "info",
+		SignupDeniedDomains:  nil,
+		NetworkAccessEnabled: false,
+		settings: []Setting{
+			{Key: "HTTP_PORT", Value: "8080"},
+			{Key: "LOG_LEVEL", Value: "info"},
+		},
+	}
+	next := &Config{
+		HTTPPort:             8081,
+		LogLevel:             "debug",
+		SignupDeniedDomains:  []string{"spam.example"},
+		NetworkAccessEnabled: true,
+		settings: []Setting{
+			{Key: "HTTP_PORT", Value: "8081"},
+			{Key: "LOG_LEVEL", Value: "debug"},
+		},
+	}
+
+	got := prev.Reloaded(next)
+	if got.HTTPPort != 8080 {
+		t.Errorf("HTTPPort = %d, want the restart-only value kept", got.HTTPPort)
+	}
+	if got.LogLevel != "debug" || len(got.SignupDeniedDomains) != 1 || !got.NetworkAccessEnabled {
+		t.Errorf("reloadable settings not applied: %+v", got)
+	}
+
+	// The restart-only change is still reported until a restart
+	reload, restart := got.Changed(next)
+	if len(reload) != 0 || len(restart) != 1 || restart[0] != "HTTP_PORT" {
+		t.Errorf("Changed() = %v, %v, want [] [HTTP_PORT]", reload, restart)
+	}
+}
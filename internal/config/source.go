@@ -0,0 +1,358 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Setting is one configuration value and where it came from.
+type Setting struct {
+	Key string
+	// Value is the raw value, or the default when Source is "default".
+	Value string
+	// Source is "default", "env", "env <KEY>_FILE", or the config file key
+	// such as "config.yaml: cors.allowed_origins".
+	Source string
+	// Secret values are redacted by Redacted.
+	Secret bool
+}
+
+// Redacted returns the value with secrets masked.
+func (s Setting) Redacted() string {
+	if s.Secret && s.Value != "" {
+		return "[redacted]"
+	}
+	return s.Value
+}
+
+// fileValue is a node of the config file under the name it can be looked up
+// by: its path joined with underscores and upper-cased, so cors.allowed_origins
+// and cors_allowed_origins both set CORS_ALLOWED_ORIGINS.
+type fileValue struct {
+	path string
+	node *yaml.Node
+}
+
+// loader reads settings from the environment, falling back to the config
+// file. Invalid values are collected as errors instead of being replaced by
+// defaults, so a typo fails startup rather than silently changing behavior.
+type loader struct {
+	fileName string
+	file     map[string]fileValue
+	leaves   []fileLeaf
+	used     map[string]bool
+	settings map[string]Setting
+	errs     []error
+}
+
+// fileLeaf is a scalar or list in the file with the names of itself and its
+// ancestors. It's unknown if none of them was looked up.
+type fileLeaf struct {
+	path  string
+	names []string
+}
+
+func newLoader(path string) (*loader, error) {
+	l := &loader{
+		file:     make(map[string]fileValue),
+		used:     make(map[string]bool),
+		settings: make(map[string]Setting),
+	}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+	// JSON is valid YAML, so one parser covers both formats
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	l.fileName = filepath.Base(path)
+	if len(doc.Content) == 0 {
+		return l, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config file %s: top level must be a mapping", path)
+	}
+	if err := l.index(root, "", "", nil); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// index registers every node under its lookup name.
+func (l *loader) index(node *yaml.Node, path, name string, ancestors []string) error {
+	if name != "" {
+		if prev, ok := l.file[name]; ok {
+			if prev.path == path {
+				return fmt.Errorf("config file %s: %s is set twice", l.fileName, path)
+			}
+			return fmt.Errorf("config file %s: %s and %s set the same value", l.fileName, prev.path, path)
+		}
+		l.file[name] = fileValue{path: path, node: node}
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], name)
+	}
+
+	if node.Kind != yaml.MappingNode {
+		l.leaves = append(l.leaves, fileLeaf{path: path, names: ancestors})
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i].Value
+		childPath, childName := key, strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+		if path != "" {
+			childPath, childName = path+"."+key, name+"_"+childName
+		}
+		if err := l.index(node.Content[i+1], childPath, childName, ancestors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the raw value of key and where it came from.
+func (l *loader) lookup(key string, secret bool) (string, string, bool) {
+	l.used[key] = true
+	if secret {
+		l.used[key+"_FILE"] = true
+	}
+
+	if v := os.Getenv(key); v != "" {
+		return v, "env", true
+	}
+	if secret {
+		if path := os.Getenv(key + "_FILE"); path != "" {
+			return l.readSecret(key, path), "env " + key + "_FILE", true
+		}
+	}
+	if fv, ok := l.file[key]; ok {
+		v, err := nodeValue(fv.node)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %s: %v", l.fileName, fv.path, err))
+			return "", "", false
+		}
+		if v != "" {
+			return v, l.fileName + ": " + fv.path, true
+		}
+	}
+	if fv, ok := l.file[key+"_FILE"]; ok && secret {
+		if path, err := nodeValue(fv.node); err == nil && path != "" {
+			return l.readSecret(key, path), l.fileName + ": " + fv.path, true
+		}
+	}
+	return "", "", false
+}
+
+func (l *loader) readSecret(key, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s_FILE: %v", key, err))
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+// get looks key up, records the setting, and parses it. Parse errors are
+// collected and the default is kept so loading can report every problem.
+func get[T any](l *loader, key string, def T, secret bool, parse func(string) (T, error), format func(T) string) T {
+	raw, source, ok := l.lookup(key, secret)
+	if !ok {
+		l.settings[key] = Setting{Key: key, Value: format(def), Source: "default", Secret: secret}
+		return def
+	}
+	l.settings[key] = Setting{Key: key, Value: raw, Source: source, Secret: secret}
+
+	v, err := parse(raw)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: %v (from %s)", key, err, source))
+		return def
+	}
+	return v
+}
+
+func (l *loader) string(key, def string) string {
+	return get(l, key, def, false, parseString, formatString)
+}
+
+func (l *loader) secret(key string) string {
+	return get(l, key, "", true, parseString, formatString)
+}
+
+func (l *loader) int(key string, def int) int {
+	return get(l, key, def, false, func(s string) (int, error) {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a whole number", s)
+		}
+		return v, nil
+	}, strconv.Itoa)
+}
+
+func (l *loader) float(key string, def float64) float64 {
+	return get(l, key, def, false, func(s string) (float64, error) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", s)
+		}
+		return v, nil
+	}, func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) })
+}
+
+func (l *loader) bool(key string, def bool) bool {
+	return get(l, key, def, false, func(s string) (bool, error) {
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return false, fmt.Errorf("%q is not true or false", s)
+		}
+		return v, nil
+	}, strconv.FormatBool)
+}
+
+func (l *loader) duration(key string, def time.Duration) time.Duration {
+	return get(l, key, def, false, func(s string) (time.Duration, error) {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a duration like 30s or 5m", s)
+		}
+		return v, nil
+	}, time.Duration.String)
+}
+
+// list parses a comma-separated value, dropping empty entries.
+func (l *loader) list(key string) []string {
+	return get(l, key, nil, false, parseList, formatList)
+}
+
+// listOr is list with a default for when the setting is absent.
+func (l *loader) listOr(key string, def []string) []string {
+	return get(l, key, def, false, parseList, formatList)
+}
+
+func (l *loader) secretList(key string) []string {
+	return get(l, key, nil, true, parseList, formatList)
+}
+
+// routeMap parses per-route settings of the form
+// "/auth/v1/user=GET,PUT;/auth/v1/token=POST" into a map of path prefix to
+// comma-separated values.
+func (l *loader) routeMap(key string) map[string][]string {
+	return get(l, key, nil, false, func(s string) (map[string][]string, error) {
+		routes := make(map[string][]string)
+		for _, entry := range strings.Split(s, ";") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			path, list, found := strings.Cut(entry, "=")
+			if !found || strings.TrimSpace(path) == "" {
+				return nil, fmt.Errorf("%q is not a path=value,value entry", entry)
+			}
+			routes[strings.TrimSpace(path)], _ = parseList(list)
+		}
+		return routes, nil
+	}, formatRouteMap)
+}
+
+// finish returns the collected errors, plus one for each file setting no
+// lookup asked for.
+func (l *loader) finish() error {
+	for _, leaf := range l.leaves {
+		known := false
+		for _, name := range leaf.names {
+			if l.used[name] {
+				known = true
+				break
+			}
+		}
+		if !known {
+			l.errs = append(l.errs, fmt.Errorf("%s: %s is not a known setting", l.fileName, leaf.path))
+		}
+	}
+	return errors.Join(l.errs...)
+}
+
+// sortedSettings returns the settings ordered by key.
+func (l *loader) sortedSettings() []Setting {
+	list := make([]Setting, 0, len(l.settings))
+	for _, s := range l.settings {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+	return list
+}
+
+// nodeValue flattens a file value into the same text form the environment
+// uses: lists are comma-separated and route maps "path=a,b;path=c".
+func nodeValue(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return "", nil
+		}
+		return node.Value, nil
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("list entries must be plain values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, ","), nil
+	case yaml.MappingNode:
+		entries := make([]string, 0, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v, err := nodeValue(node.Content[i+1])
+			if err != nil || node.Content[i+1].Kind == yaml.MappingNode {
+				return "", fmt.Errorf("%s: route values must be a value or a list", node.Content[i].Value)
+			}
+			entries = append(entries, node.Content[i].Value+"="+v)
+		}
+		return strings.Join(entries, ";"), nil
+	default:
+		return "", fmt.Errorf("unsupported value")
+	}
+}
+
+func parseString(s string) (string, error) { return s, nil }
+
+func formatString(s string) string { return s }
+
+func parseList(s string) ([]string, error) {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+func formatList(list []string) string {
+	return strings.Join(list, ",")
+}
+
+func formatRouteMap(routes map[string][]string) string {
+	paths := make([]string, 0, len(routes))
+	for path := range routes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	entries := make([]string, 0, len(paths))
+	for _, path := range paths {
+		entries = append(entries, path+"="+strings.Join(routes[path], ","))
+	}
+	return strings.Join(entries, ";")
+}
@@ -0,0 +1,279 @@
+// Package devices records which attested device keys each Supabase user signs
+// in from, and enforces limits on those bindings: devices per user, accounts
+// per device, and what happens when a key shows up on a different user.
+package devices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+var (
+	ErrTooManyDevices  = errors.New("user has too many devices")
+	ErrTooManyAccounts = errors.New("device has too many accounts")
+	ErrDeviceMoved     = errors.New("device is bound to another user")
+)
+
+// Action is what to do when a device key appears on a different user.
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionAlert Action = "alert"
+	ActionBlock Action = "block"
+)
+
+// ParseAction parses "allow", "alert" or "block".
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case ActionAllow, ActionAlert, ActionBlock:
+		return a, nil
+	default:
+		return "", fmt.Errorf("invalid device key move action %q", s)
+	}
+}
+
+// Device is a device key bound to a user.
+type Device struct {
+	KeyID     string    `json:"key_id"`
+	Platform  string    `json:"platform,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// account is a user bound to a device key.
+type account struct {
+	UserID    string    `json:"user_id"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Config holds configuration for the registry.
+type Config struct {
+	Store store.Store
+	// MaxDevicesPerUser caps the devices a user can sign in from. Zero means
+	// no limit.
+	MaxDevicesPerUser int
+	// MaxAccountsPerDevice caps the users that can sign in from one device,
+	// which stops account farming from a single phone. Zero means no limit.
+	MaxAccountsPerDevice int
+	// OnKeyMove applies when a device key is seen on a user other than the
+	// one it was last used by. Defaults to ActionAlert.
+	OnKeyMove Action
+	// TTL forgets bindings that haven't been used for this long, freeing
+	// their slot. Defaults to 90 days.
+	TTL time.Duration
+}
+
+// Result describes a recorded sign-in.
+type Result struct {
+	// NewDevice is set the first time the user signs in from the device.
+	NewDevice bool
+	// PreviousUser is the user the device was last used by, when that's a
+	// different user.
+	PreviousUser string
+}
+
+// Registry stores device-user bindings.
+type Registry struct {
+	store     store.Store
+	maxDev    int
+	maxAcct   int
+	onKeyMove Action
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// New creates a device registry.
+func New(cfg Config) (*Registry, error) {
+	if cfg.Store == nil {
+		return nil, fmt.Errorf("device binding requires a store")
+	}
+	r := &Registry{
+		store:     cfg.Store,
+		maxDev:    cfg.MaxDevicesPerUser,
+		maxAcct:   cfg.MaxAccountsPerDevice,
+		onKeyMove: cfg.OnKeyMove,
+		ttl:       cfg.TTL,
+		now:       time.Now,
+	}
+	if r.onKeyMove == "" {
+		r.onKeyMove = ActionAlert
+	}
+	if r.ttl <= 0 {
+		r.ttl = 90 * 24 * time.Hour
+	}
+	return r, nil
+}
+
+// Record binds a device key to a user on sign-in. It returns ErrTooManyDevices,
+// ErrTooManyAccounts or ErrDeviceMoved when a policy blocks the binding.
+//
+// Bindings are read-modify-write, so concurrent first sign-ins can overshoot
+// a limit by a device or two.
+func (r *Registry) Record(ctx context.Context, userID, keyID, platform string) (Result, error) {
+	var result Result
+	now := r.now()
+
+	devices, err := r.Devices(ctx, userID)
+	if err != nil {
+		return result, err
+	}
+	accounts, err := r.accounts(ctx, keyID)
+	if err != nil {
+		return result, err
+	}
+
+	di := indexDevice(devices, keyID)
+	ai := indexAccount(accounts, userID)
+
+	if ai < 0 && len(accounts) > 0 {
+		result.PreviousUser = lastUser(accounts)
+		if r.maxAcct > 0 && len(accounts) >= r.maxAcct {
+			return result, ErrTooManyAccounts
+		}
+		if r.onKeyMove == ActionBlock {
+			return result, ErrDeviceMoved
+		}
+	}
+	if di < 0 {
+		result.NewDevice = true
+		if r.maxDev > 0 && len(devices) >= r.maxDev {
+			return result, ErrTooManyDevices
+		}
+	}
+
+	if di < 0 {
+		devices = append(devices, Device{KeyID: keyID, Platform: platform, FirstSeen: now, LastSeen: now})
+	} else {
+		devices[di].LastSeen = now
+		if platform != "" {
+			devices[di].Platform = platform
+		}
+	}
+	if ai < 0 {
+		accounts = append(accounts, account{UserID: userID, FirstSeen: now, LastSeen: now})
+	} else {
+		accounts[ai].LastSeen = now
+	}
+
+	if err := r.save(ctx, "user:"+userID, devices); err != nil {
+		return result, err
+	}
+	if err := r.save(ctx, "device:"+keyID, accounts); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Devices returns the devices bound to a user, most recently used first.
+func (r *Registry) Devices(ctx context.Context, userID string) ([]Device, error) {
+	var devices []Device
+	if err := r.load(ctx, "user:"+userID, &devices); err != nil {
+		return nil, err
+	}
+
+	cutoff := r.now().Add(-r.ttl)
+	live := devices[:0]
+	for _, d := range devices {
+		if d.LastSeen.After(cutoff) {
+			live = append(live, d)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].LastSeen.After(live[j].LastSeen) })
+	return live, nil
+}
+
+// Remove unbinds a device from a user, e.g. when they replace a phone and
+// hit the device limit.
+func (r *Registry) Remove(ctx context.Context, userID, keyID string) error {
+	devices, err := r.Devices(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if i := indexDevice(devices, keyID); i >= 0 {
+		devices = append(devices[:i], devices[i+1:]...)
+		if err := r.save(ctx, "user:"+userID, devices); err != nil {
+			return err
+		}
+	}
+
+	accounts, err := r.accounts(ctx, keyID)
+	if err != nil {
+		return err
+	}
+	if i := indexAccount(accounts, userID); i >= 0 {
+		accounts = append(accounts[:i], accounts[i+1:]...)
+		return r.save(ctx, "device:"+keyID, accounts)
+	}
+	return nil
+}
+
+func (r *Registry) accounts(ctx context.Context, keyID string) ([]account, error) {
+	var accounts []account
+	if err := r.load(ctx, "device:"+keyID, &accounts); err != nil {
+		return nil, err
+	}
+
+	cutoff := r.now().Add(-r.ttl)
+	live := accounts[:0]
+	for _, a := range accounts {
+		if a.LastSeen.After(cutoff) {
+			live = append(live, a)
+		}
+	}
+	return live, nil
+}
+
+func (r *Registry) load(ctx context.Context, key string, v any) error {
+	data, err := r.store.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (r *Registry) save(ctx context.Context, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.store.Set(ctx, key, data, r.ttl)
+}
+
+func indexDevice(devices []Device, keyID string) int {
+	for i, d := range devices {
+		if d.KeyID == keyID {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexAccount(accounts []account, userID string) int {
+	for i, a := range accounts {
+		if a.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+func lastUser(accounts []account) string {
+	var last account
+	for _, a := range accounts {
+		if a.LastSeen.After(last.LastSeen) {
+			last = a
+		}
+	}
+	return last.UserID
+}
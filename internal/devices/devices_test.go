@@ -0,0 +1,141 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+type signIn struct {
+	user, key    string
+	wantErr      error
+	newDevice    bool
+	previousUser string
+}
+
+func newTestRegistry(t *testing.T, cfg Config) (*Registry, *time.Time) {
+	t.Helper()
+	s := store.NewMemory()
+	t.Cleanup(func() { s.Close() })
+	cfg.Store = s
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func TestRecord(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		signIns []signIn
+	}{
+		{
+			name:   "no limits",
+			config: Config{OnKeyMove: ActionAllow},
+			signIns: []signIn{
+				{user: "u1", key: "k1", newDevice: true},
+				{user: "u1", key: "k1"},
+				{user: "u1", key: "k2", newDevice: true},
+				{user: "u2", key: "k1", newDevice: true, previousUser: "u1"},
+			},
+		},
+		{
+			name:   "max devices per user",
+			config: Config{MaxDevicesPerUser: 2},
+			signIns: []signIn{
+				{user: "u1", key: "k1", newDevice: true},
+				{user: "u1", key: "k2", newDevice: true},
+				{user: "u1", key: "k3", newDevice: true, wantErr: ErrTooManyDevices},
+				{user: "u1", key: "k1"},
+			},
+		},
+		{
+			name:   "max accounts per device",
+			config: Config{MaxAccountsPerDevice: 2},
+			signIns: []signIn{
+				{user: "u1", key: "k1", newDevice: true},
+				{user: "u2", key: "k1", newDevice: true, previousUser: "u1"},
+				{user: "u3", key: "k1", previousUser: "u2", wantErr: ErrTooManyAccounts},
+				{user: "u2", key: "k1"},
+			},
+		},
+		{
+			name:   "block key move",
+			config: Config{OnKeyMove: ActionBlock},
+			signIns: []signIn{
+				{user: "u1", key: "k1", newDevice: true},
+				{user: "u2", key: "k1", previousUser: "u1", wantErr: ErrDeviceMoved},
+				{user: "u1", key: "k1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, now := newTestRegistry(t, tt.config)
+			for i, s := range tt.signIns {
+				*now = now.Add(time.Minute)
+				result, err := r.Record(context.Background(), s.user, s.key, "ios")
+				if !errors.Is(err, s.wantErr) {
+					t.Fatalf("sign-in %d: Record() error = %v, want %v", i, err, s.wantErr)
+				}
+				if err != nil {
+					continue
+				}
+				if result.NewDevice != s.newDevice || result.PreviousUser != s.previousUser {
+					t.Errorf("sign-in %d: Record() = %+v, want NewDevice=%v PreviousUser=%q",
+						i, result, s.newDevice, s.previousUser)
+				}
+			}
+		})
+	}
+}
+
+func TestDevicesAndRemove(t *testing.T) {
+	r, now := newTestRegistry(t, Config{MaxDevicesPerUser: 1, TTL: time.Hour})
+	ctx := context.Background()
+
+	r.Record(ctx, "u1", "k1", "ios")
+	devices, err := r.Devices(ctx, "u1")
+	if err != nil || len(devices) != 1 || devices[0].KeyID != "k1" || devices[0].Platform != "ios" {
+		t.Fatalf("Devices() = %+v, %v", devices, err)
+	}
+
+	if _, err := r.Record(ctx, "u1", "k2", "ios"); !errors.Is(err, ErrTooManyDevices) {
+		t.Fatalf("Record() error = %v, want ErrTooManyDevices", err)
+	}
+	if err := r.Remove(ctx, "u1", "k1"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := r.Record(ctx, "u1", "k2", "ios"); err != nil {
+		t.Fatalf("Record() after Remove error = %v", err)
+	}
+
+	// Stale bindings stop counting towards the limit
+	*now = now.Add(2 * time.Hour)
+	if _, err := r.Record(ctx, "u1", "k3", "android"); err != nil {
+		t.Fatalf("Record() after TTL error = %v", err)
+	}
+	devices, _ = r.Devices(ctx, "u1")
+	if len(devices) != 1 || devices[0].KeyID != "k3" {
+		t.Errorf("Devices() = %+v, want only k3", devices)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, s := range []string{"allow", "alert", "block"} {
+		if _, err := ParseAction(s); err != nil {
+			t.Errorf("ParseAction(%q) error = %v", s, err)
+		}
+	}
+	if _, err := ParseAction("deny"); err == nil {
+		t.Error("ParseAction(deny) error = nil")
+	}
+}
@@ -0,0 +1,227 @@
+// Package dpop implements DPoP (RFC 9449) sender-constrained tokens: it
+// validates the proof JWTs clients sign with a private key they hold, and
+// remembers which key each Supabase session is bound to so a stolen access or
+// refresh token is useless without that key.
+package dpop
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+// Header carries the proof on requests.
+const Header = "DPoP"
+
+var (
+	ErrMissingProof = errors.New("DPoP proof required")
+	ErrInvalidProof = errors.New("invalid DPoP proof")
+	ErrReplay       = errors.New("DPoP proof replayed")
+	ErrKeyMismatch  = errors.New("DPoP key does not match the bound key")
+)
+
+// maxJTILength bounds the replay keys clients can make us store.
+const maxJTILength = 256
+
+// Config holds configuration for the validator.
+type Config struct {
+	// Store holds seen proof IDs and session bindings.
+	Store store.Store
+	// MaxAge is how old a proof's iat may be.
+	MaxAge time.Duration
+	// Leeway allows for client clock skew.
+	Leeway time.Duration
+	// BindingTTL is how long a session stays bound after its last token
+	// grant. It should cover the refresh token's lifetime.
+	BindingTTL time.Duration
+}
+
+// Proof is a validated DPoP proof.
+type Proof struct {
+	// Thumbprint is the RFC 7638 thumbprint of the proof key ("jkt").
+	Thumbprint string
+	ID         string
+	IssuedAt   time.Time
+}
+
+// proofClaims are the claims RFC 9449 defines for a proof.
+type proofClaims struct {
+	ID       string `json:"jti"`
+	Method   string `json:"htm"`
+	URL      string `json:"htu"`
+	IssuedAt int64  `json:"iat"`
+	// TokenHash is the base64url SHA-256 of the access token the proof is
+	// presented with.
+	TokenHash string `json:"ath"`
+}
+
+// proofKey is the embedded public key. A key with a private part is rejected.
+type proofKey struct {
+	jwt.JWK
+	D string `json:"d"`
+}
+
+// Validator checks proofs and tracks key bindings.
+type Validator struct {
+	store      store.Store
+	maxAge     time.Duration
+	leeway     time.Duration
+	bindingTTL time.Duration
+	now        func() time.Time
+}
+
+// New creates a DPoP validator.
+func New(cfg Config) (*Validator, error) {
+	if cfg.Store == nil {
+		return nil, fmt.Errorf("DPoP requires a store")
+	}
+	v := &Validator{
+		store:      cfg.Store,
+		maxAge:     cfg.MaxAge,
+		leeway:     cfg.Leeway,
+		bindingTTL: cfg.BindingTTL,
+		now:        time.Now,
+	}
+	if v.maxAge <= 0 {
+		v.maxAge = time.Minute
+	}
+	if v.leeway < 0 {
+		v.leeway = 0
+	}
+	if v.bindingTTL <= 0 {
+		v.bindingTTL = 30 * 24 * time.Hour
+	}
+	return v, nil
+}
+
+// Validate checks a proof for a request to method and requestURL. When
+// accessToken is set the proof must also carry its hash in "ath". Each proof
+// is accepted once; a second use returns ErrReplay.
+func (v *Validator) Validate(ctx context.Context, raw, method, requestURL, accessToken string) (*Proof, error) {
+	if raw == "" {
+		return nil, ErrMissingProof
+	}
+
+	t, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, ErrInvalidProof
+	}
+	if t.Header.Typ != "dpop+jwt" {
+		return nil, ErrInvalidProof
+	}
+	if t.Header.Alg != "ES256" && t.Header.Alg != "RS256" {
+		return nil, ErrInvalidProof
+	}
+
+	var key proofKey
+	if len(t.Header.JWK) == 0 || json.Unmarshal(t.Header.JWK, &key) != nil || key.D != "" {
+		return nil, ErrInvalidProof
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		return nil, ErrInvalidProof
+	}
+	if err := t.VerifySignature(pub); err != nil {
+		return nil, ErrInvalidProof
+	}
+
+	var claims proofClaims
+	if err := json.Unmarshal(t.Payload, &claims); err != nil {
+		return nil, ErrInvalidProof
+	}
+	if claims.ID == "" || len(claims.ID) > maxJTILength {
+		return nil, ErrInvalidProof
+	}
+	if claims.Method != method || !sameURL(claims.URL, requestURL) {
+		return nil, ErrInvalidProof
+	}
+
+	now := v.now()
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	if issuedAt.After(now.Add(v.leeway)) || issuedAt.Before(now.Add(-v.maxAge-v.leeway)) {
+		return nil, ErrInvalidProof
+	}
+
+	if accessToken != "" && claims.TokenHash != TokenHash(accessToken) {
+		return nil, ErrInvalidProof
+	}
+
+	thumbprint, err := key.Thumbprint()
+	if err != nil {
+		return nil, ErrInvalidProof
+	}
+
+	// A proof can't be used outside its iat window, so that's all the
+	// replay cache needs to cover
+	n, err := v.store.Incr(ctx, "jti:"+thumbprint+":"+claims.ID, v.maxAge+2*v.leeway)
+	if err != nil {
+		return nil, err
+	}
+	if n > 1 {
+		return nil, ErrReplay
+	}
+
+	return &Proof{Thumbprint: thumbprint, ID: claims.ID, IssuedAt: issuedAt}, nil
+}
+
+// Bind records that a session's tokens may only be used with the key
+// thumbprint. Binding again refreshes the TTL.
+func (v *Validator) Bind(ctx context.Context, sessionID, thumbprint string) error {
+	return v.store.Set(ctx, "session:"+sessionID, []byte(thumbprint), v.bindingTTL)
+}
+
+// Binding returns the thumbprint a session is bound to, or "" if the session
+// is not bound.
+func (v *Validator) Binding(ctx context.Context, sessionID string) (string, error) {
+	value, err := v.store.Get(ctx, "session:"+sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// TokenHash returns the "ath" value for an access token.
+func TokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// sameURL compares htu against the request URL ignoring query and fragment,
+// case in the scheme and host, and default ports, as RFC 9449 section 4.3
+// allows.
+func sameURL(htu, requestURL string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		normalizeHost(a) == normalizeHost(b) &&
+		a.EscapedPath() == b.EscapedPath()
+}
+
+func normalizeHost(u *url.URL) string {
+	host := strings.ToLower(u.Host)
+	switch {
+	case strings.EqualFold(u.Scheme, "https"):
+		host = strings.TrimSuffix(host, ":443")
+	case strings.EqualFold(u.Scheme, "http"):
+		host = strings.TrimSuffix(host, ":80")
+	}
+	return host
+}
@@ -0,0 +1,157 @@
+package dpop
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func encode(v any) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func publicJWK(key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func signProof(key *ecdsa.PrivateKey, header map[string]any, claims map[string]any) string {
+	input := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestValidator(t *testing.T) *Validator {
+	t.Helper()
+	s := store.NewMemory()
+	t.Cleanup(func() { s.Close() })
+	v, err := New(Config{Store: s, MaxAge: time.Minute, Leeway: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	const target = "https://auth.example.com/auth/v1/user"
+	const token = "access-token"
+
+	header := func(mutate func(map[string]any)) map[string]any {
+		h := map[string]any{"typ": "dpop+jwt", "alg": "ES256", "jwk": publicJWK(key)}
+		if mutate != nil {
+			mutate(h)
+		}
+		return h
+	}
+	claims := func(mutate func(map[string]any)) map[string]any {
+		c := map[string]any{
+			"jti": "id-" + time.Now().Format(time.RFC3339Nano),
+			"htm": "GET",
+			"htu": target,
+			"iat": time.Now().Unix(),
+			"ath": TokenHash(token),
+		}
+		if mutate != nil {
+			mutate(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		proof string
+		err   error
+	}{
+		{"valid", signProof(key, header(nil), claims(nil)), nil},
+		{"htu with query and default port", signProof(key, header(nil), claims(func(c map[string]any) {
+			c["htu"] = "HTTPS://Auth.Example.com:443/auth/v1/user?x=1"
+		})), nil},
+		{"missing", "", ErrMissingProof},
+		{"wrong typ", signProof(key, header(func(h map[string]any) { h["typ"] = "JWT" }), claims(nil)), ErrInvalidProof},
+		{"symmetric alg", signProof(key, header(func(h map[string]any) { h["alg"] = "HS256" }), claims(nil)), ErrInvalidProof},
+		{"no jwk", signProof(key, header(func(h map[string]any) { delete(h, "jwk") }), claims(nil)), ErrInvalidProof},
+		{"private jwk", signProof(key, header(func(h map[string]any) {
+			jwk := publicJWK(key)
+			jwk["d"] = "secret"
+			h["jwk"] = jwk
+		}), claims(nil)), ErrInvalidProof},
+		{"signed by other key", signProof(other, header(nil), claims(nil)), ErrInvalidProof},
+		{"wrong method", signProof(key, header(nil), claims(func(c map[string]any) { c["htm"] = "POST" })), ErrInvalidProof},
+		{"wrong url", signProof(key, header(nil), claims(func(c map[string]any) { c["htu"] = "https://evil.example.com/auth/v1/user" })), ErrInvalidProof},
+		{"too old", signProof(key, header(nil), claims(func(c map[string]any) { c["iat"] = time.Now().Add(-2 * time.Minute).Unix() })), ErrInvalidProof},
+		{"in the future", signProof(key, header(nil), claims(func(c map[string]any) { c["iat"] = time.Now().Add(time.Minute).Unix() })), ErrInvalidProof},
+		{"no jti", signProof(key, header(nil), claims(func(c map[string]any) { delete(c, "jti") })), ErrInvalidProof},
+		{"wrong ath", signProof(key, header(nil), claims(func(c map[string]any) { c["ath"] = TokenHash("other") })), ErrInvalidProof},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestValidator(t)
+			proof, err := v.Validate(context.Background(), tt.proof, "GET", target, token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			jwk := jwt.JWK{Kty: "EC", Crv: "P-256", X: publicJWK(key)["x"], Y: publicJWK(key)["y"]}
+			want, _ := jwk.Thumbprint()
+			if proof.Thumbprint != want {
+				t.Errorf("Thumbprint = %q, want %q", proof.Thumbprint, want)
+			}
+		})
+	}
+}
+
+func TestValidateReplay(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	v := newTestValidator(t)
+	proof := signProof(key,
+		map[string]any{"typ": "dpop+jwt", "alg": "ES256", "jwk": publicJWK(key)},
+		map[string]any{"jti": "once", "htm": "POST", "htu": "https://auth.example.com/token", "iat": time.Now().Unix()},
+	)
+
+	if _, err := v.Validate(context.Background(), proof, "POST", "https://auth.example.com/token", ""); err != nil {
+		t.Fatalf("first Validate() error = %v", err)
+	}
+	if _, err := v.Validate(context.Background(), proof, "POST", "https://auth.example.com/token", ""); !errors.Is(err, ErrReplay) {
+		t.Fatalf("second Validate() error = %v, want ErrReplay", err)
+	}
+}
+
+func TestBinding(t *testing.T) {
+	v := newTestValidator(t)
+	ctx := context.Background()
+
+	jkt, err := v.Binding(ctx, "session-1")
+	if err != nil || jkt != "" {
+		t.Fatalf("Binding() = %q, %v, want unbound", jkt, err)
+	}
+	if err := v.Bind(ctx, "session-1", "thumb"); err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if jkt, _ := v.Binding(ctx, "session-1"); jkt != "thumb" {
+		t.Errorf("Binding() = %q, want %q", jkt, "thumb")
+	}
+}
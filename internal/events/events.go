@@ -0,0 +1,501 @@
+// Package events delivers auth events to webhook endpoints. Events are queued
+// in memory and sent by a small worker pool; each delivery is HMAC-signed and
+// retried with exponential backoff, and deliveries that still fail are
+// appended to a dead-letter file.
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// Headers set on every delivery.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	IDHeader        = "X-Webhook-ID"
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Type identifies an event.
+type Type string
+
+const (
+	TypeSignup            Type = "signup"
+	TypeLogin             Type = "login"
+	TypeLogout            Type = "logout"
+	TypeLoginFailed       Type = "login_failed"
+	TypeAttestationFailed Type = "attestation_failed"
+	TypeReplayDetected    Type = "replay_detected"
+)
+
+// Types lists every event type.
+var Types = []Type{
+	TypeSignup,
+	TypeLogin,
+	TypeLogout,
+	TypeLoginFailed,
+	TypeAttestationFailed,
+	TypeReplayDetected,
+}
+
+// Event is the JSON payload posted to endpoints.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      Type      `json:"type"`
+	Time      time.Time `json:"time"`
+	UserID    string    `json:"user_id,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	Platform  string    `json:"platform,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	// Reason is the error code for failures.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Endpoint is a webhook URL and the events it receives.
+type Endpoint struct {
+	URL string
+	// Events filters what is sent; empty means every event.
+	Events []Type
+}
+
+// ParseEndpoint parses "url" or "url#type|type", e.g.
+// "https://fraud.example.com/hook#login|login_failed". The fragment is never
+// sent to the endpoint, so it is free to carry the filter.
+func ParseEndpoint(s string) (Endpoint, error) {
+	raw, filter, _ := strings.Cut(s, "#")
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return Endpoint{}, fmt.Errorf("invalid webhook URL %q", raw)
+	}
+
+	ep := Endpoint{URL: raw}
+	if filter == "" {
+		return ep, nil
+	}
+	for _, name := range strings.Split(filter, "|") {
+		t := Type(strings.TrimSpace(name))
+		if !isType(t) {
+			return Endpoint{}, fmt.Errorf("unknown webhook event %q", name)
+		}
+		ep.Events = append(ep.Events, t)
+	}
+	return ep, nil
+}
+
+func (ep Endpoint) wants(t Type) bool {
+	if len(ep.Events) == 0 {
+		return true
+	}
+	for _, e := range ep.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+func isType(t Type) bool {
+	for _, known := range Types {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Config holds configuration for the dispatcher.
+type Config struct {
+	Endpoints []Endpoint
+	// Secret signs every delivery.
+	Secret string
+	// QueueSize bounds pending deliveries; events are dropped when it's full.
+	QueueSize int
+	Workers   int
+	// MaxAttempts includes the first try.
+	MaxAttempts int
+	// Backoff is the delay before the first retry; it doubles up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds each delivery request.
+	Timeout time.Duration
+	// DeadLetterFile receives deliveries that failed for good, one JSON
+	// object per line. Empty only logs them.
+	DeadLetterFile string
+	// Client overrides the HTTP client, for tests.
+	Client *http.Client
+}
+
+// Dispatcher queues events and delivers them to the endpoints.
+type Dispatcher struct {
+	cfg     Config
+	client  *http.Client
+	metrics *metrics.Metrics
+	logger  *logging.Logger
+
+	queue chan delivery
+	wg    sync.WaitGroup
+	// stop aborts pending retries during shutdown
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.RWMutex
+	closed bool
+
+	deadMu     sync.Mutex
+	deadLetter *os.File
+}
+
+type delivery struct {
+	endpoint Endpoint
+	event    Event
+	body     []byte
+}
+
+// deadLetter is a line in the dead-letter file.
+type deadLetter struct {
+	Endpoint string    `json:"endpoint"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+	Event    Event     `json:"event"`
+}
+
+// New creates a dispatcher and starts its workers. m may be nil.
+func New(cfg Config, m *metrics.Metrics, logger *logging.Logger) (*Dispatcher, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, errors.New("events: at least one endpoint is required")
+	}
+	if cfg.Secret == "" {
+		return nil, errors.New("events: signing secret is required")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = max(time.Minute, cfg.Backoff)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	d := &Dispatcher{
+		cfg:     cfg,
+		client:  cfg.Client,
+		metrics: m,
+		logger:  logger,
+		queue:   make(chan delivery, cfg.QueueSize),
+		stop:    make(chan struct{}),
+	}
+	if d.client == nil {
+		d.client = &http.Client{
+			Timeout: cfg.Timeout,
+			// A redirect would re-send the signed payload somewhere else
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	if cfg.DeadLetterFile != "" {
+		f, err := os.OpenFile(cfg.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("events: open dead-letter file: %w", err)
+		}
+		d.deadLetter = f
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d, nil
+}
+
+// Emit queues an event for every endpoint that wants it. It never blocks:
+// when the queue is full the delivery is dead-lettered instead. Emit on a nil
+// dispatcher does nothing, so callers don't need to check whether webhooks
+// are configured.
+func (d *Dispatcher) Emit(e Event) {
+	if d == nil {
+		return
+	}
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		d.logger.Logger.Error(logging.EmojiError+" failed to encode webhook event", zap.Error(err))
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+
+	for _, ep := range d.cfg.Endpoints {
+		if !ep.wants(e.Type) {
+			continue
+		}
+		dl := delivery{endpoint: ep, event: e, body: body}
+		select {
+		case d.queue <- dl:
+		default:
+			d.count(e.Type, "dropped")
+			d.logger.NetworkError("webhook queue full, dropping event",
+				zap.String("event", string(e.Type)),
+				zap.String("endpoint", ep.URL),
+			)
+			d.writeDeadLetter(dl, 0, errors.New("queue full"))
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued deliveries until ctx is
+// done. Whatever is still pending then is dead-lettered.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		d.stopOnce.Do(func() { close(d.stop) })
+		<-done
+		err = ctx.Err()
+	}
+
+	if d.deadLetter != nil {
+		d.deadMu.Lock()
+		d.deadLetter.Close()
+		d.deadMu.Unlock()
+	}
+	return err
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for dl := range d.queue {
+		d.deliver(dl)
+	}
+}
+
+// deliver sends one delivery, retrying until it succeeds, fails permanently
+// or runs out of attempts.
+func (d *Dispatcher) deliver(dl delivery) {
+	var lastErr error
+	for attempt := 1; attempt <= d.cfg.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			d.count(dl.event.Type, "retried")
+			select {
+			case <-time.After(d.backoff(attempt - 1)):
+			case <-d.stop:
+				d.fail(dl, attempt-1, fmt.Errorf("shutting down: %w", lastErr))
+				return
+			}
+		}
+
+		retry, err := d.send(dl)
+		if err == nil {
+			d.count(dl.event.Type, "delivered")
+			return
+		}
+		lastErr = err
+		d.logger.NetworkError("webhook delivery failed",
+			zap.Error(err),
+			zap.String("event", string(dl.event.Type)),
+			zap.String("endpoint", dl.endpoint.URL),
+			zap.Int("attempt", attempt),
+		)
+		if !retry {
+			d.fail(dl, attempt, err)
+			return
+		}
+	}
+	d.fail(dl, d.cfg.MaxAttempts, lastErr)
+}
+
+// send makes one delivery attempt. The bool reports whether a failure is
+// worth retrying.
+func (d *Dispatcher) send(dl delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, dl.endpoint.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", buildinfo.UserAgent())
+	req.Header.Set(EventHeader, string(dl.event.Type))
+	req.Header.Set(IDHeader, dl.event.ID)
+	req.Header.Set(SignatureHeader, Sign(d.cfg.Secret, time.Now(), dl.body))
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	if d.metrics != nil {
+		d.metrics.WebhookDeliveryDuration.WithLabelValues(string(dl.event.Type)).Observe(time.Since(start).Seconds())
+	}
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500:
+		return true, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("endpoint returned %d", resp.StatusCode)
+	}
+}
+
+// backoff returns the delay before retry n, with up to 20% jitter so a
+// recovering endpoint isn't hit by every retry at once.
+func (d *Dispatcher) backoff(n int) time.Duration {
+	delay := d.cfg.Backoff << (n - 1)
+	if delay <= 0 || delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay + time.Duration(mathrand.Int64N(int64(delay)/5+1))
+}
+
+func (d *Dispatcher) fail(dl delivery, attempts int, err error) {
+	d.count(dl.event.Type, "failed")
+	d.writeDeadLetter(dl, attempts, err)
+}
+
+func (d *Dispatcher) writeDeadLetter(dl delivery, attempts int, err error) {
+	if d.deadLetter == nil {
+		d.logger.NetworkError("webhook delivery abandoned",
+			zap.Error(err),
+			zap.String("event", string(dl.event.Type)),
+			zap.String("event_id", dl.event.ID),
+			zap.String("endpoint", dl.endpoint.URL),
+		)
+		return
+	}
+
+	line, _ := json.Marshal(deadLetter{
+		Endpoint: dl.endpoint.URL,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: time.Now().UTC(),
+		Event:    dl.event,
+	})
+
+	d.deadMu.Lock()
+	defer d.deadMu.Unlock()
+	if _, werr := d.deadLetter.Write(append(line, '\n')); werr != nil {
+		d.logger.Logger.Error(logging.EmojiError+" failed to write webhook dead letter", zap.Error(werr))
+	}
+}
+
+func (d *Dispatcher) count(t Type, result string) {
+	if d.metrics != nil {
+		d.metrics.WebhookDeliveriesTotal.WithLabelValues(string(t), result).Inc()
+	}
+}
+
+// Sign returns the signature header value for a payload sent at t:
+// "t=<unix>,v1=<hex HMAC-SHA256 of "<unix>.<body>">".
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, body)
+}
+
+// Verify checks a signature header against the payload, rejecting
+// signatures older than tolerance. Receivers in Go can use it directly.
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "evt_" + hex.EncodeToString(b)
+}
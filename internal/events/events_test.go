@@ -0,0 +1,242 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+const testSecret = "whsec-test"
+
+func newTestDispatcher(t *testing.T, cfg Config) *Dispatcher {
+	t.Helper()
+	cfg.Secret = testSecret
+	if cfg.Backoff == 0 {
+		cfg.Backoff = time.Millisecond
+	}
+	logger, _ := logging.New("error", false)
+	d, err := New(cfg, nil, logger)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return d
+}
+
+func readDeadLetters(t *testing.T, path string) []deadLetter {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open dead letters: %v", err)
+	}
+	defer f.Close()
+
+	var lines []deadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var dl deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &dl); err != nil {
+			t.Fatalf("invalid dead letter %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, dl)
+	}
+	return lines
+}
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		input   string
+		url     string
+		events  []Type
+		wantErr bool
+	}{
+		{input: "https://crm.example.com/hook", url: "https://crm.example.com/hook"},
+		{input: "https://fraud.example.com/hook#login|login_failed", url: "https://fraud.example.com/hook", events: []Type{TypeLogin, TypeLoginFailed}},
+		{input: "http://localhost:9000/hook#signup", url: "http://localhost:9000/hook", events: []Type{TypeSignup}},
+		{input: "https://fraud.example.com/hook#login|unknown", wantErr: true},
+		{input: "ftp://example.com/hook", wantErr: true},
+		{input: "not a url", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			ep, err := ParseEndpoint(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if ep.URL != tt.url || len(ep.Events) != len(tt.events) {
+				t.Fatalf("ParseEndpoint() = %+v", ep)
+			}
+			for i := range tt.events {
+				if ep.Events[i] != tt.events[i] {
+					t.Errorf("Events[%d] = %q, want %q", i, ep.Events[i], tt.events[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"login"}`)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		wantErr bool
+	}{
+		{"valid", Sign(testSecret, now, body), body, false},
+		{"tampered body", Sign(testSecret, now, body), []byte(`{"type":"logout"}`), true},
+		{"wrong secret", Sign("other", now, body), body, true},
+		{"too old", Sign(testSecret, now.Add(-10*time.Minute), body), body, true},
+		{"malformed", "v1=abc", body, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(testSecret, tt.header, tt.body, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(testSecret, r.Header.Get(SignatureHeader), body, time.Minute); err != nil {
+			t.Errorf("Verify() error = %v", err)
+		}
+		// Fail twice before accepting
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		json.Unmarshal(body, &e)
+		if r.Header.Get(EventHeader) != string(e.Type) || r.Header.Get(IDHeader) != e.ID {
+			t.Errorf("headers = %v, want event %q id %q", r.Header, e.Type, e.ID)
+		}
+		received <- e
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher(t, Config{
+		Endpoints: []Endpoint{
+			{URL: server.URL},
+			// Filtered out, so never contacted
+			{URL: server.URL + "/other", Events: []Type{TypeSignup}},
+		},
+	})
+	d.Emit(Event{Type: TypeLogin, UserID: "u1"})
+
+	select {
+	case e := <-received:
+		if e.Type != TypeLogin || e.UserID != "u1" || e.ID == "" || e.Time.IsZero() {
+			t.Errorf("received %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantAttempts int
+	}{
+		{"permanent failure is not retried", http.StatusBadRequest, 1},
+		{"retries are exhausted", http.StatusInternalServerError, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			path := filepath.Join(t.TempDir(), "dead.jsonl")
+			d := newTestDispatcher(t, Config{
+				Endpoints:      []Endpoint{{URL: server.URL}},
+				MaxAttempts:    3,
+				DeadLetterFile: path,
+			})
+			d.Emit(Event{Type: TypeLoginFailed, Reason: "invalid_credentials"})
+			d.Close(context.Background())
+
+			if got := int(attempts.Load()); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			lines := readDeadLetters(t, path)
+			if len(lines) != 1 {
+				t.Fatalf("dead letters = %d, want 1", len(lines))
+			}
+			if lines[0].Attempts != tt.wantAttempts || lines[0].Event.Reason != "invalid_credentials" || lines[0].Endpoint != server.URL {
+				t.Errorf("dead letter = %+v", lines[0])
+			}
+		})
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	d := newTestDispatcher(t, Config{
+		Endpoints:      []Endpoint{{URL: server.URL}},
+		QueueSize:      1,
+		Workers:        1,
+		DeadLetterFile: path,
+	})
+
+	// The worker holds one, the queue one more, and the rest are dropped
+	for i := 0; i < 5; i++ {
+		d.Emit(Event{Type: TypeSignup})
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	d.Close(context.Background())
+
+	if lines := readDeadLetters(t, path); len(lines) != 3 {
+		t.Errorf("dead letters = %d, want 3", len(lines))
+	}
+}
+
+func TestEmitNilDispatcher(t *testing.T) {
+	var d *Dispatcher
+	d.Emit(Event{Type: TypeLogin})
+	if err := d.Close(context.Background()); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Package geoip resolves client IPs to countries using a MaxMind-format
+// (.mmdb) database, reloading the file when it changes on disk so scheduled
+// database updates don't need a restart.
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// DefaultReloadInterval is how often the database file is checked for changes.
+const DefaultReloadInterval = time.Minute
+
+// Info is the result of a lookup. Fields are empty when the address isn't in
+// the database.
+type Info struct {
+	// Country is the ISO 3166-1 alpha-2 code, e.g. "US".
+	Country string
+	// Continent is the two-letter continent code, e.g. "EU".
+	Continent string
+}
+
+// record matches the GeoIP2/GeoLite2 Country and City layouts.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// RegisteredCountry is used for addresses without a country, such as
+	// anycast ranges.
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	Continent struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"continent"`
+}
+
+// DB is a hot-reloadable GeoIP database.
+type DB struct {
+	path string
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+
+	onReload func(error)
+	done     chan struct{}
+	once     sync.Once
+}
+
+// Open loads the database at path and checks it for changes every interval.
+// onReload, if set, is called after every reload attempt with its error.
+func Open(path string, interval time.Duration, onReload func(error)) (*DB, error) {
+	db := &DB{
+		path:     path,
+		onReload: onReload,
+		done:     make(chan struct{}),
+	}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	go db.watch(interval)
+	return db, nil
+}
+
+// Lookup returns the location of addr.
+func (db *DB) Lookup(addr netip.Addr) (Info, bool) {
+	if db == nil || !addr.IsValid() {
+		return Info{}, false
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var rec record
+	result := db.reader.Lookup(addr.Unmap())
+	if !result.Found() || result.Decode(&rec) != nil {
+		return Info{}, false
+	}
+
+	info := Info{
+		Country:   rec.Country.ISOCode,
+		Continent: rec.Continent.Code,
+	}
+	if info.Country == "" {
+		info.Country = rec.RegisteredCountry.ISOCode
+	}
+	return info, info.Country != "" || info.Continent != ""
+}
+
+// Close stops watching the file and releases the database.
+func (db *DB) Close() error {
+	db.once.Do(func() { close(db.done) })
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.reader.Close()
+}
+
+func (db *DB) load() error {
+	stat, err := os.Stat(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat GeoIP database: %w", err)
+	}
+
+	reader, err := maxminddb.Open(db.path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+
+	db.mu.Lock()
+	old := db.reader
+	db.reader = reader
+	db.modTime = stat.ModTime()
+	db.size = stat.Size()
+	db.mu.Unlock()
+
+	// Lookups hold the read lock, so nothing is using the old reader anymore
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// changed reports whether the file on disk differs from the loaded one.
+func (db *DB) changed() bool {
+	stat, err := os.Stat(db.path)
+	if err != nil {
+		// Mid-replace or deleted; keep serving the loaded copy
+		return false
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return !stat.ModTime().Equal(db.modTime) || stat.Size() != db.size
+}
+
+func (db *DB) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+			if !db.changed() {
+				continue
+			}
+			err := db.load()
+			if db.onReload != nil {
+				db.onReload(err)
+			}
+		}
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the lookup result.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the lookup result stored in ctx.
+func FromContext(ctx context.Context) (Info, bool) {
+	info, ok := ctx.Value(contextKey{}).(Info)
+	return info, ok
+}
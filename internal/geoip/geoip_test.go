@@ -0,0 +1,95 @@
+package geoip
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The databases in testdata are GeoIP2-Country files with a country and
+// continent record per network:
+//
+//	country.mmdb           198.51.100.0/24 DE, 2001:db8::/32 FR
+//	country-reloaded.mmdb  198.51.100.0/24 NL
+
+// copyDB copies a testdata database to path.
+func copyDB(t *testing.T, name, path string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	db, err := Open(filepath.Join("testdata", "country.mmdb"), time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		ip      string
+		country string
+		found   bool
+	}{
+		{"198.51.100.7", "DE", true},
+		{"::ffff:198.51.100.7", "DE", true},
+		{"2001:db8::1", "FR", true},
+		{"203.0.113.1", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			info, found := db.Lookup(netip.MustParseAddr(tt.ip))
+			if found != tt.found || info.Country != tt.country {
+				t.Errorf("Lookup(%s) = %q, %v, want %q, %v", tt.ip, info.Country, found, tt.country, tt.found)
+			}
+		})
+	}
+}
+
+func TestReloadOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	copyDB(t, "country.mmdb", path)
+
+	reloaded := make(chan error, 1)
+	db, err := Open(path, 10*time.Millisecond, func(err error) { reloaded <- err })
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	// Make sure the replacement has a different mtime even on coarse clocks
+	copyDB(t, "country-reloaded.mmdb", path)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+
+	select {
+	case err := <-reloaded:
+		if err != nil {
+			t.Fatalf("reload error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("database was not reloaded")
+	}
+
+	if info, _ := db.Lookup(netip.MustParseAddr("198.51.100.7")); info.Country != "NL" {
+		t.Errorf("Lookup() after reload = %q, want NL", info.Country)
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb"), 0, nil); err == nil {
+		t.Error("Open() should fail for a missing file")
+	}
+}
@@ -0,0 +1,188 @@
+// Package health reports whether the proxy is alive and whether it should be
+// sent traffic.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness statuses.
+const (
+	StatusReady    = "ready"
+	StatusDegraded = "degraded"
+	StatusNotReady = "not_ready"
+	StatusDraining = "draining"
+)
+
+// Check is a dependency readiness looks at.
+type Check struct {
+	Name string
+	// Critical checks make the proxy not ready when they fail. Others are
+	// reported but only mark it degraded.
+	Critical bool
+	Run      func(ctx context.Context) error
+}
+
+// Config holds configuration for readiness checks.
+type Config struct {
+	Checks []Check
+	// Timeout bounds each check run.
+	Timeout time.Duration
+	// CacheTTL is how long a check result is reused, so frequent probes
+	// from several sources don't hammer the dependencies.
+	CacheTTL time.Duration
+}
+
+// CheckResult is the outcome of one check.
+type CheckResult struct {
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Report is the readiness of the proxy with a per-check breakdown.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Readiness reports whether the proxy is ready for traffic. Draining makes
+// it fail so load balancers stop sending new requests, while the server
+// keeps handling the ones already in flight. The zero value is ready and has
+// no checks.
+type Readiness struct {
+	draining atomic.Bool
+	timeout  time.Duration
+	cacheTTL time.Duration
+	checks   []*cachedCheck
+	now      func() time.Time
+}
+
+type cachedCheck struct {
+	Check
+	// mu is held while the check runs, so concurrent probes share one run
+	mu     sync.Mutex
+	result CheckResult
+}
+
+// New creates readiness with the given checks.
+func New(cfg Config) *Readiness {
+	r := &Readiness{timeout: cfg.Timeout, cacheTTL: cfg.CacheTTL, now: time.Now}
+	if r.timeout <= 0 {
+		r.timeout = 2 * time.Second
+	}
+	for _, c := range cfg.Checks {
+		r.checks = append(r.checks, &cachedCheck{Check: c})
+	}
+	return r
+}
+
+// Drain marks the proxy as not ready. It reports whether the proxy was ready
+// before.
+func (r *Readiness) Drain() bool {
+	return r.draining.CompareAndSwap(false, true)
+}
+
+// Draining reports whether Drain has been called.
+func (r *Readiness) Draining() bool {
+	return r.draining.Load()
+}
+
+// Check runs the checks, reusing results younger than the cache TTL. Checks
+// run concurrently and each is bounded by the timeout.
+func (r *Readiness) Check() Report {
+	if r.Draining() {
+		return Report{Status: StatusDraining}
+	}
+
+	results := make([]CheckResult, len(r.checks))
+	var wg sync.WaitGroup
+	for i, c := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = r.run(c)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: make(map[string]CheckResult, len(results))}
+	for i, res := range results {
+		report.Checks[r.checks[i].Name] = res
+		if res.Status == "ok" {
+			continue
+		}
+		if res.Critical {
+			report.Status = StatusNotReady
+		} else if report.Status == StatusReady {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (r *Readiness) run(c *cachedCheck) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := r.clock()
+	if !c.result.CheckedAt.IsZero() && now.Sub(c.result.CheckedAt) < r.cacheTTL {
+		return c.result
+	}
+
+	// Not the probe's context: a probe that gives up shouldn't leave a
+	// cancelled result in the cache for the next one
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	err := c.Run(ctx)
+
+	c.result = CheckResult{
+		Status:    "ok",
+		Critical:  c.Critical,
+		Duration:  r.clock().Sub(now).Round(time.Millisecond).String(),
+		CheckedAt: now,
+	}
+	if err != nil {
+		c.result.Status = "fail"
+		c.result.Error = err.Error()
+	}
+	return c.result
+}
+
+func (r *Readiness) clock() time.Time {
+	if r.now == nil {
+		return time.Now()
+	}
+	return r.now()
+}
+
+// ReadyHandler serves the readiness report: 200 when ready or degraded, 503
+// when a critical check fails or the proxy is draining.
+func (r *Readiness) ReadyHandler(w http.ResponseWriter, req *http.Request) {
+	report := r.Check()
+	status := http.StatusOK
+	if report.Status == StatusNotReady || report.Status == StatusDraining {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// LiveHandler reports that the process is up and serving. It doesn't look at
+// dependencies: restarting the proxy doesn't fix an unreachable Redis.
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,117 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	var r Readiness
+	if r.Draining() {
+		t.Fatal("Draining() = true before Drain")
+	}
+	if !r.Drain() {
+		t.Error("first Drain() = false, want true")
+	}
+	if r.Drain() {
+		t.Error("second Drain() = true, want false")
+	}
+	if !r.Draining() {
+		t.Error("Draining() = false after Drain")
+	}
+}
+
+func check(name string, critical bool, err error) Check {
+	return Check{Name: name, Critical: critical, Run: func(context.Context) error { return err }}
+}
+
+func TestCheckStatus(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name   string
+		checks []Check
+		drain  bool
+		want   string
+		code   int
+	}{
+		{"no checks", nil, false, StatusReady, http.StatusOK},
+		{"all ok", []Check{check("redis", true, nil), check("gotrue", false, nil)}, false, StatusReady, http.StatusOK},
+		{"non-critical down", []Check{check("redis", true, nil), check("gotrue", false, down)}, false, StatusDegraded, http.StatusOK},
+		{"critical down", []Check{check("redis", true, down), check("gotrue", false, down)}, false, StatusNotReady, http.StatusServiceUnavailable},
+		{"draining", []Check{check("redis", true, nil)}, true, StatusDraining, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := New(Config{Checks: tt.checks})
+			if tt.drain {
+				r.Drain()
+			}
+
+			rec := httptest.NewRecorder()
+			r.ReadyHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.code {
+				t.Errorf("code = %d, want %d", rec.Code, tt.code)
+			}
+			var report Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatalf("invalid JSON: %v", err)
+			}
+			if report.Status != tt.want {
+				t.Errorf("status = %s, want %s", report.Status, tt.want)
+			}
+			if !tt.drain && len(report.Checks) != len(tt.checks) {
+				t.Errorf("checks = %v, want one result per check", report.Checks)
+			}
+		})
+	}
+}
+
+func TestCheckCacheAndTimeout(t *testing.T) {
+	var runs atomic.Int32
+	now := time.Unix(1000, 0)
+	r := New(Config{
+		Checks: []Check{{
+			Name:     "slow",
+			Critical: true,
+			Run: func(ctx context.Context) error {
+				runs.Add(1)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}},
+		Timeout:  10 * time.Millisecond,
+		CacheTTL: 5 * time.Second,
+	})
+	r.now = func() time.Time { return now }
+
+	report := r.Check()
+	if report.Status != StatusNotReady || report.Checks["slow"].Error == "" {
+		t.Errorf("report = %+v, want the timed out check to fail", report)
+	}
+
+	r.Check()
+	if got := runs.Load(); got != 1 {
+		t.Errorf("runs within the TTL = %d, want 1", got)
+	}
+
+	now = now.Add(6 * time.Second)
+	r.Check()
+	if got := runs.Load(); got != 2 {
+		t.Errorf("runs after the TTL = %d, want 2", got)
+	}
+}
+
+func TestLiveHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	LiveHandler(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("code = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,224 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+)
+
+// JWK is a JSON Web Key. Only the public EC P-256 and RSA fields are used.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// PublicKey converts the JWK into an *ecdsa.PublicKey or *rsa.PublicKey.
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !pub.Curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC point is not on the curve")
+		}
+		return pub, nil
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || n.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key too weak")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint, base64url-encoded.
+func (k *JWK) Thumbprint() (string, error) {
+	var canonical string
+	switch k.Kty {
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// minRefetchInterval stops tokens with made-up key IDs from making us hammer
+// the JWKS endpoint.
+const minRefetchInterval = 30 * time.Second
+
+// fetchTimeout bounds a fetch. Fetches run on their own context so a caller
+// giving up doesn't fail the fetch for everyone else waiting on it.
+const fetchTimeout = 10 * time.Second
+
+// KeySet fetches and caches the keys published on a JWKS endpoint. Keys are
+// refreshed periodically and whenever a token names an unknown key ID, so
+// key rotation is picked up without a restart.
+type KeySet struct {
+	url        string
+	client     *http.Client
+	refreshTTL time.Duration
+	group      singleflight.Group
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+// NewKeySet creates a key set for the JWKS at url. Keys are fetched lazily.
+func NewKeySet(url string, client *http.Client, refreshTTL time.Duration) *KeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if refreshTTL <= 0 {
+		refreshTTL = 10 * time.Minute
+	}
+	return &KeySet{
+		url:        url,
+		client:     client,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// Key returns the public key with the given ID. A known key is returned
+// straight from the cache, with a refresh started in the background if the
+// cache is stale; only an unknown key ID waits for a fetch.
+func (s *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	stale := time.Since(s.fetchedAt) > s.refreshTTL
+	canRefetch := time.Since(s.attemptedAt) > minRefetchInterval
+	s.mu.Unlock()
+
+	if ok {
+		if stale && canRefetch {
+			s.group.DoChan("jwks", s.refresh)
+		}
+		return key, nil
+	}
+	if !canRefetch {
+		return nil, ErrUnknownKey
+	}
+
+	select {
+	case res := <-s.group.DoChan("jwks", s.refresh):
+		if res.Err != nil {
+			return nil, res.Err
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	s.mu.Lock()
+	key, ok = s.keys[kid]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// refresh fetches the key set and swaps it in. Failed fetches keep the
+// cached keys, so a brief outage of the endpoint doesn't break verification.
+func (s *KeySet) refresh() (any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	keys, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attemptedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	s.keys = keys
+	s.fetchedAt = s.attemptedAt
+	return nil, nil
+}
+
+func (s *KeySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", buildinfo.UserAgent())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			// Skip key types we don't verify with rather than failing the set
+			continue
+		}
+		keys[jwk.Kid] = pub
+	}
+	return keys, nil
+}
@@ -0,0 +1,289 @@
+// Package jwt verifies the access tokens GoTrue issues: HS256 tokens signed
+// with the project's JWT secret, and ES256/RS256 tokens signed with keys
+// published on GoTrue's JWKS endpoint.
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed      = errors.New("malformed token")
+	ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrBadSignature   = errors.New("invalid token signature")
+	ErrExpired        = errors.New("token expired")
+	ErrMissingExpiry  = errors.New("token has no expiry")
+	ErrNotYetValid    = errors.New("token not yet valid")
+	ErrBadAudience    = errors.New("token audience mismatch")
+	ErrBadIssuer      = errors.New("token issuer mismatch")
+)
+
+// Header is the JOSE header of a token.
+type Header struct {
+	Alg string          `json:"alg"`
+	Kid string          `json:"kid"`
+	Typ string          `json:"typ"`
+	JWK json.RawMessage `json:"jwk,omitempty"`
+}
+
+// AMR is one authentication method recorded in a Supabase token.
+type AMR struct {
+	Method    string `json:"method"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Claims are the registered claims plus the ones Supabase adds.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud"`
+	Issuer    string   `json:"iss"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	ID        string   `json:"jti"`
+
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"session_id"`
+	// AAL is the authenticator assurance level: "aal1" or "aal2".
+	AAL string `json:"aal"`
+	AMR []AMR  `json:"amr"`
+}
+
+// Audience accepts both the string and array forms of "aud".
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether aud is one of the audiences.
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// Token is a parsed but not yet verified token.
+type Token struct {
+	Header       Header
+	Payload      []byte
+	SigningInput string
+	Signature    []byte
+}
+
+// Parse splits a compact JWS and decodes its header and payload without
+// verifying anything.
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	t := &Token{
+		Payload:      payload,
+		SigningInput: parts[0] + "." + parts[1],
+		Signature:    signature,
+	}
+	if err := json.Unmarshal(headerJSON, &t.Header); err != nil {
+		return nil, ErrMalformed
+	}
+	return t, nil
+}
+
+// UnverifiedClaims decodes a token's claims without checking its signature.
+// Only use it to decide to reject a token, never to accept one.
+func UnverifiedClaims(raw string) (*Claims, error) {
+	t, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	if err := json.Unmarshal(t.Payload, &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	return &claims, nil
+}
+
+// VerifySignature checks the token signature against key, which must match
+// the algorithm: []byte for HS256, *ecdsa.PublicKey for ES256 and
+// *rsa.PublicKey for RS256.
+func (t *Token) VerifySignature(key crypto.PublicKey) error {
+	digest := sha256.Sum256([]byte(t.SigningInput))
+
+	switch t.Header.Alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return ErrUnknownKey
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(t.SigningInput))
+		if !hmac.Equal(mac.Sum(nil), t.Signature) {
+			return ErrBadSignature
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(t.Signature) != 64 {
+			return ErrBadSignature
+		}
+		r := new(big.Int).SetBytes(t.Signature[:32])
+		s := new(big.Int).SetBytes(t.Signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrBadSignature
+		}
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrBadSignature
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], t.Signature); err != nil {
+			return ErrBadSignature
+		}
+	default:
+		return ErrUnsupportedAlg
+	}
+	return nil
+}
+
+// Config holds configuration for the token verifier.
+type Config struct {
+	// Secret verifies HS256 tokens (the project's legacy JWT secret).
+	Secret string
+	// JWKS provides keys for asymmetrically signed tokens.
+	JWKS *KeySet
+	// Issuer must match the token's "iss" claim.
+	Issuer string
+	// Audience, if set, must be present in the token's "aud" claim.
+	Audience string
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// Verifier checks signatures and time-based claims on access tokens.
+type Verifier struct {
+	secret   []byte
+	jwks     *KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+// NewVerifier creates a token verifier.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if cfg.Secret == "" && cfg.JWKS == nil {
+		return nil, fmt.Errorf("a JWT secret or JWKS is required")
+	}
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("a token issuer is required")
+	}
+	return &Verifier{
+		secret:   []byte(cfg.Secret),
+		jwks:     cfg.JWKS,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		leeway:   cfg.Leeway,
+	}, nil
+}
+
+// Verify parses and verifies a token and returns its claims.
+func (v *Verifier) Verify(ctx context.Context, raw string) (*Claims, error) {
+	t, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var key crypto.PublicKey
+	switch t.Header.Alg {
+	case "HS256":
+		if len(v.secret) == 0 {
+			return nil, ErrUnknownKey
+		}
+		key = v.secret
+	case "ES256", "RS256":
+		if v.jwks == nil {
+			return nil, ErrUnknownKey
+		}
+		if key, err = v.jwks.Key(ctx, t.Header.Kid); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedAlg
+	}
+
+	if err := t.VerifySignature(key); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(t.Payload, &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	now := time.Now()
+	if claims.ExpiresAt == 0 {
+		return nil, ErrMissingExpiry
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(v.leeway)) {
+		return nil, ErrExpired
+	}
+	if claims.NotBefore != 0 && now.Add(v.leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrNotYetValid
+	}
+	if claims.Issuer != v.issuer {
+		return nil, ErrBadIssuer
+	}
+	if v.audience != "" && !claims.Audience.Contains(v.audience) {
+		return nil, ErrBadAudience
+	}
+
+	return &claims, nil
+}
+
+// BearerToken returns the token from an "Authorization: Bearer" header value.
+func BearerToken(authorization string) (string, bool) {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
@@ -0,0 +1,215 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func encode(v any) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func signHS256(secret string, claims map[string]any) string {
+	input := encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signES256(key *ecdsa.PrivateKey, kid string, claims map[string]any) string {
+	input := encode(map[string]string{"alg": "ES256", "kid": kid}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+const testIssuer = "https://project.supabase.co/auth/v1"
+
+func TestVerifyHS256(t *testing.T) {
+	v, err := NewVerifier(Config{Secret: "secret", Issuer: testIssuer, Audience: "authenticated"})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	future := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", signHS256("secret", map[string]any{"sub": "u1", "iss": testIssuer, "aud": "authenticated", "exp": future, "aal": "aal2"}), nil},
+		{"audience list", signHS256("secret", map[string]any{"iss": testIssuer, "aud": []string{"x", "authenticated"}, "exp": future}), nil},
+		{"wrong secret", signHS256("other", map[string]any{"iss": testIssuer, "aud": "authenticated", "exp": future}), ErrBadSignature},
+		{"expired", signHS256("secret", map[string]any{"iss": testIssuer, "aud": "authenticated", "exp": time.Now().Add(-time.Hour).Unix()}), ErrExpired},
+		{"no expiry", signHS256("secret", map[string]any{"iss": testIssuer, "aud": "authenticated"}), ErrMissingExpiry},
+		{"wrong issuer", signHS256("secret", map[string]any{"iss": "https://other.supabase.co/auth/v1", "aud": "authenticated", "exp": future}), ErrBadIssuer},
+		{"no issuer", signHS256("secret", map[string]any{"aud": "authenticated", "exp": future}), ErrBadIssuer},
+		{"wrong audience", signHS256("secret", map[string]any{"iss": testIssuer, "aud": "anon", "exp": future}), ErrBadAudience},
+		{"garbage", "not.a.token", ErrMalformed},
+		{"alg none", encode(map[string]string{"alg": "none"}) + "." + encode(map[string]any{"aud": "authenticated"}) + ".", ErrUnsupportedAlg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+			if err == nil && tt.name == "valid" && (claims.Subject != "u1" || claims.AAL != "aal2") {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyES256WithJWKS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": "key-1",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer server.Close()
+
+	v, _ := NewVerifier(Config{JWKS: NewKeySet(server.URL, nil, time.Hour), Issuer: testIssuer})
+	claims := map[string]any{"sub": "u1", "iss": testIssuer, "exp": time.Now().Add(time.Hour).Unix()}
+
+	if _, err := v.Verify(context.Background(), signES256(key, "key-1", claims)); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if _, err := v.Verify(context.Background(), signES256(key, "key-1", claims)); err != nil {
+		t.Fatalf("second Verify() error = %v", err)
+	}
+	if fetches.Load() != 1 {
+		t.Errorf("JWKS fetched %d times, want 1 (cached)", fetches.Load())
+	}
+
+	if _, err := v.Verify(context.Background(), signES256(key, "key-2", claims)); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown kid error = %v, want ErrUnknownKey", err)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := v.Verify(context.Background(), signES256(other, "key-1", claims)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("wrong key error = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestKeySetFetchesOutsideTheLock(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := map[string]any{"keys": []map[string]string{{
+		"kty": "EC",
+		"kid": "key-1",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}}}
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ks := NewKeySet(server.URL, nil, time.Hour)
+	if _, err := ks.Key(context.Background(), "key-1"); err != nil {
+		t.Fatalf("Key() error = %v", err)
+	}
+
+	// Make the cache stale: the next lookup starts a refresh that hangs
+	ks.mu.Lock()
+	ks.fetchedAt = time.Now().Add(-2 * time.Hour)
+	ks.attemptedAt = ks.fetchedAt
+	ks.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ks.Key(context.Background(), "key-1")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("stale Key() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stale Key() blocked on the refresh")
+	}
+
+	// An unknown key waits for the in-flight fetch, but only as long as
+	// the caller's context allows
+	ks.mu.Lock()
+	ks.attemptedAt = time.Time{}
+	ks.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := ks.Key(ctx, "key-2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("unknown Key() error = %v, want context.DeadlineExceeded", err)
+	}
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("JWKS fetched %d times, want 2 (one refresh shared)", got)
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1 example
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	got, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatalf("Thumbprint() error = %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %s, want %s", got, want)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"Bearer abc", "abc", true},
+		{"bearer abc", "abc", true},
+		{"Basic abc", "", false},
+		{"Bearer", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := BearerToken(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("BearerToken(%q) = %q, %v, want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package logging
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"os"
 	"strings"
 
+	"github.com/kacy/auth-proxy/internal/requestid"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -32,6 +36,7 @@ const (
 
 type Logger struct {
 	*zap.Logger
+	level zap.AtomicLevel
 }
 
 func New(level string, isProduction bool) (*Logger, error) {
@@ -46,17 +51,9 @@ func New(level string, isProduction bool) (*Logger, error) {
 		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
 	}
 
-	switch level {
-	case "debug":
-		config.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
-	case "info":
-		config.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
-	case "warn":
-		config.Level = zap.NewAtomicLevelAt(zapcore.WarnLevel)
-	case "error":
-		config.Level = zap.NewAtomicLevelAt(zapcore.ErrorLevel)
-	default:
-		config.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	config.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	if lvl, err := zapcore.ParseLevel(level); err == nil {
+		config.Level.SetLevel(lvl)
 	}
 
 	config.OutputPaths = []string{"stdout"}
@@ -70,7 +67,50 @@ func New(level string, isProduction bool) (*Logger, error) {
 		return nil, err
 	}
 
-	return &Logger{Logger: logger}, nil
+	return &Logger{Logger: logger, level: config.Level}, nil
+}
+
+// SetLevel changes the level of l and every logger derived from it, for
+// example "debug" or "warn".
+func (l *Logger) SetLevel(level string) error {
+	if l.level == (zap.AtomicLevel{}) {
+		return fmt.Errorf("logger level can't be changed")
+	}
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.level.SetLevel(lvl)
+	return nil
+}
+
+// Level returns the current level name.
+func (l *Logger) Level() string {
+	if l.level == (zap.AtomicLevel{}) {
+		return l.Logger.Level().String()
+	}
+	return l.level.String()
+}
+
+// For returns a child logger for the request in ctx. It adds the request ID
+// and the trace and span IDs of the current span to every line, so all lines
+// of one request can be found together and joined with traces. Outside a
+// request it returns l.
+func (l *Logger) For(ctx context.Context) *Logger {
+	var fields []zap.Field
+	if id := requestid.FromContext(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields = append(fields,
+			zap.String("trace_id", sc.TraceID().String()),
+			zap.String("span_id", sc.SpanID().String()),
+		)
+	}
+	if len(fields) == 0 {
+		return l
+	}
+	return &Logger{Logger: l.Logger.With(fields...), level: l.level}
 }
 
 func (l *Logger) WithEmoji(emoji string, msg string) string {
@@ -199,9 +239,39 @@ func MaskUserID(userID string) string {
 	return userID[:8] + "-****"
 }
 
+// MaskPhone masks a phone number, keeping the leading digits (country code
+// and range) and the last two.
+// Example: "+447700900123" -> "+44770*****23"
+func MaskPhone(phone string) string {
+	if phone == "" {
+		return ""
+	}
+	if len(phone) <= 7 {
+		return "***"
+	}
+	return phone[:len(phone)-7] + "*****" + phone[len(phone)-2:]
+}
+
+// MaskKeyID masks a key ID or other opaque identifier, showing only the first
+// and last 4 characters.
+// Example: "a1b2c3d4e5f6" -> "a1b2***e5f6"
+func MaskKeyID(s string) string {
+	if s == "" {
+		return ""
+	}
+	if len(s) <= 8 {
+		return "***"
+	}
+	return s[:4] + "***" + s[len(s)-4:]
+}
+
 // SensitiveFields are field names that should not be logged.
 var SensitiveFields = []string{
 	"password",
+	"new_password",
+	"current_password",
+	"password_confirmation",
+	"nonce",
 	"access_token",
 	"refresh_token",
 	"token",
@@ -213,12 +283,10 @@ var SensitiveFields = []string{
 }
 
 // SanitizeBody removes sensitive fields from JSON bodies for logging.
+// Sensitive fields are checked before truncating so a long body can't push a
+// password into the logged prefix.
 func SanitizeBody(body []byte) string {
 	const maxLen = 1024
-	s := string(body)
-	if len(s) > maxLen {
-		return s[:maxLen] + "...(truncated)"
-	}
 
 	// Check for sensitive field patterns
 	for _, field := range SensitiveFields {
@@ -228,5 +296,10 @@ func SanitizeBody(body []byte) string {
 		}
 	}
 
+	s := string(body)
+	if len(s) > maxLen {
+		return s[:maxLen] + "...(truncated)"
+	}
+
 	return s
 }
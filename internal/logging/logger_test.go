@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/requestid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSanitizeBody(t *testing.T) {
+	long := `{"data":"` + strings.Repeat("x", 2000) + `","password":"hunter2"}`
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"plain body", `{"email":"a@example.com"}`, `{"email":"a@example.com"}`},
+		{"password", `{"password":"hunter2"}`, "[body contains sensitive data - not logged]"},
+		{"reauthentication nonce", `{"password":"x","nonce":"123456"}`, "[body contains sensitive data - not logged]"},
+		{"current password", `{"current_password":"x"}`, "[body contains sensitive data - not logged]"},
+		{"password past truncation point", long, "[body contains sensitive data - not logged]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("SanitizeBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"", ""},
+		{"john.doe@example.com", "jo***@example.com"},
+		{"a@example.com", "a***@example.com"},
+		{"invalid", "***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if got := MaskEmail(tt.email); got != tt.want {
+				t.Errorf("MaskEmail(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskPhone(t *testing.T) {
+	tests := []struct {
+		phone string
+		want  string
+	}{
+		{"", ""},
+		{"+447700900123", "+44770*****23"},
+		{"12345", "***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.phone, func(t *testing.T) {
+			if got := MaskPhone(tt.phone); got != tt.want {
+				t.Errorf("MaskPhone(%q) = %q, want %q", tt.phone, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaskKeyID(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty", "", ""},
+		{"short string", "abc", "***"},
+		{"exactly 8 chars", "12345678", "***"},
+		{"longer string", "1234567890", "1234***7890"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MaskKeyID(tt.input); got != tt.want {
+				t.Errorf("MaskKeyID(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForAddsRequestContext(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	l := &Logger{Logger: zap.New(core)}
+
+	l.For(context.Background()).Info("no trace")
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	l.For(trace.ContextWithSpanContext(ctx, sc)).Info("traced")
+
+	entries := logs.All()
+	if len(entries[0].Context) != 0 {
+		t.Errorf("untraced entry fields = %v, want none", entries[0].Context)
+	}
+	fields := entries[1].ContextMap()
+	if fields["request_id"] != "req-1" || fields["trace_id"] != sc.TraceID().String() || fields["span_id"] != sc.SpanID().String() {
+		t.Errorf("traced entry fields = %v, want request, trace and span IDs", fields)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	logger, err := New("info", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := logger.For(requestid.NewContext(context.Background(), "req-1"))
+
+	if err := logger.SetLevel("debug"); err != nil {
+		t.Fatalf("SetLevel() error = %v", err)
+	}
+	if !child.Core().Enabled(zap.DebugLevel) || logger.Level() != "debug" {
+		t.Errorf("after SetLevel(debug) Level() = %q, child debug enabled = %v", logger.Level(), child.Core().Enabled(zap.DebugLevel))
+	}
+
+	if err := logger.SetLevel("loud"); err == nil {
+		t.Error("SetLevel(loud) = nil, want an error")
+	}
+	if logger.Level() != "debug" {
+		t.Errorf("Level() = %q after a failed SetLevel, want debug", logger.Level())
+	}
+}
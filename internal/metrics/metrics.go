@@ -1,8 +1,11 @@
 package metrics
 
 import (
+	"context"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Metrics holds application-level metrics.
@@ -17,6 +20,19 @@ type Metrics struct {
 	AttestationAttemptsTotal *prometheus.CounterVec
 	AttestationSuccessTotal  *prometheus.CounterVec
 	AttestationFailuresTotal *prometheus.CounterVec
+
+	// Network access metrics
+	NetworkAccessDeniedTotal *prometheus.CounterVec
+
+	// Device binding metrics
+	DeviceBindingEventsTotal *prometheus.CounterVec
+
+	// Webhook metrics
+	WebhookDeliveriesTotal  *prometheus.CounterVec
+	WebhookDeliveryDuration *prometheus.HistogramVec
+
+	// Build metrics
+	BuildInfo *prometheus.GaugeVec
 }
 
 func New() *Metrics {
@@ -64,5 +80,53 @@ func New() *Metrics {
 			},
 			[]string{"platform", "reason"},
 		),
+		NetworkAccessDeniedTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "auth_proxy_network_access_denied_total",
+				Help: "Total number of requests denied by IP or country rules",
+			},
+			[]string{"reason", "country"},
+		),
+		DeviceBindingEventsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "auth_proxy_device_binding_events_total",
+				Help: "Total number of device binding events (new devices, key moves, blocked sign-ins)",
+			},
+			[]string{"event"},
+		),
+		WebhookDeliveriesTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "auth_proxy_webhook_deliveries_total",
+				Help: "Total number of webhook delivery outcomes (delivered, retried, failed, dropped)",
+			},
+			[]string{"event", "result"},
+		),
+		WebhookDeliveryDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "auth_proxy_webhook_delivery_duration_seconds",
+				Help:    "Webhook delivery request duration in seconds",
+				Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+			},
+			[]string{"event"},
+		),
+		BuildInfo: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "auth_proxy_build_info",
+				Help: "Always 1, labelled with the version, revision and Go version of the running build",
+			},
+			[]string{"version", "revision", "modified", "go_version", "device_attestation"},
+		),
+	}
+}
+
+// Observe records v on a histogram. When ctx carries a sampled span, its
+// trace ID is attached as an exemplar so a slow bucket links to a trace.
+func Observe(ctx context.Context, o prometheus.Observer, v float64) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		if eo, ok := o.(prometheus.ExemplarObserver); ok {
+			eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": sc.TraceID().String()})
+			return
+		}
 	}
+	o.Observe(v)
 }
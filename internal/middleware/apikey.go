@@ -2,10 +2,12 @@ package middleware
 
 import (
 	"crypto/subtle"
-	"encoding/json"
 	"net/http"
 	"strings"
 
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
 	"github.com/kacy/auth-proxy/internal/logging"
 	"go.uber.org/zap"
 )
@@ -50,7 +52,7 @@ func (m *APIKeyMiddleware) Middleware(next http.Handler) http.Handler {
 		}
 
 		// Skip for health checks
-		if strings.HasPrefix(r.URL.Path, "/health") {
+		if isHealthPath(r.URL.Path) {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -61,38 +63,37 @@ func (m *APIKeyMiddleware) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Skip for the admin API, which has its own token
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Get API key from header
 		providedKey := r.Header.Get(APIKeyHeader)
 		if providedKey == "" {
-			m.logger.AuthWarning("request missing API key",
+			m.logger.For(r.Context()).AuthWarning("request missing API key",
 				zap.String("path", r.URL.Path),
 				zap.String("method", r.Method),
-				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("client_ip", clientip.FromRequest(r)),
 			)
-			m.rejectRequest(w, "api_key_required", "API key is required", http.StatusUnauthorized)
+			audit.Report(r, audit.Entry{Action: "api_key", Decision: audit.DecisionDeny, Reason: "api_key_required"})
+			apierror.Write(w, r, apierror.ErrAPIKeyRequired)
 			return
 		}
 
 		// Constant-time comparison to prevent timing attacks
 		if subtle.ConstantTimeCompare([]byte(providedKey), []byte(m.expectedKey)) != 1 {
-			m.logger.AuthWarning("invalid API key",
+			m.logger.For(r.Context()).AuthWarning("invalid API key",
 				zap.String("path", r.URL.Path),
 				zap.String("method", r.Method),
-				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("client_ip", clientip.FromRequest(r)),
 			)
-			m.rejectRequest(w, "invalid_api_key", "Invalid API key", http.StatusForbidden)
+			audit.Report(r, audit.Entry{Action: "api_key", Decision: audit.DecisionDeny, Reason: "invalid_api_key"})
+			apierror.Write(w, r, apierror.ErrInvalidAPIKey)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
-
-func (m *APIKeyMiddleware) rejectRequest(w http.ResponseWriter, code, message string, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{
-		"error":   code,
-		"message": message,
-	})
-}
@@ -7,8 +7,15 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/kacy/auth-proxy/internal/apierror"
 	"github.com/kacy/auth-proxy/internal/attestation"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/events"
 	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -25,13 +32,16 @@ const (
 // AttestationMiddleware validates device attestation on incoming requests.
 type AttestationMiddleware struct {
 	verifier *attestation.Verifier
+	events   *events.Dispatcher
 	logger   *logging.Logger
 }
 
-// NewAttestationMiddleware creates a new attestation middleware.
-func NewAttestationMiddleware(verifier *attestation.Verifier, logger *logging.Logger) *AttestationMiddleware {
+// NewAttestationMiddleware creates a new attestation middleware. Failures are
+// reported to dispatcher, which may be nil.
+func NewAttestationMiddleware(verifier *attestation.Verifier, dispatcher *events.Dispatcher, logger *logging.Logger) *AttestationMiddleware {
 	return &AttestationMiddleware{
 		verifier: verifier,
+		events:   dispatcher,
 		logger:   logger,
 	}
 }
@@ -40,18 +50,18 @@ func NewAttestationMiddleware(verifier *attestation.Verifier, logger *logging.Lo
 func (m *AttestationMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Log incoming request for debugging
-		m.logger.Debug("attestation middleware received request",
+		m.logger.For(r.Context()).Debug("attestation middleware received request",
 			zap.String("method", r.Method),
 			zap.String("path", r.URL.Path),
-			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("client_ip", clientip.FromRequest(r)),
 			zap.Bool("attestation_enabled", m.verifier.IsEnabled()),
 			zap.Bool("ios_enabled", m.verifier.IsIOSEnabled()),
 			zap.Bool("android_enabled", m.verifier.IsAndroidEnabled()),
 		)
 
 		// Skip attestation for health checks
-		if strings.HasPrefix(r.URL.Path, "/health") {
-			m.logger.Debug("skipping attestation for health check",
+		if isHealthPath(r.URL.Path) {
+			m.logger.For(r.Context()).Debug("skipping attestation for health check",
 				zap.String("path", r.URL.Path))
 			next.ServeHTTP(w, r)
 			return
@@ -59,7 +69,7 @@ func (m *AttestationMiddleware) Middleware(next http.Handler) http.Handler {
 
 		// Skip if attestation is disabled
 		if !m.verifier.IsEnabled() {
-			m.logger.Debug("attestation disabled, skipping verification")
+			m.logger.For(r.Context()).Debug("attestation disabled, skipping verification")
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -72,7 +82,7 @@ func (m *AttestationMiddleware) Middleware(next http.Handler) http.Handler {
 		clientDataHeader := r.Header.Get(ClientDataHeader)
 		challengeHeader := r.Header.Get(ChallengeHeader)
 
-		m.logger.Debug("checking attestation headers",
+		m.logger.For(r.Context()).Debug("checking attestation headers",
 			zap.Bool("assertion_present", assertionHeader != ""),
 			zap.Bool("attestation_present", attestationHeader != ""),
 			zap.Bool("key_id_present", keyIDHeader != ""),
@@ -84,70 +94,83 @@ func (m *AttestationMiddleware) Middleware(next http.Handler) http.Handler {
 		// Check if this is an initial attestation or an assertion
 		if r.Header.Get(AssertionHeader) != "" {
 			// iOS assertion flow (subsequent requests)
-			m.logger.AppleAuth("verifying iOS assertion request",
+			m.logger.For(r.Context()).AppleAuth("verifying iOS assertion request",
 				zap.String("path", r.URL.Path),
-				zap.String("key_id", maskString(keyIDHeader)),
+				zap.String("key_id", logging.MaskKeyID(keyIDHeader)),
 			)
-			if err := m.verifyAssertion(r); err != nil {
-				m.logger.AuthError("iOS assertion verification failed",
+			if err := m.traced(r, "assertion", m.verifyAssertion); err != nil {
+				m.logger.For(r.Context()).AuthError("iOS assertion verification failed",
 					zap.Error(err),
 					zap.String("path", r.URL.Path),
-					zap.String("key_id", maskString(keyIDHeader)),
+					zap.String("key_id", logging.MaskKeyID(keyIDHeader)),
 				)
-				m.handleError(w, err)
+				m.handleError(w, r, err)
 				return
 			}
-			m.logger.AuthSuccess("iOS assertion verification succeeded",
+			m.logger.For(r.Context()).AuthSuccess("iOS assertion verification succeeded",
 				zap.String("path", r.URL.Path),
-				zap.String("key_id", maskString(keyIDHeader)),
+				zap.String("key_id", logging.MaskKeyID(keyIDHeader)),
 			)
 		} else if r.Header.Get(AttestationHeader) != "" {
 			// Initial attestation flow
-			m.logger.AppleAuth("verifying initial iOS attestation request",
+			m.logger.For(r.Context()).AppleAuth("verifying initial iOS attestation request",
 				zap.String("path", r.URL.Path),
-				zap.String("key_id", maskString(keyIDHeader)),
+				zap.String("key_id", logging.MaskKeyID(keyIDHeader)),
 				zap.String("platform", platformHeader),
 			)
-			if err := m.verifyAttestation(r); err != nil {
-				m.logger.AuthError("initial attestation verification failed",
+			if err := m.traced(r, "attestation", m.verifyAttestation); err != nil {
+				m.logger.For(r.Context()).AuthError("initial attestation verification failed",
 					zap.Error(err),
 					zap.String("path", r.URL.Path),
-					zap.String("key_id", maskString(keyIDHeader)),
+					zap.String("key_id", logging.MaskKeyID(keyIDHeader)),
 				)
-				m.handleError(w, err)
+				m.handleError(w, r, err)
 				return
 			}
-			m.logger.AuthSuccess("initial attestation verification succeeded",
+			m.logger.For(r.Context()).AuthSuccess("initial attestation verification succeeded",
 				zap.String("path", r.URL.Path),
-				zap.String("key_id", maskString(keyIDHeader)),
+				zap.String("key_id", logging.MaskKeyID(keyIDHeader)),
 			)
 		} else {
 			// No attestation provided
-			m.logger.AuthWarning("request without attestation headers - rejecting",
+			m.logger.For(r.Context()).AuthWarning("request without attestation headers - rejecting",
 				zap.String("path", r.URL.Path),
 				zap.String("method", r.Method),
-				zap.String("remote_addr", r.RemoteAddr),
+				zap.String("client_ip", clientip.FromRequest(r)),
 			)
-			m.handleError(w, attestation.ErrAttestationRequired)
+			m.handleError(w, r, attestation.ErrAttestationRequired)
 			return
 		}
 
-		m.logger.Debug("attestation verification completed successfully",
+		m.logger.For(r.Context()).Debug("attestation verification completed successfully",
 			zap.String("path", r.URL.Path),
 		)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// traced runs a verification inside an attestation.verify span.
+func (m *AttestationMiddleware) traced(r *http.Request, kind string, verify func(*http.Request) error) error {
+	ctx, span := tracing.Tracer().Start(r.Context(), "attestation.verify", trace.WithAttributes(
+		attribute.String("attestation.kind", kind),
+		attribute.String("attestation.platform", strings.ToLower(r.Header.Get(PlatformHeader))),
+	))
+	defer span.End()
+
+	err := verify(r.WithContext(ctx))
+	tracing.RecordError(span, err)
+	return err
+}
+
 func (m *AttestationMiddleware) verifyAttestation(r *http.Request) error {
 	platform := parsePlatform(r.Header.Get(PlatformHeader))
 	token := r.Header.Get(AttestationHeader)
 	keyID := r.Header.Get(KeyIDHeader)
 	challenge := r.Header.Get(ChallengeHeader)
 
-	m.logger.Debug("verifying initial attestation",
+	m.logger.For(r.Context()).Debug("verifying initial attestation",
 		zap.String("platform", r.Header.Get(PlatformHeader)),
-		zap.String("key_id", maskString(keyID)),
+		zap.String("key_id", logging.MaskKeyID(keyID)),
 		zap.Bool("has_token", token != ""),
 		zap.Bool("has_challenge", challenge != ""),
 		zap.Int("token_length", len(token)),
@@ -169,8 +192,8 @@ func (m *AttestationMiddleware) verifyAssertion(r *http.Request) error {
 	keyID := r.Header.Get(KeyIDHeader)
 	clientDataB64 := r.Header.Get(ClientDataHeader)
 
-	m.logger.Debug("verifying assertion",
-		zap.String("key_id", maskString(keyID)),
+	m.logger.For(r.Context()).Debug("verifying assertion",
+		zap.String("key_id", logging.MaskKeyID(keyID)),
 		zap.Bool("has_assertion", assertion != ""),
 		zap.Bool("has_client_data", clientDataB64 != ""),
 		zap.Int("assertion_length", len(assertion)),
@@ -180,16 +203,16 @@ func (m *AttestationMiddleware) verifyAssertion(r *http.Request) error {
 	// Decode the base64-encoded client data
 	clientData, err := base64.StdEncoding.DecodeString(clientDataB64)
 	if err != nil {
-		m.logger.AuthError("failed to decode base64 client data",
+		m.logger.For(r.Context()).AuthError("failed to decode base64 client data",
 			zap.Error(err),
-			zap.String("client_data_b64", maskString(clientDataB64)),
+			zap.String("client_data_b64", logging.MaskKeyID(clientDataB64)),
 		)
 		return attestation.ErrInvalidAssertion
 	}
 
-	m.logger.Debug("successfully decoded client data",
+	m.logger.For(r.Context()).Debug("successfully decoded client data",
 		zap.Int("decoded_length", len(clientData)),
-		zap.String("client_data_preview", maskString(string(clientData))),
+		zap.String("client_data_preview", logging.MaskKeyID(string(clientData))),
 	)
 
 	data := &attestation.AssertionData{
@@ -201,49 +224,48 @@ func (m *AttestationMiddleware) verifyAssertion(r *http.Request) error {
 	return m.verifier.VerifyAssertion(r.Context(), data)
 }
 
-func (m *AttestationMiddleware) handleError(w http.ResponseWriter, err error) {
-	w.Header().Set("Content-Type", "application/json")
-
-	var statusCode int
-	var errorCode string
-	var message string
+func (m *AttestationMiddleware) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *apierror.Error
 
 	switch err {
 	case attestation.ErrAttestationRequired:
-		statusCode = http.StatusUnauthorized
-		errorCode = "attestation_required"
-		message = "Device attestation is required for this request"
+		apiErr = apierror.ErrAttestationRequired
 	case attestation.ErrInvalidAttestation:
-		statusCode = http.StatusForbidden
-		errorCode = "invalid_attestation"
-		message = "Device attestation verification failed"
+		apiErr = apierror.ErrInvalidAttestation
 	case attestation.ErrUnsupportedPlatform:
-		statusCode = http.StatusBadRequest
-		errorCode = "unsupported_platform"
-		message = "Unsupported platform for attestation"
+		apiErr = apierror.ErrUnsupportedPlatform
 	case attestation.ErrKeyNotFound:
-		statusCode = http.StatusUnauthorized
-		errorCode = "key_not_found"
-		message = "Attestation key not found, re-attestation required"
+		apiErr = apierror.ErrKeyNotFound
 	case attestation.ErrReplayDetected:
-		statusCode = http.StatusForbidden
-		errorCode = "replay_detected"
-		message = "Assertion replay detected"
+		apiErr = apierror.ErrReplayDetected
 	case attestation.ErrInvalidAssertion:
-		statusCode = http.StatusForbidden
-		errorCode = "invalid_assertion"
-		message = "Invalid assertion"
+		apiErr = apierror.ErrInvalidAssertion
 	default:
-		statusCode = http.StatusInternalServerError
-		errorCode = "attestation_error"
-		message = "Attestation verification error"
+		apiErr = apierror.ErrAttestationFailed.WithCause(err)
 	}
+	errorCode := apiErr.Code
+
+	audit.Report(r, audit.Entry{
+		Action:   "attestation",
+		Decision: audit.DecisionDeny,
+		Reason:   errorCode,
+		KeyID:    r.Header.Get(KeyIDHeader),
+	})
 
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error":   errorCode,
-		"message": message,
+	eventType := events.TypeAttestationFailed
+	if err == attestation.ErrReplayDetected {
+		eventType = events.TypeReplayDetected
+	}
+	m.events.Emit(events.Event{
+		Type:      eventType,
+		Platform:  r.Header.Get(PlatformHeader),
+		ClientIP:  clientip.FromRequest(r),
+		UserAgent: r.Header.Get("User-Agent"),
+		Path:      r.URL.Path,
+		Reason:    errorCode,
 	})
+
+	apierror.Write(w, r, apiErr)
 }
 
 func parsePlatform(s string) attestation.Platform {
@@ -257,19 +279,12 @@ func parsePlatform(s string) attestation.Platform {
 	}
 }
 
-func maskString(s string) string {
-	if len(s) <= 8 {
-		return "***"
-	}
-	return s[:4] + "***" + s[len(s)-4:]
-}
-
 // ChallengeHandler returns an HTTP handler for generating attestation challenges.
 // Clients call this endpoint to get a challenge before performing attestation.
 func ChallengeHandler(verifier *attestation.Verifier, logger *logging.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			apierror.Write(w, r, apierror.ErrMethodNotAllowed)
 			return
 		}
 
@@ -279,34 +294,19 @@ func ChallengeHandler(verifier *attestation.Verifier, logger *logging.Logger) ht
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error":   "invalid_request",
-				"message": "Invalid JSON body",
-			})
+			apierror.Write(w, r, apierror.ErrInvalidRequest.WithMessage("Invalid JSON body").WithCause(err))
 			return
 		}
 
 		if req.Identifier == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error":   "invalid_request",
-				"message": "Identifier is required",
-			})
+			apierror.Write(w, r, apierror.ErrInvalidRequest.WithMessage("Identifier is required"))
 			return
 		}
 
 		challenge, err := verifier.GenerateChallenge(req.Identifier)
 		if err != nil {
-			logger.AuthError("failed to generate challenge", zap.Error(err))
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(map[string]string{
-				"error":   "challenge_error",
-				"message": "Failed to generate challenge",
-			})
+			logger.For(r.Context()).AuthError("failed to generate challenge", zap.Error(err))
+			apierror.Write(w, r, apierror.ErrChallengeFailed.WithCause(err))
 			return
 		}
 
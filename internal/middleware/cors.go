@@ -0,0 +1,287 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"go.uber.org/zap"
+)
+
+// DefaultCORSHeaders are the request headers browser clients need to talk to
+// the proxy: the Supabase client headers plus the attestation and session
+// headers the proxy itself understands.
+var DefaultCORSHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	APIKeyHeader,
+	"X-Client-Info",
+	"X-Supabase-Api-Version",
+	PlatformHeader,
+	AttestationHeader,
+	"X-Attestation-*",
+	"X-Session-Mode",
+	"X-CSRF-Token",
+	"DPoP",
+}
+
+// DefaultCORSMethods are the methods allowed when none are configured.
+var DefaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// CORSRoute overrides the allowed methods and headers for a path prefix.
+type CORSRoute struct {
+	PathPrefix string
+	Methods    []string
+	Headers    []string
+}
+
+// CORSConfig holds configuration for the CORS middleware.
+type CORSConfig struct {
+	Enabled bool
+	// AllowedOrigins accepts exact origins ("https://app.example.com"),
+	// wildcard subdomains ("https://*.example.com"), regular expressions
+	// prefixed with "re:" ("re:^https://pr-[0-9]+\.example\.com$") or "*".
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders entries ending in "*" match any header with that prefix.
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+	Routes []CORSRoute
+}
+
+// CORSMiddleware applies a CORS policy and answers preflight requests locally,
+// so they never reach API key or attestation checks.
+type CORSMiddleware struct {
+	enabled          bool
+	anyOrigin        bool
+	exactOrigins     map[string]bool
+	wildcardOrigins  []string
+	regexOrigins     []*regexp.Regexp
+	methods          []string
+	headers          []string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+	routes           []CORSRoute
+	logger           *logging.Logger
+}
+
+// NewCORSMiddleware creates a new CORS middleware.
+func NewCORSMiddleware(cfg CORSConfig, logger *logging.Logger) (*CORSMiddleware, error) {
+	m := &CORSMiddleware{
+		enabled:          cfg.Enabled,
+		exactOrigins:     make(map[string]bool),
+		methods:          cfg.AllowedMethods,
+		headers:          cfg.AllowedHeaders,
+		exposedHeaders:   strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+		routes:           cfg.Routes,
+		logger:           logger,
+	}
+
+	if len(m.methods) == 0 {
+		m.methods = DefaultCORSMethods
+	}
+	if len(m.headers) == 0 {
+		m.headers = DefaultCORSHeaders
+	}
+	if cfg.MaxAge > 0 {
+		m.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		switch {
+		case origin == "*":
+			if cfg.AllowCredentials {
+				return nil, fmt.Errorf("CORS credentials cannot be allowed for any origin")
+			}
+			m.anyOrigin = true
+		case strings.HasPrefix(origin, "re:"):
+			// Anchored, so a pattern can't be satisfied by an origin that
+			// merely contains an allowed one
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(origin, "re:") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid CORS origin pattern %q: %w", origin, err)
+			}
+			m.regexOrigins = append(m.regexOrigins, re)
+		case strings.Contains(origin, "://*."):
+			m.wildcardOrigins = append(m.wildcardOrigins, strings.ToLower(origin))
+		default:
+			m.exactOrigins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+		}
+	}
+
+	return m, nil
+}
+
+// Middleware returns the HTTP middleware handler.
+func (m *CORSMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !m.enabled || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !m.originAllowed(origin) {
+			if isPreflight {
+				m.logger.For(r.Context()).AuthWarning("CORS preflight from disallowed origin",
+					zap.String("origin", origin),
+					zap.String("path", r.URL.Path),
+				)
+				apierror.Write(w, r, apierror.ErrOriginNotAllowed)
+				return
+			}
+			// Let the request through without CORS headers; the browser
+			// won't expose the response to the page.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		methods, headers := m.policyFor(r.URL.Path)
+
+		if isPreflight {
+			m.handlePreflight(w, r, origin, methods, headers)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if m.allowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if m.exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", m.exposedHeaders)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *CORSMiddleware) handlePreflight(w http.ResponseWriter, r *http.Request, origin string, methods, headers []string) {
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !containsFold(methods, method) {
+		apierror.Write(w, r, apierror.ErrPreflightNotAllowed)
+		return
+	}
+
+	var requested []string
+	for _, h := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if !headerAllowed(headers, h) {
+			apierror.Write(w, r, apierror.ErrHeaderNotAllowed.WithMessage("Header "+h+" is not allowed"))
+			return
+		}
+		requested = append(requested, h)
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(requested) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if m.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	if m.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", m.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// policyFor returns the methods and headers for the most specific route.
+func (m *CORSMiddleware) policyFor(path string) ([]string, []string) {
+	methods, headers := m.methods, m.headers
+	longest := -1
+
+	for _, route := range m.routes {
+		if !matchRoute(route.PathPrefix, path) || len(route.PathPrefix) <= longest {
+			continue
+		}
+		longest = len(route.PathPrefix)
+		methods, headers = m.methods, m.headers
+		if len(route.Methods) > 0 {
+			methods = route.Methods
+		}
+		if len(route.Headers) > 0 {
+			headers = route.Headers
+		}
+	}
+
+	return methods, headers
+}
+
+func (m *CORSMiddleware) originAllowed(origin string) bool {
+	if m.anyOrigin {
+		return true
+	}
+
+	lower := strings.ToLower(origin)
+	if m.exactOrigins[lower] {
+		return true
+	}
+
+	for _, pattern := range m.wildcardOrigins {
+		// "https://*.example.com" -> scheme "https://", suffix ".example.com"
+		scheme, suffix, _ := strings.Cut(pattern, "*")
+		host, ok := strings.CutPrefix(lower, scheme)
+		if ok && strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return true
+		}
+	}
+
+	for _, re := range m.regexOrigins {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerAllowed matches a header name against allowed names, where entries
+// ending in "*" match by prefix.
+func headerAllowed(allowed []string, header string) bool {
+	for _, a := range allowed {
+		if prefix, ok := strings.CutSuffix(a, "*"); ok {
+			if len(header) >= len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(a, header) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+func newTestCORS(t *testing.T, cfg CORSConfig) http.Handler {
+	t.Helper()
+	logger, _ := logging.New("error", false)
+	cfg.Enabled = true
+	m, err := NewCORSMiddleware(cfg, logger)
+	if err != nil {
+		t.Fatalf("NewCORSMiddleware() error = %v", err)
+	}
+	return m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+}
+
+func TestCORSOriginMatching(t *testing.T) {
+	handler := newTestCORS(t, CORSConfig{
+		AllowedOrigins: []string{
+			"https://app.example.com",
+			"https://*.preview.example.com",
+			`re:^https://pr-[0-9]+\.staging\.example\.com$`,
+			`re:https://admin\.example\.com`,
+		},
+	})
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"http://app.example.com", false},
+		{"https://a.preview.example.com", true},
+		{"https://preview.example.com", false},
+		{"https://pr-42.staging.example.com", true},
+		{"https://pr-x.staging.example.com", false},
+		{"https://admin.example.com", true},
+		{"https://admin.example.com.evil.io", false},
+		{"https://evil.io/?https://admin.example.com", false},
+		{"https://evil.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/v1/token", nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			got := rec.Header().Get("Access-Control-Allow-Origin") == tt.origin
+			if got != tt.want {
+				t.Errorf("origin %q allowed = %v, want %v", tt.origin, got, tt.want)
+			}
+			if rec.Code != http.StatusTeapot {
+				t.Errorf("simple request not passed through, status = %d", rec.Code)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := newTestCORS(t, CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+		Routes: []CORSRoute{
+			{PathPrefix: "/auth/v1/user", Methods: []string{"GET", "PUT"}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		method  string
+		headers string
+		want    int
+	}{
+		{"allowed with attestation headers", "/token", "POST", "apikey, X-Attestation-Key-ID, content-type", http.StatusNoContent},
+		{"route override allows PUT", "/user", "PUT", "authorization", http.StatusNoContent},
+		{"route override rejects POST", "/auth/v1/user", "POST", "", http.StatusForbidden},
+		{"unknown header rejected", "/token", "POST", "X-Evil", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusNoContent {
+				return
+			}
+			if rec.Header().Get("Access-Control-Max-Age") != "600" {
+				t.Errorf("Access-Control-Max-Age = %q, want 600", rec.Header().Get("Access-Control-Max-Age"))
+			}
+			if rec.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Error("credentials not allowed")
+			}
+		})
+	}
+}
+
+func TestCORSPreflightDisallowedOrigin(t *testing.T) {
+	handler := newTestCORS(t, CORSConfig{AllowedOrigins: []string{"https://app.example.com"}})
+
+	req := httptest.NewRequest(http.MethodOptions, "/token", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("disallowed origin received Access-Control-Allow-Origin")
+	}
+}
+
+func TestCORSRejectsAnyOriginWithCredentials(t *testing.T) {
+	logger, _ := logging.New("error", false)
+	_, err := NewCORSMiddleware(CORSConfig{
+		Enabled:          true,
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	}, logger)
+	if err == nil {
+		t.Error("NewCORSMiddleware() with any origin and credentials = nil error, want one")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/kacy/auth-proxy/internal/clientip"
 	"github.com/kacy/auth-proxy/internal/logging"
 	"go.uber.org/zap"
 )
@@ -56,6 +57,7 @@ func (m *LoggingMiddleware) Middleware(next http.Handler) http.Handler {
 			zap.String("method", r.Method),
 			zap.String("path", r.URL.Path),
 			zap.String("remote_addr", r.RemoteAddr),
+			zap.String("client_ip", clientip.FromRequest(r)),
 			zap.String("user_agent", r.UserAgent()),
 		}
 
@@ -69,7 +71,7 @@ func (m *LoggingMiddleware) Middleware(next http.Handler) http.Handler {
 			fields = append(fields, zap.String("request_body", sanitized))
 		}
 
-		m.logger.Request("incoming request", fields...)
+		m.logger.For(r.Context()).Request("incoming request", fields...)
 
 		// Wrap response writer to capture status and body
 		recorder := &responseRecorder{
@@ -101,9 +103,9 @@ func (m *LoggingMiddleware) Middleware(next http.Handler) http.Handler {
 		}
 
 		if recorder.statusCode >= 400 {
-			m.logger.Logger.Warn(logging.EmojiWarning+" request completed with error", responseFields...)
+			m.logger.For(r.Context()).Logger.Warn(logging.EmojiWarning+" request completed with error", responseFields...)
 		} else {
-			m.logger.Response("request completed", responseFields...)
+			m.logger.For(r.Context()).Response("request completed", responseFields...)
 		}
 	})
 }
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/kacy/auth-proxy/internal/metrics"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -76,8 +77,8 @@ func (m *HTTPMetrics) Middleware(next http.Handler) http.Handler {
 		status := strconv.Itoa(recorder.statusCode)
 
 		m.requestsTotal.WithLabelValues(r.Method, path, status).Inc()
-		m.requestDuration.WithLabelValues(r.Method, path).Observe(duration)
-		m.responseSize.WithLabelValues(r.Method, path).Observe(float64(recorder.written))
+		metrics.Observe(r.Context(), m.requestDuration.WithLabelValues(r.Method, path), duration)
+		metrics.Observe(r.Context(), m.responseSize.WithLabelValues(r.Method, path), float64(recorder.written))
 	})
 }
 
@@ -105,6 +106,8 @@ func normalizePath(path string) string {
 	switch {
 	case path == "/health" || path == "/healthz":
 		return "/health"
+	case path == "/livez" || path == "/readyz" || path == "/version":
+		return path
 	case path == "/auth/v1/signup" || path == "/signup":
 		return "/auth/v1/signup"
 	case path == "/auth/v1/token" || path == "/token":
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"go.uber.org/zap"
+)
+
+// DefaultMFARoutes protect account changes: email/password updates and
+// removing MFA factors.
+var DefaultMFARoutes = []MFARoute{
+	{Method: http.MethodPut, PathPrefix: "/auth/v1/user"},
+	{Method: http.MethodDelete, PathPrefix: "/auth/v1/factors"},
+}
+
+// MFARoute requires an assurance level for a method and path prefix. An
+// empty Method matches any method.
+type MFARoute struct {
+	Method     string
+	PathPrefix string
+}
+
+// ParseMFARoute parses "PUT /auth/v1/user" or "/auth/v1/factors".
+func ParseMFARoute(s string) (MFARoute, error) {
+	method, path, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		method, path = "", method
+	}
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "/") {
+		return MFARoute{}, fmt.Errorf("invalid MFA route %q", s)
+	}
+	return MFARoute{Method: strings.ToUpper(method), PathPrefix: path}, nil
+}
+
+// MFAConfig holds configuration for the MFA middleware.
+type MFAConfig struct {
+	Enabled bool
+	Routes  []MFARoute
+	// RequiredAAL is the minimum assurance level, "aal2" by default.
+	RequiredAAL string
+	// AllowUnenrolled lets users without a verified factor through at aal1,
+	// since they have no way to step up. Checking enrollment costs a call to
+	// GoTrue's /user endpoint.
+	AllowUnenrolled bool
+	// GoTrueURL and AnonKey are used to look up enrolled factors.
+	GoTrueURL string
+	AnonKey   string
+	Client    *http.Client
+}
+
+// MFAMiddleware requires a minimum authenticator assurance level (the "aal"
+// claim) on sensitive routes.
+type MFAMiddleware struct {
+	enabled         bool
+	routes          []MFARoute
+	required        string
+	requiredLevel   int
+	allowUnenrolled bool
+	userURL         string
+	anonKey         string
+	client          *http.Client
+	verifier        *jwt.Verifier
+	logger          *logging.Logger
+}
+
+// NewMFAMiddleware creates a new MFA middleware.
+func NewMFAMiddleware(cfg MFAConfig, verifier *jwt.Verifier, logger *logging.Logger) (*MFAMiddleware, error) {
+	m := &MFAMiddleware{
+		enabled:         cfg.Enabled,
+		routes:          cfg.Routes,
+		required:        cfg.RequiredAAL,
+		allowUnenrolled: cfg.AllowUnenrolled,
+		userURL:         strings.TrimSuffix(cfg.GoTrueURL, "/") + "/auth/v1/user",
+		anonKey:         cfg.AnonKey,
+		client:          cfg.Client,
+		verifier:        verifier,
+		logger:          logger,
+	}
+
+	if len(m.routes) == 0 {
+		m.routes = DefaultMFARoutes
+	}
+	if m.required == "" {
+		m.required = "aal2"
+	}
+	if m.requiredLevel = aalLevel(m.required); m.requiredLevel == 0 {
+		return nil, fmt.Errorf("invalid assurance level %q", m.required)
+	}
+	if m.client == nil {
+		m.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if m.enabled && verifier == nil {
+		return nil, fmt.Errorf("MFA enforcement requires a token verifier")
+	}
+
+	return m, nil
+}
+
+// Middleware returns the HTTP middleware handler.
+func (m *MFAMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.enabled || !m.protects(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := jwt.BearerToken(r.Header.Get("Authorization"))
+		if !ok {
+			apierror.Write(w, r, apierror.ErrNoAuthorization)
+			return
+		}
+
+		claims, err := m.verifier.Verify(r.Context(), token)
+		if err != nil {
+			m.logger.For(r.Context()).AuthWarning("rejected invalid access token",
+				zap.Error(err),
+				zap.String("path", r.URL.Path),
+				zap.String("client_ip", clientip.FromRequest(r)),
+			)
+			apierror.Write(w, r, apierror.ErrBadJWT.WithCause(err))
+			return
+		}
+
+		if aalLevel(claims.AAL) >= m.requiredLevel {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.allowUnenrolled {
+			enrolled, err := m.hasVerifiedFactor(r.Context(), token)
+			if err != nil {
+				m.logger.For(r.Context()).NetworkError("failed to look up MFA factors", zap.Error(err))
+				apierror.Write(w, r, apierror.ErrMFACheckUnavailable.WithCause(err))
+				return
+			}
+			if !enrolled {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		m.logger.For(r.Context()).AuthWarning("step-up authentication required",
+			zap.String("user_id", logging.MaskUserID(claims.Subject)),
+			zap.String("aal", claims.AAL),
+			zap.String("required_aal", m.required),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+		)
+		audit.Report(r, audit.Entry{
+			Action:   "mfa_step_up",
+			Decision: audit.DecisionDeny,
+			Reason:   "mfa_required",
+			UserID:   claims.Subject,
+		})
+		apierror.Write(w, r, apierror.ErrMFARequired.
+			WithField("current_aal", claims.AAL).
+			WithField("required_aal", m.required))
+	})
+}
+
+func (m *MFAMiddleware) protects(r *http.Request) bool {
+	for _, route := range m.routes {
+		if (route.Method == "" || route.Method == r.Method) && matchRoute(route.PathPrefix, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVerifiedFactor asks GoTrue whether the user has a verified MFA factor.
+func (m *MFAMiddleware) hasVerifiedFactor(ctx context.Context, token string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.userURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("apikey", m.anonKey)
+	req.Header.Set("User-Agent", buildinfo.UserAgent())
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, errors.New("unexpected status " + strconv.Itoa(resp.StatusCode))
+	}
+
+	var user struct {
+		Factors []struct {
+			Status string `json:"status"`
+		} `json:"factors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return false, err
+	}
+	for _, f := range user.Factors {
+		if f.Status == "verified" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// aalLevel converts "aal1"/"aal2"/"aal3" to 1/2/3, or 0 if invalid.
+func aalLevel(aal string) int {
+	level, err := strconv.Atoi(strings.TrimPrefix(aal, "aal"))
+	if err != nil || !strings.HasPrefix(aal, "aal") || level < 1 {
+		return 0
+	}
+	return level
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+const (
+	testJWTSecret = "test-secret"
+	testJWTIssuer = "http://gotrue:9999/auth/v1"
+)
+
+func signTestToken(claims map[string]any) string {
+	encode := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = testJWTIssuer
+	}
+	input := encode(map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + encode(claims)
+	mac := hmac.New(sha256.New, []byte(testJWTSecret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newTestVerifier(t *testing.T) *jwt.Verifier {
+	t.Helper()
+	v, err := jwt.NewVerifier(jwt.Config{Secret: testJWTSecret, Issuer: testJWTIssuer})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	return v
+}
+
+func TestMFAMiddleware(t *testing.T) {
+	logger, _ := logging.New("error", false)
+	m, err := NewMFAMiddleware(MFAConfig{Enabled: true}, newTestVerifier(t), logger)
+	if err != nil {
+		t.Fatalf("NewMFAMiddleware() error = %v", err)
+	}
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	aal1 := signTestToken(map[string]any{"sub": "u1", "aal": "aal1"})
+	aal2 := signTestToken(map[string]any{"sub": "u1", "aal": "aal2"})
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		token     string
+		status    int
+		errorCode string
+	}{
+		{"aal2 can change user", http.MethodPut, "/auth/v1/user", aal2, http.StatusOK, ""},
+		{"aal1 needs step-up", http.MethodPut, "/user", aal1, http.StatusForbidden, "mfa_required"},
+		{"aal1 cannot delete factor", http.MethodDelete, "/auth/v1/factors/abc", aal1, http.StatusForbidden, "mfa_required"},
+		{"aal1 can read user", http.MethodGet, "/auth/v1/user", aal1, http.StatusOK, ""},
+		{"missing token", http.MethodPut, "/auth/v1/user", "", http.StatusUnauthorized, "no_authorization"},
+		{"forged token", http.MethodPut, "/auth/v1/user", aal2 + "x", http.StatusUnauthorized, "bad_jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.errorCode != "" {
+				var body map[string]any
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body["error_code"] != tt.errorCode {
+					t.Errorf("error_code = %v, want %s", body["error_code"], tt.errorCode)
+				}
+			}
+		})
+	}
+}
+
+func TestMFAMiddlewareAllowsUnenrolled(t *testing.T) {
+	factors := `[]`
+	gotrue := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"u1","factors":` + factors + `}`))
+	}))
+	defer gotrue.Close()
+
+	logger, _ := logging.New("error", false)
+	m, _ := NewMFAMiddleware(MFAConfig{
+		Enabled:         true,
+		Routes:          []MFARoute{{PathPrefix: "/auth/v1/user"}},
+		AllowUnenrolled: true,
+		GoTrueURL:       gotrue.URL,
+	}, newTestVerifier(t), logger)
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPut, "/auth/v1/user", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestToken(map[string]any{"aal": "aal1"}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Errorf("unenrolled user status = %d, want %d", code, http.StatusOK)
+	}
+
+	factors = `[{"id":"f1","status":"verified"}]`
+	if code := send(); code != http.StatusForbidden {
+		t.Errorf("enrolled user at aal1 status = %d, want %d", code, http.StatusForbidden)
+	}
+}
+
+func TestParseMFARoute(t *testing.T) {
+	tests := []struct {
+		input string
+		want  MFARoute
+		ok    bool
+	}{
+		{"PUT /auth/v1/user", MFARoute{Method: "PUT", PathPrefix: "/auth/v1/user"}, true},
+		{"delete /factors", MFARoute{Method: "DELETE", PathPrefix: "/factors"}, true},
+		{"/auth/v1/admin", MFARoute{PathPrefix: "/auth/v1/admin"}, true},
+		{"PUT user", MFARoute{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseMFARoute(tt.input)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseMFARoute(%q) = %+v, %v", tt.input, got, err)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/geoip"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// NetworkRule is a set of IP and country rules. Deny lists win over allow
+// lists, and an allowed CIDR skips the country checks so internal ranges
+// without a country still get through. When any allow list is set, requests
+// must match one of them.
+type NetworkRule struct {
+	AllowCIDRs     []string
+	DenyCIDRs      []string
+	AllowCountries []string
+	DenyCountries  []string
+}
+
+// NetworkRoute applies a rule to a path prefix, on top of the global rule.
+// Requests must pass both, so a route can add restrictions but can't let in
+// clients the global rule blocks.
+type NetworkRoute struct {
+	PathPrefix string
+	NetworkRule
+}
+
+// NetworkAccessConfig holds configuration for the network access middleware.
+type NetworkAccessConfig struct {
+	Enabled bool
+	NetworkRule
+	Routes []NetworkRoute
+}
+
+// NetworkAccessMiddleware enforces IP and GeoIP country rules and stores the
+// GeoIP lookup in the request context for later handlers.
+type NetworkAccessMiddleware struct {
+	enabled bool
+	global  compiledRule
+	routes  []compiledRoute
+	geo     *geoip.DB
+	// countryLabels bounds the metric's country label to countries named in
+	// the rules.
+	countryLabels map[string]bool
+	metrics       *metrics.Metrics
+	logger        *logging.Logger
+}
+
+type compiledRule struct {
+	allowCIDRs     []netip.Prefix
+	denyCIDRs      []netip.Prefix
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+}
+
+type compiledRoute struct {
+	pathPrefix string
+	rule       compiledRule
+}
+
+// NewNetworkAccessMiddleware creates a new network access middleware. geo may
+// be nil when no country rules are configured.
+func NewNetworkAccessMiddleware(cfg NetworkAccessConfig, geo *geoip.DB, m *metrics.Metrics, logger *logging.Logger) (*NetworkAccessMiddleware, error) {
+	mw := &NetworkAccessMiddleware{
+		enabled:       cfg.Enabled,
+		geo:           geo,
+		countryLabels: make(map[string]bool),
+		metrics:       m,
+		logger:        logger,
+	}
+
+	var err error
+	if mw.global, err = mw.compile(cfg.NetworkRule); err != nil {
+		return nil, err
+	}
+	for _, route := range cfg.Routes {
+		rule, err := mw.compile(route.NetworkRule)
+		if err != nil {
+			return nil, fmt.Errorf("route %s: %w", route.PathPrefix, err)
+		}
+		mw.routes = append(mw.routes, compiledRoute{pathPrefix: route.PathPrefix, rule: rule})
+	}
+
+	if geo == nil && len(mw.countryLabels) > 0 {
+		return nil, fmt.Errorf("country rules require a GeoIP database")
+	}
+	return mw, nil
+}
+
+// Middleware returns the HTTP middleware handler.
+func (m *NetworkAccessMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr := requestAddr(r)
+
+		var info geoip.Info
+		if m.geo != nil {
+			info, _ = m.geo.Lookup(addr)
+			r = r.WithContext(geoip.NewContext(r.Context(), info))
+		}
+
+		if !m.enabled || isHealthPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reason, allowed := m.global.evaluate(addr, info.Country)
+		if allowed {
+			if route := m.routeFor(r.URL.Path); route != nil {
+				reason, allowed = route.evaluate(addr, info.Country)
+			}
+		}
+
+		if !allowed {
+			m.deny(w, r, reason, info.Country)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *NetworkAccessMiddleware) deny(w http.ResponseWriter, r *http.Request, reason, country string) {
+	m.logger.For(r.Context()).AuthWarning("request blocked by network access rules",
+		zap.String("reason", reason),
+		zap.String("country", country),
+		zap.String("path", r.URL.Path),
+		zap.String("client_ip", clientip.FromRequest(r)),
+	)
+
+	if m.metrics != nil {
+		m.metrics.NetworkAccessDeniedTotal.WithLabelValues(reason, m.countryLabel(country)).Inc()
+	}
+
+	audit.Report(r, audit.Entry{Action: "network_access", Decision: audit.DecisionDeny, Reason: reason})
+	apierror.Write(w, r, apierror.ErrRequestNotAllowed)
+}
+
+// routeFor returns the rule for the most specific matching route.
+func (m *NetworkAccessMiddleware) routeFor(path string) *compiledRule {
+	var match *compiledRule
+	longest := -1
+
+	for i, route := range m.routes {
+		if matchRoute(route.pathPrefix, path) && len(route.pathPrefix) > longest {
+			longest = len(route.pathPrefix)
+			match = &m.routes[i].rule
+		}
+	}
+	return match
+}
+
+func (m *NetworkAccessMiddleware) countryLabel(country string) string {
+	switch {
+	case country == "":
+		return "unknown"
+	case m.countryLabels[country]:
+		return country
+	default:
+		return "other"
+	}
+}
+
+func (m *NetworkAccessMiddleware) compile(rule NetworkRule) (compiledRule, error) {
+	compiled := compiledRule{
+		allowCountries: make(map[string]bool),
+		denyCountries:  make(map[string]bool),
+	}
+
+	for _, cidr := range rule.AllowCIDRs {
+		prefix, err := clientip.ParsePrefix(cidr)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		compiled.allowCIDRs = append(compiled.allowCIDRs, prefix)
+	}
+	for _, cidr := range rule.DenyCIDRs {
+		prefix, err := clientip.ParsePrefix(cidr)
+		if err != nil {
+			return compiledRule{}, err
+		}
+		compiled.denyCIDRs = append(compiled.denyCIDRs, prefix)
+	}
+	for _, country := range rule.AllowCountries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		compiled.allowCountries[country] = true
+		m.countryLabels[country] = true
+	}
+	for _, country := range rule.DenyCountries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		compiled.denyCountries[country] = true
+		m.countryLabels[country] = true
+	}
+
+	return compiled, nil
+}
+
+// evaluate returns whether the address is allowed, and the reason when not.
+func (r *compiledRule) evaluate(addr netip.Addr, country string) (string, bool) {
+	if containsAddr(r.denyCIDRs, addr) {
+		return "ip_denied", false
+	}
+	if containsAddr(r.allowCIDRs, addr) {
+		return "", true
+	}
+	if country != "" && r.denyCountries[country] {
+		return "country_denied", false
+	}
+	if country != "" && r.allowCountries[country] {
+		return "", true
+	}
+	if len(r.allowCIDRs) > 0 || len(r.allowCountries) > 0 {
+		return "not_allowlisted", false
+	}
+	return "", true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestAddr returns the resolved client IP, falling back to the peer.
+func requestAddr(r *http.Request) netip.Addr {
+	if info, ok := clientip.FromContext(r.Context()); ok {
+		return info.IP
+	}
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().Unmap()
+}
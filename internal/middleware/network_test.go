@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/geoip"
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+// newTestGeoDB opens testdata/country.mmdb, which maps 198.51.100.0/24 to
+// US, 203.0.113.0/24 to RU and 192.0.2.0/24 to NG.
+func newTestGeoDB(t *testing.T) *geoip.DB {
+	t.Helper()
+	db, err := geoip.Open(filepath.Join("testdata", "country.mmdb"), time.Hour, nil)
+	if err != nil {
+		t.Fatalf("geoip.Open() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestNetworkAccessRules(t *testing.T) {
+	geo := newTestGeoDB(t)
+	logger, _ := logging.New("error", false)
+
+	m, err := NewNetworkAccessMiddleware(NetworkAccessConfig{
+		Enabled: true,
+		NetworkRule: NetworkRule{
+			DenyCIDRs:     []string{"198.51.100.66"},
+			DenyCountries: []string{"ru"},
+		},
+		Routes: []NetworkRoute{
+			{PathPrefix: "/auth/v1/otp", NetworkRule: NetworkRule{
+				AllowCIDRs:    []string{"192.0.2.10"},
+				DenyCountries: []string{"NG"},
+			}},
+		},
+	}, geo, nil, logger)
+	if err != nil {
+		t.Fatalf("NewNetworkAccessMiddleware() error = %v", err)
+	}
+
+	var country string
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, _ := geoip.FromContext(r.Context())
+		country = info.Country
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		ip     string
+		path   string
+		status int
+	}{
+		{"allowed country", "198.51.100.7", "/auth/v1/token", http.StatusOK},
+		{"denied IP", "198.51.100.66", "/auth/v1/token", http.StatusForbidden},
+		{"denied country", "203.0.113.7", "/auth/v1/token", http.StatusForbidden},
+		{"route allows login", "192.0.2.7", "/auth/v1/token", http.StatusOK},
+		{"route blocks otp", "192.0.2.7", "/auth/v1/otp", http.StatusForbidden},
+		{"route blocks otp without prefix", "192.0.2.7", "/otp", http.StatusForbidden},
+		{"route allowlisted IP", "192.0.2.10", "/auth/v1/otp", http.StatusOK},
+		{"unknown country", "10.1.2.3", "/auth/v1/token", http.StatusOK},
+		{"route allow list", "10.1.2.3", "/auth/v1/otp", http.StatusForbidden},
+		{"health skipped", "203.0.113.7", "/health", http.StatusOK},
+		{"readiness probe skipped", "203.0.113.7", "/readyz", http.StatusOK},
+		{"version endpoint skipped", "203.0.113.7", "/version", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			req.RemoteAddr = tt.ip + ":1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusForbidden {
+				var body map[string]any
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body["error_code"] != "request_not_allowed" {
+					t.Errorf("error_code = %v, want request_not_allowed", body["error_code"])
+				}
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/v1/user", nil)
+	req.RemoteAddr = "198.51.100.7:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if country != "US" {
+		t.Errorf("context country = %q, want US", country)
+	}
+}
+
+func TestNetworkAccessAllowList(t *testing.T) {
+	geo := newTestGeoDB(t)
+	logger, _ := logging.New("error", false)
+
+	m, err := NewNetworkAccessMiddleware(NetworkAccessConfig{
+		Enabled: true,
+		NetworkRule: NetworkRule{
+			AllowCIDRs:     []string{"10.0.0.0/8"},
+			AllowCountries: []string{"US"},
+		},
+	}, geo, nil, logger)
+	if err != nil {
+		t.Fatalf("NewNetworkAccessMiddleware() error = %v", err)
+	}
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		ip     string
+		status int
+	}{
+		{"198.51.100.7", http.StatusOK},
+		{"10.1.2.3", http.StatusOK},
+		{"203.0.113.7", http.StatusForbidden},
+		{"192.0.2.1", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/v1/user", nil)
+			req.RemoteAddr = tt.ip + ":1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestNetworkAccessCountryRulesNeedDatabase(t *testing.T) {
+	logger, _ := logging.New("error", false)
+	_, err := NewNetworkAccessMiddleware(NetworkAccessConfig{
+		Enabled:     true,
+		NetworkRule: NetworkRule{DenyCountries: []string{"RU"}},
+	}, nil, nil, logger)
+	if err == nil {
+		t.Error("country rules without a GeoIP database should be rejected")
+	}
+}
+
+func TestNetworkAccessCountryLabel(t *testing.T) {
+	logger, _ := logging.New("error", false)
+	m, _ := NewNetworkAccessMiddleware(NetworkAccessConfig{
+		NetworkRule: NetworkRule{DenyCIDRs: []string{"192.0.2.0/24"}},
+		Routes: []NetworkRoute{
+			{PathPrefix: "/otp", NetworkRule: NetworkRule{DenyCountries: []string{"ng"}}},
+		},
+	}, &geoip.DB{}, nil, logger)
+
+	for country, want := range map[string]string{"NG": "NG", "BR": "other", "": "unknown"} {
+		if got := m.countryLabel(country); got != want {
+			t.Errorf("countryLabel(%q) = %q, want %q", country, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/passwords"
+	"go.uber.org/zap"
+)
+
+// BreachedPasswordConfig holds configuration for the breached-password check.
+type BreachedPasswordConfig struct {
+	// FailOpen lets requests through when the range API is unavailable.
+	// When false they are rejected with 503.
+	FailOpen bool
+}
+
+// BreachedPasswordMiddleware rejects known-compromised passwords on signup
+// and password change before they reach GoTrue.
+type BreachedPasswordMiddleware struct {
+	checker  *passwords.Checker
+	failOpen bool
+	logger   *logging.Logger
+}
+
+// NewBreachedPasswordMiddleware creates a new breached-password middleware.
+// A nil checker disables the check.
+func NewBreachedPasswordMiddleware(checker *passwords.Checker, cfg BreachedPasswordConfig, logger *logging.Logger) *BreachedPasswordMiddleware {
+	return &BreachedPasswordMiddleware{
+		checker:  checker,
+		failOpen: cfg.FailOpen,
+		logger:   logger,
+	}
+}
+
+// Middleware returns the HTTP middleware handler.
+func (m *BreachedPasswordMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.checker == nil || !isPasswordRoute(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := readBody(w, r, maxPolicyBodySize)
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+
+		var req struct {
+			Password string `json:"password"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Password == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		breached, err := m.checker.IsBreached(r.Context(), req.Password)
+		if err != nil {
+			m.logger.For(r.Context()).NetworkError("breached password check failed",
+				zap.Error(err),
+				zap.String("path", r.URL.Path),
+				zap.Bool("fail_open", m.failOpen),
+			)
+			if m.failOpen {
+				next.ServeHTTP(w, r)
+				return
+			}
+			apierror.Write(w, r, apierror.ErrPasswordCheckUnavailable.WithCause(err))
+			return
+		}
+
+		if breached {
+			m.logger.For(r.Context()).AuthWarning("rejected breached password",
+				zap.String("path", r.URL.Path),
+				zap.String("method", r.Method),
+				zap.String("client_ip", clientip.FromRequest(r)),
+			)
+			audit.Report(r, audit.Entry{Action: "breached_password", Decision: audit.DecisionDeny, Reason: "weak_password"})
+			apierror.Write(w, r, apierror.ErrWeakPassword.WithField("weak_password", map[string][]string{
+				"reasons": {"pwned"},
+			}))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isPasswordRoute matches signup and password change requests.
+func isPasswordRoute(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost:
+		return matchRoute("/auth/v1/signup", r.URL.Path)
+	case http.MethodPut:
+		return matchRoute("/auth/v1/user", r.URL.Path)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/passwords"
+)
+
+func TestBreachedPasswordMiddleware(t *testing.T) {
+	sum := sha1.Sum([]byte("password1"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	rangeAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/"+hash[:5]) {
+			fmt.Fprintf(w, "%s:42\r\n", hash[5:])
+		}
+	}))
+	defer rangeAPI.Close()
+
+	logger, _ := logging.New("error", false)
+	checker := passwords.NewChecker(passwords.Config{Endpoint: rangeAPI.URL})
+	handler := NewBreachedPasswordMiddleware(checker, BreachedPasswordConfig{}, logger).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	padded := `{"email":"a@example.com","password":"password1","data":{"pad":"` + strings.Repeat("a", maxPolicyBodySize) + `"}}`
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		status    int
+		errorCode string
+	}{
+		{"strong password", http.MethodPost, "/auth/v1/signup", `{"email":"a@example.com","password":"c0rrect-h0rse"}`, http.StatusOK, ""},
+		{"breached on signup", http.MethodPost, "/auth/v1/signup", `{"email":"a@example.com","password":"password1"}`, http.StatusUnprocessableEntity, "weak_password"},
+		{"breached on password change", http.MethodPut, "/user", `{"password":"password1"}`, http.StatusUnprocessableEntity, "weak_password"},
+		{"padded past the limit", http.MethodPost, "/auth/v1/signup", padded, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"other route untouched", http.MethodPost, "/auth/v1/token", `{"password":"password1"}`, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.errorCode != "" {
+				var body map[string]any
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body["error_code"] != tt.errorCode {
+					t.Errorf("error_code = %v, want %s", body["error_code"], tt.errorCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/phone"
+	"go.uber.org/zap"
+)
+
+// PhonePolicyMiddleware protects the endpoints that send SMS from SMS
+// pumping before they reach GoTrue.
+type PhonePolicyMiddleware struct {
+	policy *phone.Policy
+	logger *logging.Logger
+}
+
+// NewPhonePolicyMiddleware creates a new phone policy middleware.
+// A nil policy disables the checks.
+func NewPhonePolicyMiddleware(policy *phone.Policy, logger *logging.Logger) *PhonePolicyMiddleware {
+	return &PhonePolicyMiddleware{
+		policy: policy,
+		logger: logger,
+	}
+}
+
+// Middleware returns the HTTP middleware handler.
+func (m *PhonePolicyMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.policy == nil || !isSMSRoute(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := readBody(w, r, maxPolicyBodySize)
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+
+		var req struct {
+			Phone string `json:"phone"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Phone == "" {
+			// Email OTPs and malformed bodies are left to GoTrue
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		number, err := m.policy.Check(req.Phone)
+		if err != nil {
+			m.reject(w, r, req.Phone, err)
+			return
+		}
+
+		ip := clientip.FromRequest(r)
+		if err := m.policy.Allow(r.Context(), number, ip); err != nil {
+			var limitErr *phone.LimitError
+			if !errors.As(err, &limitErr) {
+				m.logger.For(r.Context()).DatabaseError("sms send counters unavailable, allowing request", zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			m.reject(w, r, number, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *PhonePolicyMiddleware) reject(w http.ResponseWriter, r *http.Request, number string, err error) {
+	var apiErr *apierror.Error
+
+	var limitErr *phone.LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		apiErr = apierror.ErrOverSMSSendRateLimit.WithRetryAfter(limitErr.RetryAfter)
+	case errors.Is(err, phone.ErrInvalidNumber):
+		apiErr = apierror.ErrValidationFailed.WithMessage("Invalid phone number format (E.164 required)")
+	case errors.Is(err, phone.ErrCountryNotAllowed):
+		apiErr = apierror.ErrSMSSendFailed.WithMessage("SMS can't be sent to this country")
+	case errors.Is(err, phone.ErrPremiumNumber):
+		apiErr = apierror.ErrPremiumNumber
+	default:
+		apiErr = apierror.ErrSMSSendFailed
+	}
+
+	m.logger.For(r.Context()).AuthWarning("sms send blocked by phone policy",
+		zap.String("phone", logging.MaskPhone(number)),
+		zap.String("reason", err.Error()),
+		zap.String("path", r.URL.Path),
+		zap.String("client_ip", clientip.FromRequest(r)),
+	)
+	audit.Report(r, audit.Entry{Action: "phone_policy", Decision: audit.DecisionDeny, Reason: apiErr.Code})
+	apierror.Write(w, r, apiErr)
+}
+
+// isSMSRoute matches the requests that can make GoTrue send an SMS.
+func isSMSRoute(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost:
+		return matchRoute("/auth/v1/otp", r.URL.Path) ||
+			matchRoute("/auth/v1/signup", r.URL.Path) ||
+			matchRoute("/auth/v1/resend", r.URL.Path)
+	case http.MethodPut:
+		return matchRoute("/auth/v1/user", r.URL.Path)
+	default:
+		return false
+	}
+}
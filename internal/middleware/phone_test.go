@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/phone"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func TestPhonePolicyMiddleware(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+
+	policy, err := phone.New(phone.Config{
+		AllowedCountryCodes: []string{"44", "979"},
+		BlockPremium:        true,
+		PerNumberLimit:      1,
+		Store:               s,
+	})
+	if err != nil {
+		t.Fatalf("phone.New() error = %v", err)
+	}
+	logger, _ := logging.New("error", false)
+	handler := NewPhonePolicyMiddleware(policy, logger).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		status    int
+		errorCode string
+	}{
+		{"allowed", "/auth/v1/otp", `{"phone":"+447700900123"}`, http.StatusOK, ""},
+		{"number limit", "/otp", `{"phone":"447700900123"}`, http.StatusTooManyRequests, "over_sms_send_rate_limit"},
+		{"country not allowed", "/auth/v1/signup", `{"phone":"+2348012345678","password":"x"}`, http.StatusUnprocessableEntity, "sms_send_failed"},
+		{"premium number", "/auth/v1/otp", `{"phone":"+97912345678"}`, http.StatusUnprocessableEntity, "phone_premium_number"},
+		{"padded past the limit", "/auth/v1/otp", `{"phone":"+447700900456","data":{"pad":"` + strings.Repeat("a", maxPolicyBodySize) + `"}}`, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"invalid number", "/auth/v1/resend", `{"type":"sms","phone":"12"}`, http.StatusBadRequest, "validation_failed"},
+		{"email otp untouched", "/auth/v1/otp", `{"email":"a@example.com"}`, http.StatusOK, ""},
+		{"other route untouched", "/auth/v1/verify", `{"phone":"+2348012345678"}`, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.errorCode != "" {
+				var body map[string]any
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body["error_code"] != tt.errorCode {
+					t.Errorf("error_code = %v, want %s", body["error_code"], tt.errorCode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// ReloadableMiddleware wraps a middleware that can be replaced while the
+// server runs, so a config reload can swap in rebuilt policies without
+// rebuilding the handler chain.
+type ReloadableMiddleware struct {
+	current atomic.Pointer[reloadableEntry]
+}
+
+type reloadableEntry struct {
+	mw func(http.Handler) http.Handler
+}
+
+// builtHandler is the current middleware applied to one next handler.
+type builtHandler struct {
+	entry   *reloadableEntry
+	handler http.Handler
+}
+
+// NewReloadableMiddleware creates a reloadable middleware starting with mw.
+func NewReloadableMiddleware(mw func(http.Handler) http.Handler) *ReloadableMiddleware {
+	m := &ReloadableMiddleware{}
+	m.Set(mw)
+	return m
+}
+
+// Set replaces the middleware. Requests already in flight finish with the
+// previous one.
+func (m *ReloadableMiddleware) Set(mw func(http.Handler) http.Handler) {
+	m.current.Store(&reloadableEntry{mw: mw})
+}
+
+// Middleware returns the HTTP middleware handler.
+func (m *ReloadableMiddleware) Middleware(next http.Handler) http.Handler {
+	// Applying the middleware can allocate, so it's done once per Set rather
+	// than per request
+	var built atomic.Pointer[builtHandler]
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry := m.current.Load()
+		b := built.Load()
+		if b == nil || b.entry != entry {
+			b = &builtHandler{entry: entry, handler: entry.mw(next)}
+			built.Store(b)
+		}
+		b.handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReloadableMiddleware(t *testing.T) {
+	tag := func(value string) func(http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Policy", value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	m := NewReloadableMiddleware(tag("v1"))
+	handler := m.Middleware(next)
+
+	serve := func() string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/v1/user", nil))
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+		}
+		return rec.Header().Get("X-Policy")
+	}
+
+	if got := serve(); got != "v1" {
+		t.Errorf("X-Policy = %q, want v1", got)
+	}
+	m.Set(tag("v2"))
+	if got := serve(); got != "v2" {
+		t.Errorf("X-Policy after Set = %q, want v2", got)
+	}
+}
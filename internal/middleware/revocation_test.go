@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/revocation"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func TestRevocationMiddleware(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	list, _ := revocation.New(revocation.Config{Store: s})
+	list.RevokeSession(context.Background(), "revoked-session", time.Now().Add(time.Hour))
+
+	logger, _ := logging.New("error", false)
+	handler := NewRevocationMiddleware(list, logger).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	signedIn := time.Now().Add(-time.Minute).Unix()
+	tests := []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"no token", "", http.StatusOK},
+		{"not a jwt", "Bearer opaque", http.StatusOK},
+		{"live session", "Bearer " + signTestToken(map[string]any{"sub": "u1", "session_id": "live-session", "iat": signedIn}), http.StatusOK},
+		{"revoked session", "Bearer " + signTestToken(map[string]any{"sub": "u1", "session_id": "revoked-session", "iat": signedIn}), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/v1/user", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import "strings"
+
+// canonicalPath maps a request path onto the upstream path the proxy will
+// forward it to, so route rules written as "/auth/v1/token" also apply to
+// clients calling "/token".
+func canonicalPath(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/auth/v1"),
+		strings.HasPrefix(path, "/attestation/"),
+		isHealthPath(path):
+		return path
+	default:
+		return "/auth/v1" + path
+	}
+}
+
+// matchRoute reports whether path falls under the route prefix.
+func matchRoute(prefix, path string) bool {
+	prefix = strings.TrimSuffix(canonicalPath(prefix), "/")
+	path = canonicalPath(path)
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// isHealthPath reports whether path is a health, probe or version endpoint,
+// which skips the API key, attestation and network checks.
+func isHealthPath(path string) bool {
+	return strings.HasPrefix(path, "/health") || path == "/livez" || path == "/readyz" || path == "/version"
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/signup"
+	"go.uber.org/zap"
+)
+
+// maxPolicyBodySize caps the request bodies policy checks accept. Larger
+// bodies are rejected rather than checked in part, so padding can't push the
+// fields past what the check reads.
+const maxPolicyBodySize = 64 * 1024
+
+// SignupPolicyMiddleware enforces the signup email policy on /auth/v1/signup.
+type SignupPolicyMiddleware struct {
+	policy *signup.Policy
+	logger *logging.Logger
+}
+
+// NewSignupPolicyMiddleware creates a new signup policy middleware.
+// A nil policy disables the checks.
+func NewSignupPolicyMiddleware(policy *signup.Policy, logger *logging.Logger) *SignupPolicyMiddleware {
+	return &SignupPolicyMiddleware{
+		policy: policy,
+		logger: logger,
+	}
+}
+
+// Middleware returns the HTTP middleware handler.
+func (m *SignupPolicyMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.policy == nil || r.Method != http.MethodPost || !matchRoute("/auth/v1/signup", r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := readBody(w, r, maxPolicyBodySize)
+		if err != nil {
+			writeBodyError(w, r, err)
+			return
+		}
+
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Email == "" {
+			// Phone signups and malformed bodies are left to GoTrue
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := m.policy.Check(r.Context(), req.Email); err != nil {
+			if m.reject(w, r, req.Email, err) {
+				return
+			}
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode >= 200 && recorder.statusCode < 300 {
+			if err := m.policy.Record(r.Context(), req.Email); err != nil {
+				m.logger.For(r.Context()).DatabaseError("failed to record signup address", zap.Error(err))
+			}
+		}
+	})
+}
+
+// reject writes the policy violation, returning false for store errors so the
+// signup fails open.
+func (m *SignupPolicyMiddleware) reject(w http.ResponseWriter, r *http.Request, email string, err error) bool {
+	var apiErr *apierror.Error
+
+	switch {
+	case errors.Is(err, signup.ErrInvalidEmail):
+		apiErr = apierror.ErrEmailAddressInvalid
+	case errors.Is(err, signup.ErrDomainNotAllowed):
+		apiErr = apierror.ErrEmailAddressNotAuthorized.WithMessage("Email address domain is not allowed")
+	case errors.Is(err, signup.ErrDisposableEmail):
+		apiErr = apierror.ErrEmailAddressNotAuthorized.WithMessage("Disposable email addresses are not allowed")
+	case errors.Is(err, signup.ErrDuplicateAlias):
+		apiErr = apierror.ErrEmailExists
+	default:
+		m.logger.For(r.Context()).DatabaseError("signup policy check failed, allowing signup", zap.Error(err))
+		return false
+	}
+
+	m.logger.For(r.Context()).AuthWarning("signup rejected by email policy",
+		zap.String("email", logging.MaskEmail(email)),
+		zap.String("reason", err.Error()),
+		zap.String("client_ip", clientip.FromRequest(r)),
+	)
+	audit.Report(r, audit.Entry{Action: "signup_policy", Decision: audit.DecisionDeny, Reason: apiErr.Code})
+	apierror.Write(w, r, apiErr)
+	return true
+}
+
+// readBody reads the whole request body, up to limit bytes, and puts it back
+// so the proxy still forwards it. Longer bodies fail with *http.MaxBytesError.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// writeBodyError answers a request whose body readBody couldn't read.
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apierror.Write(w, r, apierror.ErrRequestTooLarge)
+		return
+	}
+	apierror.Write(w, r, apierror.ErrInvalidRequest.WithCause(err))
+}
+
+// statusRecorder captures the status code written by the next handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.statusCode = code
+	r.ResponseWriter.WriteHeader(code)
+}
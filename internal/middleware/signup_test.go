@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/signup"
+)
+
+func TestSignupPolicyMiddleware(t *testing.T) {
+	policy, err := signup.New(signup.Config{BlockDisposable: true})
+	if err != nil {
+		t.Fatalf("signup.New() error = %v", err)
+	}
+	logger, _ := logging.New("error", false)
+
+	var forwarded string
+	handler := NewSignupPolicyMiddleware(policy, logger).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	padded := `{"email":"a@mailinator.com","password":"x","data":{"pad":"` + strings.Repeat("a", maxPolicyBodySize) + `"}}`
+
+	tests := []struct {
+		name      string
+		body      string
+		status    int
+		errorCode string
+	}{
+		{"allowed", `{"email":"a@example.com","password":"x"}`, http.StatusOK, ""},
+		{"disposable", `{"email":"a@mailinator.com","password":"x"}`, http.StatusUnprocessableEntity, "email_address_not_authorized"},
+		{"padded past the limit", padded, http.StatusRequestEntityTooLarge, "request_too_large"},
+		{"phone signup untouched", `{"phone":"+447700900123","password":"x"}`, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = ""
+			req := httptest.NewRequest(http.MethodPost, "/auth/v1/signup", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.errorCode != "" {
+				var body map[string]any
+				json.Unmarshal(rec.Body.Bytes(), &body)
+				if body["error_code"] != tt.errorCode {
+					t.Errorf("error_code = %v, want %s", body["error_code"], tt.errorCode)
+				}
+				return
+			}
+			if forwarded != tt.body {
+				t.Errorf("forwarded body = %q, want the original body", forwarded)
+			}
+		})
+	}
+}
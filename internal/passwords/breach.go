@@ -0,0 +1,179 @@
+// Package passwords checks passwords against a breached-password corpus using
+// the k-anonymity range protocol: only the first five hex characters of the
+// password's SHA-1 hash ever leave the process.
+package passwords
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+)
+
+// DefaultEndpoint is the public Have I Been Pwned range API.
+const DefaultEndpoint = "https://api.pwnedpasswords.com/range"
+
+// Config holds configuration for the breach checker.
+type Config struct {
+	// Endpoint is the range API base URL; the hash prefix is appended as a
+	// path segment. Point it at a local mirror to keep lookups in-network.
+	Endpoint string
+	Timeout  time.Duration
+	// CacheTTL is how long a range response is reused.
+	CacheTTL time.Duration
+	// CacheSize bounds the number of cached ranges.
+	CacheSize int
+	// MinCount is how many times a password must appear in the corpus before
+	// it is treated as breached.
+	MinCount int
+}
+
+type cacheEntry struct {
+	suffixes  map[string]struct{}
+	expiresAt time.Time
+}
+
+// Checker looks up passwords in the breached-password corpus.
+type Checker struct {
+	endpoint  string
+	client    *http.Client
+	cacheTTL  time.Duration
+	cacheSize int
+	minCount  int
+
+	mu    sync.Mutex
+	cache map[string]cacheEntry
+}
+
+// NewChecker creates a breach checker.
+func NewChecker(cfg Config) *Checker {
+	c := &Checker{
+		endpoint:  strings.TrimSuffix(cfg.Endpoint, "/"),
+		client:    &http.Client{Timeout: cfg.Timeout},
+		cacheTTL:  cfg.CacheTTL,
+		cacheSize: cfg.CacheSize,
+		minCount:  cfg.MinCount,
+		cache:     make(map[string]cacheEntry),
+	}
+
+	if c.endpoint == "" {
+		c.endpoint = DefaultEndpoint
+	}
+	if c.client.Timeout == 0 {
+		c.client.Timeout = 2 * time.Second
+	}
+	if c.cacheSize == 0 {
+		c.cacheSize = 1000
+	}
+	if c.minCount < 1 {
+		c.minCount = 1
+	}
+
+	return c
+}
+
+// IsBreached reports whether password appears in the breach corpus.
+func (c *Checker) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	suffixes, ok := c.cached(prefix)
+	if !ok {
+		var err error
+		suffixes, err = c.fetchRange(ctx, prefix)
+		if err != nil {
+			return false, err
+		}
+		c.store(prefix, suffixes)
+	}
+
+	_, breached := suffixes[suffix]
+	return breached, nil
+}
+
+// fetchRange downloads the hash suffixes for a prefix, keeping only those
+// seen at least minCount times. Padding entries (count 0) are dropped.
+func (c *Checker) fetchRange(ctx context.Context, prefix string) (map[string]struct{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.endpoint+"/"+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", buildinfo.UserAgent())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("range API returned %d", resp.StatusCode)
+	}
+
+	suffixes := make(map[string]struct{})
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		suffix, countStr, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found {
+			continue
+		}
+		count, err := strconv.Atoi(countStr)
+		if err != nil || count < c.minCount {
+			continue
+		}
+		suffixes[strings.ToUpper(suffix)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return suffixes, nil
+}
+
+func (c *Checker) cached(prefix string) (map[string]struct{}, bool) {
+	if c.cacheTTL <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.cache[prefix]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.suffixes, true
+}
+
+func (c *Checker) store(prefix string, suffixes map[string]struct{}) {
+	if c.cacheTTL <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Prefixes are uniformly distributed, so evicting an arbitrary entry is
+	// as good as LRU here.
+	if len(c.cache) >= c.cacheSize {
+		for k := range c.cache {
+			delete(c.cache, k)
+			break
+		}
+	}
+
+	c.cache[prefix] = cacheEntry{
+		suffixes:  suffixes,
+		expiresAt: time.Now().Add(c.cacheTTL),
+	}
+}
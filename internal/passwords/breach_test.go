@@ -0,0 +1,109 @@
+package passwords
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRangeServer serves a range API that knows about the given passwords
+// with the given breach counts, plus a zero-count padding line.
+func newRangeServer(t *testing.T, known map[string]int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		prefix := strings.TrimPrefix(r.URL.Path, "/range/")
+		if len(prefix) != 5 {
+			t.Errorf("range request for %q, want a 5 character prefix", prefix)
+		}
+
+		for password, count := range known {
+			hash := sha1Hex(password)
+			if hash[:5] == prefix {
+				fmt.Fprintf(w, "%s:%d\r\n", hash[5:], count)
+			}
+		}
+		fmt.Fprintf(w, "%s:0\r\n", strings.Repeat("0", 35))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestIsBreached(t *testing.T) {
+	server, _ := newRangeServer(t, map[string]int{"password123": 250000, "rare-but-seen": 1})
+	c := NewChecker(Config{Endpoint: server.URL + "/range/"})
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password123", true},
+		{"rare-but-seen", true},
+		{"correct horse battery staple 9f8e", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got, err := c.IsBreached(context.Background(), tt.password)
+			if err != nil {
+				t.Fatalf("IsBreached() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("IsBreached(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBreachedMinCount(t *testing.T) {
+	server, _ := newRangeServer(t, map[string]int{"rare-but-seen": 2})
+	c := NewChecker(Config{Endpoint: server.URL + "/range", MinCount: 10})
+
+	breached, err := c.IsBreached(context.Background(), "rare-but-seen")
+	if err != nil {
+		t.Fatalf("IsBreached() error = %v", err)
+	}
+	if breached {
+		t.Error("password below MinCount reported as breached")
+	}
+}
+
+func TestIsBreachedCachesRanges(t *testing.T) {
+	server, calls := newRangeServer(t, map[string]int{"password123": 5})
+	c := NewChecker(Config{Endpoint: server.URL + "/range", CacheTTL: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.IsBreached(context.Background(), "password123"); err != nil {
+			t.Fatalf("IsBreached() error = %v", err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("range API called %d times, want 1", calls.Load())
+	}
+}
+
+func TestIsBreachedUpstreamError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := NewChecker(Config{Endpoint: server.URL})
+	if _, err := c.IsBreached(context.Background(), "password123"); err == nil {
+		t.Error("IsBreached() should fail when the range API is down")
+	}
+}
@@ -0,0 +1,255 @@
+// Package phone guards SMS-sending endpoints against SMS pumping (toll
+// fraud): numbers must be valid E.164 in an allowed country code, premium-rate
+// ranges are blocked, and sends are capped per number, per number block and
+// per IP over a rolling window.
+package phone
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+var (
+	ErrInvalidNumber     = errors.New("invalid phone number")
+	ErrCountryNotAllowed = errors.New("phone country code not allowed")
+	ErrPremiumNumber     = errors.New("premium-rate phone number")
+)
+
+// LimitError is returned when a send cap is exceeded.
+type LimitError struct {
+	// Scope is "number", "prefix" or "ip".
+	Scope string
+	// RetryAfter is the longest a caller may have to wait for the rolling
+	// window to let a send through again.
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return "sms send limit exceeded per " + e.Scope
+}
+
+//go:embed premium_prefixes.txt
+var bundledPremiumPrefixes string
+
+// Config holds configuration for the phone policy.
+type Config struct {
+	// AllowedCountryCodes, if set, are the only calling codes numbers may
+	// use, without "+", e.g. "1" or "44".
+	AllowedCountryCodes []string
+	// BlockPremium rejects numbers in the bundled premium-rate ranges.
+	BlockPremium bool
+	// PremiumPrefixFile adds prefixes to the bundled premium-rate list.
+	PremiumPrefixFile string
+
+	// Send caps over Window. Zero disables a cap.
+	PerNumberLimit int
+	PerPrefixLimit int
+	PerIPLimit     int
+	Window         time.Duration
+	// PrefixDigits is how many trailing digits are dropped to group numbers
+	// into a block, since pumping usually walks a number range.
+	PrefixDigits int
+
+	// Store holds the send counters; required when any cap is set.
+	Store store.Store
+}
+
+// Policy evaluates phone numbers before an SMS is sent.
+type Policy struct {
+	allowed        prefixSet
+	premium        prefixSet
+	perNumberLimit int64
+	perPrefixLimit int64
+	perIPLimit     int64
+	window         time.Duration
+	prefixDigits   int
+	store          store.Store
+}
+
+// New creates a phone policy.
+func New(cfg Config) (*Policy, error) {
+	p := &Policy{
+		allowed:        newPrefixSet(cfg.AllowedCountryCodes),
+		perNumberLimit: int64(cfg.PerNumberLimit),
+		perPrefixLimit: int64(cfg.PerPrefixLimit),
+		perIPLimit:     int64(cfg.PerIPLimit),
+		window:         cfg.Window,
+		prefixDigits:   cfg.PrefixDigits,
+		store:          cfg.Store,
+	}
+
+	if p.window <= 0 {
+		p.window = time.Hour
+	}
+	if p.prefixDigits <= 0 {
+		p.prefixDigits = 3
+	}
+	if (p.perNumberLimit > 0 || p.perPrefixLimit > 0 || p.perIPLimit > 0) && p.store == nil {
+		return nil, fmt.Errorf("send limits require a store")
+	}
+
+	if cfg.BlockPremium {
+		p.premium.load(strings.NewReader(bundledPremiumPrefixes))
+
+		if cfg.PremiumPrefixFile != "" {
+			f, err := os.Open(cfg.PremiumPrefixFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open premium prefixes file: %w", err)
+			}
+			defer f.Close()
+			if err := p.premium.load(f); err != nil {
+				return nil, fmt.Errorf("failed to read premium prefixes file: %w", err)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Check validates a number and returns it in E.164 form.
+func (p *Policy) Check(number string) (string, error) {
+	normalized, err := Normalize(number)
+	if err != nil {
+		return "", err
+	}
+
+	digits := normalized[1:]
+	if len(p.allowed) > 0 && !p.allowed.contains(digits) {
+		return "", ErrCountryNotAllowed
+	}
+	if p.premium.contains(digits) {
+		return "", ErrPremiumNumber
+	}
+	return normalized, nil
+}
+
+// Allow records a send to number from ip, or returns a *LimitError when any
+// cap is already reached. Each counter is raised before it is compared, so
+// concurrent requests can't all pass a check before any of them is counted;
+// a rejected send is then taken back off every counter it raised, so
+// rejected attempts can't use up a number's budget and lock its owner out.
+// Store failures are returned as-is so the caller can decide whether to
+// fail open.
+func (p *Policy) Allow(ctx context.Context, number, ip string) error {
+	block := number
+	if len(block) > p.prefixDigits+2 {
+		block = block[:len(block)-p.prefixDigits]
+	}
+
+	limits := []struct {
+		scope string
+		key   string
+		limit int64
+	}{
+		{"number", "number:" + number, p.perNumberLimit},
+		{"prefix", "prefix:" + block, p.perPrefixLimit},
+		{"ip", "ip:" + ip, p.perIPLimit},
+	}
+
+	var counted []string
+	for _, l := range limits {
+		if l.limit <= 0 || (l.scope == "ip" && ip == "") {
+			continue
+		}
+		count, err := store.RollingIncr(ctx, p.store, l.key, p.window)
+		if err != nil {
+			p.uncount(ctx, counted)
+			return err
+		}
+		counted = append(counted, l.key)
+		if count > l.limit {
+			p.uncount(ctx, counted)
+			return &LimitError{Scope: l.scope, RetryAfter: p.window}
+		}
+	}
+	return nil
+}
+
+// uncount takes a rejected send back off the counters it raised. It runs
+// even if the request was cancelled, so the counts don't drift upwards.
+func (p *Policy) uncount(ctx context.Context, keys []string) {
+	ctx = context.WithoutCancel(ctx)
+	for _, key := range keys {
+		store.RollingDecr(ctx, p.store, key, p.window)
+	}
+}
+
+// Normalize converts a number to E.164 ("+" followed by up to 15 digits),
+// dropping common separators and an international "00" prefix.
+// Example: "00 44 (7700) 900-123" -> "+447700900123"
+func Normalize(number string) (string, error) {
+	number = strings.TrimSpace(number)
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number = number[1:]
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	}
+
+	var b strings.Builder
+	b.WriteByte('+')
+	for _, c := range number {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteRune(c)
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", ErrInvalidNumber
+		}
+	}
+
+	normalized := b.String()
+	// Country codes never start with 0, and E.164 allows 15 digits at most.
+	// The shortest real numbers (e.g. Niue) are 7 digits with country code.
+	if len(normalized) < 8 || len(normalized) > 16 || normalized[1] == '0' {
+		return "", ErrInvalidNumber
+	}
+	return normalized, nil
+}
+
+// prefixSet matches digit strings by prefix. Calling codes are prefix-free, so
+// this also works for country codes.
+type prefixSet []string
+
+func newPrefixSet(prefixes []string) prefixSet {
+	var set prefixSet
+	for _, prefix := range prefixes {
+		set.add(prefix)
+	}
+	return set
+}
+
+func (s *prefixSet) add(prefix string) {
+	prefix = strings.TrimPrefix(strings.TrimSpace(prefix), "+")
+	if prefix != "" {
+		*s = append(*s, prefix)
+	}
+}
+
+// load reads one prefix per line, ignoring blank lines and # comments.
+func (s *prefixSet) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		s.add(line)
+	}
+	return scanner.Err()
+}
+
+func (s prefixSet) contains(digits string) bool {
+	for _, prefix := range s {
+		if strings.HasPrefix(digits, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package phone
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   error
+	}{
+		{"+447700900123", "+447700900123", nil},
+		{"447700900123", "+447700900123", nil},
+		{"00 44 (7700) 900-123", "+447700900123", nil},
+		{"+1 415.555.0100", "+14155550100", nil},
+		{"+0123456789", "", ErrInvalidNumber},
+		{"+12345", "", ErrInvalidNumber},
+		{"+1234567890123456", "", ErrInvalidNumber},
+		{"+44abc7700900", "", ErrInvalidNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Normalize(tt.input)
+			if got != tt.want || !errors.Is(err, tt.err) {
+				t.Errorf("Normalize(%q) = %q, %v, want %q, %v", tt.input, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := New(Config{
+		AllowedCountryCodes: []string{"1", "+44", "979"},
+		BlockPremium:        true,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		number string
+		err    error
+	}{
+		{"+14155550100", nil},
+		{"+447700900123", nil},
+		{"+2348012345678", ErrCountryNotAllowed},
+		{"+97912345678", ErrPremiumNumber},
+		{"not a number", ErrInvalidNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			if _, err := p.Check(tt.number); !errors.Is(err, tt.err) {
+				t.Errorf("Check(%q) error = %v, want %v", tt.number, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAllowLimits(t *testing.T) {
+	newPolicy := func(cfg Config) *Policy {
+		s := store.NewMemory()
+		t.Cleanup(func() { s.Close() })
+		cfg.Store = s
+		p, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		return p
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		numbers []string
+		ips     []string
+		scope   string
+	}{
+		{
+			name:    "per number",
+			cfg:     Config{PerNumberLimit: 2},
+			numbers: []string{"+447700900123", "+447700900123", "+447700900123"},
+			ips:     []string{"a", "b", "c"},
+			scope:   "number",
+		},
+		{
+			name:    "per prefix block",
+			cfg:     Config{PerPrefixLimit: 2},
+			numbers: []string{"+447700900001", "+447700900002", "+447700900003"},
+			ips:     []string{"a", "b", "c"},
+			scope:   "prefix",
+		},
+		{
+			name:    "per ip",
+			cfg:     Config{PerIPLimit: 2},
+			numbers: []string{"+447700900123", "+14155550100", "+33612345678"},
+			ips:     []string{"a", "a", "a"},
+			scope:   "ip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPolicy(tt.cfg)
+			last := len(tt.numbers) - 1
+			for i := range last {
+				if err := p.Allow(ctx, tt.numbers[i], tt.ips[i]); err != nil {
+					t.Fatalf("send %d error = %v", i, err)
+				}
+			}
+
+			var limitErr *LimitError
+			err := p.Allow(ctx, tt.numbers[last], tt.ips[last])
+			if !errors.As(err, &limitErr) || limitErr.Scope != tt.scope {
+				t.Errorf("final send error = %v, want limit on %s", err, tt.scope)
+			}
+		})
+	}
+}
+
+func TestAllowCountsOnlyAllowedSends(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	p, err := New(Config{PerNumberLimit: 3, PerIPLimit: 1, Window: time.Hour, Store: s})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	// An attacker keeps requesting codes for someone else's number after
+	// their own IP is capped
+	if err := p.Allow(ctx, "+447700900123", "attacker"); err != nil {
+		t.Fatalf("first send error = %v", err)
+	}
+	for range 5 {
+		var limitErr *LimitError
+		if err := p.Allow(ctx, "+447700900123", "attacker"); !errors.As(err, &limitErr) || limitErr.Scope != "ip" {
+			t.Fatalf("attacker send error = %v, want the ip limit", err)
+		}
+	}
+
+	if got, _ := store.RollingCount(ctx, s, "number:+447700900123", time.Hour); got != 1 {
+		t.Errorf("sends counted for the number = %d, want 1", got)
+	}
+	// The owner can still get a code
+	if err := p.Allow(ctx, "+447700900123", "owner"); err != nil {
+		t.Errorf("owner send error = %v, want nil", err)
+	}
+}
+
+func TestAllowConcurrentBurst(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	p, err := New(Config{PerNumberLimit: 3, Window: time.Hour, Store: s})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.Allow(ctx, "+447700900123", "pump") == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != 3 {
+		t.Errorf("allowed %d concurrent sends, want 3", got)
+	}
+	if got, _ := store.RollingCount(ctx, s, "number:+447700900123", time.Hour); got != 3 {
+		t.Errorf("sends counted = %d, want 3 after rejected sends were taken back", got)
+	}
+}
+
+func TestLimitsRequireStore(t *testing.T) {
+	if _, err := New(Config{PerIPLimit: 5}); err == nil {
+		t.Error("New() with limits and no store should fail")
+	}
+}
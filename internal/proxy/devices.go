@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/devices"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/metrics"
+	"github.com/kacy/auth-proxy/internal/middleware"
+	"go.uber.org/zap"
+)
+
+// deviceBinder records which device key each sign-in came from and blocks
+// sign-ins that break the device policy.
+type deviceBinder struct {
+	registry *devices.Registry
+	signOut  func(ctx context.Context, accessToken string)
+	logger   *logging.Logger
+	metrics  *metrics.Metrics
+}
+
+// handleResponse binds the device to the user of a successful sign-in. When
+// the policy blocks the binding, the session GoTrue just issued is signed out
+// and the client gets an error instead of the tokens.
+func (b *deviceBinder) handleResponse(resp *http.Response) error {
+	keyID := resp.Request.Header.Get(middleware.KeyIDHeader)
+	if keyID == "" || resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.Body == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	setBody(resp, body)
+
+	var tokens tokenResponse
+	if json.Unmarshal(body, &tokens) != nil || tokens.AccessToken == "" || tokens.User == nil || tokens.User.ID == "" {
+		return nil
+	}
+
+	userID := tokens.User.ID
+	platform := resp.Request.Header.Get(middleware.PlatformHeader)
+	result, err := b.registry.Record(resp.Request.Context(), userID, keyID, platform)
+
+	fields := []zap.Field{
+		zap.String("user_id", logging.MaskUserID(userID)),
+		zap.String("key_id", logging.MaskKeyID(keyID)),
+		zap.String("client_ip", clientip.FromRequest(resp.Request)),
+	}
+	if result.PreviousUser != "" {
+		fields = append(fields, zap.String("previous_user_id", logging.MaskUserID(result.PreviousUser)))
+	}
+
+	var apiErr *apierror.Error
+	switch {
+	case errors.Is(err, devices.ErrTooManyDevices):
+		b.count("blocked_max_devices")
+		apiErr = apierror.ErrDeviceLimitExceeded
+	case errors.Is(err, devices.ErrTooManyAccounts):
+		b.count("blocked_max_accounts")
+		apiErr = apierror.ErrDeviceAccountsExceeded
+	case errors.Is(err, devices.ErrDeviceMoved):
+		b.count("blocked_key_moved")
+		apiErr = apierror.ErrDeviceBoundToOtherUser
+	case err != nil:
+		// A store outage shouldn't lock everyone out of signing in
+		b.logger.For(resp.Request.Context()).DatabaseError("failed to record device binding", append(fields, zap.Error(err))...)
+		return nil
+	default:
+		if result.NewDevice {
+			b.count("new_device")
+		}
+		if result.PreviousUser != "" {
+			b.count("key_moved")
+			b.logger.For(resp.Request.Context()).AuthWarning("device key signed in as a different user", fields...)
+			audit.Report(resp.Request, audit.Entry{
+				Action:   "device_binding",
+				Decision: audit.DecisionAlert,
+				Reason:   "device_key_moved",
+				UserID:   userID,
+				KeyID:    keyID,
+			})
+		}
+		return nil
+	}
+
+	b.logger.For(resp.Request.Context()).AuthWarning("sign-in blocked by device policy", append(fields, zap.Error(err))...)
+	audit.Report(resp.Request, audit.Entry{
+		Action:   "device_binding",
+		Decision: audit.DecisionDeny,
+		Reason:   apiErr.Code,
+		UserID:   userID,
+		KeyID:    keyID,
+	})
+	b.signOut(resp.Request.Context(), tokens.AccessToken)
+	apierror.Replace(resp, apiErr)
+	return nil
+}
+
+func (b *deviceBinder) count(event string) {
+	if b.metrics != nil {
+		b.metrics.DeviceBindingEventsTotal.WithLabelValues(event).Inc()
+	}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/devices"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/middleware"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func TestDeviceBinding(t *testing.T) {
+	var logouts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/v1/token":
+			var req struct {
+				Email string `json:"email"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":"access","refresh_token":"refresh","user":{"id":%q}}`, req.Email)
+		case "/auth/v1/logout":
+			logouts.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	s := store.NewMemory()
+	defer s.Close()
+	registry, _ := devices.New(devices.Config{Store: s, MaxAccountsPerDevice: 1})
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{TargetURL: server.URL, AnonKey: "anon", Devices: registry}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	signInAs := func(user, keyID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", strings.NewReader(`{"email":"`+user+`"}`))
+		req.Header.Set(middleware.KeyIDHeader, keyID)
+		req.Header.Set(middleware.PlatformHeader, "ios")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := signInAs("user-1", "device-key-1"); rec.Code != http.StatusOK {
+		t.Fatalf("first sign-in status = %d", rec.Code)
+	}
+
+	rec := signInAs("user-2", "device-key-1")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("second account on device: status = %d, want 403", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "access") {
+		t.Errorf("blocked response leaked tokens: %s", rec.Body)
+	}
+	var body map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["error_code"] != "device_account_limit_exceeded" {
+		t.Errorf("error_code = %v, want device_account_limit_exceeded", body["error_code"])
+	}
+	if logouts.Load() != 1 {
+		t.Errorf("upstream logouts = %d, want the blocked session signed out", logouts.Load())
+	}
+
+	if rec := signInAs("user-2", "device-key-2"); rec.Code != http.StatusOK {
+		t.Errorf("sign-in on another device status = %d, want 200", rec.Code)
+	}
+}
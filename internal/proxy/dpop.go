@@ -0,0 +1,267 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/dpop"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/store"
+	"go.uber.org/zap"
+)
+
+// DPoPConfig configures DPoP sender-constrained tokens.
+type DPoPConfig struct {
+	Enabled bool
+	Store   store.Store
+	// MaxAge is how old a proof may be.
+	MaxAge time.Duration
+	// Leeway allows for client clock skew.
+	Leeway time.Duration
+	// BindingTTL is how long a session stays bound to its key after the last
+	// token grant.
+	BindingTTL time.Duration
+}
+
+// dpopChallenge is sent with every DPoP error, as RFC 9449 section 7.1 asks.
+const dpopChallenge = `DPoP algs="ES256 RS256"`
+
+type dpopContextKey struct{}
+
+// dpopContext carries the proof key from a /token request to its response.
+type dpopContext struct {
+	thumbprint string
+}
+
+// dpopGuard binds sessions to the key that signed the DPoP proof on the token
+// grant, and requires proofs from that key on every later request made with
+// the session's tokens.
+type dpopGuard struct {
+	validator *dpop.Validator
+	publicURL *url.URL
+	logger    *logging.Logger
+}
+
+func newDPoPGuard(cfg DPoPConfig, publicURL string, logger *logging.Logger) (*dpopGuard, error) {
+	validator, err := dpop.New(dpop.Config{
+		Store:      cfg.Store,
+		MaxAge:     cfg.MaxAge,
+		Leeway:     cfg.Leeway,
+		BindingTTL: cfg.BindingTTL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	g := &dpopGuard{validator: validator, logger: logger}
+	if publicURL != "" {
+		if g.publicURL, err = url.Parse(publicURL); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// check validates the DPoP proof on a request. Token grants may carry a proof
+// to bind the new session; requests with the access token of a bound session
+// must carry a proof from the bound key. It returns false if it already wrote
+// a response.
+func (g *dpopGuard) check(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	proof := r.Header.Get(dpop.Header)
+	r.Header.Del(dpop.Header)
+
+	if r.Method == http.MethodPost && strings.HasPrefix(upstreamPath(r.URL.Path), "/auth/v1/token") {
+		dc := &dpopContext{}
+		if proof != "" {
+			p, err := g.validator.Validate(r.Context(), proof, r.Method, g.requestURL(r), "")
+			if err != nil {
+				g.reject(w, r, err)
+				return r, false
+			}
+			dc.thumbprint = p.Thumbprint
+		}
+		return r.WithContext(context.WithValue(r.Context(), dpopContextKey{}, dc)), true
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || (!strings.EqualFold(scheme, "DPoP") && !strings.EqualFold(scheme, "Bearer")) {
+		return r, true
+	}
+	token = strings.TrimSpace(token)
+
+	// GoTrue only understands Bearer
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	sessionID := tokenSessionID(token)
+	if sessionID == "" {
+		return r, true
+	}
+
+	bound, err := g.validator.Binding(r.Context(), sessionID)
+	if err != nil {
+		g.logger.For(r.Context()).DatabaseError("failed to look up DPoP binding", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrDPoPCheckUnavailable.WithMessage("DPoP binding could not be checked, try again later").WithCause(err))
+		return r, false
+	}
+	if bound == "" {
+		return r, true
+	}
+
+	p, err := g.validator.Validate(r.Context(), proof, r.Method, g.requestURL(r), token)
+	if err != nil {
+		g.reject(w, r, err)
+		return r, false
+	}
+	if p.Thumbprint != bound {
+		g.reject(w, r, dpop.ErrKeyMismatch)
+		return r, false
+	}
+	return r, true
+}
+
+// handleResponse binds the session issued by a token grant to the proof key.
+// A grant for an already bound session (a refresh) must use the same key, so
+// a stolen refresh token can't be rebound to the thief's key.
+func (g *dpopGuard) handleResponse(resp *http.Response) error {
+	dc, _ := resp.Request.Context().Value(dpopContextKey{}).(*dpopContext)
+	if dc == nil || resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.Body == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var tokens tokenResponse
+	json.Unmarshal(body, &tokens)
+	sessionID := tokenSessionID(tokens.AccessToken)
+	if sessionID == "" {
+		setBody(resp, body)
+		return nil
+	}
+
+	ctx := resp.Request.Context()
+	bound, err := g.validator.Binding(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bound != "" && bound != dc.thumbprint:
+		g.logger.For(ctx).AuthWarning("rejected token grant for a session bound to another DPoP key",
+			zap.String("session_id", logging.MaskUserID(sessionID)),
+			zap.Bool("proof_present", dc.thumbprint != ""),
+			zap.String("client_ip", clientip.FromRequest(resp.Request)),
+		)
+		audit.Report(resp.Request, audit.Entry{Action: "dpop", Decision: audit.DecisionDeny, Reason: "dpop_key_mismatch"})
+		resp.Header.Set("WWW-Authenticate", dpopChallenge+`, error="invalid_dpop_proof"`)
+		apierror.Replace(resp, apierror.ErrInvalidDPoPProof.WithMessage("This session is bound to a different DPoP key"))
+	case dc.thumbprint != "":
+		if err := g.validator.Bind(ctx, sessionID, dc.thumbprint); err != nil {
+			return err
+		}
+		setBody(resp, setTokenType(body, "DPoP"))
+	default:
+		setBody(resp, body)
+	}
+	return nil
+}
+
+func (g *dpopGuard) reject(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, dpop.ErrMissingProof) && !errors.Is(err, dpop.ErrInvalidProof) &&
+		!errors.Is(err, dpop.ErrReplay) && !errors.Is(err, dpop.ErrKeyMismatch) {
+		g.logger.For(r.Context()).DatabaseError("failed to record DPoP proof", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrDPoPCheckUnavailable.WithCause(err))
+		return
+	}
+
+	g.logger.For(r.Context()).AuthWarning("rejected DPoP proof",
+		zap.Error(err),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+		zap.String("client_ip", clientip.FromRequest(r)),
+	)
+	audit.Report(r, audit.Entry{Action: "dpop", Decision: audit.DecisionDeny, Reason: dpopReason(err)})
+	w.Header().Set("WWW-Authenticate", dpopChallenge+`, error="invalid_dpop_proof"`)
+	apierror.Write(w, r, apierror.ErrInvalidDPoPProof.WithMessage(dpopMessage(err)).WithCause(err))
+}
+
+// requestURL is the URL the client called, which the proof's htu must match.
+func (g *dpopGuard) requestURL(r *http.Request) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawPath: r.URL.RawPath}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if g.publicURL != nil {
+		u.Scheme = g.publicURL.Scheme
+		u.Host = g.publicURL.Host
+	}
+	return u.String()
+}
+
+// tokenSessionID reads the session_id claim without verifying the token.
+// That's enough to find the binding: a token with an edited claim fails
+// GoTrue's signature check anyway.
+func tokenSessionID(token string) string {
+	claims, err := jwt.UnverifiedClaims(token)
+	if err != nil {
+		return ""
+	}
+	return claims.SessionID
+}
+
+// setTokenType overwrites token_type in a /token response body.
+func setTokenType(body []byte, tokenType string) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	fields["token_type"], _ = json.Marshal(tokenType)
+
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return updated
+}
+
+// dpopReason names a proof error for the audit log.
+func dpopReason(err error) string {
+	switch {
+	case errors.Is(err, dpop.ErrMissingProof):
+		return "dpop_proof_missing"
+	case errors.Is(err, dpop.ErrReplay):
+		return "dpop_proof_replayed"
+	case errors.Is(err, dpop.ErrKeyMismatch):
+		return "dpop_key_mismatch"
+	default:
+		return "dpop_proof_invalid"
+	}
+}
+
+// dpopMessage tells the client what was wrong with its proof. Validation
+// details stay in the debug detail.
+func dpopMessage(err error) string {
+	switch {
+	case errors.Is(err, dpop.ErrMissingProof):
+		return "A DPoP proof is required for this session"
+	case errors.Is(err, dpop.ErrReplay):
+		return "DPoP proof has already been used"
+	case errors.Is(err, dpop.ErrKeyMismatch):
+		return "This session is bound to a different DPoP key"
+	default:
+		return "Invalid DPoP proof"
+	}
+}
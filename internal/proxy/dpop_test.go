@@ -0,0 +1,270 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/dpop"
+	"github.com/kacy/auth-proxy/internal/events"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func b64JSON(v any) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// testAccessToken is an unsigned token carrying a session ID; the proxy only
+// reads the claim and leaves signature checks to GoTrue.
+func testAccessToken(sessionID string, n int32) string {
+	return b64JSON(map[string]string{"alg": "HS256"}) + "." +
+		b64JSON(map[string]any{"session_id": sessionID, "n": n}) + ".sig"
+}
+
+var proofCounter atomic.Int64
+
+func signTestProof(key *ecdsa.PrivateKey, method, htu, accessToken string) string {
+	header := map[string]any{
+		"typ": "dpop+jwt",
+		"alg": "ES256",
+		"jwk": map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		},
+	}
+	claims := map[string]any{
+		"jti": fmt.Sprintf("proof-%d", proofCounter.Add(1)),
+		"htm": method,
+		"htu": htu,
+		"iat": time.Now().Unix(),
+	}
+	if accessToken != "" {
+		claims["ath"] = dpop.TokenHash(accessToken)
+	}
+
+	input := b64JSON(header) + "." + b64JSON(claims)
+	digest := sha256.Sum256([]byte(input))
+	r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newDPoPTestProxy(t *testing.T) (*Proxy, *atomic.Value) {
+	t.Helper()
+	var grants atomic.Int32
+	var upstreamHeaders atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeaders.Store(r.Header.Clone())
+		switch r.URL.Path {
+		case "/auth/v1/token":
+			n := grants.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":%q,"token_type":"bearer","refresh_token":"refresh-%d","expires_in":3600,"user":{"id":"user-1"}}`,
+				testAccessToken("session-1", n), n)
+		default:
+			w.Write([]byte(`{"id":"user-1"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	s := store.NewMemory()
+	t.Cleanup(func() { s.Close() })
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{
+		TargetURL: server.URL,
+		AnonKey:   "anon",
+		DPoP:      DPoPConfig{Enabled: true, Store: s},
+	}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return p, &upstreamHeaders
+}
+
+func dpopGrant(t *testing.T, p *Proxy, proof string) (*httptest.ResponseRecorder, map[string]any) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=refresh_token", strings.NewReader(`{}`))
+	if proof != "" {
+		req.Header.Set(dpop.Header, proof)
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	var body map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return rec, body
+}
+
+func TestDPoPBindsSession(t *testing.T) {
+	p, upstreamHeaders := newDPoPTestProxy(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	thief, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	const tokenURL = "http://example.com/token"
+	const userURL = "http://example.com/user"
+
+	rec, body := dpopGrant(t, p, signTestProof(key, http.MethodPost, tokenURL, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("grant status = %d, body %s", rec.Code, rec.Body)
+	}
+	if body["token_type"] != "DPoP" {
+		t.Errorf("token_type = %v, want DPoP", body["token_type"])
+	}
+	token := body["access_token"].(string)
+
+	getUser := func(scheme, proof string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Set("Authorization", scheme+" "+token)
+		if proof != "" {
+			req.Header.Set(dpop.Header, proof)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec
+	}
+
+	valid := signTestProof(key, http.MethodGet, userURL, token)
+	tests := []struct {
+		name   string
+		scheme string
+		proof  string
+		status int
+	}{
+		{"no proof", "Bearer", "", http.StatusUnauthorized},
+		{"proof from another key", "DPoP", signTestProof(thief, http.MethodGet, userURL, token), http.StatusUnauthorized},
+		{"proof for another URL", "DPoP", signTestProof(key, http.MethodGet, "http://example.com/factors", token), http.StatusUnauthorized},
+		{"proof without ath", "DPoP", signTestProof(key, http.MethodGet, userURL, ""), http.StatusUnauthorized},
+		{"valid proof", "DPoP", valid, http.StatusOK},
+		{"replayed proof", "DPoP", valid, http.StatusUnauthorized},
+		{"bearer scheme with proof", "Bearer", signTestProof(key, http.MethodGet, userURL, token), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getUser(tt.scheme, tt.proof)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "DPoP") {
+				t.Errorf("WWW-Authenticate = %q, want a DPoP challenge", rec.Header().Get("WWW-Authenticate"))
+			}
+			if rec.Code == http.StatusOK {
+				h := upstreamHeaders.Load().(http.Header)
+				if got := h.Get("Authorization"); got != "Bearer "+token {
+					t.Errorf("upstream Authorization = %q, want the bearer token", got)
+				}
+				if h.Get(dpop.Header) != "" {
+					t.Error("DPoP header was forwarded upstream")
+				}
+			}
+		})
+	}
+}
+
+func TestDPoPRefreshRequiresBoundKey(t *testing.T) {
+	p, _ := newDPoPTestProxy(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	thief, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	const tokenURL = "http://example.com/token"
+
+	if rec, _ := dpopGrant(t, p, signTestProof(key, http.MethodPost, tokenURL, "")); rec.Code != http.StatusOK {
+		t.Fatalf("initial grant status = %d", rec.Code)
+	}
+
+	if rec, body := dpopGrant(t, p, ""); rec.Code != http.StatusUnauthorized || body["access_token"] != nil {
+		t.Errorf("refresh without proof: status = %d, body %v", rec.Code, body)
+	}
+	if rec, body := dpopGrant(t, p, signTestProof(thief, http.MethodPost, tokenURL, "")); rec.Code != http.StatusUnauthorized || body["access_token"] != nil {
+		t.Errorf("refresh with another key: status = %d, body %v", rec.Code, body)
+	}
+	if rec, _ := dpopGrant(t, p, signTestProof(key, http.MethodPost, tokenURL, "")); rec.Code != http.StatusOK {
+		t.Errorf("refresh with bound key: status = %d", rec.Code)
+	}
+}
+
+func TestDPoPUnboundTokensPassThrough(t *testing.T) {
+	p, _ := newDPoPTestProxy(t)
+
+	rec, body := dpopGrant(t, p, "")
+	if rec.Code != http.StatusOK || body["token_type"] != "bearer" {
+		t.Fatalf("grant without proof: status = %d, token_type = %v", rec.Code, body["token_type"])
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Authorization", "Bearer "+body["access_token"].(string))
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("unbound request status = %d, want 200", rec.Code)
+	}
+}
+
+func TestDPoPRejectedGrantIsNotReportedAsLogin(t *testing.T) {
+	p, _ := newDPoPTestProxy(t)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	thief, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	const tokenURL = "http://example.com/token"
+
+	var received []events.Event
+	var mu sync.Mutex
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e events.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		mu.Lock()
+		received = append(received, e)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	logger, _ := logging.New("error", false)
+	dispatcher, err := events.New(events.Config{Endpoints: []events.Endpoint{{URL: hook.URL}}, Secret: "secret", Workers: 1}, nil, logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+	p.events = &authEvents{dispatcher: dispatcher}
+
+	signIn := func(proof string) int {
+		req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", strings.NewReader(`{"email":"a@example.com","password":"x"}`))
+		req.Header.Set(dpop.Header, proof)
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := signIn(signTestProof(key, http.MethodPost, tokenURL, "")); code != http.StatusOK {
+		t.Fatalf("sign-in with key status = %d", code)
+	}
+	if code := signIn(signTestProof(thief, http.MethodPost, tokenURL, "")); code != http.StatusUnauthorized {
+		t.Fatalf("sign-in with another key status = %d, want 401", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dispatcher.Close(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0].Type != events.TypeLogin || received[1].Type != events.TypeLoginFailed {
+		t.Fatalf("events = %+v, want a login then a failed login", received)
+	}
+	if received[1].Reason != "invalid_dpop_proof" {
+		t.Errorf("failed login reason = %q, want invalid_dpop_proof", received[1].Reason)
+	}
+}
@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"go.uber.org/zap"
+)
+
+// DefaultEnumerationRoutes are the endpoints whose responses reveal whether an
+// account exists.
+var DefaultEnumerationRoutes = []string{
+	"/auth/v1/recover",
+	"/auth/v1/otp",
+	"/auth/v1/signup",
+}
+
+// EnumerationConfig holds configuration for anti-enumeration mode.
+type EnumerationConfig struct {
+	Enabled bool
+	// Routes are the path prefixes whose responses are normalized.
+	Routes []string
+	// MinLatency is the floor every response on those routes is padded to,
+	// so a fast "no such user" can't be told apart from a slow email send.
+	MinLatency time.Duration
+	// Jitter adds up to this much random delay on top of MinLatency.
+	Jitter time.Duration
+}
+
+// enumerationCodes are the GoTrue error codes that only happen for an
+// existing (or missing) account. Validation errors and rate limits are
+// returned as-is since they don't depend on the account. /otp answers an
+// unknown address with otp_disabled when create_user is false, and with
+// signup_disabled when signups are off, while a known one gets a code.
+var enumerationCodes = map[string]bool{
+	"otp_disabled":        true,
+	"signup_disabled":     true,
+	"user_already_exists": true,
+	"email_exists":        true,
+	"phone_exists":        true,
+	"user_not_found":      true,
+	"email_not_confirmed": true,
+	"phone_not_confirmed": true,
+	"user_banned":         true,
+}
+
+// uniformBody is the single success response clients see on normalized routes.
+var uniformBody = []byte(`{}`)
+
+type requestStartKey struct{}
+
+// enumerationGuard makes recover, OTP and signup responses look the same
+// whether or not the account exists.
+type enumerationGuard struct {
+	routes     []string
+	minLatency time.Duration
+	jitter     time.Duration
+	// signOut ends sessions GoTrue issued on an autoconfirm signup, which
+	// are withheld from the client
+	signOut func(ctx context.Context, accessToken string)
+	logger  *logging.Logger
+}
+
+func newEnumerationGuard(cfg EnumerationConfig, logger *logging.Logger) *enumerationGuard {
+	routes := cfg.Routes
+	if len(routes) == 0 {
+		routes = DefaultEnumerationRoutes
+	}
+	return &enumerationGuard{
+		routes:     routes,
+		minLatency: cfg.MinLatency,
+		jitter:     cfg.Jitter,
+		logger:     logger,
+	}
+}
+
+// applies reports whether requests to path are normalized.
+func (g *enumerationGuard) applies(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	path := upstreamPath(r.URL.Path)
+	for _, route := range g.routes {
+		route = strings.TrimSuffix(upstreamPath(route), "/")
+		if path == route || strings.HasPrefix(path, route+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// start records when the request arrived so the response can be padded. A
+// start recorded earlier, by Middleware, is kept.
+func (g *enumerationGuard) start(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(requestStartKey{}).(time.Time); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestStartKey{}, time.Now()))
+}
+
+// handleResponse rewrites account-dependent outcomes into the uniform success
+// response and pads latency to the configured floor.
+func (g *enumerationGuard) handleResponse(resp *http.Response) error {
+	start, ok := resp.Request.Context().Value(requestStartKey{}).(time.Time)
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		g.normalize(resp, "")
+	case resp.StatusCode >= 200 && resp.StatusCode < 500:
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		var outcome struct {
+			ErrorCode   string `json:"error_code"`
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(body, &outcome)
+
+		switch {
+		// An autoconfirm signup returns a session only for a new account.
+		// It's withheld and ended, so the client signs in with /token
+		// like it would for an existing account
+		case resp.StatusCode < 300 && outcome.AccessToken != "":
+			if g.signOut != nil {
+				g.signOut(resp.Request.Context(), outcome.AccessToken)
+			}
+			g.normalize(resp, "")
+		case resp.StatusCode < 300:
+			g.normalize(resp, "")
+		case resp.StatusCode >= 400 && enumerationCodes[outcome.ErrorCode]:
+			g.normalize(resp, outcome.ErrorCode)
+		default:
+			setBody(resp, body)
+		}
+	}
+
+	g.pad(resp.Request.Context(), start)
+	return nil
+}
+
+func (g *enumerationGuard) normalize(resp *http.Response, errorCode string) {
+	// The real outcome only goes to our logs
+	g.logger.For(resp.Request.Context()).Response("normalized response to prevent account enumeration",
+		zap.String("path", resp.Request.URL.Path),
+		zap.Int("upstream_status", resp.StatusCode),
+		zap.String("upstream_error_code", errorCode),
+	)
+
+	resp.Body.Close()
+	resp.StatusCode = http.StatusOK
+	resp.Status = "200 OK"
+	resp.Header.Set("Content-Type", "application/json")
+	setBody(resp, bytes.Clone(uniformBody))
+}
+
+// Middleware normalizes account-dependent errors the proxy writes itself
+// before the request reaches GoTrue, such as the signup policy's email_exists
+// for an alias of a registered address. Other responses pass through.
+func (g *enumerationGuard) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !g.applies(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		r = g.start(r)
+
+		ew := &enumerationWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if !ew.held {
+			return
+		}
+
+		var outcome struct {
+			ErrorCode string `json:"error_code"`
+		}
+		json.Unmarshal(ew.body.Bytes(), &outcome)
+		if !enumerationCodes[outcome.ErrorCode] {
+			w.WriteHeader(ew.status)
+			w.Write(ew.body.Bytes())
+			return
+		}
+
+		g.logger.For(r.Context()).Response("normalized response to prevent account enumeration",
+			zap.String("path", r.URL.Path),
+			zap.Int("proxy_status", ew.status),
+			zap.String("proxy_error_code", outcome.ErrorCode),
+		)
+		g.pad(r.Context(), r.Context().Value(requestStartKey{}).(time.Time))
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(uniformBody)
+	})
+}
+
+// enumerationWriter holds back error responses so Middleware can check them.
+type enumerationWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	held        bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (w *enumerationWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code >= 400 {
+		w.held = true
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *enumerationWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.held {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// pad sleeps until the latency floor plus jitter has passed since start.
+func (g *enumerationGuard) pad(ctx context.Context, start time.Time) {
+	target := g.minLatency
+	if g.jitter > 0 {
+		target += rand.N(g.jitter)
+	}
+
+	wait := time.Until(start.Add(target))
+	if wait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
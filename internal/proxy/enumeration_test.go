@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+// signedOut records access tokens enumerableGoTrue was asked to sign out.
+var signedOut atomic.Value
+
+// enumerableGoTrue answers differently for known and unknown accounts, like
+// GoTrue can.
+func enumerableGoTrue(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/auth/v1/logout" {
+		signedOut.Store(r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case req.Email == "invalid":
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":400,"error_code":"validation_failed","msg":"invalid email"}`))
+	case r.URL.Path == "/auth/v1/signup" && req.Email == "known@example.com":
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"code":422,"error_code":"user_already_exists","msg":"User already registered"}`))
+	case r.URL.Path == "/auth/v1/signup" && req.Email == "auto@example.com":
+		w.Write([]byte(`{"access_token":"a","refresh_token":"r","user":{"id":"u"}}`))
+	case r.URL.Path == "/auth/v1/signup":
+		w.Write([]byte(`{"id":"new-user","email":"` + req.Email + `"}`))
+	case r.URL.Path == "/auth/v1/otp" && req.Email == "no-create@example.com":
+		// create_user=false for an unknown address
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"code":422,"error_code":"otp_disabled","msg":"Signups not allowed for otp"}`))
+	case r.URL.Path == "/auth/v1/otp" && req.Email == "signups-off@example.com":
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"code":422,"error_code":"signup_disabled","msg":"Signups not allowed for this instance"}`))
+	case req.Email == "known@example.com":
+		w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"code":404,"error_code":"user_not_found","msg":"User not found"}`))
+	}
+}
+
+func newEnumerationTestProxy(t *testing.T, cfg EnumerationConfig) *Proxy {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(enumerableGoTrue))
+	t.Cleanup(upstream.Close)
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{
+		TargetURL:   upstream.URL,
+		AnonKey:     "anon",
+		Enumeration: cfg,
+	}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return p
+}
+
+func post(p *Proxy, path, email string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"email":"`+email+`"}`))
+	p.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestEnumerationNormalizesResponses(t *testing.T) {
+	p := newEnumerationTestProxy(t, EnumerationConfig{Enabled: true})
+
+	tests := []struct {
+		name   string
+		path   string
+		email  string
+		status int
+		body   string
+	}{
+		{"recover known", "/recover", "known@example.com", http.StatusOK, "{}"},
+		{"recover unknown", "/recover", "unknown@example.com", http.StatusOK, "{}"},
+		{"otp unknown", "/auth/v1/otp", "unknown@example.com", http.StatusOK, "{}"},
+		{"otp unknown without create_user", "/auth/v1/otp", "no-create@example.com", http.StatusOK, "{}"},
+		{"otp unknown with signups off", "/auth/v1/otp", "signups-off@example.com", http.StatusOK, "{}"},
+		{"signup new", "/signup", "new@example.com", http.StatusOK, "{}"},
+		{"signup existing", "/signup", "known@example.com", http.StatusOK, "{}"},
+		{"validation error kept", "/recover", "invalid", http.StatusBadRequest, ""},
+		{"autoconfirm session withheld", "/signup", "auto@example.com", http.StatusOK, "{}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(p, tt.path, tt.email)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestEnumerationSignsOutWithheldSessions(t *testing.T) {
+	p := newEnumerationTestProxy(t, EnumerationConfig{Enabled: true})
+	signedOut.Store("")
+
+	post(p, "/signup", "auto@example.com")
+	if got := signedOut.Load(); got != "Bearer a" {
+		t.Errorf("signed out %q, want the autoconfirm session", got)
+	}
+}
+
+func TestEnumerationMiddleware(t *testing.T) {
+	p := newEnumerationTestProxy(t, EnumerationConfig{Enabled: true})
+
+	tests := []struct {
+		name   string
+		path   string
+		err    *apierror.Error
+		status int
+		body   string
+	}{
+		{"alias of an existing account", "/signup", apierror.ErrEmailExists, http.StatusOK, "{}"},
+		{"policy error kept", "/signup", apierror.ErrEmailAddressNotAuthorized, http.StatusUnprocessableEntity, ""},
+		{"other route untouched", "/token", apierror.ErrEmailExists, http.StatusUnprocessableEntity, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := p.EnumerationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				apierror.Write(w, r, tt.err)
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{}`)))
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if tt.body == "" && !strings.Contains(rec.Body.String(), tt.err.Code) {
+				t.Errorf("body = %q, want the original error", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestEnumerationRoutesAreOptIn(t *testing.T) {
+	p := newEnumerationTestProxy(t, EnumerationConfig{
+		Enabled: true,
+		Routes:  []string{"/auth/v1/recover"},
+	})
+
+	if rec := post(p, "/recover", "unknown@example.com"); rec.Code != http.StatusOK {
+		t.Errorf("recover status = %d, want normalized 200", rec.Code)
+	}
+	if rec := post(p, "/signup", "known@example.com"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("signup status = %d, want untouched 422", rec.Code)
+	}
+}
+
+func TestEnumerationPadsLatency(t *testing.T) {
+	p := newEnumerationTestProxy(t, EnumerationConfig{
+		Enabled:    true,
+		MinLatency: 50 * time.Millisecond,
+		Jitter:     10 * time.Millisecond,
+	})
+
+	start := time.Now()
+	post(p, "/recover", "unknown@example.com")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("response took %v, want at least the 50ms floor", elapsed)
+	}
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/events"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/middleware"
+)
+
+// maxLoginBody bounds the login bodies read for the attempted identifier.
+const maxLoginBody = 64 << 10
+
+// eventUser is the user object in signup, token and verify responses. Signup
+// without auto-confirm returns it bare, everything else nests it in a session.
+type eventUser struct {
+	ID          string `json:"id"`
+	Email       string `json:"email"`
+	Phone       string `json:"phone"`
+	AppMetadata struct {
+		Provider string `json:"provider"`
+	} `json:"app_metadata"`
+}
+
+type loginAttemptKey struct{}
+
+// loginAttempt is who a password grant tried to sign in as, kept so a failed
+// login can say which account was targeted.
+type loginAttempt struct {
+	email string
+	phone string
+}
+
+// authEvents turns upstream auth outcomes into webhook events.
+type authEvents struct {
+	dispatcher *events.Dispatcher
+}
+
+// start remembers the identifier of a sign-in attempt.
+func (ae *authEvents) start(r *http.Request) *http.Request {
+	if r.Method != http.MethodPost || !strings.HasPrefix(upstreamPath(r.URL.Path), "/auth/v1/token") ||
+		r.URL.Query().Get("grant_type") == "refresh_token" || r.Body == nil {
+		return r
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxLoginBody))
+	r.Body.Close()
+	setRequestBody(r, body)
+	if err != nil {
+		return r
+	}
+
+	var fields struct {
+		Email string `json:"email"`
+		Phone string `json:"phone"`
+	}
+	if json.Unmarshal(body, &fields) != nil {
+		return r
+	}
+	attempt := loginAttempt{email: fields.Email, phone: fields.Phone}
+	return r.WithContext(context.WithValue(r.Context(), loginAttemptKey{}, attempt))
+}
+
+// handleResponse emits signup, login, failed login and logout events.
+func (ae *authEvents) handleResponse(resp *http.Response) error {
+	r := resp.Request
+	path := r.URL.Path
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+
+	switch {
+	case strings.HasPrefix(path, "/auth/v1/logout"):
+		if ok {
+			ae.emitLogout(r)
+		}
+		return nil
+	case strings.HasPrefix(path, "/auth/v1/signup"):
+		if ok {
+			return ae.emitUser(resp, events.TypeSignup)
+		}
+	case strings.HasPrefix(path, "/auth/v1/token"):
+		if r.URL.Query().Get("grant_type") == "refresh_token" {
+			return nil
+		}
+		if ok {
+			return ae.emitUser(resp, events.TypeLogin)
+		}
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			return ae.emitLoginFailed(resp)
+		}
+	case strings.HasPrefix(path, "/auth/v1/verify"):
+		// Magic links and OTPs; redirects carry no body and are skipped
+		if ok {
+			return ae.emitUser(resp, events.TypeLogin)
+		}
+	}
+	return nil
+}
+
+func (ae *authEvents) emitUser(resp *http.Response, t events.Type) error {
+	body, err := readJSONBody(resp)
+	if err != nil || body == nil {
+		return err
+	}
+
+	var parsed struct {
+		eventUser
+		User *eventUser `json:"user"`
+	}
+	if json.Unmarshal(body, &parsed) != nil {
+		return nil
+	}
+	user := parsed.User
+	if user == nil {
+		user = &parsed.eventUser
+	}
+	if user.ID == "" {
+		return nil
+	}
+
+	e := newEvent(resp.Request, t)
+	e.UserID = user.ID
+	e.Email = user.Email
+	e.Phone = user.Phone
+	e.Provider = user.AppMetadata.Provider
+	if e.Provider == "" && t == events.TypeLogin {
+		e.Provider = resp.Request.URL.Query().Get("grant_type")
+	}
+	ae.dispatcher.Emit(e)
+	return nil
+}
+
+func (ae *authEvents) emitLoginFailed(resp *http.Response) error {
+	e := newEvent(resp.Request, events.TypeLoginFailed)
+	e.Provider = resp.Request.URL.Query().Get("grant_type")
+	if attempt, ok := resp.Request.Context().Value(loginAttemptKey{}).(loginAttempt); ok {
+		e.Email = attempt.email
+		e.Phone = attempt.phone
+	}
+
+	body, err := readJSONBody(resp)
+	if err != nil {
+		return err
+	}
+	// GoTrue's current error shape, then the OAuth one older versions use
+	var gotrueErr struct {
+		ErrorCode string `json:"error_code"`
+		Error     string `json:"error"`
+	}
+	if body != nil && json.Unmarshal(body, &gotrueErr) == nil {
+		e.Reason = gotrueErr.ErrorCode
+		if e.Reason == "" {
+			e.Reason = gotrueErr.Error
+		}
+	}
+
+	ae.dispatcher.Emit(e)
+	return nil
+}
+
+func (ae *authEvents) emitLogout(r *http.Request) {
+	token, ok := jwt.BearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		return
+	}
+	claims, err := jwt.UnverifiedClaims(token)
+	if err != nil {
+		return
+	}
+
+	e := newEvent(r, events.TypeLogout)
+	e.UserID = claims.Subject
+	e.Email = claims.Email
+	ae.dispatcher.Emit(e)
+}
+
+func newEvent(r *http.Request, t events.Type) events.Event {
+	return events.Event{
+		Type:      t,
+		Platform:  r.Header.Get(middleware.PlatformHeader),
+		ClientIP:  clientip.FromRequest(r),
+		UserAgent: r.Header.Get("User-Agent"),
+		Path:      r.URL.Path,
+	}
+}
+
+// readJSONBody reads a JSON response body and puts it back. It returns nil
+// for anything that isn't JSON.
+func readJSONBody(resp *http.Response) ([]byte, error) {
+	if resp.Body == nil {
+		return nil, nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	setBody(resp, body)
+	return body, nil
+}
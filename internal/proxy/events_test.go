@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/events"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/middleware"
+)
+
+func TestAuthEvents(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/auth/v1/signup":
+			w.Write([]byte(`{"id":"user-1","email":"new@example.com","app_metadata":{"provider":"email"}}`))
+		case r.URL.Path == "/auth/v1/token" && strings.Contains(r.URL.RawQuery, "refresh_token"):
+			w.Write([]byte(`{"access_token":"a","refresh_token":"r","user":{"id":"user-1"}}`))
+		case r.URL.Path == "/auth/v1/token" && strings.Contains(r.URL.RawQuery, "password"):
+			var creds struct{ Password string }
+			json.NewDecoder(r.Body).Decode(&creds)
+			if creds.Password != "right" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"code":400,"error_code":"invalid_credentials","msg":"Invalid login credentials"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"a","refresh_token":"r","user":{"id":"user-1","email":"new@example.com","app_metadata":{"provider":"email"}}}`))
+		case r.URL.Path == "/auth/v1/logout":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer upstream.Close()
+
+	var mu sync.Mutex
+	var received []events.Event
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e events.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		mu.Lock()
+		received = append(received, e)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	logger, _ := logging.New("error", false)
+	dispatcher, err := events.New(events.Config{
+		Endpoints: []events.Endpoint{{URL: hook.URL}},
+		Secret:    "secret",
+		// A single worker delivers in the order events were emitted
+		Workers: 1,
+	}, nil, logger)
+	if err != nil {
+		t.Fatalf("events.New() error = %v", err)
+	}
+
+	p, err := New(Config{TargetURL: upstream.URL, AnonKey: "anon", Events: dispatcher}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	logout := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	logout.Header.Set("Authorization", "Bearer "+b64JSON(map[string]string{"alg": "HS256"})+"."+
+		b64JSON(map[string]any{"sub": "user-1", "email": "new@example.com"})+".sig")
+
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(`{"email":"new@example.com","password":"right"}`)),
+		httptest.NewRequest(http.MethodPost, "/token?grant_type=password", strings.NewReader(`{"email":"new@example.com","password":"wrong"}`)),
+		httptest.NewRequest(http.MethodPost, "/token?grant_type=password", strings.NewReader(`{"email":"new@example.com","password":"right"}`)),
+		// Refreshes aren't sign-ins and emit nothing
+		httptest.NewRequest(http.MethodPost, "/token?grant_type=refresh_token", strings.NewReader(`{"refresh_token":"r"}`)),
+		logout,
+	}
+	for _, req := range requests {
+		req.Header.Set(middleware.PlatformHeader, "ios")
+		p.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dispatcher.Close(ctx)
+
+	want := []events.Event{
+		{Type: events.TypeSignup, UserID: "user-1", Email: "new@example.com", Provider: "email"},
+		{Type: events.TypeLoginFailed, Email: "new@example.com", Provider: "password", Reason: "invalid_credentials"},
+		{Type: events.TypeLogin, UserID: "user-1", Email: "new@example.com", Provider: "email"},
+		{Type: events.TypeLogout, UserID: "user-1", Email: "new@example.com"},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != len(want) {
+		t.Fatalf("received %d events, want %d: %+v", len(received), len(want), received)
+	}
+	for i, w := range want {
+		got := received[i]
+		if got.Type != w.Type || got.UserID != w.UserID || got.Email != w.Email ||
+			got.Provider != w.Provider || got.Reason != w.Reason {
+			t.Errorf("event %d = %+v, want %+v", i, got, w)
+		}
+		if got.Platform != "ios" {
+			t.Errorf("event %d platform = %q, want ios", i, got.Platform)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -11,8 +12,15 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/devices"
+	"github.com/kacy/auth-proxy/internal/events"
 	"github.com/kacy/auth-proxy/internal/logging"
 	"github.com/kacy/auth-proxy/internal/metrics"
+	"github.com/kacy/auth-proxy/internal/revocation"
+	"github.com/kacy/auth-proxy/internal/tokenwrap"
 	"go.uber.org/zap"
 )
 
@@ -22,6 +30,43 @@ type Config struct {
 	AnonKey        string
 	Timeout        time.Duration
 	MaxRequestBody int64
+
+	// PublicURL is the externally visible base URL of the proxy. When set,
+	// upstream Location headers, redirect_to parameters and cookie domains
+	// are rewritten to it.
+	PublicURL string
+	// RedirectAllowList restricts the redirect_to values clients may send.
+	RedirectAllowList []string
+
+	// Sessions enables backend-for-frontend cookie sessions for web clients.
+	Sessions SessionConfig
+
+	// Enumeration normalizes responses that reveal whether an account exists.
+	Enumeration EnumerationConfig
+
+	// DPoP binds sessions to a client-held key (RFC 9449).
+	DPoP DPoPConfig
+
+	// RefreshTokens, if set, replaces refresh tokens in responses with
+	// device-bound handles and unwraps them on refresh grants.
+	RefreshTokens *tokenwrap.Wrapper
+
+	// Devices, if set, binds attested device keys to the users that sign in
+	// on them and enforces the device policy.
+	Devices *devices.Registry
+
+	// Revocations, if set, records sessions ended by logout and stops revoked
+	// sessions from being refreshed. Access tokens are checked against it by
+	// middleware.
+	Revocations *revocation.List
+
+	// Events, if set, receives signup, login, failed login and logout events.
+	Events *events.Dispatcher
+
+	// Middleware wraps the upstream call, first entry outermost. It runs after
+	// cookie sessions have been turned into a bearer token, so checks on the
+	// access token apply to web clients too.
+	Middleware []func(http.Handler) http.Handler
 }
 
 // Proxy handles reverse proxying requests to Supabase Auth.
@@ -29,8 +74,19 @@ type Proxy struct {
 	config  Config
 	target  *url.URL
 	proxy   *httputil.ReverseProxy
+	handler http.Handler
+	client  *http.Client
 	logger  *logging.Logger
 	metrics *metrics.Metrics
+
+	redirects   *redirectRewriter
+	sessions    *sessionManager
+	enumeration *enumerationGuard
+	dpop        *dpopGuard
+	refresh     *refreshWrapper
+	devices     *deviceBinder
+	revocations *revocationRecorder
+	events      *authEvents
 }
 
 // New creates a new HTTP reverse proxy.
@@ -47,23 +103,133 @@ func New(cfg Config, logger *logging.Logger, m *metrics.Metrics) (*Proxy, error)
 		metrics: m,
 	}
 
+	if cfg.PublicURL != "" {
+		p.redirects, err = newRedirectRewriter(target, cfg.PublicURL, cfg.RedirectAllowList)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Enumeration.Enabled {
+		p.enumeration = newEnumerationGuard(cfg.Enumeration, logger)
+	}
+
+	if cfg.DPoP.Enabled {
+		p.dpop, err = newDPoPGuard(cfg.DPoP, cfg.PublicURL, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.RefreshTokens != nil {
+		p.refresh = &refreshWrapper{wrapper: cfg.RefreshTokens, logger: logger}
+	}
+
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	upstream := newUpstreamTransport(transport, m)
+
 	p.proxy = &httputil.ReverseProxy{
 		Director:       p.director,
 		ModifyResponse: p.modifyResponse,
 		ErrorHandler:   p.errorHandler,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 100,
-			IdleConnTimeout:     90 * time.Second,
-		},
+		Transport:      upstream,
+	}
+
+	p.handler = p.proxy
+	for i := len(cfg.Middleware) - 1; i >= 0; i-- {
+		p.handler = cfg.Middleware[i](p.handler)
+	}
+
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	p.client = &http.Client{Transport: upstream, Timeout: timeout}
+
+	if p.enumeration != nil {
+		p.enumeration.signOut = p.signOut
+	}
+
+	if cfg.Revocations != nil {
+		p.revocations = &revocationRecorder{list: cfg.Revocations, signOut: p.signOut, logger: logger}
+	}
+
+	if cfg.Events != nil {
+		p.events = &authEvents{dispatcher: cfg.Events}
+	}
+
+	if cfg.Devices != nil {
+		p.devices = &deviceBinder{registry: cfg.Devices, signOut: p.signOut, logger: logger, metrics: m}
+	}
+
+	if cfg.Sessions.Enabled {
+		p.sessions, err = newSessionManager(cfg.Sessions, target, cfg.AnonKey, p.client, logger)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return p, nil
 }
 
+// EnumerationMiddleware normalizes account-dependent errors that handlers in
+// front of the proxy write, so they don't undo anti-enumeration mode. It
+// passes requests through unchanged when the mode is off.
+func (p *Proxy) EnumerationMiddleware(next http.Handler) http.Handler {
+	if p.enumeration == nil {
+		return next
+	}
+	return p.enumeration.Middleware(next)
+}
+
 // ServeHTTP implements http.Handler.
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p.proxy.ServeHTTP(w, r)
+	if p.redirects != nil {
+		if target := r.URL.Query().Get("redirect_to"); target != "" && !p.redirects.isAllowed(target) {
+			p.logger.For(r.Context()).AuthWarning("rejected redirect_to outside allowlist",
+				zap.String("path", r.URL.Path),
+				zap.String("redirect_to", target),
+			)
+			apierror.Write(w, r, apierror.ErrInvalidRedirect)
+			return
+		}
+	}
+
+	if p.sessions != nil {
+		var ok bool
+		if r, ok = p.sessions.attach(w, r); !ok {
+			return
+		}
+	}
+
+	if p.dpop != nil {
+		var ok bool
+		if r, ok = p.dpop.check(w, r); !ok {
+			return
+		}
+	}
+
+	if p.refresh != nil {
+		var ok bool
+		if r, ok = p.refresh.unwrapRequest(w, r); !ok {
+			return
+		}
+	}
+
+	if p.events != nil {
+		r = p.events.start(r)
+	}
+
+	if p.enumeration != nil && p.enumeration.applies(r) {
+		r = p.enumeration.start(r)
+	}
+
+	p.handler.ServeHTTP(w, r)
 }
 
 // director modifies the request before forwarding to the target.
@@ -77,9 +243,7 @@ func (p *Proxy) director(req *http.Request) {
 	req.Host = p.target.Host
 
 	// Ensure path starts with /auth/v1 for Supabase Auth API
-	if !strings.HasPrefix(req.URL.Path, "/auth/v1") {
-		req.URL.Path = "/auth/v1" + req.URL.Path
-	}
+	req.URL.Path = upstreamPath(req.URL.Path)
 
 	// Add required Supabase headers
 	req.Header.Set("apikey", p.config.AnonKey)
@@ -90,13 +254,25 @@ func (p *Proxy) director(req *http.Request) {
 	// Remove hop-by-hop headers
 	removeHopByHopHeaders(req.Header)
 
-	p.logger.Request("proxying request to Supabase",
+	// Forward the sanitized client chain so GoTrue's per-IP limits see the
+	// real client rather than the proxy
+	setForwardedFor(req)
+
+	p.logger.For(req.Context()).Request("proxying request to Supabase",
 		zap.String("original_path", originalPath),
 		zap.String("target_path", req.URL.Path),
 		zap.String("method", req.Method),
 	)
 }
 
+// upstreamPath maps a client path onto the Supabase Auth API path.
+func upstreamPath(path string) string {
+	if strings.HasPrefix(path, "/auth/v1") {
+		return path
+	}
+	return "/auth/v1" + path
+}
+
 // authResponse represents a Supabase auth response with user info.
 type authResponse struct {
 	User *struct {
@@ -132,10 +308,15 @@ func (p *Proxy) modifyResponse(resp *http.Response) error {
 	// Remove hop-by-hop headers from response
 	removeHopByHopHeaders(resp.Header)
 
+	// Keep the upstream host out of redirects and cookies
+	if p.redirects != nil {
+		p.redirects.rewriteResponse(resp.Header)
+	}
+
 	path := resp.Request.URL.Path
 
 	// Log response status
-	p.logger.Response("received response from Supabase",
+	p.logger.For(resp.Request.Context()).Response("received response from Supabase",
 		zap.Int("status", resp.StatusCode),
 		zap.String("path", path),
 	)
@@ -145,6 +326,53 @@ func (p *Proxy) modifyResponse(resp *http.Response) error {
 		p.logAuthResponse(resp)
 	}
 
+	// Before anything that acts on the issued session, so a revoked or
+	// blocked session never gets bound, and no rejected session is
+	// reported or stored
+	if p.revocations != nil {
+		if err := p.revocations.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+	if p.devices != nil {
+		if err := p.devices.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+	// Also before sessions, which strip the tokens from cookie-mode responses
+	if p.dpop != nil {
+		if err := p.dpop.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	// After the checks above, so a sign-in they block is reported as failed
+	if p.events != nil {
+		if err := p.events.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	if p.sessions != nil {
+		if err := p.sessions.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	// After sessions, which keep raw tokens server-side for cookie clients
+	if p.refresh != nil {
+		if err := p.refresh.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+
+	// Runs last so everything above sees the real upstream outcome
+	if p.enumeration != nil {
+		if err := p.enumeration.handleResponse(resp); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -157,7 +385,7 @@ func (p *Proxy) logAuthResponse(resp *http.Response) {
 	// Read the body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		p.logger.Logger.Debug("failed to read auth response body", zap.Error(err))
+		p.logger.For(resp.Request.Context()).Logger.Debug("failed to read auth response body", zap.Error(err))
 		return
 	}
 
@@ -178,7 +406,7 @@ func (p *Proxy) logAuthResponse(resp *http.Response) {
 			provider = "email"
 		}
 
-		p.logger.OAuthSuccess(
+		p.logger.For(resp.Request.Context()).OAuthSuccess(
 			provider,
 			authResp.User.Email,
 			authResp.User.ID,
@@ -189,14 +417,54 @@ func (p *Proxy) logAuthResponse(resp *http.Response) {
 
 // errorHandler handles proxy errors.
 func (p *Proxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	p.logger.NetworkError("proxy error",
+	p.logger.For(r.Context()).NetworkError("proxy error",
 		zap.Error(err),
 		zap.String("path", r.URL.Path),
 	)
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadGateway)
-	w.Write([]byte(`{"error":"upstream service unavailable","code":"bad_gateway"}`))
+	apierror.Write(w, r, apierror.ErrBadGateway.WithCause(err))
+}
+
+// signOut ends a session upstream. It's used for sessions GoTrue issued that
+// the proxy refuses to hand to the client.
+func (p *Proxy) signOut(ctx context.Context, accessToken string) {
+	logoutURL := p.target.JoinPath("/auth/v1/logout").String() + "?scope=local"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, logoutURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("apikey", p.config.AnonKey)
+	req.Header.Set("User-Agent", buildinfo.UserAgent())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.logger.For(ctx).NetworkError("failed to sign out session upstream", zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+}
+
+// setForwardedFor replaces client-supplied forwarding headers with the chain
+// verified by the client IP resolver. ReverseProxy appends the direct peer to
+// X-Forwarded-For itself, so it is left off here.
+func setForwardedFor(req *http.Request) {
+	info, ok := clientip.FromContext(req.Context())
+	if !ok {
+		return
+	}
+
+	hops := make([]string, 0, len(info.Chain))
+	for _, addr := range info.Chain[:len(info.Chain)-1] {
+		hops = append(hops, addr.String())
+	}
+
+	req.Header.Del("Forwarded")
+	req.Header.Del("X-Forwarded-For")
+	if len(hops) > 0 {
+		req.Header.Set("X-Forwarded-For", strings.Join(hops, ", "))
+	}
+	req.Header.Set("X-Real-IP", info.IP.String())
 }
 
 // hopByHopHeaders are headers that should not be forwarded.
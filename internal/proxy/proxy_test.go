@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/requestid"
+	"github.com/kacy/auth-proxy/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestForwardsSanitizedClientChain(t *testing.T) {
+	var gotXFF, gotRealIP string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotXFF = r.Header.Get("X-Forwarded-For")
+		gotRealIP = r.Header.Get("X-Real-IP")
+	}))
+	defer upstream.Close()
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{TargetURL: upstream.URL, AnonKey: "anon"}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	resolver, _ := clientip.NewResolver([]string{"10.0.0.0/8"}, "")
+	handler := resolver.Middleware(p)
+
+	req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 198.51.100.9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotXFF != "198.51.100.9, 10.0.0.1" {
+		t.Errorf("upstream X-Forwarded-For = %q, want spoofed entry dropped", gotXFF)
+	}
+	if gotRealIP != "198.51.100.9" {
+		t.Errorf("upstream X-Real-IP = %q, want %q", gotRealIP, "198.51.100.9")
+	}
+}
+
+func TestPropagatesTraceContext(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	tracing.Install(provider)
+	defer provider.Shutdown(context.Background())
+
+	var gotTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Get("Traceparent")
+	}))
+	defer upstream.Close()
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{TargetURL: upstream.URL, AnonKey: "anon"}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, root := tracing.Tracer().Start(context.Background(), "request")
+	p.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/auth/v1/settings", nil).WithContext(ctx))
+	root.End()
+
+	traceID := root.SpanContext().TraceID().String()
+	if !strings.Contains(gotTraceparent, traceID) {
+		t.Errorf("upstream traceparent = %q, want trace %s", gotTraceparent, traceID)
+	}
+	var found bool
+	for _, s := range exp.GetSpans() {
+		if s.Name == "upstream GET /auth/v1/settings" && s.Parent.SpanID() == root.SpanContext().SpanID() {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no upstream span under the request span in %d spans", len(exp.GetSpans()))
+	}
+}
+
+func TestUpstreamEndpoint(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/auth/v1/token", "/auth/v1/token"},
+		{"/auth/v1/factors/3f2a/verify", "/auth/v1/factors"},
+		{"/auth/v1/admin/users/3f2a", "/auth/v1/admin"},
+		{"/auth/v1/made-up-endpoint", "/other"},
+		{"/rest/v1/profiles", "/other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := upstreamEndpoint(tt.path); got != tt.want {
+				t.Errorf("upstreamEndpoint(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var gotID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(requestid.Header)
+	}))
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{TargetURL: upstream.URL, AnonKey: "anon"}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/auth/v1/settings", nil)
+		return req.WithContext(requestid.NewContext(req.Context(), "req-1"))
+	}
+
+	p.ServeHTTP(httptest.NewRecorder(), newRequest())
+	if gotID != "req-1" {
+		t.Errorf("upstream %s = %q, want %q", requestid.Header, gotID, "req-1")
+	}
+
+	// Upstream failures carry the ID in the error body
+	upstream.Close()
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, newRequest())
+	var body map[string]interface{}
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusBadGateway || body["error_code"] != "bad_gateway" || body["request_id"] != "req-1" {
+		t.Errorf("error response = %d %v, want 502 with request_id", rec.Code, body)
+	}
+}
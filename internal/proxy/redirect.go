@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// redirectRewriter maps upstream URLs in responses onto the proxy's public URL
+// and checks client-supplied redirect_to values against an allowlist, so the
+// proxy can't be used as an open redirector.
+type redirectRewriter struct {
+	upstream *url.URL
+	public   *url.URL
+	allowed  []*url.URL
+}
+
+func newRedirectRewriter(upstream *url.URL, publicURL string, allowList []string) (*redirectRewriter, error) {
+	public, err := url.Parse(publicURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public URL: %w", err)
+	}
+	if public.Scheme == "" || public.Host == "" {
+		return nil, fmt.Errorf("public URL must be absolute: %q", publicURL)
+	}
+
+	rw := &redirectRewriter{
+		upstream: upstream,
+		public:   public,
+	}
+
+	for _, entry := range allowList {
+		u, err := url.Parse(entry)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid redirect allowlist entry: %q", entry)
+		}
+		rw.allowed = append(rw.allowed, u)
+	}
+
+	return rw, nil
+}
+
+// rewriteResponse rewrites the Location header and Set-Cookie domains that
+// point at the upstream host.
+func (rw *redirectRewriter) rewriteResponse(header http.Header) {
+	if location := header.Get("Location"); location != "" {
+		header.Set("Location", rw.rewriteLocation(location))
+	}
+
+	cookies := header.Values("Set-Cookie")
+	if len(cookies) == 0 {
+		return
+	}
+	header.Del("Set-Cookie")
+	for _, cookie := range cookies {
+		header.Add("Set-Cookie", rw.rewriteCookieDomain(cookie))
+	}
+}
+
+// rewriteLocation swaps the upstream host for the public one, both in the URL
+// itself and in a redirect_to query parameter. Other query parameters are left
+// byte-for-byte intact since OAuth providers sign or compare them.
+func (rw *redirectRewriter) rewriteLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	changed := false
+	if rw.isUpstream(u) {
+		u.Scheme = rw.public.Scheme
+		u.Host = rw.public.Host
+		changed = true
+	}
+
+	if u.RawQuery != "" {
+		if rawQuery, ok := rw.rewriteRedirectParam(u.RawQuery); ok {
+			u.RawQuery = rawQuery
+			changed = true
+		}
+	}
+
+	if !changed {
+		return location
+	}
+	return u.String()
+}
+
+// rewriteRedirectParam rewrites an upstream redirect_to value in a raw query
+// string, keeping the order and encoding of every other parameter.
+func (rw *redirectRewriter) rewriteRedirectParam(rawQuery string) (string, bool) {
+	parts := strings.Split(rawQuery, "&")
+	changed := false
+
+	for i, part := range parts {
+		key, value, found := strings.Cut(part, "=")
+		if !found || key != "redirect_to" {
+			continue
+		}
+
+		target, err := url.QueryUnescape(value)
+		if err != nil {
+			continue
+		}
+		u, err := url.Parse(target)
+		if err != nil || !rw.isUpstream(u) {
+			continue
+		}
+
+		u.Scheme = rw.public.Scheme
+		u.Host = rw.public.Host
+		parts[i] = key + "=" + url.QueryEscape(u.String())
+		changed = true
+	}
+
+	return strings.Join(parts, "&"), changed
+}
+
+// rewriteCookieDomain replaces a Domain attribute naming the upstream host.
+func (rw *redirectRewriter) rewriteCookieDomain(cookie string) string {
+	attrs := strings.Split(cookie, ";")
+	for i, attr := range attrs {
+		name, value, found := strings.Cut(strings.TrimSpace(attr), "=")
+		if !found || !strings.EqualFold(name, "domain") {
+			continue
+		}
+		if strings.EqualFold(strings.TrimPrefix(value, "."), rw.upstream.Hostname()) {
+			attrs[i] = " Domain=" + rw.public.Hostname()
+		}
+	}
+	return strings.Join(attrs, ";")
+}
+
+// isAllowed reports whether a client may be redirected to target. The public
+// URL's own host is always allowed.
+func (rw *redirectRewriter) isAllowed(target string) bool {
+	if strings.Contains(target, `\`) {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Scheme, rw.public.Scheme) && strings.EqualFold(u.Host, rw.public.Host) {
+		return true
+	}
+
+	for _, allowed := range rw.allowed {
+		if !strings.EqualFold(allowed.Scheme, u.Scheme) {
+			continue
+		}
+		if !hostMatches(allowed.Host, u.Host) {
+			continue
+		}
+		if !pathMatches(allowed.Path, u.Path) {
+			continue
+		}
+		return true
+	}
+
+	return false
+}
+
+func (rw *redirectRewriter) isUpstream(u *url.URL) bool {
+	return u.Host != "" && strings.EqualFold(u.Host, rw.upstream.Host)
+}
+
+// hostMatches compares a host against a pattern that may start with "*." to
+// match any subdomain (but not the bare domain itself).
+func hostMatches(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return pattern == host
+}
+
+// pathMatches checks that path sits at or below the allowed path prefix.
+func pathMatches(allowed, path string) bool {
+	allowed = strings.TrimSuffix(allowed, "/")
+	if allowed == "" {
+		return true
+	}
+	return path == allowed || strings.HasPrefix(path, allowed+"/")
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+)
+
+func newTestRewriter(t *testing.T, allowList ...string) *redirectRewriter {
+	t.Helper()
+	upstream, _ := url.Parse("https://abc.supabase.co")
+	rw, err := newRedirectRewriter(upstream, "https://auth.example.com", allowList)
+	if err != nil {
+		t.Fatalf("newRedirectRewriter() error = %v", err)
+	}
+	return rw
+}
+
+func TestRewriteLocation(t *testing.T) {
+	rw := newTestRewriter(t)
+
+	tests := []struct {
+		name     string
+		location string
+		want     string
+	}{
+		{
+			"upstream host",
+			"https://abc.supabase.co/auth/v1/callback?code=123",
+			"https://auth.example.com/auth/v1/callback?code=123",
+		},
+		{
+			"redirect_to param",
+			"https://accounts.google.com/o/oauth2/auth?state=x&redirect_to=https%3A%2F%2Fabc.supabase.co%2Fdone&scope=email+profile",
+			"https://accounts.google.com/o/oauth2/auth?state=x&redirect_to=https%3A%2F%2Fauth.example.com%2Fdone&scope=email+profile",
+		},
+		{
+			"foreign host untouched",
+			"https://app.example.com/welcome#access_token=abc",
+			"https://app.example.com/welcome#access_token=abc",
+		},
+		{
+			"provider redirect_uri untouched",
+			"https://accounts.google.com/o/oauth2/auth?redirect_uri=https%3A%2F%2Fabc.supabase.co%2Fauth%2Fv1%2Fcallback",
+			"https://accounts.google.com/o/oauth2/auth?redirect_uri=https%3A%2F%2Fabc.supabase.co%2Fauth%2Fv1%2Fcallback",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rw.rewriteLocation(tt.location); got != tt.want {
+				t.Errorf("rewriteLocation() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteCookieDomain(t *testing.T) {
+	rw := newTestRewriter(t)
+
+	header := http.Header{}
+	header.Add("Set-Cookie", "sb-session=abc; Path=/; Domain=.abc.supabase.co; HttpOnly")
+	header.Add("Set-Cookie", "other=1; Domain=example.org")
+	rw.rewriteResponse(header)
+
+	cookies := header.Values("Set-Cookie")
+	if cookies[0] != "sb-session=abc; Path=/; Domain=auth.example.com; HttpOnly" {
+		t.Errorf("upstream cookie domain not rewritten: %q", cookies[0])
+	}
+	if cookies[1] != "other=1; Domain=example.org" {
+		t.Errorf("foreign cookie domain changed: %q", cookies[1])
+	}
+}
+
+func TestRedirectIsAllowed(t *testing.T) {
+	rw := newTestRewriter(t, "https://app.example.com/auth", "https://*.preview.example.com", "myapp://callback")
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"https://auth.example.com/anything", true},
+		{"https://app.example.com/auth", true},
+		{"https://app.example.com/auth/done", true},
+		{"https://app.example.com/authx", false},
+		{"https://app.example.com/", false},
+		{"https://pr-1.preview.example.com/", true},
+		{"https://preview.example.com/", false},
+		{"http://pr-1.preview.example.com/", false},
+		{"myapp://callback", true},
+		{"https://evil.com", false},
+		{"//evil.com", false},
+		{`https:/\evil.com`, false},
+		{"/relative", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := rw.isAllowed(tt.target); got != tt.want {
+				t.Errorf("isAllowed(%q) = %v, want %v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTPRejectsDisallowedRedirect(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "http://"+r.Host+"/auth/v1/callback")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{
+		TargetURL:         upstream.URL,
+		AnonKey:           "anon",
+		PublicURL:         "https://auth.example.com",
+		RedirectAllowList: []string{"https://app.example.com"},
+	}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/authorize?redirect_to=https://evil.com", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("disallowed redirect_to status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/authorize?redirect_to=https://app.example.com/", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("allowed redirect_to status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if got := rec.Header().Get("Location"); got != "https://auth.example.com/auth/v1/callback" {
+		t.Errorf("Location = %q, want rewritten to public URL", got)
+	}
+}
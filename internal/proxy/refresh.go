@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/clientip"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/middleware"
+	"github.com/kacy/auth-proxy/internal/tokenwrap"
+	"go.uber.org/zap"
+)
+
+// maxRefreshBody bounds the refresh grant bodies read for unwrapping.
+const maxRefreshBody = 64 << 10
+
+// refreshWrapper swaps refresh tokens for device-bound handles on the way out
+// and back on the way in.
+type refreshWrapper struct {
+	wrapper *tokenwrap.Wrapper
+	logger  *logging.Logger
+}
+
+// unwrapRequest replaces the handle in a refresh grant with the refresh token
+// it seals. It returns false if it already wrote a response.
+func (rw *refreshWrapper) unwrapRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(upstreamPath(r.URL.Path), "/auth/v1/token") ||
+		r.URL.Query().Get("grant_type") != "refresh_token" || r.Body == nil {
+		return r, true
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRefreshBody))
+	r.Body.Close()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apierror.Write(w, r, apierror.ErrRequestTooLarge)
+		} else {
+			apierror.Write(w, r, apierror.ErrInvalidRequest.WithMessage("Failed to read request body").WithCause(err))
+		}
+		return r, false
+	}
+
+	var fields map[string]json.RawMessage
+	var handle string
+	if json.Unmarshal(body, &fields) != nil || json.Unmarshal(fields["refresh_token"], &handle) != nil || handle == "" {
+		// Let GoTrue reject it in its usual way
+		setRequestBody(r, body)
+		return r, true
+	}
+
+	device := r.Header.Get(middleware.KeyIDHeader)
+	refreshToken, err := rw.wrapper.Unwrap(r.Context(), handle, device)
+	if errors.Is(err, tokenwrap.ErrInvalidHandle) {
+		rw.logger.For(r.Context()).AuthWarning("rejected refresh token handle",
+			zap.Bool("device_present", device != ""),
+			zap.Bool("wrapped", tokenwrap.IsWrapped(handle)),
+			zap.String("client_ip", clientip.FromRequest(r)),
+		)
+		audit.Report(r, audit.Entry{
+			Action:   "refresh_token",
+			Decision: audit.DecisionDeny,
+			Reason:   "refresh_token_not_found",
+			KeyID:    device,
+		})
+		// Same response GoTrue gives for an unknown refresh token, so SDKs
+		// sign the user out rather than retrying
+		apierror.Write(w, r, apierror.ErrRefreshTokenNotFound)
+		return r, false
+	}
+	if err != nil {
+		rw.logger.For(r.Context()).DatabaseError("failed to unwrap refresh token", zap.Error(err))
+		apierror.Write(w, r, apierror.ErrRefreshUnavailable.WithCause(err))
+		return r, false
+	}
+
+	fields["refresh_token"], _ = json.Marshal(refreshToken)
+	body, err = json.Marshal(fields)
+	if err != nil {
+		apierror.Write(w, r, apierror.ErrInvalidRequest.WithMessage("Invalid request body").WithCause(err))
+		return r, false
+	}
+	setRequestBody(r, body)
+	return r, true
+}
+
+// handleResponse wraps refresh tokens in JSON session responses and in the
+// fragment of implicit-flow redirects, and revokes the handles of a session
+// when it logs out.
+func (rw *refreshWrapper) handleResponse(resp *http.Response) error {
+	ctx := resp.Request.Context()
+	device := resp.Request.Header.Get(middleware.KeyIDHeader)
+
+	if strings.HasPrefix(resp.Request.URL.Path, "/auth/v1/logout") && resp.StatusCode < 300 {
+		// GoTrue accepted the token, so its session claim can be trusted.
+		// Other sessions on the device keep their handles; GoTrue revokes
+		// their refresh tokens itself for global and "others" logouts.
+		token, _ := jwt.BearerToken(resp.Request.Header.Get("Authorization"))
+		if err := rw.wrapper.RevokeSession(ctx, tokenSessionID(token)); err != nil {
+			rw.logger.For(ctx).DatabaseError("failed to revoke session refresh tokens", zap.Error(err))
+		}
+		return nil
+	}
+
+	if location := resp.Header.Get("Location"); location != "" && strings.Contains(location, "refresh_token=") {
+		wrapped, err := rw.wrapLocation(resp, location, device)
+		if err != nil {
+			return err
+		}
+		resp.Header.Set("Location", wrapped)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.Body == nil {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "application/json" {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(body, []byte(`"refresh_token"`)) {
+		setBody(resp, body)
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	var refreshToken string
+	if json.Unmarshal(body, &fields) != nil || json.Unmarshal(fields["refresh_token"], &refreshToken) != nil || refreshToken == "" {
+		setBody(resp, body)
+		return nil
+	}
+
+	var accessToken string
+	json.Unmarshal(fields["access_token"], &accessToken)
+	handle, err := rw.wrapper.Wrap(ctx, refreshToken, device, tokenSessionID(accessToken))
+	if err != nil {
+		return err
+	}
+	fields["refresh_token"], _ = json.Marshal(handle)
+	if body, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	setBody(resp, body)
+	return nil
+}
+
+// wrapLocation wraps the refresh token GoTrue puts in the fragment of an
+// implicit-flow redirect.
+func (rw *refreshWrapper) wrapLocation(resp *http.Response, location, device string) (string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Fragment == "" {
+		return location, nil
+	}
+	params, err := url.ParseQuery(u.Fragment)
+	if err != nil || params.Get("refresh_token") == "" {
+		return location, nil
+	}
+
+	handle, err := rw.wrapper.Wrap(resp.Request.Context(), params.Get("refresh_token"), device, tokenSessionID(params.Get("access_token")))
+	if err != nil {
+		return "", err
+	}
+	params.Set("refresh_token", handle)
+	u.Fragment = params.Encode()
+	return u.String(), nil
+}
+
+// setRequestBody replaces a request body and fixes up its length.
+func setRequestBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/middleware"
+	"github.com/kacy/auth-proxy/internal/store"
+	"github.com/kacy/auth-proxy/internal/tokenwrap"
+)
+
+func newRefreshTestProxy(t *testing.T) (*Proxy, *atomic.Value) {
+	t.Helper()
+	var grants atomic.Int32
+	var received atomic.Value
+	received.Store("")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/v1/token":
+			var req struct {
+				RefreshToken string `json:"refresh_token"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			received.Store(req.RefreshToken)
+			n := grants.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":%q,"refresh_token":"raw-refresh-%d","expires_in":3600}`,
+				testAccessToken(fmt.Sprintf("session-%d", n), n), n)
+		case "/auth/v1/verify":
+			w.Header().Set("Location", "https://app.example.com/callback#access_token=a&refresh_token=raw-refresh-fragment&type=magiclink")
+			w.WriteHeader(http.StatusSeeOther)
+		case "/auth/v1/logout":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	s := store.NewMemory()
+	t.Cleanup(func() { s.Close() })
+	wrapper, err := tokenwrap.New(tokenwrap.Config{
+		Keys:  []tokenwrap.Key{{ID: "k1", Secret: bytes.Repeat([]byte{7}, 32)}},
+		Store: s,
+	})
+	if err != nil {
+		t.Fatalf("tokenwrap.New() error = %v", err)
+	}
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{
+		TargetURL:     server.URL,
+		AnonKey:       "anon",
+		RefreshTokens: wrapper,
+	}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return p, &received
+}
+
+func tokenGrant(p *Proxy, grantType, refreshToken, device string) (*httptest.ResponseRecorder, map[string]any) {
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type="+grantType, bytes.NewReader(body))
+	if device != "" {
+		req.Header.Set(middleware.KeyIDHeader, device)
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	var resp map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestRefreshTokenWrapping(t *testing.T) {
+	p, received := newRefreshTestProxy(t)
+
+	rec, body := tokenGrant(p, "password", "", "device-a")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sign in status = %d", rec.Code)
+	}
+	handle, _ := body["refresh_token"].(string)
+	if !tokenwrap.IsWrapped(handle) {
+		t.Fatalf("refresh_token = %q, want a wrapped handle", handle)
+	}
+	if body["access_token"] != testAccessToken("session-1", 1) {
+		t.Errorf("access_token = %v, want it untouched", body["access_token"])
+	}
+
+	tests := []struct {
+		name   string
+		handle string
+		device string
+		status int
+		code   string
+	}{
+		{"other device", handle, "device-b", http.StatusBadRequest, "refresh_token_not_found"},
+		{"raw token", "raw-refresh-1", "device-a", http.StatusBadRequest, "refresh_token_not_found"},
+		{"same device", handle, "device-a", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received.Store("")
+			rec, body := tokenGrant(p, "refresh_token", tt.handle, tt.device)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				if body["error_code"] != tt.code {
+					t.Errorf("error_code = %v, want %s", body["error_code"], tt.code)
+				}
+				if received.Load() != "" {
+					t.Error("rejected refresh reached upstream")
+				}
+				return
+			}
+			if received.Load() != "raw-refresh-1" {
+				t.Errorf("upstream got refresh_token %q, want the unwrapped token", received.Load())
+			}
+			if rt, _ := body["refresh_token"].(string); !tokenwrap.IsWrapped(rt) {
+				t.Errorf("rotated refresh_token = %q, want a wrapped handle", rt)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenWrappingLogoutRevokesSession(t *testing.T) {
+	p, _ := newRefreshTestProxy(t)
+
+	_, body := tokenGrant(p, "password", "", "device-a")
+	loggedOut := body["refresh_token"].(string)
+	accessToken := body["access_token"].(string)
+	_, body = tokenGrant(p, "password", "", "device-a")
+	other := body["refresh_token"].(string)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout?scope=local", nil)
+	req.Header.Set(middleware.KeyIDHeader, "device-a")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if rec, _ := tokenGrant(p, "refresh_token", loggedOut, "device-a"); rec.Code != http.StatusBadRequest {
+		t.Errorf("refresh after logout status = %d, want 400", rec.Code)
+	}
+	if rec, _ := tokenGrant(p, "refresh_token", other, "device-a"); rec.Code != http.StatusOK {
+		t.Errorf("refresh of the device's other session status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRefreshTokenWrappingRejectsOversizedBody(t *testing.T) {
+	p, received := newRefreshTestProxy(t)
+
+	body := `{"refresh_token":"raw-refresh-1","pad":"` + strings.Repeat("a", maxRefreshBody) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=refresh_token", strings.NewReader(body))
+	req.Header.Set(middleware.KeyIDHeader, "device-a")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+	if received.Load() != "" {
+		t.Error("oversized refresh reached upstream")
+	}
+}
+
+func TestRefreshTokenWrappingRedirectFragment(t *testing.T) {
+	p, _ := newRefreshTestProxy(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/verify?token=abc&type=magiclink", nil)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid Location: %v", err)
+	}
+	params, _ := url.ParseQuery(location.Fragment)
+	if rt := params.Get("refresh_token"); !tokenwrap.IsWrapped(rt) {
+		t.Errorf("fragment refresh_token = %q, want a wrapped handle", rt)
+	}
+	if params.Get("access_token") != "a" || strings.Contains(location.Fragment, "raw-refresh") {
+		t.Errorf("fragment = %q", location.Fragment)
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/audit"
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/revocation"
+	"go.uber.org/zap"
+)
+
+// revocationRecorder adds logged-out sessions to the revocation list and
+// keeps revoked sessions from being refreshed.
+type revocationRecorder struct {
+	list    *revocation.List
+	signOut func(ctx context.Context, accessToken string)
+	logger  *logging.Logger
+}
+
+// handleResponse records successful logouts, and turns token grants for
+// revoked sessions into errors.
+func (rr *revocationRecorder) handleResponse(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil
+	}
+
+	path := resp.Request.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/auth/v1/logout"):
+		rr.recordLogout(resp.Request)
+		return nil
+	case strings.HasPrefix(path, "/auth/v1/token") && resp.Body != nil:
+		return rr.checkGrant(resp)
+	}
+	return nil
+}
+
+// recordLogout revokes what the logout's scope covers: this session by
+// default, every session of the user for "global", and every other session
+// for "others".
+func (rr *revocationRecorder) recordLogout(r *http.Request) {
+	token, ok := jwt.BearerToken(r.Header.Get("Authorization"))
+	if !ok {
+		return
+	}
+	claims, err := jwt.UnverifiedClaims(token)
+	if err != nil {
+		return
+	}
+
+	ctx := r.Context()
+	scope := r.URL.Query().Get("scope")
+	switch scope {
+	case "global":
+		err = rr.list.RevokeUser(ctx, claims.Subject, "")
+	case "others":
+		err = rr.list.RevokeUser(ctx, claims.Subject, claims.SessionID)
+	default:
+		scope = "local"
+		err = rr.list.RevokeSession(ctx, claims.SessionID, time.Unix(claims.ExpiresAt, 0))
+	}
+	if err != nil {
+		rr.logger.For(ctx).DatabaseError("failed to record revoked session", zap.Error(err))
+		return
+	}
+
+	audit.Report(r, audit.Entry{
+		Action:   "revocation",
+		Decision: audit.DecisionRevoke,
+		Reason:   "logout_" + scope,
+		UserID:   claims.Subject,
+	})
+	rr.logger.For(ctx).AuthSuccess("session revoked on logout",
+		zap.String("user_id", logging.MaskUserID(claims.Subject)),
+		zap.String("scope", scope),
+	)
+}
+
+// checkGrant rejects a refresh of a revoked session. GoTrue would happily
+// issue new tokens for it since it only knows about logouts it saw itself, so
+// the session is also ended upstream.
+func (rr *revocationRecorder) checkGrant(resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	setBody(resp, body)
+
+	var tokens tokenResponse
+	if json.Unmarshal(body, &tokens) != nil || tokens.AccessToken == "" {
+		return nil
+	}
+	claims, err := jwt.UnverifiedClaims(tokens.AccessToken)
+	if err != nil {
+		return nil
+	}
+
+	err = rr.list.Check(resp.Request.Context(), claims)
+	if !errors.Is(err, revocation.ErrRevoked) {
+		if err != nil {
+			rr.logger.For(resp.Request.Context()).DatabaseError("failed to check revocation list", zap.Error(err))
+		}
+		return nil
+	}
+
+	rr.logger.For(resp.Request.Context()).AuthWarning("rejected token grant for a revoked session",
+		zap.String("user_id", logging.MaskUserID(claims.Subject)),
+	)
+	audit.Report(resp.Request, audit.Entry{
+		Action:   "revocation",
+		Decision: audit.DecisionDeny,
+		Reason:   "session_revoked",
+		UserID:   claims.Subject,
+	})
+	rr.signOut(resp.Request.Context(), tokens.AccessToken)
+	apierror.Replace(resp, apierror.ErrSessionNotFound)
+	return nil
+}
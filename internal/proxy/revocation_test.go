@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/revocation"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func TestRevocationOnLogout(t *testing.T) {
+	signedIn := time.Now().Add(-time.Hour).Unix()
+	token := func(session string) string {
+		return b64JSON(map[string]string{"alg": "HS256"}) + "." + b64JSON(map[string]any{
+			"sub":        "user-1",
+			"session_id": session,
+			"exp":        time.Now().Add(time.Hour).Unix(),
+			"amr":        []map[string]any{{"method": "password", "timestamp": signedIn}},
+		}) + ".sig"
+	}
+
+	var upstreamLogouts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/auth/v1/logout":
+			upstreamLogouts.Add(1)
+			w.WriteHeader(http.StatusNoContent)
+		case "/auth/v1/token":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":%q,"refresh_token":"r"}`, token("session-1"))
+		}
+	}))
+	defer server.Close()
+
+	s := store.NewMemory()
+	defer s.Close()
+	list, _ := revocation.New(revocation.Config{Store: s})
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{TargetURL: server.URL, AnonKey: "anon", Revocations: list}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	check := func(session string) error {
+		claims, _ := jwt.UnverifiedClaims(token(session))
+		return list.Check(t.Context(), claims)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+token("session-1"))
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := check("session-1"); err != revocation.ErrRevoked {
+		t.Errorf("logged out session: Check() = %v, want ErrRevoked", err)
+	}
+	if err := check("session-2"); err != nil {
+		t.Errorf("other session: Check() = %v, want nil", err)
+	}
+
+	// Refreshing the revoked session is refused and ended upstream
+	upstreamLogouts.Store(0)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/token?grant_type=refresh_token", strings.NewReader(`{"refresh_token":"r"}`)))
+	var body map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusForbidden || body["error_code"] != "session_not_found" {
+		t.Errorf("refresh of revoked session: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if upstreamLogouts.Load() != 1 {
+		t.Errorf("upstream logouts = %d, want 1", upstreamLogouts.Load())
+	}
+
+	// A global logout covers every session the user signed in to
+	req = httptest.NewRequest(http.MethodPost, "/logout?scope=global", nil)
+	req.Header.Set("Authorization", "Bearer "+token("session-3"))
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if err := check("session-2"); err != revocation.ErrRevoked {
+		t.Errorf("after global logout: Check() = %v, want ErrRevoked", err)
+	}
+}
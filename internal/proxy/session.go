@@ -0,0 +1,486 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/apierror"
+	"github.com/kacy/auth-proxy/internal/buildinfo"
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	// SessionModeHeader opts a /token request into cookie sessions. Web
+	// clients send "X-Session-Mode: cookie" when signing in.
+	SessionModeHeader = "X-Session-Mode"
+	// CSRFHeader must echo the CSRF cookie on state-changing cookie requests.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// refreshTimeout bounds a shared session refresh, which no longer follows
+// the context of the request that started it. It is also how long a
+// replica's refresh lock lives if the replica dies holding it.
+const refreshTimeout = 10 * time.Second
+
+// refreshLockPoll is how often a request waiting on another replica's
+// refresh checks whether it has finished.
+const refreshLockPoll = 50 * time.Millisecond
+
+var errSessionExpired = errors.New("session expired")
+
+// SessionConfig configures backend-for-frontend cookie sessions. Tokens stay
+// in the store and the browser only ever sees an opaque HttpOnly cookie.
+type SessionConfig struct {
+	Enabled        bool
+	Store          store.Store
+	CookieName     string
+	CSRFCookieName string
+	CookieDomain   string
+	SameSite       http.SameSite
+	// TTL is how long an idle session is kept.
+	TTL time.Duration
+	// RefreshBefore refreshes the access token when it expires within this window.
+	RefreshBefore time.Duration
+}
+
+// session is the server-side state behind a session cookie.
+type session struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    int64  `json:"expires_at"`
+	UserID       string `json:"user_id,omitempty"`
+	CSRFToken    string `json:"csrf_token"`
+}
+
+// tokenResponse is the subset of a GoTrue /token response sessions need.
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	ExpiresAt    int64  `json:"expires_at"`
+	User         *struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
+func (t *tokenResponse) expiresAt() int64 {
+	if t.ExpiresAt > 0 {
+		return t.ExpiresAt
+	}
+	return time.Now().Unix() + t.ExpiresIn
+}
+
+type sessionContextKey struct{}
+
+// sessionContext carries session state from ServeHTTP to modifyResponse.
+type sessionContext struct {
+	id      string
+	session *session
+	optIn   bool
+}
+
+type refreshCall struct {
+	done    chan struct{}
+	session *session
+	err     error
+}
+
+// sessionManager implements cookie sessions on top of the proxy.
+type sessionManager struct {
+	config  SessionConfig
+	target  *url.URL
+	anonKey string
+	client  *http.Client
+	logger  *logging.Logger
+
+	mu       sync.Mutex
+	inflight map[string]*refreshCall
+}
+
+func newSessionManager(cfg SessionConfig, target *url.URL, anonKey string, client *http.Client, logger *logging.Logger) (*sessionManager, error) {
+	if cfg.Store == nil {
+		return nil, fmt.Errorf("session store is required")
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "authproxy_session"
+	}
+	if cfg.CSRFCookieName == "" {
+		cfg.CSRFCookieName = "authproxy_csrf"
+	}
+	if cfg.SameSite == 0 {
+		cfg.SameSite = http.SameSiteLaxMode
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 7 * 24 * time.Hour
+	}
+	if cfg.RefreshBefore == 0 {
+		cfg.RefreshBefore = time.Minute
+	}
+
+	return &sessionManager{
+		config:   cfg,
+		target:   target,
+		anonKey:  anonKey,
+		client:   client,
+		logger:   logger,
+		inflight: make(map[string]*refreshCall),
+	}, nil
+}
+
+// attach resolves the session cookie, enforces CSRF, refreshes the access
+// token when needed and injects it as a bearer token. It returns false if it
+// already wrote a response.
+func (m *sessionManager) attach(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	sc := &sessionContext{
+		optIn: strings.EqualFold(r.Header.Get(SessionModeHeader), "cookie"),
+	}
+	r.Header.Del(SessionModeHeader)
+
+	cookie, err := r.Cookie(m.config.CookieName)
+	if err != nil || cookie.Value == "" || r.Header.Get("Authorization") != "" {
+		return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sc)), true
+	}
+	m.stripCookies(r)
+
+	sess, err := m.load(r.Context(), cookie.Value)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			m.logger.For(r.Context()).DatabaseError("failed to load session", zap.Error(err))
+			apierror.Write(w, r, apierror.ErrSessionUnavailable.WithCause(err))
+			return nil, false
+		}
+		return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sc)), true
+	}
+
+	if !isSafeMethod(r.Method) {
+		provided := r.Header.Get(CSRFHeader)
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(sess.CSRFToken)) != 1 {
+			m.logger.For(r.Context()).AuthWarning("session request failed CSRF check",
+				zap.String("path", r.URL.Path),
+				zap.String("method", r.Method),
+			)
+			apierror.Write(w, r, apierror.ErrCSRFFailed)
+			return nil, false
+		}
+	}
+	r.Header.Del(CSRFHeader)
+
+	if time.Until(time.Unix(sess.ExpiresAt, 0)) < m.config.RefreshBefore {
+		sess, err = m.refresh(r.Context(), cookie.Value, sess)
+		if err != nil {
+			m.logger.For(r.Context()).AuthWarning("session refresh failed", zap.Error(err))
+			m.config.Store.Delete(r.Context(), cookie.Value)
+			m.clearCookies(w.Header())
+			apierror.Write(w, r, apierror.ErrSessionExpired)
+			return nil, false
+		}
+	}
+
+	r.Header.Set("Authorization", "Bearer "+sess.AccessToken)
+	sc.id = cookie.Value
+	sc.session = sess
+	return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, sc)), true
+}
+
+// handleResponse turns opted-in /token responses into cookie sessions and
+// tears sessions down on logout.
+func (m *sessionManager) handleResponse(resp *http.Response) error {
+	sc, _ := resp.Request.Context().Value(sessionContextKey{}).(*sessionContext)
+	if sc == nil {
+		return nil
+	}
+
+	path := resp.Request.URL.Path
+	ctx := resp.Request.Context()
+
+	if strings.HasPrefix(path, "/auth/v1/logout") && sc.id != "" {
+		// Keep the session when GoTrue didn't end it, so the client can
+		// retry instead of losing the only handle on a live session
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			m.logger.For(ctx).AuthWarning("upstream logout failed, keeping cookie session",
+				zap.Int("status", resp.StatusCode))
+			return nil
+		}
+		if err := m.config.Store.Delete(ctx, sc.id); err != nil {
+			m.logger.For(ctx).DatabaseError("failed to delete session", zap.Error(err))
+		}
+		m.clearCookies(resp.Header)
+		return nil
+	}
+
+	if !strings.HasPrefix(path, "/auth/v1/token") || !sc.optIn {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.Body == nil {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var tokens tokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.AccessToken == "" {
+		setBody(resp, body)
+		return nil
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return err
+	}
+	csrf, err := randomToken()
+	if err != nil {
+		return err
+	}
+
+	sess := &session{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresAt:    tokens.expiresAt(),
+		CSRFToken:    csrf,
+	}
+	if tokens.User != nil {
+		sess.UserID = tokens.User.ID
+	}
+	if err := m.save(ctx, id, sess); err != nil {
+		return err
+	}
+
+	// Rotate away from any session the client was already holding
+	if sc.id != "" {
+		m.config.Store.Delete(ctx, sc.id)
+	}
+
+	m.setCookies(resp.Header, id, csrf)
+	setBody(resp, stripTokens(body))
+
+	m.logger.For(ctx).AuthSuccess("cookie session created",
+		zap.String("user_id", logging.MaskUserID(sess.UserID)),
+	)
+	return nil
+}
+
+// refresh exchanges the refresh token for a new access token. Concurrent
+// requests for the same session share a single upstream call so GoTrue
+// doesn't see the refresh token reused. The call outlives the request that
+// started it, so that client going away doesn't fail everyone waiting.
+func (m *sessionManager) refresh(ctx context.Context, id string, sess *session) (*session, error) {
+	m.mu.Lock()
+	if call, ok := m.inflight[id]; ok {
+		m.mu.Unlock()
+		<-call.done
+		return call.session, call.err
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	m.inflight[id] = call
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+	call.session, call.err = m.refreshLocked(ctx, id, sess)
+	cancel()
+	close(call.done)
+
+	m.mu.Lock()
+	delete(m.inflight, id)
+	m.mu.Unlock()
+
+	return call.session, call.err
+}
+
+// refreshLocked refreshes the session under a lock in the store, so replicas
+// sharing it don't spend the same refresh token twice. A session whose
+// refresh token changed since stale was loaded has already been refreshed,
+// here or elsewhere, and is returned as is.
+func (m *sessionManager) refreshLocked(ctx context.Context, id string, stale *session) (*session, error) {
+	lock := id + ":refresh"
+	for {
+		held, err := m.config.Store.Incr(ctx, lock, refreshTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if held == 1 {
+			defer m.config.Store.Delete(context.WithoutCancel(ctx), lock)
+		}
+
+		current, err := m.load(ctx, id)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, errSessionExpired
+		}
+		if err != nil {
+			return nil, err
+		}
+		if current.RefreshToken != stale.RefreshToken {
+			return current, nil
+		}
+		if held == 1 {
+			return m.doRefresh(ctx, id, current)
+		}
+
+		select {
+		case <-time.After(refreshLockPoll):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (m *sessionManager) doRefresh(ctx context.Context, id string, sess *session) (*session, error) {
+	if sess.RefreshToken == "" {
+		return nil, errSessionExpired
+	}
+
+	payload, _ := json.Marshal(map[string]string{"refresh_token": sess.RefreshToken})
+	endpoint := m.target.JoinPath("/auth/v1/token")
+	endpoint.RawQuery = "grant_type=refresh_token"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apikey", m.anonKey)
+	req.Header.Set("User-Agent", buildinfo.UserAgent())
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: upstream returned %d", errSessionExpired, resp.StatusCode)
+	}
+
+	var tokens tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.AccessToken == "" {
+		return nil, errSessionExpired
+	}
+
+	updated := *sess
+	updated.AccessToken = tokens.AccessToken
+	updated.RefreshToken = tokens.RefreshToken
+	updated.ExpiresAt = tokens.expiresAt()
+
+	if err := m.save(ctx, id, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (m *sessionManager) load(ctx context.Context, id string) (*session, error) {
+	data, err := m.config.Store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var sess session
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (m *sessionManager) save(ctx context.Context, id string, sess *session) error {
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	return m.config.Store.Set(ctx, id, data, m.config.TTL)
+}
+
+func (m *sessionManager) setCookies(h http.Header, id, csrf string) {
+	maxAge := int(m.config.TTL.Seconds())
+	h.Add("Set-Cookie", m.cookie(m.config.CookieName, id, maxAge, true).String())
+	h.Add("Set-Cookie", m.cookie(m.config.CSRFCookieName, csrf, maxAge, false).String())
+}
+
+func (m *sessionManager) clearCookies(h http.Header) {
+	h.Add("Set-Cookie", m.cookie(m.config.CookieName, "", -1, true).String())
+	h.Add("Set-Cookie", m.cookie(m.config.CSRFCookieName, "", -1, false).String())
+}
+
+func (m *sessionManager) cookie(name, value string, maxAge int, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   m.config.CookieDomain,
+		MaxAge:   maxAge,
+		HttpOnly: httpOnly,
+		Secure:   true,
+		SameSite: m.config.SameSite,
+	}
+}
+
+// stripCookies removes the proxy's own cookies before the request goes upstream.
+func (m *sessionManager) stripCookies(r *http.Request) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name == m.config.CookieName || c.Name == m.config.CSRFCookieName {
+			continue
+		}
+		r.AddCookie(c)
+	}
+}
+
+// stripTokens removes tokens from a /token response body so they never reach
+// the browser.
+func stripTokens(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	delete(fields, "access_token")
+	delete(fields, "refresh_token")
+
+	stripped, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return stripped
+}
+
+// setBody replaces a response body and fixes up its length.
+func setBody(resp *http.Response, body []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/logging"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+// fakeGoTrue issues tokens that expire after expiresIn seconds and records
+// the Authorization header of /user calls.
+type fakeGoTrue struct {
+	expiresIn int64
+	// logoutStatus overrides the 204 logout response when set
+	logoutStatus int
+	refreshes    atomic.Int32
+	lastBearer   atomic.Value
+}
+
+func (f *fakeGoTrue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/auth/v1/token":
+		n := f.refreshes.Load()
+		if r.URL.Query().Get("grant_type") == "refresh_token" {
+			n = f.refreshes.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"refresh-%d","expires_in":%d,"user":{"id":"user-1"}}`,
+			n, n, f.expiresIn)
+	case "/auth/v1/user":
+		f.lastBearer.Store(r.Header.Get("Authorization"))
+		w.Write([]byte(`{"id":"user-1"}`))
+	case "/auth/v1/logout":
+		if f.logoutStatus != 0 {
+			w.WriteHeader(f.logoutStatus)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newSessionTestProxy(t *testing.T, expiresIn int64) (*Proxy, *fakeGoTrue) {
+	t.Helper()
+	upstream := &fakeGoTrue{expiresIn: expiresIn}
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	sessions := store.NewMemory()
+	t.Cleanup(func() { sessions.Close() })
+
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{
+		TargetURL: server.URL,
+		AnonKey:   "anon",
+		Sessions: SessionConfig{
+			Enabled: true,
+			Store:   sessions,
+		},
+	}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return p, upstream
+}
+
+func signIn(t *testing.T, p *Proxy) (*http.Cookie, *http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/token?grant_type=password", strings.NewReader(`{}`))
+	req.Header.Set(SessionModeHeader, "cookie")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sign in status = %d", rec.Code)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("sign in body is not JSON: %v", err)
+	}
+	if _, ok := body["access_token"]; ok {
+		t.Error("access_token leaked to cookie client")
+	}
+	if _, ok := body["refresh_token"]; ok {
+		t.Error("refresh_token leaked to cookie client")
+	}
+
+	var sessionCookie, csrfCookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		switch c.Name {
+		case "authproxy_session":
+			sessionCookie = c
+		case "authproxy_csrf":
+			csrfCookie = c
+		}
+	}
+	if sessionCookie == nil || csrfCookie == nil {
+		t.Fatal("session and CSRF cookies not set")
+	}
+	if !sessionCookie.HttpOnly || !sessionCookie.Secure {
+		t.Error("session cookie must be HttpOnly and Secure")
+	}
+	return sessionCookie, csrfCookie
+}
+
+func TestSessionInjectsBearer(t *testing.T) {
+	p, upstream := newSessionTestProxy(t, 3600)
+	sessionCookie, _ := signIn(t, p)
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.AddCookie(sessionCookie)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if got := upstream.lastBearer.Load(); got != "Bearer access-0" {
+		t.Errorf("upstream Authorization = %v, want Bearer access-0", got)
+	}
+}
+
+func TestSessionRequiresCSRF(t *testing.T) {
+	p, _ := newSessionTestProxy(t, 3600)
+	sessionCookie, csrfCookie := signIn(t, p)
+
+	req := httptest.NewRequest(http.MethodPut, "/user", strings.NewReader(`{}`))
+	req.AddCookie(sessionCookie)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("missing CSRF status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/user", strings.NewReader(`{}`))
+	req.AddCookie(sessionCookie)
+	req.Header.Set(CSRFHeader, csrfCookie.Value)
+	rec = httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("valid CSRF status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestSessionRefreshesExpiringToken(t *testing.T) {
+	// Tokens expire immediately, so every request has to refresh
+	p, upstream := newSessionTestProxy(t, 0)
+	sessionCookie, _ := signIn(t, p)
+
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.AddCookie(sessionCookie)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if upstream.refreshes.Load() != 1 {
+		t.Errorf("refreshes = %d, want 1", upstream.refreshes.Load())
+	}
+	if got := upstream.lastBearer.Load(); got != "Bearer access-1" {
+		t.Errorf("upstream Authorization = %v, want refreshed token", got)
+	}
+}
+
+func TestSessionLogoutClearsSession(t *testing.T) {
+	p, upstream := newSessionTestProxy(t, 3600)
+	sessionCookie, csrfCookie := signIn(t, p)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(sessionCookie)
+	req.Header.Set(CSRFHeader, csrfCookie.Value)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	cleared := false
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "authproxy_session" && c.MaxAge < 0 {
+			cleared = true
+		}
+	}
+	if !cleared {
+		t.Error("logout did not clear the session cookie")
+	}
+
+	upstream.lastBearer.Store("")
+	req = httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.AddCookie(sessionCookie)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if got := upstream.lastBearer.Load(); got != "" {
+		t.Errorf("session still usable after logout, Authorization = %v", got)
+	}
+}
+
+func TestSessionKeptWhenUpstreamLogoutFails(t *testing.T) {
+	p, upstream := newSessionTestProxy(t, 3600)
+	upstream.logoutStatus = http.StatusBadGateway
+	sessionCookie, csrfCookie := signIn(t, p)
+
+	req := httptest.NewRequest(http.MethodPost, "/logout", nil)
+	req.AddCookie(sessionCookie)
+	req.Header.Set(CSRFHeader, csrfCookie.Value)
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "authproxy_session" && c.MaxAge < 0 {
+			t.Error("failed logout cleared the session cookie")
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.AddCookie(sessionCookie)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+	if got := upstream.lastBearer.Load(); got != "Bearer access-0" {
+		t.Errorf("session not usable after failed logout, Authorization = %v", got)
+	}
+}
+
+func TestSessionRefreshOutlivesCaller(t *testing.T) {
+	p, upstream := newSessionTestProxy(t, 0)
+	sessionCookie, _ := signIn(t, p)
+
+	sess, err := p.sessions.load(context.Background(), sessionCookie.Value)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	refreshed, err := p.sessions.refresh(ctx, sessionCookie.Value, sess)
+	if err != nil {
+		t.Fatalf("refresh() with a cancelled caller error = %v", err)
+	}
+	if refreshed.AccessToken != "access-1" || upstream.refreshes.Load() != 1 {
+		t.Errorf("access token = %s after %d refreshes, want access-1 after 1", refreshed.AccessToken, upstream.refreshes.Load())
+	}
+}
+
+func TestSessionRefreshSkipsRotatedToken(t *testing.T) {
+	p, upstream := newSessionTestProxy(t, 0)
+	sessionCookie, _ := signIn(t, p)
+
+	// Loaded before the refresh below saved the rotated token
+	stale, err := p.sessions.load(context.Background(), sessionCookie.Value)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if _, err := p.sessions.refresh(context.Background(), sessionCookie.Value, stale); err != nil {
+		t.Fatalf("refresh() error = %v", err)
+	}
+
+	refreshed, err := p.sessions.refresh(context.Background(), sessionCookie.Value, stale)
+	if err != nil {
+		t.Fatalf("refresh() with a stale session error = %v", err)
+	}
+	if refreshed.RefreshToken != "refresh-1" || upstream.refreshes.Load() != 1 {
+		t.Errorf("refresh token = %s after %d refreshes, want refresh-1 after 1", refreshed.RefreshToken, upstream.refreshes.Load())
+	}
+}
+
+func TestSessionRefreshSharedAcrossReplicas(t *testing.T) {
+	p, upstream := newSessionTestProxy(t, 0)
+	sessionCookie, _ := signIn(t, p)
+
+	// A second replica on the same session store
+	logger, _ := logging.New("error", false)
+	replica, err := newSessionManager(p.sessions.config, p.sessions.target, "anon", p.sessions.client, logger)
+	if err != nil {
+		t.Fatalf("newSessionManager() error = %v", err)
+	}
+
+	stale, err := p.sessions.load(context.Background(), sessionCookie.Value)
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 8)
+	for i := range tokens {
+		m := p.sessions
+		if i%2 == 1 {
+			m = replica
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sess, err := m.refresh(context.Background(), sessionCookie.Value, stale); err == nil {
+				tokens[i] = sess.RefreshToken
+			}
+		}()
+	}
+	wg.Wait()
+
+	if upstream.refreshes.Load() != 1 {
+		t.Errorf("refreshes = %d, want 1", upstream.refreshes.Load())
+	}
+	for i, token := range tokens {
+		if token != "refresh-1" {
+			t.Errorf("request %d got refresh token %q, want refresh-1", i, token)
+		}
+	}
+}
+
+func TestMiddlewareSeesSessionBearer(t *testing.T) {
+	upstream := httptest.NewServer(&fakeGoTrue{expiresIn: 3600})
+	defer upstream.Close()
+	sessions := store.NewMemory()
+	defer sessions.Close()
+
+	var seen string
+	logger, _ := logging.New("error", false)
+	p, err := New(Config{
+		TargetURL: upstream.URL,
+		AnonKey:   "anon",
+		Sessions:  SessionConfig{Enabled: true, Store: sessions},
+		Middleware: []func(http.Handler) http.Handler{
+			func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					seen = r.Header.Get("Authorization")
+					next.ServeHTTP(w, r)
+				})
+			},
+		},
+	}, logger, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	sessionCookie, _ := signIn(t, p)
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.AddCookie(sessionCookie)
+	p.ServeHTTP(httptest.NewRecorder(), req)
+
+	if seen != "Bearer access-0" {
+		t.Errorf("middleware saw Authorization = %q, want the session's bearer", seen)
+	}
+}
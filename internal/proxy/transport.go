@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/metrics"
+	"github.com/kacy/auth-proxy/internal/requestid"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// newUpstreamTransport wraps the transport to GoTrue with a client span per
+// round trip, which also carries the W3C traceparent upstream, with the
+// upstream metrics, and with the request ID.
+func newUpstreamTransport(next http.RoundTripper, m *metrics.Metrics) http.RoundTripper {
+	if m != nil {
+		next = &metricsTransport{next: next, metrics: m}
+	}
+	next = &requestIDTransport{next: next}
+	return otelhttp.NewTransport(next,
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "upstream " + r.Method + " " + upstreamEndpoint(r.URL.Path)
+		}),
+	)
+}
+
+// requestIDTransport sends the ID of the request being handled with every
+// upstream call, including the ones the proxy makes on its own such as
+// cookie session refreshes, so GoTrue's logs can be matched with ours.
+type requestIDTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if id := requestid.FromContext(req.Context()); id != "" && req.Header.Get(requestid.Header) != id {
+		req = req.Clone(req.Context())
+		req.Header.Set(requestid.Header, id)
+	}
+	return t.next.RoundTrip(req)
+}
+
+// metricsTransport records upstream request counts, latencies and errors.
+type metricsTransport struct {
+	next    http.RoundTripper
+	metrics *metrics.Metrics
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := upstreamEndpoint(req.URL.Path)
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	metrics.Observe(req.Context(), t.metrics.UpstreamRequestDuration.WithLabelValues(endpoint), time.Since(start).Seconds())
+
+	if err != nil {
+		t.metrics.UpstreamErrors.WithLabelValues(endpoint, "transport").Inc()
+		return nil, err
+	}
+	t.metrics.UpstreamRequestsTotal.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.StatusCode >= 500 {
+		t.metrics.UpstreamErrors.WithLabelValues(endpoint, "server_error").Inc()
+	}
+	return resp, nil
+}
+
+// upstreamEndpoints are the GoTrue endpoints given their own metric label.
+var upstreamEndpoints = map[string]bool{
+	"admin": true, "authorize": true, "callback": true, "factors": true,
+	"health": true, "invite": true, "logout": true, "magiclink": true,
+	"otp": true, "reauthenticate": true, "recover": true, "resend": true,
+	"settings": true, "signup": true, "sso": true, "token": true,
+	"user": true, "verify": true,
+}
+
+// upstreamEndpoint keeps only the first segment under /auth/v1 so IDs in
+// paths like /auth/v1/factors/{id}/verify don't blow up label cardinality.
+// Clients choose the path, so anything unknown is grouped as /other.
+func upstreamEndpoint(path string) string {
+	rest, _ := strings.CutPrefix(path, "/auth/v1/")
+	segment, _, _ := strings.Cut(rest, "/")
+	if !upstreamEndpoints[segment] {
+		return "/other"
+	}
+	return "/auth/v1/" + segment
+}
@@ -0,0 +1,303 @@
+// Package proxyproto implements a net.Listener that decodes HAProxy PROXY
+// protocol v1 and v2 headers, so connections arriving through a TCP load
+// balancer (AWS NLB, HAProxy in TCP mode) report the real client address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMissingHeader = errors.New("proxyproto: connection from trusted source has no PROXY header")
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY header")
+)
+
+// v2Signature starts every PROXY protocol v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	// v1MaxLength is the longest valid v1 header, including CRLF.
+	v1MaxLength = 107
+	v2HeaderLen = 16
+)
+
+// Config holds configuration for the PROXY protocol listener.
+type Config struct {
+	// TrustedSources are the load balancers allowed to send PROXY headers.
+	// Connections from them must carry a header; connections from anywhere
+	// else are served as-is. Empty means every connection must carry one.
+	TrustedSources []string
+	// ReadHeaderTimeout bounds how long to wait for the header.
+	ReadHeaderTimeout time.Duration
+}
+
+// Listener wraps a net.Listener and decodes PROXY headers on its connections.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+// NewListener wraps ln with PROXY protocol support.
+func NewListener(ln net.Listener, cfg Config) (*Listener, error) {
+	l := &Listener{
+		Listener: ln,
+		timeout:  cfg.ReadHeaderTimeout,
+	}
+	if l.timeout == 0 {
+		l.timeout = 5 * time.Second
+	}
+
+	for _, source := range cfg.TrustedSources {
+		prefix, err := parsePrefix(source)
+		if err != nil {
+			return nil, err
+		}
+		l.trusted = append(l.trusted, prefix)
+	}
+
+	return l, nil
+}
+
+// Accept returns the next connection. The header is read lazily on first use
+// so a slow client can't stall the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return &Conn{
+		Conn:    conn,
+		reader:  bufio.NewReaderSize(conn, 256),
+		timeout: l.timeout,
+	}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	if len(l.trusted) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection whose remote address comes from its PROXY header.
+type Conn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// Read reads from the connection after the PROXY header.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header, or the
+// transport address for LOCAL/UNKNOWN headers.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address from the PROXY header, if any.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	src, dst, err := readHeader(c.reader)
+	if err != nil {
+		c.err = err
+		return
+	}
+	c.remoteAddr = src
+	c.localAddr = dst
+}
+
+// readHeader parses a v1 or v2 header. A nil source means the header carried
+// no address (v1 UNKNOWN, v2 LOCAL or unsupported family).
+func readHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	// Every valid header is longer than the v2 signature, so a short read
+	// means the client sent something else and closed
+	peek, err := r.Peek(len(v2Signature))
+	if err != nil {
+		if len(peek) > 0 {
+			return nil, nil, ErrMissingHeader
+		}
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.Equal(peek, v2Signature):
+		return readV2(r)
+	case strings.HasPrefix(string(peek), "PROXY "):
+		return readV1(r)
+	default:
+		return nil, nil, ErrMissingHeader
+	}
+}
+
+// readV1 parses "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, nil, ErrInvalidHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	if src.Addr().Is4() != (fields[1] == "TCP4") {
+		return nil, nil, ErrInvalidHeader
+	}
+
+	return net.TCPAddrFromAddrPort(src), net.TCPAddrFromAddrPort(dst), nil
+}
+
+func parseV1Addr(ip, port string) (netip.AddrPort, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.AddrPort{}, ErrInvalidHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, ErrInvalidHeader
+	}
+	return netip.AddrPortFrom(addr, uint16(p)), nil
+}
+
+// readV2 parses the binary v2 header, ignoring any TLVs.
+func readV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 || command > 1 {
+		return nil, nil, ErrInvalidHeader
+	}
+	family := header[13]
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL: health checks from the load balancer itself
+	if command == 0 {
+		return nil, nil, nil
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if length < 12 {
+			return nil, nil, ErrInvalidHeader
+		}
+		src := netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])), binary.BigEndian.Uint16(payload[8:10]))
+		dst := netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[4:8])), binary.BigEndian.Uint16(payload[10:12]))
+		return net.TCPAddrFromAddrPort(src), net.TCPAddrFromAddrPort(dst), nil
+	case 0x21: // TCP over IPv6
+		if length < 36 {
+			return nil, nil, ErrInvalidHeader
+		}
+		src := netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])), binary.BigEndian.Uint16(payload[32:34]))
+		dst := netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[16:32])), binary.BigEndian.Uint16(payload[34:36]))
+		return net.TCPAddrFromAddrPort(src), net.TCPAddrFromAddrPort(dst), nil
+	default:
+		// UDP, unix sockets and unspecified families carry nothing useful
+		return nil, nil, nil
+	}
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q: %w", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP %q: %w", s, err)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
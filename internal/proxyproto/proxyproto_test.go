@@ -0,0 +1,165 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve accepts one connection on a PROXY listener and reports its remote
+// address and the payload that follows the header.
+func serve(t *testing.T, cfg Config, send []byte) (string, string, error) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	pl, err := NewListener(ln, cfg)
+	if err != nil {
+		t.Fatalf("NewListener() error = %v", err)
+	}
+	defer pl.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		conn.Write(send)
+		conn.Close()
+	}()
+
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatalf("Accept() error = %v", err)
+	}
+	defer conn.Close()
+
+	remote := conn.RemoteAddr().String()
+	payload, err := io.ReadAll(conn)
+	return remote, string(payload), err
+}
+
+func v2Header(command byte, family byte, addrs []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(h[14:16], uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestV1Header(t *testing.T) {
+	remote, payload, err := serve(t, Config{}, []byte("PROXY TCP4 198.51.100.7 10.0.0.1 51234 443\r\nGET / HTTP/1.1\r\n"))
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if remote != "198.51.100.7:51234" {
+		t.Errorf("RemoteAddr() = %s, want 198.51.100.7:51234", remote)
+	}
+	if payload != "GET / HTTP/1.1\r\n" {
+		t.Errorf("payload = %q, header not stripped", payload)
+	}
+}
+
+func TestV1TCP6Header(t *testing.T) {
+	remote, _, err := serve(t, Config{}, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 4000 443\r\n"))
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if remote != "[2001:db8::1]:4000" {
+		t.Errorf("RemoteAddr() = %s, want [2001:db8::1]:4000", remote)
+	}
+}
+
+func TestV2IPv4Header(t *testing.T) {
+	addrs := []byte{198, 51, 100, 7, 10, 0, 0, 1, 0xc8, 0x22, 0x01, 0xbb}
+	remote, payload, err := serve(t, Config{}, append(v2Header(1, 0x11, addrs), []byte("hello")...))
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if remote != "198.51.100.7:51234" {
+		t.Errorf("RemoteAddr() = %s, want 198.51.100.7:51234", remote)
+	}
+	if payload != "hello" {
+		t.Errorf("payload = %q, want %q", payload, "hello")
+	}
+}
+
+func TestV2IPv6HeaderWithTLV(t *testing.T) {
+	addrs := make([]byte, 36)
+	copy(addrs[0:16], net.ParseIP("2001:db8::7"))
+	copy(addrs[16:32], net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(addrs[32:34], 4000)
+	binary.BigEndian.PutUint16(addrs[34:36], 443)
+	addrs = append(addrs, 0x04, 0x00, 0x01, 0xff) // NOOP TLV
+
+	remote, _, err := serve(t, Config{}, v2Header(1, 0x21, addrs))
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if remote != "[2001:db8::7]:4000" {
+		t.Errorf("RemoteAddr() = %s, want [2001:db8::7]:4000", remote)
+	}
+}
+
+func TestV2LocalKeepsTransportAddress(t *testing.T) {
+	remote, payload, err := serve(t, Config{}, append(v2Header(0, 0x00, nil), []byte("ping")...))
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if !strings.HasPrefix(remote, "127.0.0.1:") {
+		t.Errorf("RemoteAddr() = %s, want the transport address", remote)
+	}
+	if payload != "ping" {
+		t.Errorf("payload = %q, want %q", payload, "ping")
+	}
+}
+
+func TestMissingHeaderFromTrustedSource(t *testing.T) {
+	_, _, err := serve(t, Config{}, []byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	if err != ErrMissingHeader {
+		t.Errorf("read error = %v, want ErrMissingHeader", err)
+	}
+}
+
+func TestUntrustedSourcePassesThrough(t *testing.T) {
+	remote, payload, err := serve(t, Config{TrustedSources: []string{"10.0.0.0/8"}},
+		[]byte("PROXY TCP4 6.6.6.6 10.0.0.1 1 2\r\n"))
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if !strings.HasPrefix(remote, "127.0.0.1:") {
+		t.Errorf("RemoteAddr() = %s, spoofed header from untrusted source was honored", remote)
+	}
+	if !strings.HasPrefix(payload, "PROXY") {
+		t.Errorf("payload = %q, want raw bytes passed through", payload)
+	}
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	pl, _ := NewListener(ln, Config{ReadHeaderTimeout: 50 * time.Millisecond})
+	defer pl.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer client.Close()
+
+	conn, _ := pl.Accept()
+	defer conn.Close()
+
+	start := time.Now()
+	_, err = bufio.NewReader(conn).ReadByte()
+	if err == nil {
+		t.Fatal("Read() should fail when no header arrives")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("header read took %v, timeout not applied", time.Since(start))
+	}
+}
@@ -0,0 +1,61 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		wantKept bool
+	}{
+		{"generates when missing", "", false},
+		{"keeps a uuid", "0b6f3c1e-8a4d-4f8e-9c1a-2d7e5b3f9a10", true},
+		{"keeps an ingress id", "a3f1c9e2b7d84f06", true},
+		{"replaces a header with spaces", "abc def", false},
+		{"replaces a header with newlines", "abc\ninjected", false},
+		{"replaces an oversized header", strings.Repeat("a", maxLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID, upstreamID string
+			handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = FromContext(r.Context())
+				upstreamID = r.Header.Get(Header)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				req.Header.Set(Header, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if ctxID == "" {
+				t.Fatal("no request ID in context")
+			}
+			if got := ctxID == tt.incoming; got != tt.wantKept {
+				t.Errorf("request ID = %q, kept incoming = %v, want %v", ctxID, got, tt.wantKept)
+			}
+			if upstreamID != ctxID || rec.Header().Get(Header) != ctxID {
+				t.Errorf("request header %q, response header %q, want %q", upstreamID, rec.Header().Get(Header), ctxID)
+			}
+		})
+	}
+}
+
+func TestNewIsUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := New()
+		if len(id) != 32 || seen[id] {
+			t.Fatalf("New() = %q, want a fresh 32 character ID", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,152 @@
+// Package revocation keeps a list of revoked Supabase sessions so the proxy
+// can reject access tokens that are still within their expiry after a logout,
+// a password reset or a security incident.
+package revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+// ErrRevoked is returned for tokens whose session has been revoked.
+var ErrRevoked = errors.New("session revoked")
+
+// Config holds configuration for the revocation list.
+type Config struct {
+	Store store.Store
+	// Retention is how long user-wide and global revocations are kept. It
+	// should cover the refresh token lifetime, since refreshing an old
+	// session yields new access tokens for it.
+	Retention time.Duration
+}
+
+// List records revoked sessions, users and global cut-offs.
+type List struct {
+	store     store.Store
+	retention time.Duration
+	now       func() time.Time
+}
+
+// New creates a revocation list.
+func New(cfg Config) (*List, error) {
+	if cfg.Store == nil {
+		return nil, fmt.Errorf("revocation requires a store")
+	}
+	l := &List{
+		store:     cfg.Store,
+		retention: cfg.Retention,
+		now:       time.Now,
+	}
+	if l.retention <= 0 {
+		l.retention = 30 * 24 * time.Hour
+	}
+	return l, nil
+}
+
+// RevokeSession revokes one session. Entries are kept until expiresAt, the
+// expiry of the last token issued for the session, or for the retention
+// period if that isn't known.
+func (l *List) RevokeSession(ctx context.Context, sessionID string, expiresAt time.Time) error {
+	if sessionID == "" {
+		return nil
+	}
+	ttl := l.retention
+	if !expiresAt.IsZero() {
+		if ttl = expiresAt.Sub(l.now()); ttl <= 0 {
+			return nil
+		}
+	}
+	return l.store.Set(ctx, "session:"+sessionID, []byte("1"), ttl)
+}
+
+// RevokeUser revokes every session the user signed in to up to now, except
+// keep (the caller's own session for a "log out other devices"), if set.
+func (l *List) RevokeUser(ctx context.Context, userID, keep string) error {
+	if userID == "" {
+		return nil
+	}
+	cutoff := []byte(strconv.FormatInt(l.now().Unix(), 10))
+	if err := l.store.Set(ctx, "user:"+userID, cutoff, l.retention); err != nil {
+		return err
+	}
+	if keep != "" {
+		// The exemption only holds for this cut-off, not later ones
+		return l.store.Set(ctx, "keep:"+keep, cutoff, l.retention)
+	}
+	return nil
+}
+
+// RevokeAll revokes every session signed in to up to now.
+func (l *List) RevokeAll(ctx context.Context) error {
+	cutoff := []byte(strconv.FormatInt(l.now().Unix(), 10))
+	return l.store.Set(ctx, "all", cutoff, l.retention)
+}
+
+// Check returns ErrRevoked if the token's session has been revoked.
+func (l *List) Check(ctx context.Context, claims *jwt.Claims) error {
+	if claims.SessionID != "" {
+		if _, err := l.store.Get(ctx, "session:"+claims.SessionID); err == nil {
+			return ErrRevoked
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+
+	start := SessionStart(claims)
+
+	if claims.Subject != "" {
+		cutoff, err := l.store.Get(ctx, "user:"+claims.Subject)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+		case err != nil:
+			return err
+		case before(start, cutoff):
+			keep, err := l.store.Get(ctx, "keep:"+claims.SessionID)
+			if err != nil && !errors.Is(err, store.ErrNotFound) {
+				return err
+			}
+			if claims.SessionID == "" || string(keep) != string(cutoff) {
+				return ErrRevoked
+			}
+		}
+	}
+
+	cutoff, err := l.store.Get(ctx, "all")
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+	case err != nil:
+		return err
+	case before(start, cutoff):
+		return ErrRevoked
+	}
+	return nil
+}
+
+// SessionStart is when the user signed in to the token's session: the
+// earliest authentication method timestamp, which survives refreshes and
+// MFA step-ups. Tokens without "amr" fall back to "iat".
+func SessionStart(claims *jwt.Claims) int64 {
+	var start int64
+	for _, m := range claims.AMR {
+		if m.Timestamp > 0 && (start == 0 || m.Timestamp < start) {
+			start = m.Timestamp
+		}
+	}
+	if start == 0 {
+		start = claims.IssuedAt
+	}
+	return start
+}
+
+// before reports whether a session that started at start is covered by the
+// cut-off. Sessions started in the same second count as revoked.
+func before(start int64, cutoff []byte) bool {
+	t, err := strconv.ParseInt(string(cutoff), 10, 64)
+	return err == nil && start <= t
+}
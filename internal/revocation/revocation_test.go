@@ -0,0 +1,139 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/jwt"
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func newTestList(t *testing.T) (*List, *time.Time) {
+	t.Helper()
+	s := store.NewMemory()
+	t.Cleanup(func() { s.Close() })
+	l, err := New(Config{Store: s})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := time.Unix(1_800_000_000, 0)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func claims(user, session string, signedIn int64) *jwt.Claims {
+	return &jwt.Claims{
+		Subject:   user,
+		SessionID: session,
+		IssuedAt:  signedIn + 3000,
+		AMR:       []jwt.AMR{{Method: "password", Timestamp: signedIn}, {Method: "totp", Timestamp: signedIn + 2000}},
+	}
+}
+
+func TestRevocation(t *testing.T) {
+	const t0 = 1_800_000_000
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		revoke  func(l *List) error
+		claims  *jwt.Claims
+		revoked bool
+	}{
+		{
+			name:    "nothing revoked",
+			revoke:  func(l *List) error { return nil },
+			claims:  claims("u1", "s1", t0-100),
+			revoked: false,
+		},
+		{
+			name:    "session",
+			revoke:  func(l *List) error { return l.RevokeSession(ctx, "s1", time.Unix(t0+3600, 0)) },
+			claims:  claims("u1", "s1", t0-100),
+			revoked: true,
+		},
+		{
+			name:    "other session",
+			revoke:  func(l *List) error { return l.RevokeSession(ctx, "s2", time.Unix(t0+3600, 0)) },
+			claims:  claims("u1", "s1", t0-100),
+			revoked: false,
+		},
+		{
+			name:    "user revokes earlier sign-ins even after refresh and step-up",
+			revoke:  func(l *List) error { return l.RevokeUser(ctx, "u1", "") },
+			claims:  claims("u1", "s1", t0-5000),
+			revoked: true,
+		},
+		{
+			name:    "user allows later sign-ins",
+			revoke:  func(l *List) error { return l.RevokeUser(ctx, "u1", "") },
+			claims:  claims("u1", "s1", t0+10),
+			revoked: false,
+		},
+		{
+			name:    "user keeps the caller's session",
+			revoke:  func(l *List) error { return l.RevokeUser(ctx, "u1", "s1") },
+			claims:  claims("u1", "s1", t0-100),
+			revoked: false,
+		},
+		{
+			name:    "other user",
+			revoke:  func(l *List) error { return l.RevokeUser(ctx, "u2", "") },
+			claims:  claims("u1", "s1", t0-100),
+			revoked: false,
+		},
+		{
+			name:    "all",
+			revoke:  func(l *List) error { return l.RevokeAll(ctx) },
+			claims:  claims("u1", "s1", t0-100),
+			revoked: true,
+		},
+		{
+			name:    "all allows later sign-ins",
+			revoke:  func(l *List) error { return l.RevokeAll(ctx) },
+			claims:  claims("u1", "s1", t0+10),
+			revoked: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestList(t)
+			if err := tt.revoke(l); err != nil {
+				t.Fatalf("revoke error = %v", err)
+			}
+			err := l.Check(ctx, tt.claims)
+			if errors.Is(err, ErrRevoked) != tt.revoked {
+				t.Errorf("Check() error = %v, want revoked = %v", err, tt.revoked)
+			}
+		})
+	}
+}
+
+func TestKeptSessionLosesExemptionOnLaterRevoke(t *testing.T) {
+	l, now := newTestList(t)
+	ctx := context.Background()
+	c := claims("u1", "s1", now.Unix()-100)
+
+	l.RevokeUser(ctx, "u1", "s1")
+	if err := l.Check(ctx, c); err != nil {
+		t.Fatalf("Check() after logout of other sessions error = %v", err)
+	}
+
+	*now = now.Add(time.Minute)
+	l.RevokeUser(ctx, "u1", "")
+	if err := l.Check(ctx, c); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Check() after a later user revoke error = %v, want ErrRevoked", err)
+	}
+}
+
+func TestSessionStart(t *testing.T) {
+	if got := SessionStart(&jwt.Claims{IssuedAt: 50}); got != 50 {
+		t.Errorf("SessionStart() without amr = %d, want iat", got)
+	}
+	if got := SessionStart(claims("u", "s", 100)); got != 100 {
+		t.Errorf("SessionStart() = %d, want earliest amr timestamp", got)
+	}
+}
@@ -0,0 +1,241 @@
+// Package signup enforces email policy on new accounts: domain allow and deny
+// lists, disposable-address blocking and alias normalization to catch the same
+// mailbox signing up twice.
+package signup
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+var (
+	ErrInvalidEmail     = errors.New("invalid email address")
+	ErrDomainNotAllowed = errors.New("email domain not allowed")
+	ErrDisposableEmail  = errors.New("disposable email address")
+	ErrDuplicateAlias   = errors.New("email is an alias of an existing account")
+)
+
+//go:embed disposable_domains.txt
+var bundledDisposableDomains string
+
+// Config holds configuration for the signup policy.
+type Config struct {
+	// AllowedDomains, if set, is the only set of domains (and their
+	// subdomains) that may sign up.
+	AllowedDomains []string
+	// DeniedDomains are rejected along with their subdomains.
+	DeniedDomains []string
+	// BlockDisposable rejects addresses on the bundled disposable list.
+	BlockDisposable bool
+	// DisposableFile adds domains to the bundled disposable list.
+	DisposableFile string
+	// ReplaceDisposable uses DisposableFile instead of the bundled list.
+	ReplaceDisposable bool
+	// DetectAliases rejects signups whose normalized address (lowercased,
+	// +tag removed, Gmail dots removed) already belongs to another signup.
+	DetectAliases bool
+	// AliasRetention is how long a signup address is remembered for alias
+	// detection. Defaults to a year.
+	AliasRetention time.Duration
+	// Store records normalized addresses; required when DetectAliases is set.
+	Store store.Store
+}
+
+// Policy evaluates signup emails against the configured rules.
+type Policy struct {
+	allowed       domainSet
+	denied        domainSet
+	disposable    domainSet
+	detectAliases bool
+	retention     time.Duration
+	store         store.Store
+}
+
+// New creates a signup policy.
+func New(cfg Config) (*Policy, error) {
+	p := &Policy{
+		allowed:       newDomainSet(cfg.AllowedDomains),
+		denied:        newDomainSet(cfg.DeniedDomains),
+		detectAliases: cfg.DetectAliases,
+		retention:     cfg.AliasRetention,
+		store:         cfg.Store,
+	}
+	if p.retention <= 0 {
+		p.retention = 365 * 24 * time.Hour
+	}
+
+	if p.detectAliases && p.store == nil {
+		return nil, fmt.Errorf("alias detection requires a store")
+	}
+
+	if cfg.ReplaceDisposable && cfg.DisposableFile == "" {
+		return nil, fmt.Errorf("replacing the disposable domain list requires a disposable domains file")
+	}
+
+	if cfg.BlockDisposable {
+		p.disposable = domainSet{}
+		if !cfg.ReplaceDisposable {
+			p.disposable.load(strings.NewReader(bundledDisposableDomains))
+		}
+
+		if cfg.DisposableFile != "" {
+			f, err := os.Open(cfg.DisposableFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open disposable domains file: %w", err)
+			}
+			defer f.Close()
+			if err := p.disposable.load(f); err != nil {
+				return nil, fmt.Errorf("failed to read disposable domains file: %w", err)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// Check validates an email for signup. Store failures are returned as-is so
+// the caller can decide whether to fail open.
+func (p *Policy) Check(ctx context.Context, email string) error {
+	addr, domain, err := parse(email)
+	if err != nil {
+		return err
+	}
+
+	if len(p.allowed) > 0 && !p.allowed.contains(domain) {
+		return ErrDomainNotAllowed
+	}
+	if p.denied.contains(domain) {
+		return ErrDomainNotAllowed
+	}
+	if p.disposable.contains(domain) {
+		return ErrDisposableEmail
+	}
+
+	if p.detectAliases {
+		normalized, err := Normalize(addr)
+		if err != nil {
+			return err
+		}
+		existing, err := p.store.Get(ctx, normalized)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+		case err != nil:
+			return err
+		case string(existing) != addr:
+			return ErrDuplicateAlias
+		}
+	}
+
+	return nil
+}
+
+// Record remembers a successful signup for alias detection.
+func (p *Policy) Record(ctx context.Context, email string) error {
+	if !p.detectAliases {
+		return nil
+	}
+	addr, _, err := parse(email)
+	if err != nil {
+		return err
+	}
+	normalized, err := Normalize(addr)
+	if err != nil {
+		return err
+	}
+	return p.store.Set(ctx, normalized, []byte(addr), p.retention)
+}
+
+// Normalize reduces an address to the mailbox it delivers to: lowercased,
+// with any +tag removed, and for Gmail with dots removed and googlemail.com
+// folded into gmail.com.
+// Example: "John.Doe+promo@GoogleMail.com" -> "johndoe@gmail.com"
+func Normalize(email string) (string, error) {
+	addr, domain, err := parse(email)
+	if err != nil {
+		return "", err
+	}
+
+	local := addr[:strings.LastIndex(addr, "@")]
+	if i := strings.IndexByte(local, '+'); i > 0 {
+		local = local[:i]
+	}
+
+	if domain == "googlemail.com" {
+		domain = "gmail.com"
+	}
+	if domain == "gmail.com" {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	return local + "@" + domain, nil
+}
+
+// parse lowercases an address and splits off its domain.
+func parse(email string) (addr, domain string, err error) {
+	addr = strings.ToLower(strings.TrimSpace(email))
+	i := strings.LastIndex(addr, "@")
+	if i <= 0 || i == len(addr)-1 {
+		return "", "", ErrInvalidEmail
+	}
+	domain = addr[i+1:]
+	if !strings.Contains(domain, ".") {
+		return "", "", ErrInvalidEmail
+	}
+	return addr, domain, nil
+}
+
+// domainSet matches a domain and all of its subdomains.
+type domainSet map[string]bool
+
+func newDomainSet(domains []string) domainSet {
+	set := domainSet{}
+	for _, d := range domains {
+		set.add(d)
+	}
+	return set
+}
+
+func (s domainSet) add(domain string) {
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain != "" {
+		s[domain] = true
+	}
+}
+
+// load reads one domain per line, skipping blank lines and # comments.
+func (s domainSet) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s.add(line)
+	}
+	return scanner.Err()
+}
+
+func (s domainSet) contains(domain string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for {
+		if s[domain] {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
@@ -0,0 +1,168 @@
+package signup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"User@Example.com", "user@example.com"},
+		{"john.doe+promo@gmail.com", "johndoe@gmail.com"},
+		{"John.Doe@GoogleMail.com", "johndoe@gmail.com"},
+		{"first.last+tag@example.com", "first.last@example.com"},
+		{"+tag@example.com", "+tag@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			got, err := Normalize(tt.email)
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   Config
+		email string
+		want  error
+	}{
+		{"no rules", Config{}, "a@example.com", nil},
+		{"invalid address", Config{}, "not-an-email", ErrInvalidEmail},
+		{"allowlisted domain", Config{AllowedDomains: []string{"corp.com"}}, "a@corp.com", nil},
+		{"allowlisted subdomain", Config{AllowedDomains: []string{"corp.com"}}, "a@eng.corp.com", nil},
+		{"outside allowlist", Config{AllowedDomains: []string{"corp.com"}}, "a@gmail.com", ErrDomainNotAllowed},
+		{"lookalike outside allowlist", Config{AllowedDomains: []string{"corp.com"}}, "a@evilcorp.com", ErrDomainNotAllowed},
+		{"denied domain", Config{DeniedDomains: []string{"competitor.com"}}, "a@Competitor.com", ErrDomainNotAllowed},
+		{"disposable blocked", Config{BlockDisposable: true}, "a@mailinator.com", ErrDisposableEmail},
+		{"disposable subdomain blocked", Config{BlockDisposable: true}, "a@x.yopmail.com", ErrDisposableEmail},
+		{"disposable allowed when off", Config{}, "a@mailinator.com", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.cfg)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := p.Check(context.Background(), tt.email); !errors.Is(err, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.email, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyDisposableFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "extra.txt")
+	os.WriteFile(path, []byte("# custom\nburner.example\n"), 0o600)
+
+	p, err := New(Config{BlockDisposable: true, DisposableFile: path})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Check(context.Background(), "a@burner.example"); err != ErrDisposableEmail {
+		t.Errorf("custom disposable domain: Check() = %v, want ErrDisposableEmail", err)
+	}
+	if err := p.Check(context.Background(), "a@mailinator.com"); err != ErrDisposableEmail {
+		t.Errorf("bundled disposable domain: Check() = %v, want ErrDisposableEmail", err)
+	}
+}
+
+func TestPolicyReplaceDisposable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "only.txt")
+	os.WriteFile(path, []byte("burner.example\n"), 0o600)
+
+	p, err := New(Config{BlockDisposable: true, DisposableFile: path, ReplaceDisposable: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := p.Check(context.Background(), "a@burner.example"); err != ErrDisposableEmail {
+		t.Errorf("listed domain: Check() = %v, want ErrDisposableEmail", err)
+	}
+	if err := p.Check(context.Background(), "a@mailinator.com"); err != nil {
+		t.Errorf("bundled domain after replace: Check() = %v, want nil", err)
+	}
+
+	if _, err := New(Config{BlockDisposable: true, ReplaceDisposable: true}); err == nil {
+		t.Error("New() replacing the list without a file should fail")
+	}
+}
+
+// ttlStore records the TTL of the last Set.
+type ttlStore struct {
+	store.Store
+	ttl time.Duration
+}
+
+func (s *ttlStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.ttl = ttl
+	return s.Store.Set(ctx, key, value, ttl)
+}
+
+func TestRecordExpires(t *testing.T) {
+	mem := store.NewMemory()
+	defer mem.Close()
+	s := &ttlStore{Store: mem}
+
+	p, err := New(Config{DetectAliases: true, Store: s})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	p.Record(context.Background(), "john.doe@gmail.com")
+	if s.ttl != 365*24*time.Hour {
+		t.Errorf("default retention = %v, want a year", s.ttl)
+	}
+
+	p, _ = New(Config{DetectAliases: true, Store: s, AliasRetention: time.Hour})
+	p.Record(context.Background(), "john.doe@gmail.com")
+	if s.ttl != time.Hour {
+		t.Errorf("retention = %v, want 1h", s.ttl)
+	}
+}
+
+func TestPolicyDetectsAliases(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	p, err := New(Config{DetectAliases: true, Store: s})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if err := p.Check(ctx, "john.doe@gmail.com"); err != nil {
+		t.Fatalf("first signup: Check() = %v", err)
+	}
+	p.Record(ctx, "john.doe@gmail.com")
+
+	if err := p.Check(ctx, "johndoe+spam@gmail.com"); err != ErrDuplicateAlias {
+		t.Errorf("alias signup: Check() = %v, want ErrDuplicateAlias", err)
+	}
+	// Retrying the exact same address is left to GoTrue
+	if err := p.Check(ctx, "John.Doe@gmail.com"); err != nil {
+		t.Errorf("same address: Check() = %v, want nil", err)
+	}
+}
+
+func TestNewRequiresStoreForAliases(t *testing.T) {
+	if _, err := New(Config{DetectAliases: true}); err == nil {
+		t.Error("New() with DetectAliases and no store should fail")
+	}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// cleanupInterval is how often expired entries are swept from memory.
+const cleanupInterval = time.Minute
+
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// Memory is an in-memory Store. State is lost on restart and not shared
+// between instances.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewMemory creates an in-memory store and starts its cleanup loop.
+func NewMemory() *Memory {
+	m := &Memory{
+		entries: make(map[string]memoryEntry),
+		done:    make(chan struct{}),
+	}
+	go m.cleanup()
+	return m
+}
+
+// Get returns the value for key, or ErrNotFound.
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+// Set stores value under key.
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = newMemoryEntry(value, ttl)
+	return nil
+}
+
+// Incr adds one to the counter at key.
+func (m *Memory) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		m.entries[key] = newMemoryEntry([]byte("1"), ttl)
+		return 1, nil
+	}
+
+	n, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n++
+	entry.value = []byte(strconv.FormatInt(n, 10))
+	m.entries[key] = entry
+	return n, nil
+}
+
+// Decr subtracts one from the counter at key if it exists.
+func (m *Memory) Decr(ctx context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n--
+	entry.value = []byte(strconv.FormatInt(n, 10))
+	m.entries[key] = entry
+	return n, nil
+}
+
+// Delete removes key.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// Close stops the cleanup loop.
+func (m *Memory) Close() error {
+	m.once.Do(func() { close(m.done) })
+	return nil
+}
+
+func newMemoryEntry(value []byte, ttl time.Duration) memoryEntry {
+	entry := memoryEntry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	return entry
+}
+
+func (m *Memory) cleanup() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for key, entry := range m.entries {
+				if entry.expired(now) {
+					delete(m.entries, key)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
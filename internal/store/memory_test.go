@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryGetSetDelete(t *testing.T) {
+	s := NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	if _, err := s.Get(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("Get() on missing key error = %v, want ErrNotFound", err)
+	}
+
+	s.Set(ctx, "k", []byte("v"), 0)
+	got, err := s.Get(ctx, "k")
+	if err != nil || string(got) != "v" {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "v")
+	}
+
+	s.Delete(ctx, "k")
+	if _, err := s.Get(ctx, "k"); err != ErrNotFound {
+		t.Errorf("Get() after Delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	s := NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Set(ctx, "k", []byte("v"), 10*time.Millisecond)
+	if _, err := s.Get(ctx, "k"); err != nil {
+		t.Fatalf("Get() before expiry error = %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := s.Get(ctx, "k"); err != ErrNotFound {
+		t.Errorf("Get() after expiry error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryIncr(t *testing.T) {
+	s := NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := s.Incr(ctx, "counter", 10*time.Millisecond)
+		if err != nil || got != want {
+			t.Fatalf("Incr() = %d, %v, want %d", got, err, want)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := s.Incr(ctx, "counter", time.Minute); got != 1 {
+		t.Errorf("Incr() after expiry = %d, want 1", got)
+	}
+}
+
+func TestMemoryDecr(t *testing.T) {
+	s := NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	s.Incr(ctx, "counter", time.Minute)
+	s.Incr(ctx, "counter", time.Minute)
+	if got, err := s.Decr(ctx, "counter"); err != nil || got != 1 {
+		t.Fatalf("Decr() = %d, %v, want 1", got, err)
+	}
+	if got, err := s.Decr(ctx, "missing"); err != nil || got != 0 {
+		t.Errorf("Decr() of a missing key = %d, %v, want 0", got, err)
+	}
+	if _, err := s.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Decr() created a missing key, Get() error = %v", err)
+	}
+}
+
+func TestRollingIncr(t *testing.T) {
+	s := NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	for want := int64(1); want <= 5; want++ {
+		got, err := RollingIncr(ctx, s, "sends", time.Hour)
+		if err != nil {
+			t.Fatalf("RollingIncr() error = %v", err)
+		}
+		// The previous bucket is empty, so the estimate is exact
+		if got != want {
+			t.Errorf("RollingIncr() = %d, want %d", got, want)
+		}
+	}
+
+	if got, _ := RollingIncr(ctx, s, "other", time.Hour); got != 1 {
+		t.Errorf("RollingIncr() for a different key = %d, want 1", got)
+	}
+
+	for range 2 {
+		if got, _ := RollingCount(ctx, s, "sends", time.Hour); got != 5 {
+			t.Errorf("RollingCount() = %d, want 5 without recording", got)
+		}
+	}
+	if got, _ := RollingCount(ctx, s, "unseen", time.Hour); got != 0 {
+		t.Errorf("RollingCount() for an unseen key = %d, want 0", got)
+	}
+}
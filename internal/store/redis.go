@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Store backed by a shared Redis client. Keys are namespaced with
+// a prefix so several stores can share one database.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedis creates a Redis-backed store. The client is owned by the caller
+// and is not closed by Close.
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Get returns the value for key, or ErrNotFound.
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.client.Get(ctx, r.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+// Set stores value under key.
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, r.prefix+key, value, ttl).Err()
+}
+
+// incrScript increments a counter and sets its expiry only on creation, so
+// later increments don't extend the window.
+var incrScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+// Incr adds one to the counter at key, setting the expiry when it is created.
+func (r *Redis) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	return incrScript.Run(ctx, r.client, []string{r.prefix + key}, ttl.Milliseconds()).Int64()
+}
+
+// decrScript decrements a counter only if it exists, so undoing an
+// increment after the counter expired doesn't leave a stray key without a TTL.
+var decrScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('DECR', KEYS[1])
+`)
+
+// Decr subtracts one from the counter at key if it exists.
+func (r *Redis) Decr(ctx context.Context, key string) (int64, error) {
+	return decrScript.Run(ctx, r.client, []string{r.prefix + key}).Int64()
+}
+
+// Delete removes key.
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, r.prefix+key).Err()
+}
+
+// Close is a no-op; the shared client is closed by its owner.
+func (r *Redis) Close() error {
+	return nil
+}
@@ -0,0 +1,37 @@
+// Package store provides a small key/value abstraction with TTLs, backed by
+// memory for single-instance deployments or Redis for shared state.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned when a key does not exist or has expired.
+var ErrNotFound = errors.New("key not found")
+
+// Store is a key/value store with per-key expiry.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the value for key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value under key. A ttl of 0 means no expiry.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Incr atomically adds one to the integer stored at key and returns the
+	// new value. A missing key starts at zero and gets the given ttl.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+
+	// Decr atomically subtracts one from the integer stored at key, keeping
+	// its expiry, and returns the new value. A missing key is left missing
+	// and reported as zero.
+	Decr(ctx context.Context, key string) (int64, error)
+
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// Close releases resources held by the store.
+	Close() error
+}
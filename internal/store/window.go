@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RollingIncr records an event under key and returns the approximate number
+// of events in the last window. It keeps two fixed-window counters and weights
+// the previous one by how much of it still overlaps the rolling window, which
+// avoids the burst a plain fixed window allows at its boundary.
+func RollingIncr(ctx context.Context, s Store, key string, window time.Duration) (int64, error) {
+	return rolling(ctx, s, key, window, true)
+}
+
+// RollingCount returns the approximate number of events RollingIncr recorded
+// under key in the last window, without recording one.
+func RollingCount(ctx context.Context, s Store, key string, window time.Duration) (int64, error) {
+	return rolling(ctx, s, key, window, false)
+}
+
+// RollingDecr takes back an event RollingIncr just recorded under key, for
+// callers that count first and then find the event over a limit. If the
+// window rolled over in between, the event stays counted.
+func RollingDecr(ctx context.Context, s Store, key string, window time.Duration) error {
+	bucket := time.Now().UnixNano() / int64(window)
+	_, err := s.Decr(ctx, fmt.Sprintf("%s:%d", key, bucket))
+	return err
+}
+
+func rolling(ctx context.Context, s Store, key string, window time.Duration, record bool) (int64, error) {
+	now := time.Now()
+	bucket := now.UnixNano() / int64(window)
+
+	var current int64
+	var err error
+	if record {
+		current, err = s.Incr(ctx, fmt.Sprintf("%s:%d", key, bucket), 2*window)
+	} else {
+		current, err = counter(ctx, s, fmt.Sprintf("%s:%d", key, bucket))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	previous, err := counter(ctx, s, fmt.Sprintf("%s:%d", key, bucket-1))
+	if err != nil {
+		return 0, err
+	}
+
+	elapsed := float64(now.UnixNano()%int64(window)) / float64(window)
+	return current + int64(float64(previous)*(1-elapsed)), nil
+}
+
+// counter reads a counter written by Incr, treating a missing one as zero.
+func counter(ctx context.Context, s Store, key string) (int64, error) {
+	value, err := s.Get(ctx, key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+	n, _ := strconv.ParseInt(string(value), 10, 64)
+	return n, nil
+}
@@ -0,0 +1,254 @@
+// Package tokenwrap seals Supabase refresh tokens into opaque handles so
+// clients never hold the raw token. Handles are AES-GCM sealed under a
+// rotating key set and bound to the attested device key ID, so a handle copied
+// off one device can't be redeemed from another.
+package tokenwrap
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+// prefix marks a wrapped handle and its format version.
+const prefix = "rtw1."
+
+// sessionSeparator splits the session ID from the refresh token in a sealed
+// handle. Handles sealed before sessions were recorded hold only the token.
+const sessionSeparator = "\x00"
+
+// revokedSessionTTL is how long a logged-out session's handles stay revoked.
+// GoTrue revokes the session's refresh tokens on logout too, so this only has
+// to outlast the window in which a replayed handle could race that.
+const revokedSessionTTL = 30 * 24 * time.Hour
+
+// ErrInvalidHandle is returned for handles that are malformed, sealed with an
+// unknown key, bound to another device or revoked.
+var ErrInvalidHandle = errors.New("invalid refresh token handle")
+
+// Key is one sealing key. Secret must be 32 bytes (AES-256).
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys parses "id:base64secret" entries. The first key seals new handles;
+// the rest are only used to open handles sealed before a rotation.
+func ParseKeys(entries []string) ([]Key, error) {
+	keys := make([]Key, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" || strings.ContainsAny(id, ".|") {
+			return nil, fmt.Errorf("invalid key entry, expected id:base64secret")
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: invalid base64: %w", id, err)
+		}
+		if len(secret) != 32 {
+			return nil, fmt.Errorf("key %q: must be 32 bytes, got %d", id, len(secret))
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		seen[id] = true
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// Config holds configuration for the wrapper.
+type Config struct {
+	// Keys seal and open handles; Keys[0] is the active key.
+	Keys []Key
+	// Store holds each device's binding generation. Bumping it revokes every
+	// handle issued to the device.
+	Store store.Store
+	// AcceptUnwrapped lets raw refresh tokens through, so sessions started
+	// before wrapping was enabled keep working until their next refresh.
+	AcceptUnwrapped bool
+}
+
+// Wrapper seals and opens refresh token handles.
+type Wrapper struct {
+	keys            atomic.Pointer[keyring]
+	store           store.Store
+	acceptUnwrapped bool
+}
+
+// keyring is the key set in use, swapped whole when the keys are reloaded.
+type keyring struct {
+	active string
+	aeads  map[string]cipher.AEAD
+}
+
+// New creates a wrapper.
+func New(cfg Config) (*Wrapper, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("at least one wrapping key is required")
+	}
+	if cfg.Store == nil {
+		return nil, fmt.Errorf("refresh token wrapping requires a store")
+	}
+
+	w := &Wrapper{
+		store:           cfg.Store,
+		acceptUnwrapped: cfg.AcceptUnwrapped,
+	}
+	if err := w.SetKeys(cfg.Keys); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// SetKeys replaces the key set, for rotating keys without a restart. Handles
+// sealed under a key that is no longer listed stop opening.
+func (w *Wrapper) SetKeys(keys []Key) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("at least one wrapping key is required")
+	}
+
+	ring := &keyring{
+		active: keys[0].ID,
+		aeads:  make(map[string]cipher.AEAD, len(keys)),
+	}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", key.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", key.ID, err)
+		}
+		ring.aeads[key.ID] = aead
+	}
+	w.keys.Store(ring)
+	return nil
+}
+
+// IsWrapped reports whether s looks like a handle rather than a raw token.
+func IsWrapped(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// Wrap seals a refresh token for a device. The handle records sessionID, if
+// known, so RevokeSession can invalidate it.
+func (w *Wrapper) Wrap(ctx context.Context, refreshToken, deviceID, sessionID string) (string, error) {
+	generation, err := w.generation(ctx, deviceID)
+	if err != nil {
+		return "", err
+	}
+
+	ring := w.keys.Load()
+	aead := ring.aeads[ring.active]
+	plaintext := refreshToken
+	if sessionID != "" {
+		plaintext = sessionID + sessionSeparator + refreshToken
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), additionalData(ring.active, deviceID, generation))
+	return prefix + ring.active + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Unwrap opens a handle presented by a device and returns the refresh token.
+// Raw tokens are returned unchanged if AcceptUnwrapped is set.
+func (w *Wrapper) Unwrap(ctx context.Context, handle, deviceID string) (string, error) {
+	if !IsWrapped(handle) {
+		if w.acceptUnwrapped {
+			return handle, nil
+		}
+		return "", ErrInvalidHandle
+	}
+
+	kid, encoded, ok := strings.Cut(strings.TrimPrefix(handle, prefix), ".")
+	if !ok {
+		return "", ErrInvalidHandle
+	}
+	aead, ok := w.keys.Load().aeads[kid]
+	if !ok {
+		return "", ErrInvalidHandle
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrInvalidHandle
+	}
+
+	generation, err := w.generation(ctx, deviceID)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData(kid, deviceID, generation))
+	if err != nil {
+		return "", ErrInvalidHandle
+	}
+
+	sessionID, refreshToken, ok := strings.Cut(string(plaintext), sessionSeparator)
+	if !ok {
+		return sessionID, nil
+	}
+	_, err = w.store.Get(ctx, "session:"+sessionID)
+	if err == nil {
+		return "", ErrInvalidHandle
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// RevokeSession invalidates the handles issued for one session, leaving the
+// device's other sessions alone.
+func (w *Wrapper) RevokeSession(ctx context.Context, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+	return w.store.Set(ctx, "session:"+sessionID, []byte("revoked"), revokedSessionTTL)
+}
+
+// RevokeDevice invalidates every handle issued to a device by rotating its
+// binding. The device's current GoTrue sessions are left alone; they just
+// can't be refreshed from a wrapped handle any more.
+func (w *Wrapper) RevokeDevice(ctx context.Context, deviceID string) error {
+	if deviceID == "" {
+		return nil
+	}
+	_, err := w.store.Incr(ctx, "device:"+deviceID, 0)
+	return err
+}
+
+// generation returns the device's current binding generation.
+func (w *Wrapper) generation(ctx context.Context, deviceID string) (int64, error) {
+	if deviceID == "" {
+		return 0, nil
+	}
+	value, err := w.store.Get(ctx, "device:"+deviceID)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// additionalData binds a handle to its key, device and binding generation.
+func additionalData(kid, deviceID string, generation int64) []byte {
+	return []byte(prefix + kid + "|" + deviceID + "|" + strconv.FormatInt(generation, 10))
+}
@@ -0,0 +1,201 @@
+package tokenwrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kacy/auth-proxy/internal/store"
+)
+
+func testKey(id string, fill byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{fill}, 32)}
+}
+
+func newTestWrapper(t *testing.T, s store.Store, acceptUnwrapped bool, keys ...Key) *Wrapper {
+	t.Helper()
+	w, err := New(Config{Keys: keys, Store: s, AcceptUnwrapped: acceptUnwrapped})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return w
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr bool
+	}{
+		{"valid", []string{"k2:" + secret, "k1:" + secret}, false},
+		{"missing id", []string{":" + secret}, true},
+		{"no separator", []string{secret}, true},
+		{"short secret", []string{"k1:" + base64.StdEncoding.EncodeToString([]byte("short"))}, true},
+		{"bad base64", []string{"k1:!!!"}, true},
+		{"duplicate", []string{"k1:" + secret, "k1:" + secret}, true},
+		{"dot in id", []string{"k.1:" + secret}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeys(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && keys[0].ID != "k2" {
+				t.Errorf("first key = %q, want k2", keys[0].ID)
+			}
+		})
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+	w := newTestWrapper(t, s, false, testKey("k1", 1))
+
+	handle, err := w.Wrap(ctx, "raw-refresh", "device-a", "")
+	if err != nil {
+		t.Fatalf("Wrap() error = %v", err)
+	}
+	if !IsWrapped(handle) || strings.Contains(handle, "raw-refresh") {
+		t.Fatalf("handle %q does not look wrapped", handle)
+	}
+
+	tests := []struct {
+		name    string
+		handle  string
+		device  string
+		want    string
+		wantErr error
+	}{
+		{"same device", handle, "device-a", "raw-refresh", nil},
+		{"other device", handle, "device-b", "", ErrInvalidHandle},
+		{"no device", handle, "", "", ErrInvalidHandle},
+		{"tampered", handle[:len(handle)-2] + "AA", "device-a", "", ErrInvalidHandle},
+		{"unknown key", strings.Replace(handle, "rtw1.k1.", "rtw1.k9.", 1), "device-a", "", ErrInvalidHandle},
+		{"raw token rejected", "raw-refresh", "device-a", "", ErrInvalidHandle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := w.Unwrap(ctx, tt.handle, tt.device)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Unwrap() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Unwrap() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	old := newTestWrapper(t, s, false, testKey("k1", 1))
+	handle, _ := old.Wrap(ctx, "raw-refresh", "device-a", "")
+
+	rotated := newTestWrapper(t, s, false, testKey("k2", 2), testKey("k1", 1))
+	if got, err := rotated.Unwrap(ctx, handle, "device-a"); err != nil || got != "raw-refresh" {
+		t.Fatalf("Unwrap() after rotation = %q, %v", got, err)
+	}
+	fresh, _ := rotated.Wrap(ctx, "raw-refresh", "device-a", "")
+	if !strings.HasPrefix(fresh, "rtw1.k2.") {
+		t.Errorf("new handle %q not sealed with the active key", fresh)
+	}
+
+	retired := newTestWrapper(t, s, false, testKey("k2", 2))
+	if _, err := retired.Unwrap(ctx, handle, "device-a"); !errors.Is(err, ErrInvalidHandle) {
+		t.Errorf("Unwrap() with retired key error = %v, want ErrInvalidHandle", err)
+	}
+}
+
+func TestSetKeys(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+
+	w := newTestWrapper(t, s, false, testKey("k1", 1))
+	handle, _ := w.Wrap(ctx, "raw-refresh", "device-a", "")
+
+	if err := w.SetKeys(nil); err == nil {
+		t.Error("SetKeys(nil) = nil, want an error")
+	}
+	if err := w.SetKeys([]Key{testKey("k2", 2), testKey("k1", 1)}); err != nil {
+		t.Fatalf("SetKeys() error = %v", err)
+	}
+	if got, err := w.Unwrap(ctx, handle, "device-a"); err != nil || got != "raw-refresh" {
+		t.Fatalf("Unwrap() after SetKeys = %q, %v", got, err)
+	}
+	if fresh, _ := w.Wrap(ctx, "raw-refresh", "device-a", ""); !strings.HasPrefix(fresh, "rtw1.k2.") {
+		t.Errorf("new handle %q not sealed with the new active key", fresh)
+	}
+}
+
+func TestRevokeDevice(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+	w := newTestWrapper(t, s, false, testKey("k1", 1))
+
+	revoked, _ := w.Wrap(ctx, "raw-a", "device-a", "")
+	other, _ := w.Wrap(ctx, "raw-b", "device-b", "")
+
+	if err := w.RevokeDevice(ctx, "device-a"); err != nil {
+		t.Fatalf("RevokeDevice() error = %v", err)
+	}
+	if _, err := w.Unwrap(ctx, revoked, "device-a"); !errors.Is(err, ErrInvalidHandle) {
+		t.Errorf("revoked handle error = %v, want ErrInvalidHandle", err)
+	}
+	if got, err := w.Unwrap(ctx, other, "device-b"); err != nil || got != "raw-b" {
+		t.Errorf("other device Unwrap() = %q, %v", got, err)
+	}
+
+	fresh, _ := w.Wrap(ctx, "raw-a2", "device-a", "")
+	if got, err := w.Unwrap(ctx, fresh, "device-a"); err != nil || got != "raw-a2" {
+		t.Errorf("handle issued after revocation Unwrap() = %q, %v", got, err)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	ctx := context.Background()
+	w := newTestWrapper(t, s, false, testKey("k1", 1))
+
+	revoked, _ := w.Wrap(ctx, "raw-a", "device-a", "session-a")
+	other, _ := w.Wrap(ctx, "raw-b", "device-a", "session-b")
+	legacy, _ := w.Wrap(ctx, "raw-c", "device-a", "")
+
+	if err := w.RevokeSession(ctx, "session-a"); err != nil {
+		t.Fatalf("RevokeSession() error = %v", err)
+	}
+	if _, err := w.Unwrap(ctx, revoked, "device-a"); !errors.Is(err, ErrInvalidHandle) {
+		t.Errorf("revoked handle error = %v, want ErrInvalidHandle", err)
+	}
+	if got, err := w.Unwrap(ctx, other, "device-a"); err != nil || got != "raw-b" {
+		t.Errorf("other session Unwrap() = %q, %v", got, err)
+	}
+	if got, err := w.Unwrap(ctx, legacy, "device-a"); err != nil || got != "raw-c" {
+		t.Errorf("handle without a session Unwrap() = %q, %v", got, err)
+	}
+}
+
+func TestAcceptUnwrapped(t *testing.T) {
+	s := store.NewMemory()
+	defer s.Close()
+	w := newTestWrapper(t, s, true, testKey("k1", 1))
+
+	if got, err := w.Unwrap(context.Background(), "raw-refresh", "device-a"); err != nil || got != "raw-refresh" {
+		t.Errorf("Unwrap() = %q, %v, want raw token passed through", got, err)
+	}
+}
@@ -0,0 +1,140 @@
+// Package tracing sets up OpenTelemetry tracing: OTLP export, W3C trace
+// context propagation, and spans for the proxy's middleware stages.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName names the tracer for spans created by the proxy.
+const TracerName = "github.com/kacy/auth-proxy"
+
+// Config holds configuration for trace export.
+type Config struct {
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://otel-collector:4318;
+	// /v1/traces is added when there's no path. Empty uses the exporter's
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	Endpoint    string
+	ServiceName string
+	// SampleRatio is the share of new traces recorded. Requests that arrive
+	// with a sampled traceparent are always recorded.
+	SampleRatio float64
+}
+
+// Setup exports spans to an OTLP collector, installs the global tracer
+// provider and propagators, and returns a function that flushes and stops
+// the exporter.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	var opts []otlptracehttp.Option
+	if cfg.Endpoint != "" {
+		endpoint, err := url.Parse(cfg.Endpoint)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("tracing: invalid endpoint %q", cfg.Endpoint)
+		}
+		if endpoint.Path == "" || endpoint.Path == "/" {
+			endpoint.Path = "/v1/traces"
+		}
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint.String()))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "auth-proxy"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	Install(provider)
+	return provider.Shutdown, nil
+}
+
+// Install makes provider the global tracer provider and propagates W3C trace
+// context and baggage. Tests install a provider backed by an in-memory
+// exporter.
+func Install(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+}
+
+// Tracer returns the proxy's tracer from the global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// RecordError marks span as failed with err, ignoring nil errors.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Stage wraps a middleware in a span covering only its own work. The span
+// ends when the middleware hands the request on, and the rest of the chain
+// continues under the parent span, so stages show up as siblings rather than
+// nesting ever deeper. A stage that answers the request itself is marked
+// with middleware.passed=false.
+func Stage(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handoff := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if s, ok := ctx.Value(stageKey{}).(*stageSpan); ok {
+				s.span.SetAttributes(attribute.Bool("middleware.passed", true))
+				s.span.End()
+				ctx = trace.ContextWithSpan(ctx, s.parent)
+				r = r.WithContext(ctx)
+			}
+			next.ServeHTTP(w, r)
+		})
+		wrapped := mw(handoff)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parent := trace.SpanFromContext(r.Context())
+			ctx, span := Tracer().Start(r.Context(), "middleware."+name)
+			s := &stageSpan{span: span, parent: parent}
+			ctx = context.WithValue(ctx, stageKey{}, s)
+
+			wrapped.ServeHTTP(w, r.WithContext(ctx))
+			if span.IsRecording() {
+				span.SetAttributes(attribute.Bool("middleware.passed", false))
+				span.End()
+			}
+		})
+	}
+}
+
+type stageKey struct{}
+
+type stageSpan struct {
+	span   trace.Span
+	parent trace.Span
+}
@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func installRecorder(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	Install(provider)
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return exp
+}
+
+func spanNamed(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func passed(s *tracetest.SpanStub) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == "middleware.passed" {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestStage(t *testing.T) {
+	exp := installRecorder(t)
+
+	pass := func(next http.Handler) http.Handler { return next }
+	deny := func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+
+	tests := []struct {
+		name       string
+		second     func(http.Handler) http.Handler
+		wantPassed bool
+		wantStatus int
+	}{
+		{"passes the request on", pass, true, http.StatusOK},
+		{"answers the request itself", deny, false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp.Reset()
+			final := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			handler := Stage("first", pass)(Stage("second", tt.second)(final))
+
+			ctx, root := Tracer().Start(context.Background(), "request")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+			root.End()
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			spans := exp.GetSpans()
+			first, second := spanNamed(spans, "middleware.first"), spanNamed(spans, "middleware.second")
+			if first == nil || second == nil {
+				t.Fatalf("spans = %d, want both stages", len(spans))
+			}
+			// Stages are siblings under the request span
+			rootID := root.SpanContext().SpanID()
+			if first.Parent.SpanID() != rootID || second.Parent.SpanID() != rootID {
+				t.Error("stage spans are not children of the request span")
+			}
+			if !passed(first).AsBool() {
+				t.Error("first stage not marked as passed")
+			}
+			if got := passed(second).AsBool(); got != tt.wantPassed {
+				t.Errorf("second stage passed = %v, want %v", got, tt.wantPassed)
+			}
+		})
+	}
+}
+
+func TestRedisHook(t *testing.T) {
+	exp := installRecorder(t)
+	hook := RedisHook{}
+
+	tests := []struct {
+		name      string
+		err       error
+		wantError bool
+	}{
+		{"success", nil, false},
+		{"missing key", redis.Nil, false},
+		{"failure", errors.New("connection refused"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp.Reset()
+			process := hook.ProcessHook(func(ctx context.Context, cmd redis.Cmder) error { return tt.err })
+			if err := process(context.Background(), redis.NewStringCmd(context.Background(), "get", "session:abc")); err != tt.err {
+				t.Fatalf("ProcessHook() error = %v, want %v", err, tt.err)
+			}
+
+			spans := exp.GetSpans()
+			if len(spans) != 1 || spans[0].Name != "redis.get" {
+				t.Fatalf("spans = %+v, want one redis.get span", spans)
+			}
+			if got := spans[0].Status.Code == codes.Error; got != tt.wantError {
+				t.Errorf("span error = %v, want %v", got, tt.wantError)
+			}
+			for _, kv := range spans[0].Attributes {
+				if kv.Value.Emit() == "session:abc" {
+					t.Error("span records the key")
+				}
+			}
+		})
+	}
+}